@@ -3,6 +3,7 @@ module github.com/vkngwrapper/vulkan-tutorial
 go 1.25
 
 require (
+	github.com/CannibalVox/cgoparam v1.1.0
 	github.com/g3n/engine v0.2.0
 	github.com/loov/hrtime v1.0.3
 	github.com/pkg/errors v0.9.1
@@ -13,7 +14,4 @@ require (
 	github.com/vkngwrapper/math v1.1.2
 )
 
-require (
-	github.com/CannibalVox/cgoparam v1.1.0 // indirect
-	github.com/google/uuid v1.3.0 // indirect
-)
+require github.com/google/uuid v1.3.0 // indirect
@@ -1049,7 +1049,7 @@ func (app *HelloTriangleApplication) createTextureImage() error {
 	var pixelData []byte
 
 	for y := imageBounds.Min.Y; y < imageBounds.Max.Y; y++ {
-		for x := imageBounds.Min.X; x < imageBounds.Max.Y; x++ {
+		for x := imageBounds.Min.X; x < imageBounds.Max.X; x++ {
 			r, g, b, a := decodedImage.At(x, y).RGBA()
 			pixelData = append(pixelData, byte(r), byte(g), byte(b), byte(a))
 		}
@@ -1155,7 +1155,7 @@ func (app *HelloTriangleApplication) createTextureImage() error {
 	imageDims := imageBounds.Size()
 	imageSize := imageDims.X * imageDims.Y * 4
 
-	app.mipLevels = int(math.Log2(math.Max(float64(imageDims.X), float64(imageDims.Y))))
+	app.mipLevels = int(math.Log2(math.Max(float64(imageDims.X), float64(imageDims.Y)))) + 1
 
 	stagingBuffer, stagingMemory, err := app.createBuffer(imageSize, core1_0.BufferUsageTransferSrc, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
 	if err != nil {
@@ -1165,7 +1165,7 @@ func (app *HelloTriangleApplication) createTextureImage() error {
 	var pixelData []byte
 
 	for y := imageBounds.Min.Y; y < imageBounds.Max.Y; y++ {
-		for x := imageBounds.Min.X; x < imageBounds.Max.Y; x++ {
+		for x := imageBounds.Min.X; x < imageBounds.Max.X; x++ {
 			r, g, b, a := decodedImage.At(x, y).RGBA()
 			pixelData = append(pixelData, byte(r), byte(g), byte(b), byte(a))
 		}
@@ -1497,29 +1497,55 @@ func writeData(memory core1_0.DeviceMemory, offset int, data any) error {
 	return nil
 }
 
-func (app *HelloTriangleApplication) addVertex(decoder *obj.Decoder, uniqueVertices map[int]uint32, face obj.Face, faceIndex int) {
+// objVertex builds the vertex for one corner of an OBJ face
+func objVertex(decoder *obj.Decoder, face obj.Face, faceIndex int) Vertex {
 	vertInd := face.Vertices[faceIndex]
-	index, vertexExists := uniqueVertices[vertInd]
-
-	if !vertexExists {
-		vert := Vertex{Position: vkngmath.Vec3[float32]{
-			X: decoder.Vertices[vertInd*3],
-			Y: decoder.Vertices[vertInd*3+1],
-			Z: decoder.Vertices[vertInd*3+2],
-		}, Color: vkngmath.Vec3[float32]{X: 1, Y: 1, Z: 1}}
-
-		uvInd := face.Uvs[faceIndex]
-		vert.TexCoord = vkngmath.Vec2[float32]{
-			X: decoder.Uvs[uvInd*2],
-			Y: 1.0 - decoder.Uvs[uvInd*2+1],
-		}
+	vert := Vertex{Position: vkngmath.Vec3[float32]{
+		X: decoder.Vertices[vertInd*3],
+		Y: decoder.Vertices[vertInd*3+1],
+		Z: decoder.Vertices[vertInd*3+2],
+	}, Color: vkngmath.Vec3[float32]{X: 1, Y: 1, Z: 1}}
+
+	uvInd := face.Uvs[faceIndex]
+	vert.TexCoord = vkngmath.Vec2[float32]{
+		X: decoder.Uvs[uvInd*2],
+		Y: 1.0 - decoder.Uvs[uvInd*2+1],
+	}
+
+	return vert
+}
+
+// objVertices triangulates every face of a decoded OBJ and returns its unique vertices
+// and the indices that draw them
+func objVertices(decoder *obj.Decoder) ([]Vertex, []uint32) {
+	var vertices []Vertex
+	var indices []uint32
+	uniqueVertices := make(map[Vertex]uint32)
+
+	for _, decodedObj := range decoder.Objects {
+		for _, face := range decodedObj.Faces {
+			// We need to triangularize faces
+			for i := 2; i < len(face.Vertices); i++ {
+				for _, faceIndex := range [3]int{0, i - 1, i} {
+					vert := objVertex(decoder, face, faceIndex)
+
+					// Corners that share a position but not a uv (texture seams) must stay
+					// separate, so deduplicate on the whole vertex rather than the position index
+					index, vertexExists := uniqueVertices[vert]
+
+					if !vertexExists {
+						index = uint32(len(vertices))
+						vertices = append(vertices, vert)
+						uniqueVertices[vert] = index
+					}
 
-		index = uint32(len(app.vertices))
-		app.vertices = append(app.vertices, vert)
-		uniqueVertices[vertInd] = index
+					indices = append(indices, index)
+				}
+			}
+		}
 	}
 
-	app.indices = append(app.indices, index)
+	return vertices, indices
 }
 
 func (app *HelloTriangleApplication) loadModel() error {
@@ -1540,19 +1566,7 @@ func (app *HelloTriangleApplication) loadModel() error {
 		return err
 	}
 
-	uniqueVertices := make(map[int]uint32)
-
-	for _, decodedObj := range decoder.Objects {
-		for _, face := range decodedObj.Faces {
-			// We need to triangularize faces
-			for i := 2; i < len(face.Vertices); i++ {
-				app.addVertex(decoder, uniqueVertices, face, 0)
-				app.addVertex(decoder, uniqueVertices, face, i-1)
-				app.addVertex(decoder, uniqueVertices, face, i)
-			}
-		}
-	}
-
+	app.vertices, app.indices = objVertices(decoder)
 	return nil
 }
 
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/g3n/engine/loader/obj"
+	vkngmath "github.com/vkngwrapper/math"
+)
+
+func TestOBJVertices(t *testing.T) {
+	testCases := []struct {
+		name         string
+		obj          string
+		wantVertices int
+		wantIndices  int
+		// wantCorners is the 1-based position and texture coordinate from the OBJ of every
+		// index, in draw order
+		wantCorners [][2]int
+	}{
+		{
+			name: "Quad",
+			obj: `o quad
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+vt 0 0
+vt 1 0
+vt 1 1
+vt 0 1
+f 1/1 2/2 3/3 4/4
+`,
+			wantVertices: 4,
+			wantIndices:  6,
+			wantCorners:  [][2]int{{1, 1}, {2, 2}, {3, 3}, {1, 1}, {3, 3}, {4, 4}},
+		},
+		{
+			// Two quads share the edge at x = 1 and its texture coordinates, so its
+			// corners are shared as well
+			name: "SharedEdge",
+			obj: `o quads
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+v 2 0 0
+v 2 1 0
+vt 0 0
+vt 0.5 0
+vt 0.5 1
+vt 0 1
+vt 1 0
+vt 1 1
+f 1/1 2/2 3/3 4/4
+f 2/2 5/5 6/6 3/3
+`,
+			wantVertices: 6,
+			wantIndices:  12,
+			wantCorners: [][2]int{
+				{1, 1}, {2, 2}, {3, 3}, {1, 1}, {3, 3}, {4, 4},
+				{2, 2}, {5, 5}, {6, 6}, {2, 2}, {6, 6}, {3, 3},
+			},
+		},
+		{
+			// The same quads, but the right one is mapped to its own part of the texture,
+			// which puts a uv seam along the shared edge. Keying on the
+			// position index alone would weld the seam back into 6 vertices.
+			name: "UVSeam",
+			obj: `o quads
+v 0 0 0
+v 1 0 0
+v 1 1 0
+v 0 1 0
+v 2 0 0
+v 2 1 0
+vt 0 0
+vt 0.5 0
+vt 0.5 1
+vt 0 1
+vt 0.6 0
+vt 1 0
+vt 1 1
+vt 0.6 1
+f 1/1 2/2 3/3 4/4
+f 2/5 5/6 6/7 3/8
+`,
+			wantVertices: 8,
+			wantIndices:  12,
+			wantCorners: [][2]int{
+				{1, 1}, {2, 2}, {3, 3}, {1, 1}, {3, 3}, {4, 4},
+				{2, 5}, {5, 6}, {6, 7}, {2, 5}, {6, 7}, {3, 8},
+			},
+		},
+		{
+			name: "Pentagon",
+			obj: `o pentagon
+v 0 0 0
+v 1 0 0
+v 1.5 1 0
+v 0.5 2 0
+v -0.5 1 0
+vt 0 0
+vt 1 0
+vt 1 0.5
+vt 0.5 1
+vt 0 0.5
+f 1/1 2/2 3/3 4/4 5/5
+`,
+			wantVertices: 5,
+			wantIndices:  9,
+			wantCorners:  [][2]int{{1, 1}, {2, 2}, {3, 3}, {1, 1}, {3, 3}, {4, 4}, {1, 1}, {4, 4}, {5, 5}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			decoder, err := obj.DecodeReader(strings.NewReader(testCase.obj), strings.NewReader(""))
+			if err != nil {
+				t.Fatalf("decoding: %v", err)
+			}
+
+			vertices, indices := objVertices(decoder)
+			if len(vertices) != testCase.wantVertices {
+				t.Errorf("got %d vertices, expected %d", len(vertices), testCase.wantVertices)
+			}
+			if len(indices) != testCase.wantIndices {
+				t.Errorf("got %d indices, expected %d", len(indices), testCase.wantIndices)
+			}
+
+			seen := make(map[Vertex]bool)
+			for _, vert := range vertices {
+				if seen[vert] {
+					t.Errorf("vertex %v appears more than once", vert)
+				}
+				seen[vert] = true
+			}
+
+			for k, index := range indices {
+				if int(index) >= len(vertices) {
+					t.Fatalf("index %d is past the %d vertices", index, len(vertices))
+				}
+
+				if k >= len(testCase.wantCorners) {
+					continue
+				}
+				position, uv := testCase.wantCorners[k][0]-1, testCase.wantCorners[k][1]-1
+				want := Vertex{
+					Position: vkngmath.Vec3[float32]{X: decoder.Vertices[position*3], Y: decoder.Vertices[position*3+1], Z: decoder.Vertices[position*3+2]},
+					Color:    vkngmath.Vec3[float32]{X: 1, Y: 1, Z: 1},
+					// Vulkan's v axis points down the image, the other way to OBJ's
+					TexCoord: vkngmath.Vec2[float32]{X: decoder.Uvs[uv*2], Y: 1 - decoder.Uvs[uv*2+1]},
+				}
+				if vertices[index] != want {
+					t.Errorf("index %d draws %v, expected %v", k, vertices[index], want)
+				}
+			}
+		})
+	}
+}
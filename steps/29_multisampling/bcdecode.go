@@ -0,0 +1,721 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// blockDecoder decodes one compressed block into 16 texels, written row by row to out
+type blockDecoder func(block []byte, out []byte)
+
+type decompression struct {
+	Format     core1_0.Format
+	PixelBytes int
+	Decode     blockDecoder
+}
+
+// decompressions maps each block-compressed format to the uncompressed format it is
+// expanded to when the device can't sample it
+var decompressions = map[core1_0.Format]decompression{
+	core1_0.FormatBC1_RGBUnsignedNormalized:  {core1_0.FormatR8G8B8A8UnsignedNormalized, 4, decodeBC1},
+	core1_0.FormatBC1_RGBsRGB:                {core1_0.FormatR8G8B8A8SRGB, 4, decodeBC1},
+	core1_0.FormatBC1_RGBAUnsignedNormalized: {core1_0.FormatR8G8B8A8UnsignedNormalized, 4, decodeBC1},
+	core1_0.FormatBC1_RGBAsRGB:               {core1_0.FormatR8G8B8A8SRGB, 4, decodeBC1},
+	core1_0.FormatBC2_UnsignedNormalized:     {core1_0.FormatR8G8B8A8UnsignedNormalized, 4, decodeBC2},
+	core1_0.FormatBC2_sRGB:                   {core1_0.FormatR8G8B8A8SRGB, 4, decodeBC2},
+	core1_0.FormatBC3_UnsignedNormalized:     {core1_0.FormatR8G8B8A8UnsignedNormalized, 4, decodeBC3},
+	core1_0.FormatBC3_sRGB:                   {core1_0.FormatR8G8B8A8SRGB, 4, decodeBC3},
+	core1_0.FormatBC4_UnsignedNormalized:     {core1_0.FormatR8G8B8A8UnsignedNormalized, 4, decodeBC4Unsigned},
+	core1_0.FormatBC4_SignedNormalized:       {core1_0.FormatR8G8B8A8SignedNormalized, 4, decodeBC4Signed},
+	core1_0.FormatBC5_UnsignedNormalized:     {core1_0.FormatR8G8B8A8UnsignedNormalized, 4, decodeBC5Unsigned},
+	core1_0.FormatBC5_SignedNormalized:       {core1_0.FormatR8G8B8A8SignedNormalized, 4, decodeBC5Signed},
+	core1_0.FormatBC6_UnsignedFloat:          {core1_0.FormatR16G16B16A16SignedFloat, 8, decodeBC6Unsigned},
+	core1_0.FormatBC6_SignedFloat:            {core1_0.FormatR16G16B16A16SignedFloat, 8, decodeBC6Signed},
+	core1_0.FormatBC7_UnsignedNormalized:     {core1_0.FormatR8G8B8A8UnsignedNormalized, 4, decodeBC7},
+	core1_0.FormatBC7_sRGB:                   {core1_0.FormatR8G8B8A8SRGB, 4, decodeBC7},
+}
+
+// decompressTexture expands every mip level of a block-compressed texture. Rows of
+// blocks are spread across goroutines.
+func decompressTexture(texture *textureData) (*textureData, error) {
+	decomp, supported := decompressions[texture.Format]
+	if !supported {
+		return nil, errors.Errorf("decompressTexture: cannot decompress %s", texture.Format)
+	}
+
+	blockBytes := textureFormats[texture.Format].BlockBytes
+	output := &textureData{
+		Format: decomp.Format,
+		Width:  texture.Width,
+		Height: texture.Height,
+	}
+
+	for level, data := range texture.Levels {
+		width, height := texture.LevelExtent(level)
+		blocksWide := (width + 3) / 4
+		blocksHigh := (height + 3) / 4
+		pixels := make([]byte, width*height*decomp.PixelBytes)
+
+		var waitGroup sync.WaitGroup
+		rowsPerWorker := (blocksHigh + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0)
+		for firstRow := 0; firstRow < blocksHigh; firstRow += rowsPerWorker {
+			lastRow := min(firstRow+rowsPerWorker, blocksHigh)
+
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+
+				texels := make([]byte, 16*decomp.PixelBytes)
+				for blockY := firstRow; blockY < lastRow; blockY++ {
+					for blockX := 0; blockX < blocksWide; blockX++ {
+						blockOffset := (blockY*blocksWide + blockX) * blockBytes
+						decomp.Decode(data[blockOffset:blockOffset+blockBytes], texels)
+
+						// Blocks on the right and bottom edges can hang off the image
+						for y := 0; y < 4 && blockY*4+y < height; y++ {
+							columns := min(4, width-blockX*4)
+							dst := ((blockY*4+y)*width + blockX*4) * decomp.PixelBytes
+							copy(pixels[dst:dst+columns*decomp.PixelBytes], texels[y*4*decomp.PixelBytes:])
+						}
+					}
+				}
+			}()
+		}
+		waitGroup.Wait()
+
+		output.Levels = append(output.Levels, pixels)
+	}
+
+	return output, nil
+}
+
+// blockBits reads a 128-bit block as a little-endian bit stream
+type blockBits struct {
+	low  uint64
+	high uint64
+	pos  int
+}
+
+func newBlockBits(block []byte) blockBits {
+	return blockBits{
+		low:  binary.LittleEndian.Uint64(block[0:8]),
+		high: binary.LittleEndian.Uint64(block[8:16]),
+	}
+}
+
+func (b *blockBits) read(count int) int {
+	var value uint64
+	if b.pos >= 64 {
+		value = b.high >> (b.pos - 64)
+	} else {
+		value = b.low >> b.pos
+		if b.pos+count > 64 {
+			value |= b.high << (64 - b.pos)
+		}
+	}
+
+	b.pos += count
+	return int(value & (1<<count - 1))
+}
+
+func expand565(color uint16) [3]int {
+	r := int(color>>11) & 0x1f
+	g := int(color>>5) & 0x3f
+	b := int(color) & 0x1f
+	return [3]int{r<<3 | r>>2, g<<2 | g>>4, b<<3 | b>>2}
+}
+
+// decodeBC1Colors decodes the 8-byte color half shared by BC1, BC2 and BC3. Only BC1
+// blocks can use the three color mode with transparent black.
+func decodeBC1Colors(block []byte, out []byte, allowTransparent bool) {
+	color0 := binary.LittleEndian.Uint16(block[0:2])
+	color1 := binary.LittleEndian.Uint16(block[2:4])
+	endpoint0, endpoint1 := expand565(color0), expand565(color1)
+
+	var palette [4][4]byte
+	for channel := 0; channel < 3; channel++ {
+		e0, e1 := endpoint0[channel], endpoint1[channel]
+		palette[0][channel] = byte(e0)
+		palette[1][channel] = byte(e1)
+
+		if color0 > color1 || !allowTransparent {
+			palette[2][channel] = byte((2*e0 + e1) / 3)
+			palette[3][channel] = byte((e0 + 2*e1) / 3)
+		} else {
+			palette[2][channel] = byte((e0 + e1) / 2)
+		}
+	}
+	palette[0][3], palette[1][3], palette[2][3] = 0xff, 0xff, 0xff
+	if color0 > color1 || !allowTransparent {
+		palette[3][3] = 0xff
+	}
+
+	indices := binary.LittleEndian.Uint32(block[4:8])
+	for texel := 0; texel < 16; texel++ {
+		copy(out[texel*4:texel*4+4], palette[(indices>>(2*texel))&3][:])
+	}
+}
+
+func decodeBC1(block []byte, out []byte) {
+	decodeBC1Colors(block, out, true)
+}
+
+func decodeBC2(block []byte, out []byte) {
+	decodeBC1Colors(block[8:16], out, false)
+
+	alpha := binary.LittleEndian.Uint64(block[0:8])
+	for texel := 0; texel < 16; texel++ {
+		out[texel*4+3] = byte((alpha>>(4*texel))&0xf) * 17
+	}
+}
+
+// decodeAlphaChannel decodes an 8-byte BC3 alpha or BC4 channel block into every
+// stride'th byte of out. Signed blocks are written as two's complement bytes.
+func decodeAlphaChannel(block []byte, out []byte, stride int, signed bool) {
+	var e0, e1 int
+	if signed {
+		e0, e1 = max(int(int8(block[0])), -127), max(int(int8(block[1])), -127)
+	} else {
+		e0, e1 = int(block[0]), int(block[1])
+	}
+
+	var palette [8]int
+	palette[0], palette[1] = e0, e1
+	if e0 > e1 {
+		for i := 1; i < 7; i++ {
+			palette[i+1] = ((7-i)*e0 + i*e1) / 7
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			palette[i+1] = ((5-i)*e0 + i*e1) / 5
+		}
+		if signed {
+			palette[6], palette[7] = -127, 127
+		} else {
+			palette[6], palette[7] = 0, 255
+		}
+	}
+
+	var indices uint64
+	for i := 0; i < 6; i++ {
+		indices |= uint64(block[2+i]) << (8 * i)
+	}
+	for texel := 0; texel < 16; texel++ {
+		out[texel*stride] = byte(palette[(indices>>(3*texel))&7])
+	}
+}
+
+func decodeBC3(block []byte, out []byte) {
+	decodeBC1Colors(block[8:16], out, false)
+	decodeAlphaChannel(block[0:8], out[3:], 4, false)
+}
+
+func fillChannels(out []byte, value byte, channels ...int) {
+	for texel := 0; texel < 16; texel++ {
+		for _, channel := range channels {
+			out[texel*4+channel] = value
+		}
+	}
+}
+
+func decodeBC4Unsigned(block []byte, out []byte) {
+	decodeAlphaChannel(block, out, 4, false)
+	fillChannels(out, 0, 1, 2)
+	fillChannels(out, 0xff, 3)
+}
+
+func decodeBC4Signed(block []byte, out []byte) {
+	decodeAlphaChannel(block, out, 4, true)
+	fillChannels(out, 0, 1, 2)
+	fillChannels(out, 0x7f, 3)
+}
+
+func decodeBC5Unsigned(block []byte, out []byte) {
+	decodeAlphaChannel(block[0:8], out, 4, false)
+	decodeAlphaChannel(block[8:16], out[1:], 4, false)
+	fillChannels(out, 0, 2)
+	fillChannels(out, 0xff, 3)
+}
+
+func decodeBC5Signed(block []byte, out []byte) {
+	decodeAlphaChannel(block[0:8], out, 4, true)
+	decodeAlphaChannel(block[8:16], out[1:], 4, true)
+	fillChannels(out, 0, 2)
+	fillChannels(out, 0x7f, 3)
+}
+
+// BPTC (BC6H and BC7) tables, from the Khronos Data Format Specification. Each
+// two-subset partition is a mask with bit i set when texel i is in subset 1.
+var bptcPartitions2 = [64]uint16{
+	0xcccc, 0x8888, 0xeeee, 0xecc8, 0xc880, 0xfeec, 0xfec8, 0xec80,
+	0xc800, 0xffec, 0xfe80, 0xe800, 0xffe8, 0xff00, 0xfff0, 0xf000,
+	0xf710, 0x008e, 0x7100, 0x08ce, 0x008c, 0x7310, 0x3100, 0x8cce,
+	0x088c, 0x3110, 0x6666, 0x366c, 0x17e8, 0x0ff0, 0x718e, 0x399c,
+	0xaaaa, 0xf0f0, 0x5a5a, 0x33cc, 0x3c3c, 0x55aa, 0x9696, 0xa55a,
+	0x73ce, 0x13c8, 0x324c, 0x3bdc, 0x6996, 0xc33c, 0x9966, 0x0660,
+	0x0272, 0x04e4, 0x4e40, 0x2720, 0xc936, 0x936c, 0x39c6, 0x639c,
+	0x9336, 0x9cc6, 0x817e, 0xe718, 0xccf0, 0x0fcc, 0x7744, 0xee22,
+}
+
+var bptcPartitions3 = [64][16]byte{
+	{0, 0, 1, 1, 0, 0, 1, 1, 0, 2, 2, 1, 2, 2, 2, 2},
+	{0, 0, 0, 1, 0, 0, 1, 1, 2, 2, 1, 1, 2, 2, 2, 1},
+	{0, 0, 0, 0, 2, 0, 0, 1, 2, 2, 1, 1, 2, 2, 1, 1},
+	{0, 2, 2, 2, 0, 0, 2, 2, 0, 0, 1, 1, 0, 1, 1, 1},
+	{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 2, 2, 1, 1, 2, 2},
+	{0, 0, 1, 1, 0, 0, 1, 1, 0, 0, 2, 2, 0, 0, 2, 2},
+	{0, 0, 2, 2, 0, 0, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1},
+	{0, 0, 1, 1, 0, 0, 1, 1, 2, 2, 1, 1, 2, 2, 1, 1},
+	{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2},
+	{0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 2, 2, 2, 2},
+	{0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 2, 2},
+	{0, 0, 1, 2, 0, 0, 1, 2, 0, 0, 1, 2, 0, 0, 1, 2},
+	{0, 1, 1, 2, 0, 1, 1, 2, 0, 1, 1, 2, 0, 1, 1, 2},
+	{0, 1, 2, 2, 0, 1, 2, 2, 0, 1, 2, 2, 0, 1, 2, 2},
+	{0, 0, 1, 1, 0, 1, 1, 2, 1, 1, 2, 2, 1, 2, 2, 2},
+	{0, 0, 1, 1, 2, 0, 0, 1, 2, 2, 0, 0, 2, 2, 2, 0},
+	{0, 0, 0, 1, 0, 0, 1, 1, 0, 1, 1, 2, 1, 1, 2, 2},
+	{0, 1, 1, 1, 0, 0, 1, 1, 2, 0, 0, 1, 2, 2, 0, 0},
+	{0, 0, 0, 0, 1, 1, 2, 2, 1, 1, 2, 2, 1, 1, 2, 2},
+	{0, 0, 2, 2, 0, 0, 2, 2, 0, 0, 2, 2, 1, 1, 1, 1},
+	{0, 1, 1, 1, 0, 1, 1, 1, 0, 2, 2, 2, 0, 2, 2, 2},
+	{0, 0, 0, 1, 0, 0, 0, 1, 2, 2, 2, 1, 2, 2, 2, 1},
+	{0, 0, 0, 0, 0, 0, 1, 1, 0, 1, 2, 2, 0, 1, 2, 2},
+	{0, 0, 0, 0, 1, 1, 0, 0, 2, 2, 1, 0, 2, 2, 1, 0},
+	{0, 1, 2, 2, 0, 1, 2, 2, 0, 0, 1, 1, 0, 0, 0, 0},
+	{0, 0, 1, 2, 0, 0, 1, 2, 1, 1, 2, 2, 2, 2, 2, 2},
+	{0, 1, 1, 0, 1, 2, 2, 1, 1, 2, 2, 1, 0, 1, 1, 0},
+	{0, 0, 0, 0, 0, 1, 1, 0, 1, 2, 2, 1, 1, 2, 2, 1},
+	{0, 0, 2, 2, 1, 1, 0, 2, 1, 1, 0, 2, 0, 0, 2, 2},
+	{0, 1, 1, 0, 0, 1, 1, 0, 2, 0, 0, 2, 2, 2, 2, 2},
+	{0, 0, 1, 1, 0, 1, 2, 2, 0, 1, 2, 2, 0, 0, 1, 1},
+	{0, 0, 0, 0, 2, 0, 0, 0, 2, 2, 1, 1, 2, 2, 2, 1},
+	{0, 0, 0, 0, 0, 0, 0, 2, 1, 1, 2, 2, 1, 2, 2, 2},
+	{0, 2, 2, 2, 0, 0, 2, 2, 0, 0, 1, 2, 0, 0, 1, 1},
+	{0, 0, 1, 1, 0, 0, 1, 2, 0, 0, 2, 2, 0, 2, 2, 2},
+	{0, 1, 2, 0, 0, 1, 2, 0, 0, 1, 2, 0, 0, 1, 2, 0},
+	{0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 0, 0, 0, 0},
+	{0, 1, 2, 0, 1, 2, 0, 1, 2, 0, 1, 2, 0, 1, 2, 0},
+	{0, 1, 2, 0, 2, 0, 1, 2, 1, 2, 0, 1, 0, 1, 2, 0},
+	{0, 0, 1, 1, 2, 2, 0, 0, 1, 1, 2, 2, 0, 0, 1, 1},
+	{0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 0, 0, 0, 0, 1, 1},
+	{0, 1, 0, 1, 0, 1, 0, 1, 2, 2, 2, 2, 2, 2, 2, 2},
+	{0, 0, 0, 0, 0, 0, 0, 0, 2, 1, 2, 1, 2, 1, 2, 1},
+	{0, 0, 2, 2, 1, 1, 2, 2, 0, 0, 2, 2, 1, 1, 2, 2},
+	{0, 0, 2, 2, 0, 0, 1, 1, 0, 0, 2, 2, 0, 0, 1, 1},
+	{0, 2, 2, 0, 1, 2, 2, 1, 0, 2, 2, 0, 1, 2, 2, 1},
+	{0, 1, 0, 1, 2, 2, 2, 2, 2, 2, 2, 2, 0, 1, 0, 1},
+	{0, 0, 0, 0, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1, 2, 1},
+	{0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 2, 2, 2, 2},
+	{0, 2, 2, 2, 0, 1, 1, 1, 0, 2, 2, 2, 0, 1, 1, 1},
+	{0, 0, 0, 2, 1, 1, 1, 2, 0, 0, 0, 2, 1, 1, 1, 2},
+	{0, 0, 0, 0, 2, 1, 1, 2, 2, 1, 1, 2, 2, 1, 1, 2},
+	{0, 2, 2, 2, 0, 1, 1, 1, 0, 1, 1, 1, 0, 2, 2, 2},
+	{0, 0, 0, 2, 1, 1, 1, 2, 1, 1, 1, 2, 0, 0, 0, 2},
+	{0, 1, 1, 0, 0, 1, 1, 0, 0, 1, 1, 0, 2, 2, 2, 2},
+	{0, 0, 0, 0, 0, 0, 0, 0, 2, 1, 1, 2, 2, 1, 1, 2},
+	{0, 1, 1, 0, 0, 1, 1, 0, 2, 2, 2, 2, 2, 2, 2, 2},
+	{0, 0, 2, 2, 0, 0, 1, 1, 0, 0, 1, 1, 0, 0, 2, 2},
+	{0, 0, 2, 2, 1, 1, 2, 2, 1, 1, 2, 2, 0, 0, 2, 2},
+	{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 1, 1, 2},
+	{0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 1},
+	{0, 2, 2, 2, 1, 2, 2, 2, 0, 2, 2, 2, 1, 2, 2, 2},
+	{0, 1, 0, 1, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2},
+	{0, 1, 1, 1, 2, 0, 1, 1, 2, 2, 0, 1, 2, 2, 2, 0},
+}
+
+// Anchor texels store their index with one bit less. Texel 0 is always the anchor of
+// subset 0, and these tables give the anchors of the other subsets.
+var bptcAnchors2 = [64]byte{
+	15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15,
+	15, 2, 8, 2, 2, 8, 8, 15, 2, 8, 2, 2, 8, 8, 2, 2,
+	15, 15, 6, 8, 2, 8, 15, 15, 2, 8, 2, 2, 2, 15, 15, 6,
+	6, 2, 6, 8, 15, 15, 2, 2, 15, 15, 15, 15, 15, 2, 2, 15,
+}
+
+var bptcAnchors3Second = [64]byte{
+	3, 3, 15, 15, 8, 3, 15, 15, 8, 8, 6, 6, 6, 5, 3, 3,
+	3, 3, 8, 15, 3, 3, 6, 10, 5, 8, 8, 6, 8, 5, 15, 15,
+	8, 15, 3, 5, 6, 10, 8, 15, 15, 3, 15, 5, 15, 15, 15, 15,
+	3, 15, 5, 5, 5, 8, 5, 10, 5, 10, 8, 13, 15, 12, 3, 3,
+}
+
+var bptcAnchors3Third = [64]byte{
+	15, 8, 8, 3, 15, 15, 3, 8, 15, 15, 15, 15, 15, 15, 15, 8,
+	15, 8, 15, 3, 15, 8, 15, 8, 3, 15, 6, 10, 15, 15, 10, 8,
+	15, 3, 15, 10, 10, 8, 9, 10, 6, 15, 8, 15, 3, 6, 6, 8,
+	15, 3, 15, 15, 15, 15, 15, 15, 15, 15, 15, 15, 3, 15, 15, 8,
+}
+
+var bptcWeights = [5][]int{
+	2: {0, 21, 43, 64},
+	3: {0, 9, 18, 27, 37, 46, 55, 64},
+	4: {0, 4, 9, 13, 17, 21, 26, 30, 34, 38, 43, 47, 51, 55, 60, 64},
+}
+
+func bptcInterpolate(e0, e1 int, indexBits int, index int) int {
+	weight := bptcWeights[indexBits][index]
+	return ((64-weight)*e0 + weight*e1 + 32) >> 6
+}
+
+// bptcSubset returns the subset a texel belongs to for the given partition
+func bptcSubset(subsets int, partition int, texel int) int {
+	switch subsets {
+	case 2:
+		return int(bptcPartitions2[partition]>>texel) & 1
+	case 3:
+		return int(bptcPartitions3[partition][texel])
+	}
+	return 0
+}
+
+func bptcIsAnchor(subsets int, partition int, texel int) bool {
+	switch {
+	case texel == 0:
+		return true
+	case subsets == 2:
+		return texel == int(bptcAnchors2[partition])
+	case subsets == 3:
+		return texel == int(bptcAnchors3Second[partition]) || texel == int(bptcAnchors3Third[partition])
+	}
+	return false
+}
+
+type bc7Mode struct {
+	Subsets        int
+	PartitionBits  int
+	RotationBits   int
+	SelectionBits  int
+	ColorBits      int
+	AlphaBits      int
+	EndpointPBits  bool
+	SharedPBits    bool
+	IndexBits      int
+	AlphaIndexBits int
+}
+
+var bc7Modes = [8]bc7Mode{
+	{Subsets: 3, PartitionBits: 4, ColorBits: 4, EndpointPBits: true, IndexBits: 3},
+	{Subsets: 2, PartitionBits: 6, ColorBits: 6, SharedPBits: true, IndexBits: 3},
+	{Subsets: 3, PartitionBits: 6, ColorBits: 5, IndexBits: 2},
+	{Subsets: 2, PartitionBits: 6, ColorBits: 7, EndpointPBits: true, IndexBits: 2},
+	{Subsets: 1, RotationBits: 2, SelectionBits: 1, ColorBits: 5, AlphaBits: 6, IndexBits: 2, AlphaIndexBits: 3},
+	{Subsets: 1, RotationBits: 2, ColorBits: 7, AlphaBits: 8, IndexBits: 2, AlphaIndexBits: 2},
+	{Subsets: 1, ColorBits: 7, AlphaBits: 7, EndpointPBits: true, IndexBits: 4},
+	{Subsets: 2, PartitionBits: 6, ColorBits: 5, AlphaBits: 5, EndpointPBits: true, IndexBits: 2},
+}
+
+func decodeBC7(block []byte, out []byte) {
+	bits := newBlockBits(block)
+
+	modeIndex := 0
+	for modeIndex < 8 && bits.read(1) == 0 {
+		modeIndex++
+	}
+	if modeIndex == 8 {
+		// Reserved mode- decoders output transparent black
+		clear(out[:64])
+		return
+	}
+	mode := bc7Modes[modeIndex]
+
+	partition := bits.read(mode.PartitionBits)
+	rotation := bits.read(mode.RotationBits)
+	selection := bits.read(mode.SelectionBits)
+
+	// Endpoints are stored channel by channel: every red, then every green, and so on
+	endpointCount := mode.Subsets * 2
+	var endpoints [6][4]int
+	for channel := 0; channel < 3; channel++ {
+		for endpoint := 0; endpoint < endpointCount; endpoint++ {
+			endpoints[endpoint][channel] = bits.read(mode.ColorBits)
+		}
+	}
+	for endpoint := 0; endpoint < endpointCount; endpoint++ {
+		endpoints[endpoint][3] = bits.read(mode.AlphaBits)
+	}
+
+	colorBits, alphaBits := mode.ColorBits, mode.AlphaBits
+	if mode.EndpointPBits || mode.SharedPBits {
+		var pBits [6]int
+		if mode.EndpointPBits {
+			for endpoint := 0; endpoint < endpointCount; endpoint++ {
+				pBits[endpoint] = bits.read(1)
+			}
+		} else {
+			for subset := 0; subset < mode.Subsets; subset++ {
+				pBit := bits.read(1)
+				pBits[subset*2], pBits[subset*2+1] = pBit, pBit
+			}
+		}
+
+		for endpoint := 0; endpoint < endpointCount; endpoint++ {
+			for channel := 0; channel < 4; channel++ {
+				endpoints[endpoint][channel] = endpoints[endpoint][channel]<<1 | pBits[endpoint]
+			}
+		}
+		colorBits++
+		if alphaBits > 0 {
+			alphaBits++
+		}
+	}
+
+	// Expand each endpoint to 8 bits by replicating its high bits into the low bits
+	for endpoint := 0; endpoint < endpointCount; endpoint++ {
+		for channel := 0; channel < 4; channel++ {
+			channelBits := colorBits
+			if channel == 3 {
+				channelBits = alphaBits
+			}
+
+			if channelBits == 0 {
+				endpoints[endpoint][channel] = 0xff
+				continue
+			}
+			value := endpoints[endpoint][channel] << (8 - channelBits)
+			endpoints[endpoint][channel] = value | value>>channelBits
+		}
+	}
+
+	var colorIndices, alphaIndices [16]int
+	for texel := 0; texel < 16; texel++ {
+		indexBits := mode.IndexBits
+		if bptcIsAnchor(mode.Subsets, partition, texel) {
+			indexBits--
+		}
+		colorIndices[texel] = bits.read(indexBits)
+	}
+
+	colorIndexBits, alphaIndexBits := mode.IndexBits, mode.IndexBits
+	if mode.AlphaIndexBits > 0 {
+		for texel := 0; texel < 16; texel++ {
+			indexBits := mode.AlphaIndexBits
+			if texel == 0 {
+				indexBits--
+			}
+			alphaIndices[texel] = bits.read(indexBits)
+		}
+		alphaIndexBits = mode.AlphaIndexBits
+
+		if selection == 1 {
+			colorIndices, alphaIndices = alphaIndices, colorIndices
+			colorIndexBits, alphaIndexBits = alphaIndexBits, colorIndexBits
+		}
+	} else {
+		alphaIndices = colorIndices
+	}
+
+	for texel := 0; texel < 16; texel++ {
+		subset := bptcSubset(mode.Subsets, partition, texel)
+		e0, e1 := endpoints[subset*2], endpoints[subset*2+1]
+
+		var color [4]int
+		for channel := 0; channel < 3; channel++ {
+			color[channel] = bptcInterpolate(e0[channel], e1[channel], colorIndexBits, colorIndices[texel])
+		}
+		color[3] = bptcInterpolate(e0[3], e1[3], alphaIndexBits, alphaIndices[texel])
+
+		if rotation > 0 {
+			color[3], color[rotation-1] = color[rotation-1], color[3]
+		}
+
+		for channel := 0; channel < 4; channel++ {
+			out[texel*4+channel] = byte(color[channel])
+		}
+	}
+}
+
+// bc6Field is a run of bits in a BC6H block that belongs to one channel of one endpoint.
+// Endpoints 0 and 1 belong to the first region, 2 and 3 to the second.
+type bc6Field struct {
+	Endpoint int
+	Channel  int
+	Shift    int
+	Count    int
+}
+
+type bc6Mode struct {
+	Transformed  bool
+	Regions      int
+	EndpointBits int
+	DeltaBits    [3]int
+	Fields       []bc6Field
+}
+
+// bc6Layout parses the bit layouts from the BC6H specification. Each field is written
+// as channel, endpoint and bit range, e.g. "r0:9-0" or "g2:4".
+func bc6Layout(layout string) []bc6Field {
+	var fields []bc6Field
+	for _, token := range strings.Fields(layout) {
+		channel := strings.IndexByte("rgb", token[0])
+		endpoint := int(token[1] - '0')
+
+		var high, low int
+		if strings.Contains(token, "-") {
+			fmt.Sscanf(token[3:], "%d-%d", &high, &low)
+		} else {
+			fmt.Sscanf(token[3:], "%d", &high)
+			low = high
+		}
+
+		fields = append(fields, bc6Field{Endpoint: endpoint, Channel: channel, Shift: low, Count: high - low + 1})
+	}
+	return fields
+}
+
+// bc6Modes is keyed by the value of the mode bits. Modes 0 and 1 use 2 mode bits and
+// the rest use 5.
+var bc6Modes = map[int]bc6Mode{
+	0x00: {true, 2, 10, [3]int{5, 5, 5}, bc6Layout("g2:4 b2:4 b3:4 r0:9-0 g0:9-0 b0:9-0 r1:4-0 g3:4 g2:3-0 g1:4-0 b3:0 g3:3-0 b1:4-0 b3:1 b2:3-0 r2:4-0 b3:2 r3:4-0 b3:3")},
+	0x01: {true, 2, 7, [3]int{6, 6, 6}, bc6Layout("g2:5 g3:4 g3:5 r0:6-0 b3:0 b3:1 b2:4 g0:6-0 b2:5 b3:2 g2:4 b0:6-0 b3:3 b3:5 b3:4 r1:5-0 g2:3-0 g1:5-0 g3:3-0 b1:5-0 b2:3-0 r2:5-0 r3:5-0")},
+	0x02: {true, 2, 11, [3]int{5, 4, 4}, bc6Layout("r0:9-0 g0:9-0 b0:9-0 r1:4-0 r0:10 g2:3-0 g1:3-0 g0:10 b3:0 g3:3-0 b1:3-0 b0:10 b3:1 b2:3-0 r2:4-0 b3:2 r3:4-0 b3:3")},
+	0x06: {true, 2, 11, [3]int{4, 5, 4}, bc6Layout("r0:9-0 g0:9-0 b0:9-0 r1:3-0 r0:10 g3:4 g2:3-0 g1:4-0 g0:10 g3:3-0 b1:3-0 b0:10 b3:1 b2:3-0 r2:3-0 b3:0 b3:2 r3:3-0 g2:4 b3:3")},
+	0x0a: {true, 2, 11, [3]int{4, 4, 5}, bc6Layout("r0:9-0 g0:9-0 b0:9-0 r1:3-0 r0:10 b2:4 g2:3-0 g1:3-0 g0:10 b3:0 g3:3-0 b1:4-0 b0:10 b2:3-0 r2:3-0 b3:1 b3:2 r3:3-0 b3:4 b3:3")},
+	0x0e: {true, 2, 9, [3]int{5, 5, 5}, bc6Layout("r0:8-0 b2:4 g0:8-0 g2:4 b0:8-0 b3:4 r1:4-0 g3:4 g2:3-0 g1:4-0 b3:0 g3:3-0 b1:4-0 b3:1 b2:3-0 r2:4-0 b3:2 r3:4-0 b3:3")},
+	0x12: {true, 2, 8, [3]int{6, 5, 5}, bc6Layout("r0:7-0 g3:4 b2:4 g0:7-0 b3:2 g2:4 b0:7-0 b3:3 b3:4 r1:5-0 g2:3-0 g1:4-0 b3:0 g3:3-0 b1:4-0 b3:1 b2:3-0 r2:5-0 r3:5-0")},
+	0x16: {true, 2, 8, [3]int{5, 6, 5}, bc6Layout("r0:7-0 b3:0 b2:4 g0:7-0 g2:5 g2:4 b0:7-0 g3:5 b3:4 r1:4-0 g3:4 g2:3-0 g1:5-0 g3:3-0 b1:4-0 b3:1 b2:3-0 r2:4-0 b3:2 r3:4-0 b3:3")},
+	0x1a: {true, 2, 8, [3]int{5, 5, 6}, bc6Layout("r0:7-0 b3:1 b2:4 g0:7-0 b2:5 g2:4 b0:7-0 b3:5 b3:4 r1:4-0 g3:4 g2:3-0 g1:4-0 b3:0 g3:3-0 b1:5-0 b2:3-0 r2:4-0 b3:2 r3:4-0 b3:3")},
+	0x1e: {false, 2, 6, [3]int{6, 6, 6}, bc6Layout("r0:5-0 g3:4 b3:0 b3:1 b2:4 g0:5-0 g2:5 b2:5 b3:2 g2:4 b0:5-0 g3:5 b3:3 b3:5 b3:4 r1:5-0 g2:3-0 g1:5-0 g3:3-0 b1:5-0 b2:3-0 r2:5-0 r3:5-0")},
+	0x03: {false, 1, 10, [3]int{10, 10, 10}, bc6Layout("r0:9-0 g0:9-0 b0:9-0 r1:9-0 g1:9-0 b1:9-0")},
+	0x07: {true, 1, 11, [3]int{9, 9, 9}, bc6Layout("r0:9-0 g0:9-0 b0:9-0 r1:8-0 r0:10 g1:8-0 g0:10 b1:8-0 b0:10")},
+	// The high bits of the base endpoint are stored in reverse order in the last two modes
+	0x0b: {true, 1, 12, [3]int{8, 8, 8}, bc6Layout("r0:9-0 g0:9-0 b0:9-0 r1:7-0 r0:11 r0:10 g1:7-0 g0:11 g0:10 b1:7-0 b0:11 b0:10")},
+	0x0f: {true, 1, 16, [3]int{4, 4, 4}, bc6Layout("r0:9-0 g0:9-0 b0:9-0 r1:3-0 r0:15 r0:14 r0:13 r0:12 r0:11 r0:10 g1:3-0 g0:15 g0:14 g0:13 g0:12 g0:11 g0:10 b1:3-0 b0:15 b0:14 b0:13 b0:12 b0:11 b0:10")},
+}
+
+func signExtend(value int, bits int) int {
+	shift := strconv.IntSize - bits
+	return value << shift >> shift
+}
+
+func bc6Unquantize(value int, bits int, signed bool) int {
+	if !signed {
+		switch {
+		case bits >= 15:
+			return value
+		case value == 0:
+			return 0
+		case value == 1<<bits-1:
+			return 0xffff
+		}
+		return (value<<16 + 0x8000) >> bits
+	}
+
+	if bits >= 16 {
+		return value
+	}
+
+	negative := value < 0
+	if negative {
+		value = -value
+	}
+
+	switch {
+	case value == 0:
+	case value >= 1<<(bits-1)-1:
+		value = 0x7fff
+	default:
+		value = (value<<15 + 0x4000) >> (bits - 1)
+	}
+
+	if negative {
+		return -value
+	}
+	return value
+}
+
+// bc6FinishUnquantize scales an interpolated value to the bits of a half float
+func bc6FinishUnquantize(value int, signed bool) uint16 {
+	if !signed {
+		return uint16(value * 31 >> 6)
+	}
+
+	if value < 0 {
+		return 0x8000 | uint16(-value*31>>5)
+	}
+	return uint16(value * 31 >> 5)
+}
+
+func decodeBC6(block []byte, out []byte, signed bool) {
+	bits := newBlockBits(block)
+
+	modeBits := bits.read(2)
+	if modeBits > 1 {
+		modeBits |= bits.read(3) << 2
+	}
+
+	mode, known := bc6Modes[modeBits]
+	if !known {
+		// Reserved modes decode to black
+		clear(out[:128])
+		return
+	}
+
+	var endpoints [4][3]int
+	for _, field := range mode.Fields {
+		endpoints[field.Endpoint][field.Channel] |= bits.read(field.Count) << field.Shift
+	}
+
+	partition := 0
+	indexBits := 4
+	if mode.Regions == 2 {
+		partition = bits.read(5)
+		indexBits = 3
+	}
+
+	endpointCount := mode.Regions * 2
+	mask := 1<<mode.EndpointBits - 1
+	for channel := 0; channel < 3; channel++ {
+		if signed {
+			endpoints[0][channel] = signExtend(endpoints[0][channel], mode.EndpointBits)
+		}
+
+		for endpoint := 1; endpoint < endpointCount; endpoint++ {
+			value := endpoints[endpoint][channel]
+			if mode.Transformed {
+				// Other endpoints are stored as signed deltas from the first one
+				value = (endpoints[0][channel] + signExtend(value, mode.DeltaBits[channel])) & mask
+			}
+			if signed {
+				value = signExtend(value, mode.EndpointBits)
+			}
+			endpoints[endpoint][channel] = value
+		}
+
+		for endpoint := 0; endpoint < endpointCount; endpoint++ {
+			endpoints[endpoint][channel] = bc6Unquantize(endpoints[endpoint][channel], mode.EndpointBits, signed)
+		}
+	}
+
+	for texel := 0; texel < 16; texel++ {
+		texelIndexBits := indexBits
+		if bptcIsAnchor(mode.Regions, partition, texel) {
+			texelIndexBits--
+		}
+		index := bits.read(texelIndexBits)
+
+		region := bptcSubset(mode.Regions, partition, texel)
+		for channel := 0; channel < 3; channel++ {
+			value := bptcInterpolate(endpoints[region*2][channel], endpoints[region*2+1][channel], indexBits, index)
+			binary.LittleEndian.PutUint16(out[texel*8+channel*2:], bc6FinishUnquantize(value, signed))
+		}
+
+		// Alpha is always 1.0
+		binary.LittleEndian.PutUint16(out[texel*8+6:], 0x3c00)
+	}
+}
+
+func decodeBC6Unsigned(block []byte, out []byte) {
+	decodeBC6(block, out, false)
+}
+
+func decodeBC6Signed(block []byte, out []byte) {
+	decodeBC6(block, out, true)
+}
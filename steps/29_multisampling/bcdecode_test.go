@@ -0,0 +1,747 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// bc1Block builds a BC1 color block from two 565 endpoints and a 2-bit index per texel
+func bc1Block(color0, color1 uint16, indices [16]int) []byte {
+	block := make([]byte, 8)
+	binary.LittleEndian.PutUint16(block[0:], color0)
+	binary.LittleEndian.PutUint16(block[2:], color1)
+
+	var packed uint32
+	for texel, index := range indices {
+		packed |= uint32(index) << (2 * texel)
+	}
+	binary.LittleEndian.PutUint32(block[4:], packed)
+	return block
+}
+
+// alphaBlock builds a BC3 alpha or BC4 channel block from two endpoints and a 3-bit
+// index per texel
+func alphaBlock(endpoint0, endpoint1 byte, indices [16]int) []byte {
+	block := []byte{endpoint0, endpoint1}
+
+	var packed uint64
+	for texel, index := range indices {
+		packed |= uint64(index) << (3 * texel)
+	}
+	for i := 0; i < 6; i++ {
+		block = append(block, byte(packed>>(8*i)))
+	}
+	return block
+}
+
+// explicitAlphaBlock builds a BC2 alpha block from a 4-bit alpha per texel
+func explicitAlphaBlock(alphas [16]int) []byte {
+	var packed uint64
+	for texel, alpha := range alphas {
+		packed |= uint64(alpha) << (4 * texel)
+	}
+	return binary.LittleEndian.AppendUint64(nil, packed)
+}
+
+func concatBlocks(blocks ...[]byte) []byte {
+	var joined []byte
+	for _, block := range blocks {
+		joined = append(joined, block...)
+	}
+	return joined
+}
+
+// texelIndices returns an index for every texel, picked by the texel's number
+func texelIndices(index func(texel int) int) [16]int {
+	var indices [16]int
+	for texel := range indices {
+		indices[texel] = index(texel)
+	}
+	return indices
+}
+
+const (
+	bc1Red  = 0xf800
+	bc1Blue = 0x001f
+)
+
+func TestBlockDecoders(t *testing.T) {
+	// Texels 0-3 pick palette entries 0-3, and the rest pick entry 0
+	firstFour := texelIndices(func(texel int) int {
+		if texel < 4 {
+			return texel
+		}
+		return 0
+	})
+	eachOfEight := texelIndices(func(texel int) int { return texel % 8 })
+
+	testCases := []struct {
+		name   string
+		decode blockDecoder
+		block  []byte
+		want   func(texel int) [4]byte
+	}{
+		{
+			// color0 > color1 selects four opaque colors, two of them interpolated
+			name:   "BC1FourColor",
+			decode: decodeBC1,
+			block:  bc1Block(bc1Red, bc1Blue, firstFour),
+			want: func(texel int) [4]byte {
+				return [][4]byte{{255, 0, 0, 255}, {0, 0, 255, 255}, {170, 0, 85, 255}, {85, 0, 170, 255}}[firstFour[texel]]
+			},
+		},
+		{
+			// color0 <= color1 selects three colors and transparent black
+			name:   "BC1ThreeColorTransparent",
+			decode: decodeBC1,
+			block:  bc1Block(bc1Blue, bc1Red, firstFour),
+			want: func(texel int) [4]byte {
+				return [][4]byte{{0, 0, 255, 255}, {255, 0, 0, 255}, {127, 0, 127, 255}, {0, 0, 0, 0}}[firstFour[texel]]
+			},
+		},
+		{
+			// 565 endpoints are widened by replicating their high bits
+			name:   "BC1Expand565",
+			decode: decodeBC1,
+			block:  bc1Block(0x8410, 0x8410, [16]int{}),
+			want:   func(texel int) [4]byte { return [4]byte{132, 130, 132, 255} },
+		},
+		{
+			// a0 > a1 interpolates six alphas, and BC3 color blocks are always four colors
+			name:   "BC3EightAlpha",
+			decode: decodeBC3,
+			block:  concatBlocks(alphaBlock(255, 0, eachOfEight), bc1Block(bc1Blue, bc1Red, texelIndices(func(int) int { return 3 }))),
+			want: func(texel int) [4]byte {
+				alphas := []byte{255, 0, 218, 182, 145, 109, 72, 36}
+				return [4]byte{170, 0, 85, alphas[texel%8]}
+			},
+		},
+		{
+			// a0 <= a1 interpolates four alphas and adds 0 and 255
+			name:   "BC3SixAlpha",
+			decode: decodeBC3,
+			block:  concatBlocks(alphaBlock(0, 255, eachOfEight), bc1Block(bc1Red, bc1Blue, [16]int{})),
+			want: func(texel int) [4]byte {
+				alphas := []byte{0, 255, 51, 102, 153, 204, 0, 255}
+				return [4]byte{255, 0, 0, alphas[texel%8]}
+			},
+		},
+		{
+			// BC2 stores a 4-bit alpha per texel, and its color blocks are always four colors
+			name:   "BC2",
+			decode: decodeBC2,
+			block:  concatBlocks(explicitAlphaBlock(texelIndices(func(texel int) int { return texel })), bc1Block(bc1Blue, bc1Red, firstFour)),
+			want: func(texel int) [4]byte {
+				color := [][4]byte{{0, 0, 255}, {255, 0, 0}, {85, 0, 170}, {170, 0, 85}}[firstFour[texel]]
+				color[3] = byte(texel * 17)
+				return color
+			},
+		},
+		{
+			name:   "BC4UnsignedEight",
+			decode: decodeBC4Unsigned,
+			block:  alphaBlock(200, 100, eachOfEight),
+			want: func(texel int) [4]byte {
+				reds := []byte{200, 100, 185, 171, 157, 142, 128, 114}
+				return [4]byte{reds[texel%8], 0, 0, 255}
+			},
+		},
+		{
+			name:   "BC4UnsignedSix",
+			decode: decodeBC4Unsigned,
+			block:  alphaBlock(100, 200, eachOfEight),
+			want: func(texel int) [4]byte {
+				reds := []byte{100, 200, 120, 140, 160, 180, 0, 255}
+				return [4]byte{reds[texel%8], 0, 0, 255}
+			},
+		},
+		{
+			// 100 > -100 interpolates six values, rounding toward zero
+			name:   "BC4Signed",
+			decode: decodeBC4Signed,
+			block:  alphaBlock(100, 0x9c, eachOfEight),
+			want: func(texel int) [4]byte {
+				reds := []int8{100, -100, 71, 42, 14, -14, -42, -71}
+				return [4]byte{byte(reds[texel%8]), 0, 0, 0x7f}
+			},
+		},
+		{
+			name:   "BC5Unsigned",
+			decode: decodeBC5Unsigned,
+			block:  concatBlocks(alphaBlock(200, 100, eachOfEight), alphaBlock(0, 0, texelIndices(func(int) int { return 7 }))),
+			want: func(texel int) [4]byte {
+				reds := []byte{200, 100, 185, 171, 157, 142, 128, 114}
+				return [4]byte{reds[texel%8], 255, 0, 255}
+			},
+		},
+		{
+			// -128 is clamped to -127, and the extra entries are -127 and 127
+			name:   "BC5Signed",
+			decode: decodeBC5Signed,
+			block:  concatBlocks(alphaBlock(0x80, 127, eachOfEight), alphaBlock(5, 5, [16]int{})),
+			want: func(texel int) [4]byte {
+				reds := []int8{-127, 127, -76, -25, 25, 76, -127, 127}
+				return [4]byte{byte(reds[texel%8]), 5, 0, 0x7f}
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			out := make([]byte, 64)
+			testCase.decode(testCase.block, out)
+
+			for texel := 0; texel < 16; texel++ {
+				got := [4]byte(out[texel*4 : texel*4+4])
+				if want := testCase.want(texel); got != want {
+					t.Errorf("texel %d is %v, expected %v", texel, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecompressTexture(t *testing.T) {
+	// A 6x5 texture is 2x2 blocks, so the right and bottom blocks hang off the image.
+	// Each block is a solid color, and the 3x2 second level is a single block.
+	blockColors := []uint16{bc1Red, bc1Blue, 0x07e0, 0xffff}
+	var level0 []byte
+	for _, color := range blockColors {
+		level0 = append(level0, bc1Block(color, color, [16]int{})...)
+	}
+	texture := &textureData{
+		Format: core1_0.FormatBC1_RGBAUnsignedNormalized,
+		Width:  6,
+		Height: 5,
+		Levels: [][]byte{level0, bc1Block(bc1Blue, bc1Blue, [16]int{})},
+	}
+
+	decompressed, err := decompressTexture(texture)
+	if err != nil {
+		t.Fatalf("decompressTexture: %+v", err)
+	}
+
+	if decompressed.Format != core1_0.FormatR8G8B8A8UnsignedNormalized || decompressed.Width != 6 || decompressed.Height != 5 {
+		t.Fatalf("got a %dx%d %s texture, expected 6x5 %s", decompressed.Width, decompressed.Height, decompressed.Format, core1_0.FormatR8G8B8A8UnsignedNormalized)
+	}
+	if len(decompressed.Levels) != 2 || len(decompressed.Levels[0]) != 6*5*4 || len(decompressed.Levels[1]) != 3*2*4 {
+		t.Fatalf("got levels of %d bytes, expected %d and %d", len(decompressed.Levels), 6*5*4, 3*2*4)
+	}
+
+	expand := func(color uint16) [4]byte {
+		rgb := expand565(color)
+		return [4]byte{byte(rgb[0]), byte(rgb[1]), byte(rgb[2]), 255}
+	}
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 6; x++ {
+			got := [4]byte(decompressed.Levels[0][(y*6+x)*4:])
+			if want := expand(blockColors[(y/4)*2+x/4]); got != want {
+				t.Errorf("level 0 texel (%d, %d) is %v, expected %v", x, y, got, want)
+			}
+		}
+	}
+	for i := 0; i < 3*2; i++ {
+		got := [4]byte(decompressed.Levels[1][i*4:])
+		if want := expand(bc1Blue); got != want {
+			t.Errorf("level 1 texel %d is %v, expected %v", i, got, want)
+		}
+	}
+
+	_, err = decompressTexture(&textureData{Format: core1_0.FormatR8G8B8A8SRGB, Width: 1, Height: 1})
+	if err == nil {
+		t.Error("decompressTexture accepted an uncompressed texture, expected an error")
+	}
+}
+
+// bitWriter packs fields into a 128-bit BPTC block, least significant bit first
+type bitWriter struct {
+	block []byte
+	pos   int
+}
+
+func (w *bitWriter) write(value, count int) {
+	if w.block == nil {
+		w.block = make([]byte, 16)
+	}
+	for i := 0; i < count; i++ {
+		if value>>i&1 != 0 {
+			w.block[w.pos/8] |= 1 << (w.pos % 8)
+		}
+		w.pos++
+	}
+}
+
+// writeEach writes every value with the same number of bits
+func (w *bitWriter) writeEach(count int, values ...int) {
+	for _, value := range values {
+		w.write(value, count)
+	}
+}
+
+// writeField writes bits high to low of value, for the BC6H layouts that split endpoints up
+func (w *bitWriter) writeField(value, high, low int) {
+	w.write(value>>low, high-low+1)
+}
+
+// writeIndices writes an index per texel. Anchor texels drop the high bit of their index.
+func (w *bitWriter) writeIndices(indices [16]int, count int, anchors ...int) {
+	for texel, index := range indices {
+		if texel == 0 || slices.Contains(anchors, texel) {
+			w.write(index, count-1)
+		} else {
+			w.write(index, count)
+		}
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.pos != 128 {
+		panic(fmt.Sprintf("bitWriter: wrote %d bits, expected 128", w.pos))
+	}
+	return w.block
+}
+
+// bptcIndices gives texel i the index i, wrapped to fit in count bits, or in one bit less
+// for anchor texels
+func bptcIndices(count int, anchors ...int) [16]int {
+	return texelIndices(func(texel int) int {
+		if texel == 0 || slices.Contains(anchors, texel) {
+			return texel % (1 << (count - 1))
+		}
+		return texel % (1 << count)
+	})
+}
+
+// bptcTestWeights are the interpolation weights for 2, 3 and 4 bit indices from the
+// specification
+var bptcTestWeights = map[int][]int{
+	2: {0, 21, 43, 64},
+	3: {0, 9, 18, 27, 37, 46, 55, 64},
+	4: {0, 4, 9, 13, 17, 21, 26, 30, 34, 38, 43, 47, 51, 55, 60, 64},
+}
+
+// bc7Expectation describes what a BC7 block should decode to. Endpoints are given after
+// p-bits have been added and they have been expanded to 8 bits.
+type bc7Expectation struct {
+	subsets      [16]int
+	endpoints    [][2][4]int
+	colorBits    int
+	colorIndices [16]int
+	alphaBits    int
+	alphaIndices [16]int
+	rotation     int
+}
+
+func (e bc7Expectation) texel(texel int) [4]byte {
+	endpoints := e.endpoints[e.subsets[texel]]
+	lerp := func(channel int, weight int) int {
+		return ((64-weight)*endpoints[0][channel] + weight*endpoints[1][channel] + 32) >> 6
+	}
+
+	var color [4]int
+	for channel := 0; channel < 3; channel++ {
+		color[channel] = lerp(channel, bptcTestWeights[e.colorBits][e.colorIndices[texel]])
+	}
+	if e.alphaBits == 0 {
+		color[3] = lerp(3, bptcTestWeights[e.colorBits][e.colorIndices[texel]])
+	} else {
+		color[3] = lerp(3, bptcTestWeights[e.alphaBits][e.alphaIndices[texel]])
+	}
+	if e.rotation > 0 {
+		color[3], color[e.rotation-1] = color[e.rotation-1], color[3]
+	}
+
+	return [4]byte{byte(color[0]), byte(color[1]), byte(color[2]), byte(color[3])}
+}
+
+func TestDecodeBC7(t *testing.T) {
+	// Partitions from the specification's tables, with the anchors of their other subsets
+	partition3Of1 := [16]int{0, 0, 0, 1, 0, 0, 1, 1, 2, 2, 1, 1, 2, 2, 2, 1}
+	partition3Of17 := [16]int{0, 1, 1, 1, 0, 0, 1, 1, 2, 0, 0, 1, 2, 2, 0, 0}
+	partition2Of13 := [16]int{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 1, 1, 1, 1}
+	partition2Of35 := [16]int{0, 0, 1, 1, 0, 0, 1, 1, 1, 1, 0, 0, 1, 1, 0, 0}
+	partition2Of61 := [16]int{0, 0, 1, 1, 0, 0, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0}
+
+	rows := texelIndices(func(texel int) int { return texel / 4 })
+	columns := texelIndices(func(texel int) int { return texel % 4 })
+
+	testCases := []struct {
+		name   string
+		block  func(w *bitWriter)
+		expect bc7Expectation
+	}{
+		{
+			// Three subsets of 4-bit colors with a p-bit per endpoint
+			name: "Mode0",
+			block: func(w *bitWriter) {
+				w.write(1, 1)
+				w.write(1, 4)
+				w.writeEach(4, 0, 15, 15, 15, 0, 0)
+				w.writeEach(4, 0, 15, 0, 7, 0, 15)
+				w.writeEach(4, 0, 15, 0, 0, 15, 0)
+				w.writeEach(1, 0, 1, 1, 1, 0, 0)
+				w.writeIndices(bptcIndices(3, 3, 8), 3, 3, 8)
+			},
+			expect: bc7Expectation{
+				subsets: partition3Of1,
+				endpoints: [][2][4]int{
+					{{0, 0, 0, 255}, {255, 255, 255, 255}},
+					{{255, 8, 8, 255}, {255, 123, 8, 255}},
+					{{0, 0, 247, 255}, {0, 247, 0, 255}},
+				},
+				colorBits: 3, colorIndices: bptcIndices(3, 3, 8),
+			},
+		},
+		{
+			// Two subsets of 6-bit colors with a p-bit per subset. Partitions past 31 are only
+			// used by BC7.
+			name: "Mode1",
+			block: func(w *bitWriter) {
+				w.write(2, 2)
+				w.write(35, 6)
+				w.writeEach(6, 0, 63, 63, 0)
+				w.writeEach(6, 0, 63, 0, 63)
+				w.writeEach(6, 0, 63, 32, 32)
+				w.writeEach(1, 0, 1)
+				w.writeIndices(bptcIndices(3, 8), 3, 8)
+			},
+			expect: bc7Expectation{
+				subsets: partition2Of35,
+				endpoints: [][2][4]int{
+					{{0, 0, 0, 255}, {253, 253, 253, 255}},
+					{{255, 2, 131, 255}, {2, 255, 131, 255}},
+				},
+				colorBits: 3, colorIndices: bptcIndices(3, 8),
+			},
+		},
+		{
+			// Three subsets of 5-bit colors without p-bits
+			name: "Mode2",
+			block: func(w *bitWriter) {
+				w.write(4, 3)
+				w.write(17, 6)
+				w.writeEach(5, 0, 31, 31, 31, 8, 8)
+				w.writeEach(5, 0, 31, 0, 31, 0, 0)
+				w.writeEach(5, 0, 31, 16, 16, 0, 31)
+				w.writeIndices(bptcIndices(2, 3, 8), 2, 3, 8)
+			},
+			expect: bc7Expectation{
+				subsets: partition3Of17,
+				endpoints: [][2][4]int{
+					{{0, 0, 0, 255}, {255, 255, 255, 255}},
+					{{255, 0, 132, 255}, {255, 255, 132, 255}},
+					{{66, 0, 0, 255}, {66, 0, 255, 255}},
+				},
+				colorBits: 2, colorIndices: bptcIndices(2, 3, 8),
+			},
+		},
+		{
+			// Two subsets of 7-bit colors with a p-bit per endpoint
+			name: "Mode3",
+			block: func(w *bitWriter) {
+				w.write(8, 4)
+				w.write(61, 6)
+				w.writeEach(7, 0, 127, 100, 0)
+				w.writeEach(7, 0, 127, 50, 50)
+				w.writeEach(7, 0, 127, 0, 100)
+				w.writeEach(1, 0, 1, 1, 0)
+				w.writeIndices(bptcIndices(2, 2), 2, 2)
+			},
+			expect: bc7Expectation{
+				subsets: partition2Of61,
+				endpoints: [][2][4]int{
+					{{0, 0, 0, 255}, {255, 255, 255, 255}},
+					{{201, 101, 1, 255}, {0, 100, 200, 255}},
+				},
+				colorBits: 2, colorIndices: bptcIndices(2, 2),
+			},
+		},
+		{
+			// Separate 2-bit color and 3-bit alpha indices
+			name: "Mode4",
+			block: func(w *bitWriter) {
+				w.write(16, 5)
+				w.write(0, 2)
+				w.write(0, 1)
+				w.writeEach(5, 0, 31)
+				w.writeEach(5, 31, 0)
+				w.writeEach(5, 0, 16)
+				w.writeEach(6, 0, 63)
+				w.writeIndices(rows, 2)
+				w.writeIndices(bptcIndices(3), 3)
+			},
+			expect: bc7Expectation{
+				endpoints: [][2][4]int{{{0, 255, 0, 0}, {255, 0, 132, 255}}},
+				colorBits: 2, colorIndices: rows,
+				alphaBits: 3, alphaIndices: bptcIndices(3),
+			},
+		},
+		{
+			// The index selection bit swaps the index sets, so color uses the 3-bit indices,
+			// and rotation 1 swaps red and alpha
+			name: "Mode4RotatedAndSelected",
+			block: func(w *bitWriter) {
+				w.write(16, 5)
+				w.write(1, 2)
+				w.write(1, 1)
+				w.writeEach(5, 0, 31)
+				w.writeEach(5, 31, 0)
+				w.writeEach(5, 0, 16)
+				w.writeEach(6, 0, 63)
+				w.writeIndices(rows, 2)
+				w.writeIndices(bptcIndices(3), 3)
+			},
+			expect: bc7Expectation{
+				endpoints: [][2][4]int{{{0, 255, 0, 0}, {255, 0, 132, 255}}},
+				colorBits: 3, colorIndices: bptcIndices(3),
+				alphaBits: 2, alphaIndices: rows,
+				rotation: 1,
+			},
+		},
+		{
+			// 7-bit colors and 8-bit alpha with separate 2-bit indices, and rotation 3 swaps
+			// blue and alpha
+			name: "Mode5",
+			block: func(w *bitWriter) {
+				w.write(32, 6)
+				w.write(3, 2)
+				w.writeEach(7, 127, 0)
+				w.writeEach(7, 0, 127)
+				w.writeEach(7, 64, 64)
+				w.writeEach(8, 0, 200)
+				w.writeIndices(columns, 2)
+				w.writeIndices(rows, 2)
+			},
+			expect: bc7Expectation{
+				endpoints: [][2][4]int{{{255, 0, 129, 0}, {0, 255, 129, 200}}},
+				colorBits: 2, colorIndices: columns,
+				alphaBits: 2, alphaIndices: rows,
+				rotation: 3,
+			},
+		},
+		{
+			// One subset of 7-bit color and alpha with a p-bit per endpoint and 4-bit indices
+			name: "Mode6",
+			block: func(w *bitWriter) {
+				w.write(64, 7)
+				w.writeEach(7, 0, 127)
+				w.writeEach(7, 0, 64)
+				w.writeEach(7, 0, 0)
+				w.writeEach(7, 0, 127)
+				w.writeEach(1, 0, 1)
+				w.writeIndices(bptcIndices(4), 4)
+			},
+			expect: bc7Expectation{
+				endpoints: [][2][4]int{{{0, 0, 0, 0}, {255, 129, 1, 255}}},
+				colorBits: 4, colorIndices: bptcIndices(4),
+			},
+		},
+		{
+			// Two subsets of 5-bit color and alpha with a p-bit per endpoint
+			name: "Mode7",
+			block: func(w *bitWriter) {
+				w.write(128, 8)
+				w.write(13, 6)
+				w.writeEach(5, 0, 31, 31, 0)
+				w.writeEach(5, 0, 31, 0, 0)
+				w.writeEach(5, 0, 31, 0, 31)
+				w.writeEach(5, 0, 31, 31, 16)
+				w.writeEach(1, 0, 1, 0, 1)
+				w.writeIndices(bptcIndices(2, 15), 2, 15)
+			},
+			expect: bc7Expectation{
+				subsets: partition2Of13,
+				endpoints: [][2][4]int{
+					{{0, 0, 0, 0}, {255, 255, 255, 255}},
+					{{251, 0, 0, 251}, {4, 4, 255, 134}},
+				},
+				colorBits: 2, colorIndices: bptcIndices(2, 15),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var w bitWriter
+			testCase.block(&w)
+
+			out := make([]byte, 64)
+			decodeBC7(w.bytes(), out)
+			for texel := 0; texel < 16; texel++ {
+				got := [4]byte(out[texel*4 : texel*4+4])
+				if want := testCase.expect.texel(texel); got != want {
+					t.Errorf("texel %d is %v, expected %v", texel, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Reserved", func(t *testing.T) {
+		out := bytes.Repeat([]byte{0xff}, 64)
+		decodeBC7(make([]byte, 16), out)
+		if !bytes.Equal(out, make([]byte, 64)) {
+			t.Errorf("got %v, expected transparent black", out)
+		}
+	})
+}
+
+func TestDecodeBC6(t *testing.T) {
+	// Half floats of ramps between the endpoints, worked out from the specification's
+	// unquantize and interpolation steps
+	oneRegionUnsigned := []uint16{0x0000, 0x03c0, 0x0870, 0x0c30, 0x0ff0, 0x13b0, 0x1860, 0x1c20, 0x1fe0, 0x23a0, 0x2850, 0x2c10, 0x2fd0, 0x3390, 0x3840, 0x3c00}
+	oneRegionSigned := []uint16{0xfbff, 0xec7f, 0xd91f, 0xc99f, 0xba20, 0xaaa0, 0x9740, 0x87c0, 0x07c0, 0x1740, 0x2aa0, 0x3a20, 0x499f, 0x591f, 0x6c7f, 0x7bff}
+	transformed := []uint16{0x5ad9, 0x5a18, 0x5925, 0x5864, 0x57a2, 0x56e0, 0x55ee, 0x552c, 0x546a, 0x53a9, 0x52b6, 0x51f5, 0x5133, 0x5071, 0x4f7f, 0x4ebd}
+	twoRegionsUnsigned := [2][]uint16{
+		{0x0000, 0x1170, 0x22e0, 0x3450, 0x47af, 0x591f, 0x6a8f, 0x7bff},
+		{0x0000, 0x0b08, 0x1611, 0x211a, 0x2d5d, 0x3866, 0x436f, 0x4e78},
+	}
+	twoRegionsSigned := [2][]uint16{
+		{0xfbff, 0xd91f, 0xb640, 0x9360, 0x1360, 0x3640, 0x591f, 0x7bff},
+		{0xcf70, 0xc444, 0xb918, 0xadec, 0xa183, 0x9657, 0x8b2b, 0x0000},
+	}
+
+	// Mode 0x03 has one region of 10-bit endpoints stored as they are
+	oneRegion := func(endpoints [2][3]int) func(w *bitWriter) {
+		return func(w *bitWriter) {
+			w.write(0x03, 5)
+			for _, endpoint := range endpoints {
+				w.writeEach(10, endpoint[:]...)
+			}
+			w.writeIndices(bptcIndices(4), 4)
+		}
+	}
+
+	// Mode 0x1e has two regions of 6-bit endpoints, stored as they are but with the bits of
+	// the second region spread through the block
+	twoRegions := func(endpoints [4][3]int) func(w *bitWriter) {
+		r, g, b := 0, 1, 2
+		return func(w *bitWriter) {
+			w.write(0x1e, 5)
+			w.writeField(endpoints[0][r], 5, 0)
+			w.writeField(endpoints[3][g], 4, 4)
+			w.writeField(endpoints[3][b], 0, 0)
+			w.writeField(endpoints[3][b], 1, 1)
+			w.writeField(endpoints[2][b], 4, 4)
+			w.writeField(endpoints[0][g], 5, 0)
+			w.writeField(endpoints[2][g], 5, 5)
+			w.writeField(endpoints[2][b], 5, 5)
+			w.writeField(endpoints[3][b], 2, 2)
+			w.writeField(endpoints[2][g], 4, 4)
+			w.writeField(endpoints[0][b], 5, 0)
+			w.writeField(endpoints[3][g], 5, 5)
+			w.writeField(endpoints[3][b], 3, 3)
+			w.writeField(endpoints[3][b], 5, 5)
+			w.writeField(endpoints[3][b], 4, 4)
+			w.writeField(endpoints[1][r], 5, 0)
+			w.writeField(endpoints[2][g], 3, 0)
+			w.writeField(endpoints[1][g], 5, 0)
+			w.writeField(endpoints[3][g], 3, 0)
+			w.writeField(endpoints[1][b], 5, 0)
+			w.writeField(endpoints[2][b], 3, 0)
+			w.writeField(endpoints[2][r], 5, 0)
+			w.writeField(endpoints[3][r], 5, 0)
+			// Partition 13 puts the bottom two rows in the second region
+			w.write(13, 5)
+			w.writeIndices(bptcIndices(3, 15), 3, 15)
+		}
+	}
+	oneRegionIndices := bptcIndices(4)
+	twoRegionIndices := bptcIndices(3, 15)
+
+	testCases := []struct {
+		name   string
+		decode blockDecoder
+		block  func(w *bitWriter)
+		want   func(texel int) [3]uint16
+	}{
+		{
+			// 495 unquantizes to exactly 1.0
+			name:   "OneRegionUnsigned",
+			decode: decodeBC6Unsigned,
+			block:  oneRegion([2][3]int{{0, 495, 0}, {495, 495, 0}}),
+			want: func(texel int) [3]uint16 {
+				return [3]uint16{oneRegionUnsigned[oneRegionIndices[texel]], 0x3c00, 0}
+			},
+		},
+		{
+			// -511 and 511 are the largest magnitudes, and 247 falls just short of 1.0
+			name:   "OneRegionSigned",
+			decode: decodeBC6Signed,
+			block:  oneRegion([2][3]int{{-511, 247, -247}, {511, 247, -247}}),
+			want: func(texel int) [3]uint16 {
+				return [3]uint16{oneRegionSigned[oneRegionIndices[texel]], 0x3bf1, 0xbbf1}
+			},
+		},
+		{
+			// Mode 0x07 stores an 11-bit base endpoint and a 9-bit delta to the other one
+			name:   "Transformed",
+			decode: decodeBC6Unsigned,
+			block: func(w *bitWriter) {
+				w.write(0x07, 5)
+				w.writeField(1500, 9, 0)
+				w.writeField(1500, 9, 0)
+				w.writeField(0, 9, 0)
+				w.writeField(-200, 8, 0)
+				w.writeField(1500, 10, 10)
+				w.writeField(0, 8, 0)
+				w.writeField(1500, 10, 10)
+				w.writeField(0, 8, 0)
+				w.writeField(0, 10, 10)
+				w.writeIndices(bptcIndices(4), 4)
+			},
+			want: func(texel int) [3]uint16 {
+				return [3]uint16{transformed[oneRegionIndices[texel]], 0x5ad9, 0}
+			},
+		},
+		{
+			name:   "TwoRegionsUnsigned",
+			decode: decodeBC6Unsigned,
+			block:  twoRegions([4][3]int{{0, 0, 63}, {63, 0, 63}, {32, 0, 16}, {32, 40, 16}}),
+			want: func(texel int) [3]uint16 {
+				index := twoRegionIndices[texel]
+				if texel < 8 {
+					return [3]uint16{twoRegionsUnsigned[0][index], 0, 0x7bff}
+				}
+				return [3]uint16{0x3ef8, twoRegionsUnsigned[1][index], 0x1ff8}
+			},
+		},
+		{
+			name:   "TwoRegionsSigned",
+			decode: decodeBC6Signed,
+			block:  twoRegions([4][3]int{{-31, 0, 31}, {31, 0, 31}, {10, -20, -5}, {10, 0, -5}}),
+			want: func(texel int) [3]uint16 {
+				index := twoRegionIndices[texel]
+				if texel < 8 {
+					return [3]uint16{twoRegionsSigned[0][index], 0, 0x7bff}
+				}
+				return [3]uint16{0x28b0, twoRegionsSigned[1][index], 0x9550}
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var w bitWriter
+			testCase.block(&w)
+
+			out := make([]byte, 128)
+			testCase.decode(w.bytes(), out)
+			for texel := 0; texel < 16; texel++ {
+				var got [4]uint16
+				for channel := range got {
+					got[channel] = binary.LittleEndian.Uint16(out[texel*8+channel*2:])
+				}
+
+				want := testCase.want(texel)
+				if [4]uint16{want[0], want[1], want[2], 0x3c00} != got {
+					t.Errorf("texel %d is %04x, expected %04x with an alpha of 1.0", texel, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"image/color"
+	"log"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+	"github.com/vkngwrapper/core/v2/driver"
+	"github.com/vkngwrapper/extensions/v2/ext_debug_utils"
+	"github.com/vkngwrapper/extensions/v2/khr_swapchain"
+)
+
+var (
+	uploadLabelColor = color.RGBA{R: 0xe0, G: 0xa0, B: 0x40, A: 0xff}
+	passLabelColor   = color.RGBA{R: 0x40, G: 0x90, B: 0xe0, A: 0xff}
+)
+
+// debugUtils names objects and labels command buffers, so that validation messages and
+// graphics debuggers show what an object is instead of a raw handle. A nil *debugUtils is
+// valid and does nothing, for when ext_debug_utils isn't enabled.
+type debugUtils struct {
+	extension ext_debug_utils.Extension
+	device    core1_0.Device
+}
+
+func newDebugUtils(extension ext_debug_utils.Extension, device core1_0.Device) *debugUtils {
+	if extension == nil {
+		return nil
+	}
+
+	return &debugUtils{
+		extension: extension,
+		device:    device,
+	}
+}
+
+func debugHandle(object any) (core1_0.ObjectType, driver.VulkanHandle, bool) {
+	switch o := object.(type) {
+	case core1_0.Queue:
+		return core1_0.ObjectTypeQueue, driver.VulkanHandle(o.Handle()), true
+	case core1_0.Semaphore:
+		return core1_0.ObjectTypeSemaphore, driver.VulkanHandle(o.Handle()), true
+	case core1_0.Fence:
+		return core1_0.ObjectTypeFence, driver.VulkanHandle(o.Handle()), true
+	case core1_0.CommandPool:
+		return core1_0.ObjectTypeCommandPool, driver.VulkanHandle(o.Handle()), true
+	case core1_0.CommandBuffer:
+		return core1_0.ObjectTypeCommandBuffer, driver.VulkanHandle(o.Handle()), true
+	case core1_0.DeviceMemory:
+		return core1_0.ObjectTypeDeviceMemory, driver.VulkanHandle(o.Handle()), true
+	case core1_0.Buffer:
+		return core1_0.ObjectTypeBuffer, driver.VulkanHandle(o.Handle()), true
+	case core1_0.Image:
+		return core1_0.ObjectTypeImage, driver.VulkanHandle(o.Handle()), true
+	case core1_0.ImageView:
+		return core1_0.ObjectTypeImageView, driver.VulkanHandle(o.Handle()), true
+	case core1_0.QueryPool:
+		return core1_0.ObjectTypeQueryPool, driver.VulkanHandle(o.Handle()), true
+	case core1_0.Sampler:
+		return core1_0.ObjectTypeSampler, driver.VulkanHandle(o.Handle()), true
+	case core1_0.ShaderModule:
+		return core1_0.ObjectTypeShaderModule, driver.VulkanHandle(o.Handle()), true
+	case core1_0.PipelineLayout:
+		return core1_0.ObjectTypePipelineLayout, driver.VulkanHandle(o.Handle()), true
+	case core1_0.Pipeline:
+		return core1_0.ObjectTypePipeline, driver.VulkanHandle(o.Handle()), true
+	case core1_0.RenderPass:
+		return core1_0.ObjectTypeRenderPass, driver.VulkanHandle(o.Handle()), true
+	case core1_0.Framebuffer:
+		return core1_0.ObjectTypeFramebuffer, driver.VulkanHandle(o.Handle()), true
+	case core1_0.DescriptorSetLayout:
+		return core1_0.ObjectTypeDescriptorSetLayout, driver.VulkanHandle(o.Handle()), true
+	case core1_0.DescriptorPool:
+		return core1_0.ObjectTypeDescriptorPool, driver.VulkanHandle(o.Handle()), true
+	case core1_0.DescriptorSet:
+		return core1_0.ObjectTypeDescriptorSet, driver.VulkanHandle(o.Handle()), true
+	case khr_swapchain.Swapchain:
+		return khr_swapchain.ObjectTypeSwapchain, driver.VulkanHandle(o.Handle()), true
+	}
+
+	return core1_0.ObjectTypeUnknown, driver.NullHandle, false
+}
+
+// Name sets the debug name of a Vulkan object. Failing to name something isn't worth
+// stopping for, so failures are only logged.
+func (d *debugUtils) Name(object any, name string) {
+	if d == nil {
+		return
+	}
+
+	objectType, handle, ok := debugHandle(object)
+	if !ok {
+		log.Printf("debugUtils: can't name '%s'- unsupported object type %T", name, object)
+		return
+	}
+
+	_, err := d.extension.SetDebugUtilsObjectName(d.device, ext_debug_utils.DebugUtilsObjectNameInfo{
+		ObjectName:   name,
+		ObjectHandle: handle,
+		ObjectType:   objectType,
+	})
+	if err != nil {
+		log.Printf("debugUtils: failed to name '%s': %v", name, err)
+	}
+}
+
+// BeginLabel opens a labeled region in a command buffer, which must be closed with EndLabel
+func (d *debugUtils) BeginLabel(buffer core1_0.CommandBuffer, name string, labelColor color.Color) {
+	if d == nil {
+		return
+	}
+
+	err := d.extension.CmdBeginDebugUtilsLabel(buffer, ext_debug_utils.DebugUtilsLabel{
+		LabelName: name,
+		Color:     labelColor,
+	})
+	if err != nil {
+		log.Printf("debugUtils: failed to begin label '%s': %v", name, err)
+	}
+}
+
+func (d *debugUtils) EndLabel(buffer core1_0.CommandBuffer) {
+	if d == nil {
+		return
+	}
+
+	d.extension.CmdEndDebugUtilsLabel(buffer)
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// spanFenceWait is the span name for time spent blocked on fences, which is totalled per
+// frame
+const spanFenceWait = "fence wait"
+
+// traceEventLimit caps how many events a trace keeps, so a long run can't grow it forever
+const traceEventLimit = 1 << 20
+
+type FrameStatsOutput int
+
+const (
+	FrameStatsTitle FrameStatsOutput = iota
+	FrameStatsLog
+	FrameStatsNone
+)
+
+func parseFrameStatsOutput(output string) (FrameStatsOutput, error) {
+	switch output {
+	case "title":
+		return FrameStatsTitle, nil
+	case "log":
+		return FrameStatsLog, nil
+	case "none":
+		return FrameStatsNone, nil
+	}
+
+	return 0, errors.Errorf("unknown frame stats output '%s'- expected title, log or none", output)
+}
+
+// traceEvent is a complete event in the Chrome trace event format, with times in
+// microseconds
+type traceEvent struct {
+	Name      string  `json:"name"`
+	Category  string  `json:"cat"`
+	Phase     string  `json:"ph"`
+	Timestamp float64 `json:"ts"`
+	Duration  float64 `json:"dur"`
+	PID       int     `json:"pid"`
+	TID       int     `json:"tid"`
+}
+
+type frameSpan struct {
+	name  string
+	start time.Time
+}
+
+// frameTimer times CPU spans within each frame. It keeps rolling frame time statistics,
+// and when tracing, records every span for a Chrome trace.
+type frameTimer struct {
+	origin  time.Time
+	tracing bool
+	events  []traceEvent
+	dropped bool
+
+	frameStart     time.Time
+	frameFenceWait time.Duration
+	frameTimes     durationWindow
+	fenceWaits     durationWindow
+
+	reportStart  time.Time
+	reportFrames int
+}
+
+func newFrameTimer(tracing bool) *frameTimer {
+	now := time.Now()
+	return &frameTimer{
+		origin:      now,
+		tracing:     tracing,
+		reportStart: now,
+	}
+}
+
+func (t *frameTimer) record(name string, category string, start time.Time, duration time.Duration) {
+	if !t.tracing {
+		return
+	}
+	if len(t.events) >= traceEventLimit {
+		if !t.dropped {
+			log.Printf("trace is full at %d events- later spans are dropped", traceEventLimit)
+			t.dropped = true
+		}
+		return
+	}
+
+	t.events = append(t.events, traceEvent{
+		Name:      name,
+		Category:  category,
+		Phase:     "X",
+		Timestamp: float64(start.Sub(t.origin).Nanoseconds()) / 1000,
+		Duration:  float64(duration.Nanoseconds()) / 1000,
+		PID:       1,
+		TID:       1,
+	})
+}
+
+func (t *frameTimer) BeginFrame() {
+	t.frameStart = time.Now()
+	t.frameFenceWait = 0
+}
+
+// Begin starts a span, which is recorded when it's passed to End. Spans that are never
+// ended, such as on an error path, are dropped.
+func (t *frameTimer) Begin(name string) frameSpan {
+	return frameSpan{name: name, start: time.Now()}
+}
+
+func (t *frameTimer) End(span frameSpan) {
+	duration := time.Since(span.start)
+	if span.name == spanFenceWait {
+		t.frameFenceWait += duration
+	}
+	t.record(span.name, "cpu", span.start, duration)
+}
+
+// EndFrame finishes the frame started by BeginFrame. About once a second, it returns a
+// summary of the frames since the last one.
+func (t *frameTimer) EndFrame() (string, bool) {
+	now := time.Now()
+	duration := now.Sub(t.frameStart)
+	t.frameTimes.Add(duration)
+	t.fenceWaits.Add(t.frameFenceWait)
+	t.record("frame", "frame", t.frameStart, duration)
+
+	t.reportFrames++
+	elapsed := now.Sub(t.reportStart)
+	if elapsed < time.Second {
+		return "", false
+	}
+
+	fps := float64(t.reportFrames) / elapsed.Seconds()
+	t.reportStart = now
+	t.reportFrames = 0
+
+	frames := t.frameTimes.Stats()
+	fenceWaits := t.fenceWaits.Stats()
+	return fmt.Sprintf("%.0f fps, frame p50 %s p95 %s p99 %s, fence wait avg %s",
+		fps,
+		frames.P50.Round(time.Microsecond),
+		frames.P95.Round(time.Microsecond),
+		frames.P99.Round(time.Microsecond),
+		fenceWaits.Average.Round(time.Microsecond),
+	), true
+}
+
+// WriteTrace writes the recorded spans as a Chrome trace, which chrome://tracing and
+// Perfetto can open
+func (t *frameTimer) WriteTrace(path string) error {
+	events := t.events
+	if events == nil {
+		events = []traceEvent{}
+	}
+
+	data, err := json.Marshal(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{
+		TraceEvents:     events,
+		DisplayTimeUnit: "ms",
+	})
+	if err != nil {
+		return errors.Wrap(err, "frameTimer: failed to encode trace")
+	}
+
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "frameTimer: failed to write %s", path)
+	}
+	return nil
+}
+
+// reportFrameStats shows the frame timer's summary wherever -frame-stats asked for it
+func (app *HelloTriangleApplication) reportFrameStats(stats string) {
+	stats = fmt.Sprintf("%s, %s", app.presentMode, stats)
+
+	switch app.frameStatsOutput {
+	case FrameStatsTitle:
+		app.window.SetTitle("Vulkan - " + stats)
+	case FrameStatsLog:
+		log.Println(stats)
+	}
+}
@@ -0,0 +1,846 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"math"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	vkngmath "github.com/vkngwrapper/math"
+)
+
+// GLTFModel is the result of loading a glTF 2.0 asset. Vertex and index data for every
+// primitive are packed into a single pair of slices, and each primitive records the
+// range it occupies. Indices are local to the primitive, so they must be drawn with
+// VertexOffset as the base vertex, or flattened with Bake.
+type GLTFModel struct {
+	Vertices   []Vertex
+	Indices    []uint32
+	Primitives []GLTFPrimitive
+	Nodes      []GLTFNode
+	SceneNodes []int
+	Materials  []GLTFMaterial
+	Images     []image.Image
+}
+
+type GLTFPrimitive struct {
+	Mesh         int
+	Material     int
+	FirstIndex   int
+	IndexCount   int
+	VertexOffset int
+	VertexCount  int
+}
+
+type GLTFNode struct {
+	Name     string
+	Parent   int
+	Children []int
+	Mesh     int
+	Local    vkngmath.Mat4x4[float32]
+	World    vkngmath.Mat4x4[float32]
+}
+
+// GLTFMaterial holds the metallic-roughness material parameters. Texture fields are
+// indices into GLTFModel.Images, or -1 when the material does not use that texture.
+type GLTFMaterial struct {
+	Name             string
+	BaseColorFactor  vkngmath.Vec4[float32]
+	BaseColorTexture int
+	MetallicFactor   float32
+	RoughnessFactor  float32
+	MetallicTexture  int
+	NormalTexture    int
+	EmissiveFactor   vkngmath.Vec3[float32]
+	EmissiveTexture  int
+	AlphaMode        string
+	AlphaCutoff      float32
+	DoubleSided      bool
+}
+
+// Bake flattens every mesh instance in the default scene into world space, producing
+// vertex and index data that can be drawn with a single indexed draw
+func (m *GLTFModel) Bake() ([]Vertex, []uint32) {
+	var vertices []Vertex
+	var indices []uint32
+
+	for _, nodeIndex := range m.SceneNodes {
+		node := &m.Nodes[nodeIndex]
+		if node.Mesh < 0 {
+			continue
+		}
+
+		for _, primitive := range m.Primitives {
+			if primitive.Mesh != node.Mesh {
+				continue
+			}
+
+			baseVertex := uint32(len(vertices))
+			for _, vert := range m.Vertices[primitive.VertexOffset : primitive.VertexOffset+primitive.VertexCount] {
+				vert.Position.TransformHomogenous(&node.World)
+				vertices = append(vertices, vert)
+			}
+
+			for _, index := range m.Indices[primitive.FirstIndex : primitive.FirstIndex+primitive.IndexCount] {
+				indices = append(indices, baseVertex+index)
+			}
+		}
+	}
+
+	return vertices, indices
+}
+
+const (
+	glbMagic     = 0x46546C67
+	glbChunkJSON = 0x4E4F534A
+	glbChunkBIN  = 0x004E4942
+
+	gltfModeTriangles     = 4
+	gltfModeTriangleStrip = 5
+	gltfModeTriangleFan   = 6
+
+	gltfComponentByte          = 5120
+	gltfComponentUnsignedByte  = 5121
+	gltfComponentShort         = 5122
+	gltfComponentUnsignedShort = 5123
+	gltfComponentUnsignedInt   = 5125
+	gltfComponentFloat         = 5126
+)
+
+type gltfDocument struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	Scene  *int `json:"scene"`
+	Scenes []struct {
+		Nodes []int `json:"nodes"`
+	} `json:"scenes"`
+	Nodes []struct {
+		Name        string    `json:"name"`
+		Children    []int     `json:"children"`
+		Mesh        *int      `json:"mesh"`
+		Matrix      []float32 `json:"matrix"`
+		Translation []float32 `json:"translation"`
+		Rotation    []float32 `json:"rotation"`
+		Scale       []float32 `json:"scale"`
+	} `json:"nodes"`
+	Meshes []struct {
+		Name       string `json:"name"`
+		Primitives []struct {
+			Attributes map[string]int `json:"attributes"`
+			Indices    *int           `json:"indices"`
+			Material   *int           `json:"material"`
+			Mode       *int           `json:"mode"`
+		} `json:"primitives"`
+	} `json:"meshes"`
+	Accessors []struct {
+		BufferView    *int            `json:"bufferView"`
+		ByteOffset    int             `json:"byteOffset"`
+		ComponentType int             `json:"componentType"`
+		Normalized    bool            `json:"normalized"`
+		Count         int             `json:"count"`
+		Type          string          `json:"type"`
+		Sparse        json.RawMessage `json:"sparse"`
+	} `json:"accessors"`
+	BufferViews []struct {
+		Buffer     int `json:"buffer"`
+		ByteOffset int `json:"byteOffset"`
+		ByteLength int `json:"byteLength"`
+		ByteStride int `json:"byteStride"`
+	} `json:"bufferViews"`
+	Buffers []struct {
+		URI        string `json:"uri"`
+		ByteLength int    `json:"byteLength"`
+	} `json:"buffers"`
+	Materials []struct {
+		Name                 string `json:"name"`
+		PBRMetallicRoughness *struct {
+			BaseColorFactor          []float32        `json:"baseColorFactor"`
+			BaseColorTexture         *gltfTextureInfo `json:"baseColorTexture"`
+			MetallicFactor           *float32         `json:"metallicFactor"`
+			RoughnessFactor          *float32         `json:"roughnessFactor"`
+			MetallicRoughnessTexture *gltfTextureInfo `json:"metallicRoughnessTexture"`
+		} `json:"pbrMetallicRoughness"`
+		NormalTexture   *gltfTextureInfo `json:"normalTexture"`
+		EmissiveTexture *gltfTextureInfo `json:"emissiveTexture"`
+		EmissiveFactor  []float32        `json:"emissiveFactor"`
+		AlphaMode       string           `json:"alphaMode"`
+		AlphaCutoff     *float32         `json:"alphaCutoff"`
+		DoubleSided     bool             `json:"doubleSided"`
+	} `json:"materials"`
+	Textures []struct {
+		Source *int `json:"source"`
+	} `json:"textures"`
+	Images []struct {
+		URI        string `json:"uri"`
+		MimeType   string `json:"mimeType"`
+		BufferView *int   `json:"bufferView"`
+	} `json:"images"`
+}
+
+type gltfTextureInfo struct {
+	Index int `json:"index"`
+}
+
+type gltfLoader struct {
+	fileSystem fs.FS
+	baseDir    string
+	doc        gltfDocument
+	buffers    [][]byte
+	model      *GLTFModel
+}
+
+// loadGLTF reads a .gltf or .glb file, along with any external buffers and images it
+// references, from the provided file system
+func loadGLTF(fileSystem fs.FS, filePath string) (*GLTFModel, error) {
+	fileBytes, err := fs.ReadFile(fileSystem, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	loader := &gltfLoader{
+		fileSystem: fileSystem,
+		baseDir:    path.Dir(filePath),
+		model:      &GLTFModel{},
+	}
+
+	var binChunk []byte
+	jsonChunk := fileBytes
+	if len(fileBytes) >= 4 && binary.LittleEndian.Uint32(fileBytes) == glbMagic {
+		jsonChunk, binChunk, err = splitGLB(fileBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(jsonChunk, &loader.doc)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loadGLTF: could not parse %s", filePath)
+	}
+
+	if !strings.HasPrefix(loader.doc.Asset.Version, "2.") {
+		return nil, errors.Errorf("loadGLTF: unsupported glTF version '%s'", loader.doc.Asset.Version)
+	}
+
+	err = loader.loadBuffers(binChunk)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loader.loadImages()
+	if err != nil {
+		return nil, err
+	}
+
+	loader.loadMaterials()
+
+	err = loader.loadMeshes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = loader.loadNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	return loader.model, nil
+}
+
+func splitGLB(fileBytes []byte) ([]byte, []byte, error) {
+	if len(fileBytes) < 12 {
+		return nil, nil, errors.New("loadGLTF: truncated glb header")
+	}
+
+	version := binary.LittleEndian.Uint32(fileBytes[4:])
+	if version != 2 {
+		return nil, nil, errors.Errorf("loadGLTF: unsupported glb version %d", version)
+	}
+
+	length := int(binary.LittleEndian.Uint32(fileBytes[8:]))
+	if length > len(fileBytes) {
+		return nil, nil, errors.Errorf("loadGLTF: glb declares %d bytes but file has %d", length, len(fileBytes))
+	}
+
+	var jsonChunk, binChunk []byte
+	offset := 12
+	for offset+8 <= length {
+		chunkLength := int(binary.LittleEndian.Uint32(fileBytes[offset:]))
+		chunkType := binary.LittleEndian.Uint32(fileBytes[offset+4:])
+		offset += 8
+
+		if offset+chunkLength > length {
+			return nil, nil, errors.New("loadGLTF: glb chunk runs past the end of the file")
+		}
+
+		chunk := fileBytes[offset : offset+chunkLength]
+		switch chunkType {
+		case glbChunkJSON:
+			jsonChunk = chunk
+		case glbChunkBIN:
+			if binChunk == nil {
+				binChunk = chunk
+			}
+		}
+
+		// Chunks are padded to 4-byte boundaries
+		offset += (chunkLength + 3) &^ 3
+	}
+
+	if jsonChunk == nil {
+		return nil, nil, errors.New("loadGLTF: glb has no JSON chunk")
+	}
+
+	return jsonChunk, binChunk, nil
+}
+
+func (l *gltfLoader) readURI(uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "data:") {
+		comma := strings.IndexByte(uri, ',')
+		if comma < 0 || !strings.HasSuffix(uri[:comma], ";base64") {
+			return nil, errors.New("loadGLTF: only base64 data URIs are supported")
+		}
+
+		return base64.StdEncoding.DecodeString(uri[comma+1:])
+	}
+
+	unescaped, err := url.PathUnescape(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return fs.ReadFile(l.fileSystem, path.Join(l.baseDir, unescaped))
+}
+
+func (l *gltfLoader) loadBuffers(binChunk []byte) error {
+	for bufferIndex, buffer := range l.doc.Buffers {
+		var data []byte
+		var err error
+
+		if buffer.URI == "" {
+			if bufferIndex != 0 || binChunk == nil {
+				return errors.Errorf("loadGLTF: buffer %d has no uri and no glb binary chunk", bufferIndex)
+			}
+			data = binChunk
+		} else {
+			data, err = l.readURI(buffer.URI)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(data) < buffer.ByteLength {
+			return errors.Errorf("loadGLTF: buffer %d should have %d bytes but has %d", bufferIndex, buffer.ByteLength, len(data))
+		}
+
+		l.buffers = append(l.buffers, data[:buffer.ByteLength])
+	}
+
+	return nil
+}
+
+func (l *gltfLoader) bufferViewBytes(viewIndex int) ([]byte, int, error) {
+	if viewIndex < 0 || viewIndex >= len(l.doc.BufferViews) {
+		return nil, 0, errors.Errorf("loadGLTF: invalid buffer view %d", viewIndex)
+	}
+
+	view := l.doc.BufferViews[viewIndex]
+	if view.Buffer < 0 || view.Buffer >= len(l.buffers) {
+		return nil, 0, errors.Errorf("loadGLTF: buffer view %d references invalid buffer %d", viewIndex, view.Buffer)
+	}
+
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteStride < 0 {
+		return nil, 0, errors.Errorf("loadGLTF: buffer view %d has a negative offset, length or stride", viewIndex)
+	}
+
+	buffer := l.buffers[view.Buffer]
+	if view.ByteOffset+view.ByteLength > len(buffer) {
+		return nil, 0, errors.Errorf("loadGLTF: buffer view %d runs past the end of buffer %d", viewIndex, view.Buffer)
+	}
+
+	return buffer[view.ByteOffset : view.ByteOffset+view.ByteLength], view.ByteStride, nil
+}
+
+func gltfComponentCount(accessorType string) int {
+	switch accessorType {
+	case "SCALAR":
+		return 1
+	case "VEC2":
+		return 2
+	case "VEC3":
+		return 3
+	case "VEC4", "MAT2":
+		return 4
+	case "MAT3":
+		return 9
+	case "MAT4":
+		return 16
+	}
+
+	return 0
+}
+
+func gltfComponentSize(componentType int) int {
+	switch componentType {
+	case gltfComponentByte, gltfComponentUnsignedByte:
+		return 1
+	case gltfComponentShort, gltfComponentUnsignedShort:
+		return 2
+	case gltfComponentUnsignedInt, gltfComponentFloat:
+		return 4
+	}
+
+	return 0
+}
+
+// readAccessor calls visit once for every component of every element in the accessor,
+// converting the raw value to float64 and applying normalization when requested
+func (l *gltfLoader) readAccessor(accessorIndex int, visit func(element, component int, value float64)) (int, int, error) {
+	if accessorIndex < 0 || accessorIndex >= len(l.doc.Accessors) {
+		return 0, 0, errors.Errorf("loadGLTF: invalid accessor %d", accessorIndex)
+	}
+
+	accessor := l.doc.Accessors[accessorIndex]
+	if accessor.ByteOffset < 0 || accessor.Count < 0 {
+		return 0, 0, errors.Errorf("loadGLTF: accessor %d has a negative offset or count", accessorIndex)
+	}
+	if len(accessor.Sparse) > 0 {
+		return 0, 0, errors.Errorf("loadGLTF: accessor %d is sparse, which is not supported", accessorIndex)
+	}
+
+	components := gltfComponentCount(accessor.Type)
+	componentSize := gltfComponentSize(accessor.ComponentType)
+	if components == 0 || componentSize == 0 {
+		return 0, 0, errors.Errorf("loadGLTF: accessor %d has unsupported type %s/%d", accessorIndex, accessor.Type, accessor.ComponentType)
+	}
+
+	// Accessors without a buffer view are all zeroes
+	if accessor.BufferView == nil {
+		for element := 0; element < accessor.Count; element++ {
+			for component := 0; component < components; component++ {
+				visit(element, component, 0)
+			}
+		}
+		return accessor.Count, components, nil
+	}
+
+	data, stride, err := l.bufferViewBytes(*accessor.BufferView)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	elementSize := components * componentSize
+	if stride == 0 {
+		stride = elementSize
+	}
+
+	if accessor.Count > 0 && accessor.ByteOffset+(accessor.Count-1)*stride+elementSize > len(data) {
+		return 0, 0, errors.Errorf("loadGLTF: accessor %d runs past the end of its buffer view", accessorIndex)
+	}
+
+	for element := 0; element < accessor.Count; element++ {
+		elementData := data[accessor.ByteOffset+element*stride:]
+		for component := 0; component < components; component++ {
+			raw := elementData[component*componentSize:]
+
+			var value float64
+			switch accessor.ComponentType {
+			case gltfComponentByte:
+				value = float64(int8(raw[0]))
+				if accessor.Normalized {
+					value = math.Max(value/127.0, -1)
+				}
+			case gltfComponentUnsignedByte:
+				value = float64(raw[0])
+				if accessor.Normalized {
+					value /= 255.0
+				}
+			case gltfComponentShort:
+				value = float64(int16(binary.LittleEndian.Uint16(raw)))
+				if accessor.Normalized {
+					value = math.Max(value/32767.0, -1)
+				}
+			case gltfComponentUnsignedShort:
+				value = float64(binary.LittleEndian.Uint16(raw))
+				if accessor.Normalized {
+					value /= 65535.0
+				}
+			case gltfComponentUnsignedInt:
+				value = float64(binary.LittleEndian.Uint32(raw))
+			case gltfComponentFloat:
+				value = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw)))
+			}
+
+			visit(element, component, value)
+		}
+	}
+
+	return accessor.Count, components, nil
+}
+
+func (l *gltfLoader) loadImages() error {
+	for imageIndex, imageDef := range l.doc.Images {
+		var data []byte
+		var err error
+
+		if imageDef.BufferView != nil {
+			data, _, err = l.bufferViewBytes(*imageDef.BufferView)
+		} else {
+			data, err = l.readURI(imageDef.URI)
+		}
+		if err != nil {
+			return err
+		}
+
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrapf(err, "loadGLTF: could not decode image %d", imageIndex)
+		}
+
+		l.model.Images = append(l.model.Images, decoded)
+	}
+
+	return nil
+}
+
+func (l *gltfLoader) textureImage(info *gltfTextureInfo) int {
+	if info == nil || info.Index < 0 || info.Index >= len(l.doc.Textures) {
+		return -1
+	}
+
+	source := l.doc.Textures[info.Index].Source
+	if source == nil || *source < 0 || *source >= len(l.model.Images) {
+		return -1
+	}
+
+	return *source
+}
+
+func (l *gltfLoader) loadMaterials() {
+	for _, materialDef := range l.doc.Materials {
+		material := GLTFMaterial{
+			Name:             materialDef.Name,
+			BaseColorFactor:  vkngmath.Vec4[float32]{X: 1, Y: 1, Z: 1, W: 1},
+			BaseColorTexture: -1,
+			MetallicFactor:   1,
+			RoughnessFactor:  1,
+			MetallicTexture:  -1,
+			NormalTexture:    l.textureImage(materialDef.NormalTexture),
+			EmissiveTexture:  l.textureImage(materialDef.EmissiveTexture),
+			AlphaMode:        "OPAQUE",
+			AlphaCutoff:      0.5,
+			DoubleSided:      materialDef.DoubleSided,
+		}
+
+		if materialDef.AlphaMode != "" {
+			material.AlphaMode = materialDef.AlphaMode
+		}
+		if materialDef.AlphaCutoff != nil {
+			material.AlphaCutoff = *materialDef.AlphaCutoff
+		}
+		if len(materialDef.EmissiveFactor) == 3 {
+			material.EmissiveFactor = vkngmath.Vec3[float32]{X: materialDef.EmissiveFactor[0], Y: materialDef.EmissiveFactor[1], Z: materialDef.EmissiveFactor[2]}
+		}
+
+		pbr := materialDef.PBRMetallicRoughness
+		if pbr != nil {
+			if len(pbr.BaseColorFactor) == 4 {
+				material.BaseColorFactor = vkngmath.Vec4[float32]{X: pbr.BaseColorFactor[0], Y: pbr.BaseColorFactor[1], Z: pbr.BaseColorFactor[2], W: pbr.BaseColorFactor[3]}
+			}
+			if pbr.MetallicFactor != nil {
+				material.MetallicFactor = *pbr.MetallicFactor
+			}
+			if pbr.RoughnessFactor != nil {
+				material.RoughnessFactor = *pbr.RoughnessFactor
+			}
+			material.BaseColorTexture = l.textureImage(pbr.BaseColorTexture)
+			material.MetallicTexture = l.textureImage(pbr.MetallicRoughnessTexture)
+		}
+
+		l.model.Materials = append(l.model.Materials, material)
+	}
+}
+
+func (l *gltfLoader) loadMeshes() error {
+	for meshIndex, mesh := range l.doc.Meshes {
+		for primitiveIndex, primitiveDef := range mesh.Primitives {
+			mode := gltfModeTriangles
+			if primitiveDef.Mode != nil {
+				mode = *primitiveDef.Mode
+			}
+			if mode != gltfModeTriangles && mode != gltfModeTriangleStrip && mode != gltfModeTriangleFan {
+				return errors.Errorf("loadGLTF: mesh %d primitive %d uses unsupported mode %d", meshIndex, primitiveIndex, mode)
+			}
+
+			positionAccessor, hasPosition := primitiveDef.Attributes["POSITION"]
+			if !hasPosition {
+				return errors.Errorf("loadGLTF: mesh %d primitive %d has no POSITION attribute", meshIndex, primitiveIndex)
+			}
+
+			vertexOffset := len(l.model.Vertices)
+			count, _, err := l.readAccessor(positionAccessor, func(element, component int, value float64) {
+				if component == 0 {
+					l.model.Vertices = append(l.model.Vertices, Vertex{Color: vkngmath.Vec3[float32]{X: 1, Y: 1, Z: 1}})
+				}
+				setVec3Component(&l.model.Vertices[vertexOffset+element].Position, component, value)
+			})
+			if err != nil {
+				return err
+			}
+			vertices := l.model.Vertices[vertexOffset:]
+
+			texCoordAccessor, hasTexCoord := primitiveDef.Attributes["TEXCOORD_0"]
+			if hasTexCoord {
+				err = l.readAttribute(texCoordAccessor, count, func(element, component int, value float64) {
+					if component == 0 {
+						vertices[element].TexCoord.X = float32(value)
+					} else if component == 1 {
+						vertices[element].TexCoord.Y = float32(value)
+					}
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			colorAccessor, hasColor := primitiveDef.Attributes["COLOR_0"]
+			if hasColor {
+				err = l.readAttribute(colorAccessor, count, func(element, component int, value float64) {
+					setVec3Component(&vertices[element].Color, component, value)
+				})
+				if err != nil {
+					return err
+				}
+			}
+
+			var primitiveIndices []uint32
+			if primitiveDef.Indices != nil {
+				_, _, err = l.readAccessor(*primitiveDef.Indices, func(element, component int, value float64) {
+					primitiveIndices = append(primitiveIndices, uint32(value))
+				})
+				if err != nil {
+					return err
+				}
+			} else {
+				for i := 0; i < count; i++ {
+					primitiveIndices = append(primitiveIndices, uint32(i))
+				}
+			}
+
+			for _, index := range primitiveIndices {
+				if int(index) >= count {
+					return errors.Errorf("loadGLTF: mesh %d primitive %d has index %d but only %d vertices", meshIndex, primitiveIndex, index, count)
+				}
+			}
+
+			firstIndex := len(l.model.Indices)
+			l.model.Indices = append(l.model.Indices, triangulate(primitiveIndices, mode)...)
+
+			material := -1
+			if primitiveDef.Material != nil {
+				material = *primitiveDef.Material
+			}
+
+			l.model.Primitives = append(l.model.Primitives, GLTFPrimitive{
+				Mesh:         meshIndex,
+				Material:     material,
+				FirstIndex:   firstIndex,
+				IndexCount:   len(l.model.Indices) - firstIndex,
+				VertexOffset: vertexOffset,
+				VertexCount:  count,
+			})
+		}
+	}
+
+	return nil
+}
+
+func (l *gltfLoader) readAttribute(accessorIndex int, vertexCount int, visit func(element, component int, value float64)) error {
+	count, _, err := l.readAccessor(accessorIndex, func(element, component int, value float64) {
+		if element < vertexCount {
+			visit(element, component, value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if count != vertexCount {
+		return errors.Errorf("loadGLTF: accessor %d has %d elements but POSITION has %d", accessorIndex, count, vertexCount)
+	}
+
+	return nil
+}
+
+func setVec3Component(vec *vkngmath.Vec3[float32], component int, value float64) {
+	switch component {
+	case 0:
+		vec.X = float32(value)
+	case 1:
+		vec.Y = float32(value)
+	case 2:
+		vec.Z = float32(value)
+	}
+}
+
+// triangulate converts strips and fans to a plain triangle list
+func triangulate(indices []uint32, mode int) []uint32 {
+	switch mode {
+	case gltfModeTriangleStrip:
+		var out []uint32
+		for i := 2; i < len(indices); i++ {
+			if i%2 == 0 {
+				out = append(out, indices[i-2], indices[i-1], indices[i])
+			} else {
+				out = append(out, indices[i-1], indices[i-2], indices[i])
+			}
+		}
+		return out
+	case gltfModeTriangleFan:
+		var out []uint32
+		for i := 2; i < len(indices); i++ {
+			out = append(out, indices[i-1], indices[i], indices[0])
+		}
+		return out
+	}
+
+	return indices[:len(indices)-len(indices)%3]
+}
+
+func (l *gltfLoader) loadNodes() error {
+	nodeCount := len(l.doc.Nodes)
+	l.model.Nodes = make([]GLTFNode, nodeCount)
+
+	for nodeIndex, nodeDef := range l.doc.Nodes {
+		node := &l.model.Nodes[nodeIndex]
+		node.Name = nodeDef.Name
+		node.Parent = -1
+		node.Children = nodeDef.Children
+		node.Mesh = -1
+		if nodeDef.Mesh != nil {
+			if *nodeDef.Mesh < 0 || *nodeDef.Mesh >= len(l.doc.Meshes) {
+				return errors.Errorf("loadGLTF: node %d references invalid mesh %d", nodeIndex, *nodeDef.Mesh)
+			}
+			node.Mesh = *nodeDef.Mesh
+		}
+
+		if len(nodeDef.Matrix) == 16 {
+			for col := 0; col < 4; col++ {
+				for row := 0; row < 4; row++ {
+					node.Local[col][row] = nodeDef.Matrix[col*4+row]
+				}
+			}
+		} else {
+			// T * R * S
+			rotation := vkngmath.Quaternion[float32]{W: 1}
+			if len(nodeDef.Rotation) == 4 {
+				rotation = vkngmath.Quaternion[float32]{X: nodeDef.Rotation[0], Y: nodeDef.Rotation[1], Z: nodeDef.Rotation[2], W: nodeDef.Rotation[3]}
+			}
+			node.Local.SetQuaternion(&rotation)
+
+			if len(nodeDef.Scale) == 3 {
+				for col := 0; col < 3; col++ {
+					for row := 0; row < 3; row++ {
+						node.Local[col][row] *= nodeDef.Scale[col]
+					}
+				}
+			}
+
+			if len(nodeDef.Translation) == 3 {
+				node.Local[3][0] = nodeDef.Translation[0]
+				node.Local[3][1] = nodeDef.Translation[1]
+				node.Local[3][2] = nodeDef.Translation[2]
+			}
+		}
+	}
+
+	for nodeIndex, node := range l.model.Nodes {
+		for _, child := range node.Children {
+			if child < 0 || child >= nodeCount {
+				return errors.Errorf("loadGLTF: node %d has invalid child %d", nodeIndex, child)
+			}
+			if l.model.Nodes[child].Parent >= 0 {
+				return errors.Errorf("loadGLTF: node %d has more than one parent", child)
+			}
+			l.model.Nodes[child].Parent = nodeIndex
+		}
+	}
+
+	var roots []int
+	sceneIndex := 0
+	if l.doc.Scene != nil {
+		sceneIndex = *l.doc.Scene
+		if sceneIndex < 0 || sceneIndex >= len(l.doc.Scenes) {
+			return errors.Errorf("loadGLTF: invalid scene %d", sceneIndex)
+		}
+	}
+	if sceneIndex < len(l.doc.Scenes) {
+		roots = l.doc.Scenes[sceneIndex].Nodes
+	} else {
+		// With no scenes, every root node is displayed
+		for nodeIndex, node := range l.model.Nodes {
+			if node.Parent < 0 {
+				roots = append(roots, nodeIndex)
+			}
+		}
+	}
+
+	visited := make([]bool, nodeCount)
+	var visit func(nodeIndex int, parentWorld *vkngmath.Mat4x4[float32]) error
+	visit = func(nodeIndex int, parentWorld *vkngmath.Mat4x4[float32]) error {
+		if nodeIndex < 0 || nodeIndex >= nodeCount {
+			return errors.Errorf("loadGLTF: scene references invalid node %d", nodeIndex)
+		}
+		if visited[nodeIndex] {
+			return errors.Errorf("loadGLTF: node %d appears more than once in the scene", nodeIndex)
+		}
+		visited[nodeIndex] = true
+
+		node := &l.model.Nodes[nodeIndex]
+		node.World.SetMultMat4x4(parentWorld, &node.Local)
+		l.model.SceneNodes = append(l.model.SceneNodes, nodeIndex)
+
+		for _, child := range node.Children {
+			err := visit(child, &node.World)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var identity vkngmath.Mat4x4[float32]
+	identity.SetIdentity()
+	for _, root := range roots {
+		err := visit(root, &identity)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadGLTFModel replaces the OBJ mesh with a glTF model baked into world space. The model's
+// materials and images are loaded but not used- it is still drawn with textureFile.
+func (app *HelloTriangleApplication) loadGLTFModel(filePath string) error {
+	model, err := loadGLTF(fileSystem, filePath)
+	if err != nil {
+		return err
+	}
+
+	mesh := newMesh(model.Bake())
+	app.meshOptions.process(mesh)
+	app.mesh = mesh
+	return nil
+}
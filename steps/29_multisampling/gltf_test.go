@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	vkngmath "github.com/vkngwrapper/math"
+)
+
+// testTriangle is a single triangle with positions, texture coordinates and 16-bit indices
+var testTriangle = struct {
+	positions []float32
+	texCoords []float32
+	indices   []uint16
+}{
+	positions: []float32{0, 0, 0, 1, 0, 0, 0, 1, 0},
+	texCoords: []float32{0, 0, 1, 0, 0, 1},
+	indices:   []uint16{0, 1, 2},
+}
+
+// buildTestTriangle returns the binary buffer for testTriangle and a glTF document that
+// reads it from bufferURI, which is left out of the document when empty. nodes is used as
+// the document's node list, and every node without a parent is in the scene.
+func buildTestTriangle(t *testing.T, bufferURI string, nodes []map[string]any) ([]byte, []byte) {
+	t.Helper()
+
+	var bin bytes.Buffer
+	binary.Write(&bin, binary.LittleEndian, testTriangle.positions)
+	binary.Write(&bin, binary.LittleEndian, testTriangle.texCoords)
+	binary.Write(&bin, binary.LittleEndian, testTriangle.indices)
+	positionsLength := len(testTriangle.positions) * 4
+	texCoordsLength := len(testTriangle.texCoords) * 4
+	indicesLength := len(testTriangle.indices) * 2
+
+	buffer := map[string]any{"byteLength": bin.Len()}
+	if bufferURI != "" {
+		buffer["uri"] = bufferURI
+	}
+
+	children := map[int]bool{}
+	for _, node := range nodes {
+		if nodeChildren, ok := node["children"].([]int); ok {
+			for _, child := range nodeChildren {
+				children[child] = true
+			}
+		}
+	}
+	var roots []int
+	for nodeIndex := range nodes {
+		if !children[nodeIndex] {
+			roots = append(roots, nodeIndex)
+		}
+	}
+
+	doc := map[string]any{
+		"asset":  map[string]any{"version": "2.0"},
+		"scene":  0,
+		"scenes": []any{map[string]any{"nodes": roots}},
+		"nodes":  nodes,
+		"meshes": []any{
+			map[string]any{
+				"primitives": []any{
+					map[string]any{
+						"attributes": map[string]int{"POSITION": 0, "TEXCOORD_0": 1},
+						"indices":    2,
+					},
+				},
+			},
+		},
+		"accessors": []any{
+			map[string]any{"bufferView": 0, "componentType": gltfComponentFloat, "count": 3, "type": "VEC3"},
+			map[string]any{"bufferView": 1, "componentType": gltfComponentFloat, "count": 3, "type": "VEC2"},
+			map[string]any{"bufferView": 2, "componentType": gltfComponentUnsignedShort, "count": 3, "type": "SCALAR"},
+		},
+		"bufferViews": []any{
+			map[string]any{"buffer": 0, "byteOffset": 0, "byteLength": positionsLength},
+			map[string]any{"buffer": 0, "byteOffset": positionsLength, "byteLength": texCoordsLength},
+			map[string]any{"buffer": 0, "byteOffset": positionsLength + texCoordsLength, "byteLength": indicesLength},
+		},
+		"buffers": []any{buffer},
+	}
+
+	jsonBytes, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return jsonBytes, bin.Bytes()
+}
+
+// buildGLB packs a JSON and a BIN chunk into a binary glTF file
+func buildGLB(jsonChunk, binChunk []byte) []byte {
+	pad := func(chunk []byte, padding byte) []byte {
+		for len(chunk)%4 != 0 {
+			chunk = append(chunk, padding)
+		}
+		return chunk
+	}
+	jsonChunk = pad(append([]byte(nil), jsonChunk...), ' ')
+	binChunk = pad(append([]byte(nil), binChunk...), 0)
+
+	var glb bytes.Buffer
+	binary.Write(&glb, binary.LittleEndian, []uint32{glbMagic, 2, uint32(12 + 8 + len(jsonChunk) + 8 + len(binChunk))})
+	binary.Write(&glb, binary.LittleEndian, []uint32{uint32(len(jsonChunk)), glbChunkJSON})
+	glb.Write(jsonChunk)
+	binary.Write(&glb, binary.LittleEndian, []uint32{uint32(len(binChunk)), glbChunkBIN})
+	glb.Write(binChunk)
+
+	return glb.Bytes()
+}
+
+// editGLTF applies edit to a copy of a glTF document
+func editGLTF(t *testing.T, docJSON []byte, edit func(doc map[string]any)) []byte {
+	t.Helper()
+
+	var doc map[string]any
+	err := json.Unmarshal(docJSON, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edit(doc)
+
+	edited, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return edited
+}
+
+// gltfElement returns the object at index in one of a glTF document's arrays
+func gltfElement(doc map[string]any, array string, index int) map[string]any {
+	return doc[array].([]any)[index].(map[string]any)
+}
+
+func TestLoadGLTFRoundTrip(t *testing.T) {
+	meshNode := []map[string]any{{"mesh": 0}}
+
+	glbJSON, glbBin := buildTestTriangle(t, "", meshNode)
+	dataURIJSON, _ := buildTestTriangle(t, "data:application/octet-stream;base64,"+base64.StdEncoding.EncodeToString(glbBin), meshNode)
+	externalJSON, externalBin := buildTestTriangle(t, "triangle%20data.bin", meshNode)
+
+	fileSystem := fstest.MapFS{
+		"models/triangle.glb":             {Data: buildGLB(glbJSON, glbBin)},
+		"models/embedded.gltf":            {Data: dataURIJSON},
+		"models/external.gltf":            {Data: externalJSON},
+		"models/triangle data.bin":        {Data: externalBin},
+		"models/missing-buffer.gltf":      {Data: glbJSON},
+		"models/truncated.glb":            {Data: buildGLB(glbJSON, glbBin)[:20]},
+		"models/unsupported-version.gltf": {Data: bytes.Replace(dataURIJSON, []byte(`"2.0"`), []byte(`"1.0"`), 1)},
+	}
+
+	testCases := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{name: "GLB", path: "models/triangle.glb"},
+		{name: "DataURI", path: "models/embedded.gltf"},
+		{name: "ExternalBuffer", path: "models/external.gltf"},
+		{name: "NoBinChunk", path: "models/missing-buffer.gltf", wantErr: true},
+		{name: "TruncatedGLB", path: "models/truncated.glb", wantErr: true},
+		{name: "UnsupportedVersion", path: "models/unsupported-version.gltf", wantErr: true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			model, err := loadGLTF(fileSystem, testCase.path)
+			if testCase.wantErr {
+				if err == nil {
+					t.Fatalf("loadGLTF(%s) succeeded, expected an error", testCase.path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadGLTF(%s): %+v", testCase.path, err)
+			}
+
+			if len(model.Vertices) != 3 {
+				t.Fatalf("got %d vertices, expected 3", len(model.Vertices))
+			}
+			for i, vert := range model.Vertices {
+				position := vkngmath.Vec3[float32]{X: testTriangle.positions[i*3], Y: testTriangle.positions[i*3+1], Z: testTriangle.positions[i*3+2]}
+				texCoord := vkngmath.Vec2[float32]{X: testTriangle.texCoords[i*2], Y: testTriangle.texCoords[i*2+1]}
+				if vert.Position != position || vert.TexCoord != texCoord {
+					t.Errorf("vertex %d is %v %v, expected %v %v", i, vert.Position, vert.TexCoord, position, texCoord)
+				}
+				if vert.Color != (vkngmath.Vec3[float32]{X: 1, Y: 1, Z: 1}) {
+					t.Errorf("vertex %d has color %v, expected white", i, vert.Color)
+				}
+			}
+
+			if len(model.Indices) != len(testTriangle.indices) {
+				t.Fatalf("got %d indices, expected %d", len(model.Indices), len(testTriangle.indices))
+			}
+			for i, index := range model.Indices {
+				if index != uint32(testTriangle.indices[i]) {
+					t.Errorf("index %d is %d, expected %d", i, index, testTriangle.indices[i])
+				}
+			}
+
+			expected := GLTFPrimitive{Mesh: 0, Material: -1, FirstIndex: 0, IndexCount: 3, VertexOffset: 0, VertexCount: 3}
+			if len(model.Primitives) != 1 || model.Primitives[0] != expected {
+				t.Errorf("got primitives %+v, expected [%+v]", model.Primitives, expected)
+			}
+		})
+	}
+}
+
+func TestGLTFBake(t *testing.T) {
+	// The parent moves everything by (10, 0, 0) and draws the triangle as it is. Its child
+	// is scaled by 2, turned a quarter turn around Z and moved by (0, 5, 0) in the parent's
+	// space, then draws the triangle again. The grandchild's column-major matrix stretches X
+	// by 3 and moves by (0, 0, 1) in the child's space.
+	halfSqrt2 := float32(math.Sqrt2 / 2)
+	nodes := []map[string]any{
+		{"name": "parent", "mesh": 0, "translation": []float32{10, 0, 0}, "children": []int{1}},
+		{"name": "child", "mesh": 0, "translation": []float32{0, 5, 0}, "rotation": []float32{0, 0, halfSqrt2, halfSqrt2}, "scale": []float32{2, 2, 2}, "children": []int{2}},
+		{"name": "grandchild", "mesh": 0, "matrix": []float32{3, 0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1}},
+	}
+	docJSON, docBin := buildTestTriangle(t, "", nodes)
+
+	model, err := loadGLTF(fstest.MapFS{"scene.glb": {Data: buildGLB(docJSON, docBin)}}, "scene.glb")
+	if err != nil {
+		t.Fatalf("loadGLTF: %+v", err)
+	}
+
+	if model.Nodes[0].Parent != -1 || model.Nodes[1].Parent != 0 || model.Nodes[2].Parent != 1 {
+		t.Errorf("got parents %d, %d and %d, expected -1, 0 and 1", model.Nodes[0].Parent, model.Nodes[1].Parent, model.Nodes[2].Parent)
+	}
+
+	vertices, indices := model.Bake()
+
+	expectedPositions := []vkngmath.Vec3[float32]{
+		// parent: translated by (10, 0, 0)
+		{X: 10, Y: 0, Z: 0}, {X: 11, Y: 0, Z: 0}, {X: 10, Y: 1, Z: 0},
+		// child: scaled, then (x, y) -> (-y, x), then translated by (10, 5, 0)
+		{X: 10, Y: 5, Z: 0}, {X: 10, Y: 7, Z: 0}, {X: 8, Y: 5, Z: 0},
+		// grandchild: (3x, y, z+1), then through the child to (-2y+10, 6x+5, 2z+2)
+		{X: 10, Y: 5, Z: 2}, {X: 10, Y: 11, Z: 2}, {X: 8, Y: 5, Z: 2},
+	}
+	if len(vertices) != len(expectedPositions) {
+		t.Fatalf("got %d vertices, expected %d", len(vertices), len(expectedPositions))
+	}
+	for i, vert := range vertices {
+		expected := expectedPositions[i]
+		if math.Abs(float64(vert.Position.X-expected.X)) > 1e-5 ||
+			math.Abs(float64(vert.Position.Y-expected.Y)) > 1e-5 ||
+			math.Abs(float64(vert.Position.Z-expected.Z)) > 1e-5 {
+			t.Errorf("vertex %d is at %v, expected %v", i, vert.Position, expected)
+		}
+		if vert.TexCoord.X != testTriangle.texCoords[(i%3)*2] || vert.TexCoord.Y != testTriangle.texCoords[(i%3)*2+1] {
+			t.Errorf("vertex %d has texture coordinate %v, which Bake should not change", i, vert.TexCoord)
+		}
+	}
+
+	expectedIndices := []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8}
+	if len(indices) != len(expectedIndices) {
+		t.Fatalf("got indices %v, expected %v", indices, expectedIndices)
+	}
+	for i := range indices {
+		if indices[i] != expectedIndices[i] {
+			t.Fatalf("got indices %v, expected %v", indices, expectedIndices)
+		}
+	}
+}
+
+func TestLoadGLTFInvalid(t *testing.T) {
+	docJSON, docBin := buildTestTriangle(t, "", []map[string]any{{"mesh": 0}})
+
+	testCases := []struct {
+		name string
+		edit func(doc map[string]any)
+	}{
+		{name: "NegativeBufferViewOffset", edit: func(doc map[string]any) { gltfElement(doc, "bufferViews", 1)["byteOffset"] = -4 }},
+		{name: "NegativeBufferViewLength", edit: func(doc map[string]any) { gltfElement(doc, "bufferViews", 1)["byteLength"] = -4 }},
+		{name: "NegativeByteStride", edit: func(doc map[string]any) { gltfElement(doc, "bufferViews", 0)["byteStride"] = -12 }},
+		{name: "NegativeAccessorOffset", edit: func(doc map[string]any) { gltfElement(doc, "accessors", 1)["byteOffset"] = -4 }},
+		{name: "NegativeAccessorCount", edit: func(doc map[string]any) { gltfElement(doc, "accessors", 0)["count"] = -1 }},
+		{name: "NegativeScene", edit: func(doc map[string]any) { doc["scene"] = -1 }},
+		{name: "SceneOutOfRange", edit: func(doc map[string]any) { doc["scene"] = 1 }},
+		{name: "SceneWithoutScenes", edit: func(doc map[string]any) { delete(doc, "scenes") }},
+		{name: "InvalidMesh", edit: func(doc map[string]any) { gltfElement(doc, "nodes", 0)["mesh"] = 1 }},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			glb := buildGLB(editGLTF(t, docJSON, testCase.edit), docBin)
+			_, err := loadGLTF(fstest.MapFS{"invalid.glb": {Data: glb}}, "invalid.glb")
+			if err == nil {
+				t.Error("loadGLTF succeeded, expected an error")
+			}
+		})
+	}
+
+	// Without a scene property or any scenes, every root node is drawn
+	noScenes := editGLTF(t, docJSON, func(doc map[string]any) {
+		delete(doc, "scene")
+		delete(doc, "scenes")
+	})
+	model, err := loadGLTF(fstest.MapFS{"noscenes.glb": {Data: buildGLB(noScenes, docBin)}}, "noscenes.glb")
+	if err != nil {
+		t.Fatalf("loadGLTF without scenes: %+v", err)
+	}
+	if len(model.SceneNodes) != 1 || model.SceneNodes[0] != 0 {
+		t.Errorf("got scene nodes %v without scenes, expected [0]", model.SceneNodes)
+	}
+}
+
+func TestTriangulate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		mode    int
+		indices []uint32
+		want    []uint32
+	}{
+		{name: "Triangles", mode: gltfModeTriangles, indices: []uint32{0, 1, 2, 3, 4, 5}, want: []uint32{0, 1, 2, 3, 4, 5}},
+		// A trailing partial triangle is dropped
+		{name: "TrianglesLeftOver", mode: gltfModeTriangles, indices: []uint32{0, 1, 2, 3, 4}, want: []uint32{0, 1, 2}},
+		// Every other triangle of a strip is flipped to keep the winding order
+		{name: "Strip", mode: gltfModeTriangleStrip, indices: []uint32{0, 1, 2, 3, 4}, want: []uint32{0, 1, 2, 2, 1, 3, 2, 3, 4}},
+		{name: "StripTooShort", mode: gltfModeTriangleStrip, indices: []uint32{0, 1}},
+		{name: "Fan", mode: gltfModeTriangleFan, indices: []uint32{0, 1, 2, 3, 4}, want: []uint32{1, 2, 0, 2, 3, 0, 3, 4, 0}},
+		{name: "FanTooShort", mode: gltfModeTriangleFan, indices: []uint32{0, 1}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := triangulate(testCase.indices, testCase.mode)
+			if !slices.Equal(got, testCase.want) {
+				t.Errorf("got %v, expected %v", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestLoadGLTFMaterials(t *testing.T) {
+	encodePNG := func(colors ...color.NRGBA) []byte {
+		img := image.NewNRGBA(image.Rect(0, 0, len(colors), 1))
+		for x, c := range colors {
+			img.SetNRGBA(x, 0, c)
+		}
+
+		var encoded bytes.Buffer
+		err := png.Encode(&encoded, img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded.Bytes()
+	}
+	red := color.NRGBA{R: 255, A: 255}
+	green := color.NRGBA{G: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+
+	docJSON, docBin := buildTestTriangle(t, "", []map[string]any{{"mesh": 0}})
+
+	// The first image is stored in the glb's binary chunk, after the triangle, and the
+	// second is a data URI
+	binPNG := encodePNG(red, green)
+	imageOffset := (len(docBin) + 3) &^ 3
+	bin := append(append(docBin, make([]byte, imageOffset-len(docBin))...), binPNG...)
+
+	docJSON = editGLTF(t, docJSON, func(doc map[string]any) {
+		gltfElement(doc, "buffers", 0)["byteLength"] = len(bin)
+		doc["bufferViews"] = append(doc["bufferViews"].([]any), map[string]any{"buffer": 0, "byteOffset": imageOffset, "byteLength": len(binPNG)})
+		doc["images"] = []any{
+			map[string]any{"bufferView": 3, "mimeType": "image/png"},
+			map[string]any{"uri": "data:image/png;base64," + base64.StdEncoding.EncodeToString(encodePNG(blue))},
+		}
+		// The last texture has no source, so it leaves its material slot empty
+		doc["textures"] = []any{map[string]any{"source": 0}, map[string]any{"source": 1}, map[string]any{}}
+		doc["materials"] = []any{
+			map[string]any{
+				"name": "painted",
+				"pbrMetallicRoughness": map[string]any{
+					"baseColorFactor":          []float32{0.5, 0.5, 0.5, 1},
+					"baseColorTexture":         map[string]any{"index": 0},
+					"metallicFactor":           0,
+					"roughnessFactor":          0.25,
+					"metallicRoughnessTexture": map[string]any{"index": 1},
+				},
+				"normalTexture":  map[string]any{"index": 2},
+				"emissiveFactor": []float32{1, 0, 0},
+				"alphaMode":      "MASK",
+				"alphaCutoff":    0.25,
+				"doubleSided":    true,
+			},
+			map[string]any{"name": "default"},
+		}
+		primitive := gltfElement(doc, "meshes", 0)["primitives"].([]any)[0].(map[string]any)
+		primitive["material"] = 0
+	})
+
+	model, err := loadGLTF(fstest.MapFS{"painted.glb": {Data: buildGLB(docJSON, bin)}}, "painted.glb")
+	if err != nil {
+		t.Fatalf("loadGLTF: %+v", err)
+	}
+
+	wantImages := [][]color.NRGBA{{red, green}, {blue}}
+	if len(model.Images) != len(wantImages) {
+		t.Fatalf("got %d images, expected %d", len(model.Images), len(wantImages))
+	}
+	for imageIndex, wantColors := range wantImages {
+		img := model.Images[imageIndex]
+		if img.Bounds() != image.Rect(0, 0, len(wantColors), 1) {
+			t.Errorf("image %d has bounds %v, expected %dx1", imageIndex, img.Bounds(), len(wantColors))
+			continue
+		}
+		for x, want := range wantColors {
+			if got := color.NRGBAModel.Convert(img.At(x, 0)); got != want {
+				t.Errorf("image %d texel %d is %v, expected %v", imageIndex, x, got, want)
+			}
+		}
+	}
+
+	wantMaterials := []GLTFMaterial{
+		{
+			Name:             "painted",
+			BaseColorFactor:  vkngmath.Vec4[float32]{X: 0.5, Y: 0.5, Z: 0.5, W: 1},
+			BaseColorTexture: 0,
+			MetallicFactor:   0,
+			RoughnessFactor:  0.25,
+			MetallicTexture:  1,
+			NormalTexture:    -1,
+			EmissiveFactor:   vkngmath.Vec3[float32]{X: 1, Y: 0, Z: 0},
+			EmissiveTexture:  -1,
+			AlphaMode:        "MASK",
+			AlphaCutoff:      0.25,
+			DoubleSided:      true,
+		},
+		{
+			// The specification's defaults
+			Name:             "default",
+			BaseColorFactor:  vkngmath.Vec4[float32]{X: 1, Y: 1, Z: 1, W: 1},
+			BaseColorTexture: -1,
+			MetallicFactor:   1,
+			RoughnessFactor:  1,
+			MetallicTexture:  -1,
+			NormalTexture:    -1,
+			EmissiveTexture:  -1,
+			AlphaMode:        "OPAQUE",
+			AlphaCutoff:      0.5,
+		},
+	}
+	if !slices.Equal(model.Materials, wantMaterials) {
+		t.Errorf("got materials %+v, expected %+v", model.Materials, wantMaterials)
+	}
+
+	if len(model.Primitives) != 1 || model.Primitives[0].Material != 0 {
+		t.Errorf("got primitives %+v, expected one using material 0", model.Primitives)
+	}
+}
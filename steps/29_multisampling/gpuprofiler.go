@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/vkngwrapper/core/v2/common"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+const (
+	// gpuProfilerFrameSlots is the most command buffers that can be profiled at once. Frames
+	// are profiled per swapchain image, so swapchains with more images than this go partly
+	// unprofiled.
+	gpuProfilerFrameSlots = 8
+	// gpuUploadSlot is the slot one-off command buffers share. They're waited on as soon as
+	// they're submitted, so they never overlap.
+	gpuUploadSlot = gpuProfilerFrameSlots
+
+	gpuProfilerQueriesPerSlot = 64
+)
+
+// statsWindow is how many of the most recent samples a durationWindow keeps
+const statsWindow = 240
+
+type durationStats struct {
+	Samples int
+	Average time.Duration
+	P50     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+func (s durationStats) String() string {
+	return fmt.Sprintf("avg %s, p50 %s, p95 %s, p99 %s, max %s over %d samples", s.Average, s.P50, s.P95, s.P99, s.Max, s.Samples)
+}
+
+// durationWindow keeps the last statsWindow durations added to it
+type durationWindow struct {
+	durations []time.Duration
+	next      int
+}
+
+func (w *durationWindow) Add(duration time.Duration) {
+	if len(w.durations) < statsWindow {
+		w.durations = append(w.durations, duration)
+		return
+	}
+
+	w.durations[w.next] = duration
+	w.next = (w.next + 1) % statsWindow
+}
+
+func (w *durationWindow) Stats() durationStats {
+	if len(w.durations) == 0 {
+		return durationStats{}
+	}
+
+	sorted := slices.Clone(w.durations)
+	slices.Sort(sorted)
+
+	var total time.Duration
+	for _, duration := range sorted {
+		total += duration
+	}
+
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+
+	return durationStats{
+		Samples: len(sorted),
+		Average: total / time.Duration(len(sorted)),
+		P50:     percentile(50),
+		P95:     percentile(95),
+		P99:     percentile(99),
+		Max:     sorted[len(sorted)-1],
+	}
+}
+
+type gpuProfilerScope struct {
+	Name       string
+	Begin, End int
+}
+
+// gpuProfilerSlot holds the scopes recorded into one command buffer, and the range of the
+// query pool they write to
+type gpuProfilerSlot struct {
+	scopes  []gpuProfilerScope
+	open    []int
+	queries int
+	// pending is set when the command buffer has been submitted and its results haven't
+	// been read yet
+	pending bool
+}
+
+// gpuProfiler times named scopes in command buffers with timestamp queries. Each command
+// buffer writes to its own slot of the query pool, and its results are read back the next
+// time it's about to be submitted, when its fence has already been waited on, so reading
+// never stalls. A nil *gpuProfiler is valid and does nothing, for queues that don't
+// support timestamps.
+type gpuProfiler struct {
+	device core1_0.Device
+	pool   core1_0.QueryPool
+	// period is the number of nanoseconds per timestamp tick
+	period    float64
+	validMask uint64
+
+	slots   []gpuProfilerSlot
+	windows map[string]*durationWindow
+	names   []string
+}
+
+func newGPUProfiler(device core1_0.Device, physicalDevice core1_0.PhysicalDevice, queueFamily int) (*gpuProfiler, error) {
+	validBits := physicalDevice.QueueFamilyProperties()[queueFamily].TimestampValidBits
+	if validBits == 0 {
+		log.Printf("queue family %d doesn't support timestamps- GPU profiling is disabled", queueFamily)
+		return nil, nil
+	}
+
+	properties, err := physicalDevice.Properties()
+	if err != nil {
+		return nil, err
+	}
+
+	validMask := ^uint64(0)
+	if validBits < 64 {
+		validMask = 1<<validBits - 1
+	}
+
+	slots := gpuProfilerFrameSlots + 1
+	pool, _, err := device.CreateQueryPool(nil, core1_0.QueryPoolCreateInfo{
+		QueryType:  core1_0.QueryTypeTimestamp,
+		QueryCount: slots * gpuProfilerQueriesPerSlot,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &gpuProfiler{
+		device:    device,
+		pool:      pool,
+		period:    float64(properties.Limits.TimestampPeriod),
+		validMask: validMask,
+		slots:     make([]gpuProfilerSlot, slots),
+		windows:   make(map[string]*durationWindow),
+	}, nil
+}
+
+func (p *gpuProfiler) slot(slot int) *gpuProfilerSlot {
+	if p == nil || slot >= len(p.slots) {
+		return nil
+	}
+	return &p.slots[slot]
+}
+
+// Reset starts profiling a command buffer in a slot, discarding any results from its last
+// recording that weren't read. It must be recorded outside of a render pass.
+func (p *gpuProfiler) Reset(buffer core1_0.CommandBuffer, slot int) {
+	s := p.slot(slot)
+	if s == nil {
+		return
+	}
+
+	buffer.CmdResetQueryPool(p.pool, slot*gpuProfilerQueriesPerSlot, gpuProfilerQueriesPerSlot)
+	*s = gpuProfilerSlot{}
+}
+
+// BeginScope starts timing a scope, which ends at the matching EndScope. Scopes can nest.
+func (p *gpuProfiler) BeginScope(buffer core1_0.CommandBuffer, slot int, name string) {
+	s := p.slot(slot)
+	if s == nil {
+		return
+	}
+
+	if s.queries+2 > gpuProfilerQueriesPerSlot {
+		// Out of queries, so this scope isn't timed
+		s.open = append(s.open, -1)
+		return
+	}
+
+	buffer.CmdWriteTimestamp(core1_0.PipelineStageTopOfPipe, p.pool, slot*gpuProfilerQueriesPerSlot+s.queries)
+	s.scopes = append(s.scopes, gpuProfilerScope{Name: name, Begin: s.queries, End: -1})
+	s.open = append(s.open, len(s.scopes)-1)
+	// The end query is reserved now, so an open scope can always be closed
+	s.queries += 2
+}
+
+func (p *gpuProfiler) EndScope(buffer core1_0.CommandBuffer, slot int) {
+	s := p.slot(slot)
+	if s == nil || len(s.open) == 0 {
+		return
+	}
+
+	index := s.open[len(s.open)-1]
+	s.open = s.open[:len(s.open)-1]
+	if index < 0 {
+		return
+	}
+
+	scope := &s.scopes[index]
+	scope.End = scope.Begin + 1
+	buffer.CmdWriteTimestamp(core1_0.PipelineStageBottomOfPipe, p.pool, slot*gpuProfilerQueriesPerSlot+scope.End)
+}
+
+// Submitted marks a slot's command buffer as submitted, so its results are read by the next
+// Collect
+func (p *gpuProfiler) Submitted(slot int) {
+	s := p.slot(slot)
+	if s == nil {
+		return
+	}
+	s.pending = true
+}
+
+// Collect reads the results of a slot's last submission, once the fence it was submitted
+// with has been waited on. Results that still aren't available are dropped rather than
+// waited for.
+func (p *gpuProfiler) Collect(slot int) error {
+	s := p.slot(slot)
+	if s == nil || !s.pending || s.queries == 0 {
+		return nil
+	}
+	s.pending = false
+
+	results := make([]byte, s.queries*8)
+	res, err := p.pool.PopulateResults(slot*gpuProfilerQueriesPerSlot, s.queries, results, 8, core1_0.QueryResult64Bit)
+	if err != nil {
+		return err
+	}
+	if res == core1_0.VKNotReady {
+		return nil
+	}
+
+	for _, scope := range s.scopes {
+		if scope.End < 0 {
+			continue
+		}
+
+		begin := common.ByteOrder.Uint64(results[scope.Begin*8:])
+		end := common.ByteOrder.Uint64(results[scope.End*8:])
+		ticks := (end - begin) & p.validMask
+
+		window, ok := p.windows[scope.Name]
+		if !ok {
+			window = &durationWindow{}
+			p.windows[scope.Name] = window
+			p.names = append(p.names, scope.Name)
+		}
+		window.Add(time.Duration(float64(ticks) * p.period))
+	}
+
+	return nil
+}
+
+// Stats returns the rolling statistics of a scope over its most recent samples
+func (p *gpuProfiler) Stats(name string) (durationStats, bool) {
+	if p == nil {
+		return durationStats{}, false
+	}
+
+	window, ok := p.windows[name]
+	if !ok {
+		return durationStats{}, false
+	}
+	return window.Stats(), true
+}
+
+// LogStats logs the statistics of every scope, in the order they were first seen
+func (p *gpuProfiler) LogStats() {
+	if p == nil {
+		return
+	}
+
+	for _, name := range p.names {
+		log.Printf("gpu %s: %s", name, p.windows[name].Stats())
+	}
+}
+
+func (p *gpuProfiler) Destroy() {
+	if p == nil {
+		return
+	}
+
+	p.pool.Destroy(nil)
+}
+
+func (app *HelloTriangleApplication) createGPUProfiler() error {
+	indices, err := app.findQueueFamilies(app.physicalDevice)
+	if err != nil {
+		return err
+	}
+
+	app.gpuProfiler, err = newGPUProfiler(app.device, app.physicalDevice, *indices.GraphicsFamily)
+	if err != nil {
+		return err
+	}
+
+	if app.gpuProfiler != nil {
+		app.debug.Name(app.gpuProfiler.pool, "gpu profiler queries")
+	}
+	return nil
+}
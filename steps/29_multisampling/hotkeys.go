@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// handleKey toggles rendering options- W switches wireframe, C cycles the cull mode, B cycles
+// the blend mode, M cycles the MSAA sample count, S switches sample shading, V switches the
+// sample shading comparison, N cycles the present mode, P logs GPU timings and F12 saves a
+// screenshot
+func (app *HelloTriangleApplication) handleKey(key sdl.Keycode) error {
+	state := app.pipelineState
+
+	switch key {
+	case sdl.K_w:
+		state.Wireframe = !state.Wireframe
+	case sdl.K_c:
+		switch state.CullMode {
+		case core1_0.CullModeBack:
+			state.CullMode = core1_0.CullModeFront
+		case core1_0.CullModeFront:
+			state.CullMode = 0
+		default:
+			state.CullMode = core1_0.CullModeBack
+		}
+	case sdl.K_b:
+		state.Blend = (state.Blend + 1) % (BlendModeAdditive + 1)
+	case sdl.K_m:
+		// The sample count changes the render pass and attachments as well as the pipeline,
+		// so everything is rebuilt along with the swapchain
+		app.msaaSamples = nextSampleCount(app.usableSamples, app.msaaSamples)
+		// Remembered so the count survives picking the device again after a device loss
+		app.requestedSamples = app.msaaSamples
+		log.Printf("switched to %s MSAA", app.msaaSamples)
+		return app.recreateSwapChain()
+	case sdl.K_s:
+		if app.sampleShading == 0 {
+			log.Println("sample shading wasn't enabled- run with -sample-shading")
+			return nil
+		}
+		if state.SampleShading == 0 {
+			state.SampleShading = app.sampleShading
+		} else {
+			state.SampleShading = 0
+		}
+	case sdl.K_n:
+		available, res, err := app.surface.PhysicalDeviceSurfacePresentModes(app.physicalDevice)
+		if err != nil {
+			return checkLost(res, err)
+		}
+
+		mode := nextPresentMode(available, app.presentMode)
+		if mode == app.presentMode {
+			log.Printf("%s is the only present mode the surface supports", mode)
+			return nil
+		}
+		app.presentModes = preferPresentMode(app.presentModes, mode)
+		return app.recreateSwapChain()
+	case sdl.K_p:
+		app.gpuProfiler.LogStats()
+		return nil
+	case sdl.K_F12:
+		app.RequestScreenshot(fmt.Sprintf("screenshot-%s.png", time.Now().Format("20060102-150405.000")))
+		return nil
+	case sdl.K_v:
+		if app.sampleShading == 0 {
+			log.Println("sample shading wasn't enabled- run with -sample-shading")
+			return nil
+		}
+		app.compareSampleShading = !app.compareSampleShading
+		log.Printf("sample shading comparison: %t", app.compareSampleShading)
+		return app.recreateCommandBuffers()
+	default:
+		return nil
+	}
+
+	return app.setPipelineState(state)
+}
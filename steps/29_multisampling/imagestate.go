@@ -0,0 +1,351 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/core1_0"
+	"github.com/vkngwrapper/extensions/v2/khr_swapchain"
+)
+
+// queueFamilyIgnored is VK_QUEUE_FAMILY_IGNORED- a subresource that no queue family has
+// taken ownership of yet
+const queueFamilyIgnored = -1
+
+const writeAccesses = core1_0.AccessShaderWrite | core1_0.AccessColorAttachmentWrite |
+	core1_0.AccessDepthStencilAttachmentWrite | core1_0.AccessTransferWrite |
+	core1_0.AccessHostWrite | core1_0.AccessMemoryWrite
+
+// imageState is the layout of an image subresource, and the stage and access of the last
+// use that touched it
+type imageState struct {
+	Layout      core1_0.ImageLayout
+	Access      core1_0.AccessFlags
+	Stage       core1_0.PipelineStageFlags
+	QueueFamily int
+}
+
+// layoutState returns the state for the usual use of an image in a layout- transfer writes
+// for TransferDstOptimal, fragment shader reads for ShaderReadOnlyOptimal, and so on
+func layoutState(layout core1_0.ImageLayout) imageState {
+	state := imageState{Layout: layout, QueueFamily: queueFamilyIgnored}
+
+	switch layout {
+	case core1_0.ImageLayoutUndefined, core1_0.ImageLayoutPreInitialized:
+		state.Stage = core1_0.PipelineStageTopOfPipe
+	case core1_0.ImageLayoutTransferSrcOptimal:
+		state.Access = core1_0.AccessTransferRead
+		state.Stage = core1_0.PipelineStageTransfer
+	case core1_0.ImageLayoutTransferDstOptimal:
+		state.Access = core1_0.AccessTransferWrite
+		state.Stage = core1_0.PipelineStageTransfer
+	case core1_0.ImageLayoutShaderReadOnlyOptimal:
+		state.Access = core1_0.AccessShaderRead
+		state.Stage = core1_0.PipelineStageFragmentShader
+	case core1_0.ImageLayoutColorAttachmentOptimal:
+		state.Access = core1_0.AccessColorAttachmentRead | core1_0.AccessColorAttachmentWrite
+		state.Stage = core1_0.PipelineStageColorAttachmentOutput
+	case core1_0.ImageLayoutDepthStencilAttachmentOptimal:
+		state.Access = core1_0.AccessDepthStencilAttachmentRead | core1_0.AccessDepthStencilAttachmentWrite
+		state.Stage = core1_0.PipelineStageEarlyFragmentTests | core1_0.PipelineStageLateFragmentTests
+	case core1_0.ImageLayoutDepthStencilReadOnlyOptimal:
+		state.Access = core1_0.AccessDepthStencilAttachmentRead | core1_0.AccessShaderRead
+		state.Stage = core1_0.PipelineStageEarlyFragmentTests | core1_0.PipelineStageFragmentShader
+	case khr_swapchain.ImageLayoutPresentSrc:
+		// Presentation is synchronized with semaphores, so no access is needed here
+		state.Stage = core1_0.PipelineStageBottomOfPipe
+	default:
+		state.Access = core1_0.AccessMemoryRead | core1_0.AccessMemoryWrite
+		state.Stage = core1_0.PipelineStageAllCommands
+	}
+
+	return state
+}
+
+// imageAspect returns every aspect of an image with the given format
+func imageAspect(format core1_0.Format) core1_0.ImageAspectFlags {
+	switch format {
+	case core1_0.FormatD16UnsignedNormalized, core1_0.FormatD24X8UnsignedNormalizedPacked, core1_0.FormatD32SignedFloat:
+		return core1_0.ImageAspectDepth
+	case core1_0.FormatS8UnsignedInt:
+		return core1_0.ImageAspectStencil
+	case core1_0.FormatD16UnsignedNormalizedS8UnsignedInt:
+		return core1_0.ImageAspectDepth | core1_0.ImageAspectStencil
+	}
+
+	if hasStencilComponent(format) {
+		return core1_0.ImageAspectDepth | core1_0.ImageAspectStencil
+	}
+	return core1_0.ImageAspectColor
+}
+
+type trackedImage struct {
+	Format      core1_0.Format
+	MipLevels   int
+	ArrayLayers int
+
+	// States holds one entry per mip level and array layer, indexed by level*ArrayLayers+layer.
+	// The depth and stencil aspects of an image are always transitioned together, so they
+	// share an entry.
+	States []imageState
+}
+
+// imageStateTracker records the state of every subresource of the images registered with it,
+// and builds the barriers to move them into any other state. States are updated when barriers
+// are recorded, so command buffers using tracked images must be submitted in the order they
+// were recorded.
+type imageStateTracker struct {
+	images map[core1_0.Image]*trackedImage
+}
+
+// Track registers a newly created image, with every subresource in the undefined layout
+func (t *imageStateTracker) Track(image core1_0.Image, format core1_0.Format, mipLevels, arrayLayers int) {
+	if t.images == nil {
+		t.images = make(map[core1_0.Image]*trackedImage)
+	}
+
+	tracked := &trackedImage{
+		Format:      format,
+		MipLevels:   mipLevels,
+		ArrayLayers: arrayLayers,
+		States:      make([]imageState, mipLevels*arrayLayers),
+	}
+	for i := range tracked.States {
+		tracked.States[i] = layoutState(core1_0.ImageLayoutUndefined)
+	}
+	t.images[image] = tracked
+}
+
+// Forget stops tracking an image, which should be done before it is destroyed
+func (t *imageStateTracker) Forget(image core1_0.Image) {
+	delete(t.images, image)
+}
+
+// Assume records that a range of subresources is in a state without recording a barrier, for
+// transitions made by something other than the tracker, such as a render pass
+func (t *imageStateTracker) Assume(image core1_0.Image, subresources core1_0.ImageSubresourceRange, state imageState) error {
+	tracked, ok := t.images[image]
+	if !ok {
+		return errors.New("imageStateTracker: image is not tracked")
+	}
+
+	for level := subresources.BaseMipLevel; level < subresources.BaseMipLevel+subresources.LevelCount; level++ {
+		for layer := subresources.BaseArrayLayer; layer < subresources.BaseArrayLayer+subresources.LayerCount; layer++ {
+			if level < 0 || level >= tracked.MipLevels || layer < 0 || layer >= tracked.ArrayLayers {
+				return errors.Errorf("imageStateTracker: subresource (level %d, layer %d) is out of range", level, layer)
+			}
+			tracked.States[level*tracked.ArrayLayers+layer] = state
+		}
+	}
+
+	return nil
+}
+
+// WholeImage returns a subresource range covering every mip level and array layer of an image
+func (t *imageStateTracker) WholeImage(image core1_0.Image) core1_0.ImageSubresourceRange {
+	tracked, ok := t.images[image]
+	if !ok {
+		return core1_0.ImageSubresourceRange{}
+	}
+	return core1_0.ImageSubresourceRange{
+		AspectMask: imageAspect(tracked.Format),
+		LevelCount: tracked.MipLevels,
+		LayerCount: tracked.ArrayLayers,
+	}
+}
+
+// MipLevels returns a subresource range covering the first array layer of count mip levels
+func (t *imageStateTracker) MipLevels(image core1_0.Image, baseLevel, count int) core1_0.ImageSubresourceRange {
+	subresources := t.WholeImage(image)
+	subresources.BaseMipLevel = baseLevel
+	subresources.LevelCount = count
+	subresources.LayerCount = 1
+	return subresources
+}
+
+// State returns the current state of a single subresource
+func (t *imageStateTracker) State(image core1_0.Image, level, layer int) (imageState, error) {
+	tracked, ok := t.images[image]
+	if !ok {
+		return imageState{}, errors.New("imageStateTracker: image is not tracked")
+	}
+	if level < 0 || level >= tracked.MipLevels || layer < 0 || layer >= tracked.ArrayLayers {
+		return imageState{}, errors.Errorf("imageStateTracker: subresource (level %d, layer %d) is out of range", level, layer)
+	}
+	return tracked.States[level*tracked.ArrayLayers+layer], nil
+}
+
+// Transition records a barrier moving a range of subresources from whatever states they are
+// in to the target state. Subresources that are already in the target layout and are only
+// being read don't need a barrier and are skipped.
+//
+// The target's QueueFamily should be left as queueFamilyIgnored- moving an image from one
+// queue family to another is done with TransferOwnership.
+func (t *imageStateTracker) Transition(buffer core1_0.CommandBuffer, image core1_0.Image, subresources core1_0.ImageSubresourceRange, target imageState) error {
+	barriers, srcStage, err := t.barriers(image, subresources, target, false)
+	if err != nil {
+		return err
+	}
+	if len(barriers) == 0 {
+		return nil
+	}
+
+	for i := range barriers {
+		barriers[i].SrcQueueFamilyIndex = queueFamilyIgnored
+		barriers[i].DstQueueFamilyIndex = queueFamilyIgnored
+	}
+
+	return buffer.CmdPipelineBarrier(srcStage, target.Stage, 0, nil, nil, barriers)
+}
+
+// TransferOwnership moves a range of subresources of an image with exclusive sharing mode to
+// the queue family in target.QueueFamily. The release barrier is recorded to a command buffer
+// for the queue that owns the subresources now, and the matching acquire barrier to a command
+// buffer for the new queue. The acquiring submission must wait on the releasing one, usually
+// with a semaphore.
+func (t *imageStateTracker) TransferOwnership(release, acquire core1_0.CommandBuffer, image core1_0.Image, subresources core1_0.ImageSubresourceRange, target imageState) error {
+	if target.QueueFamily == queueFamilyIgnored {
+		return errors.New("imageStateTracker.TransferOwnership: no destination queue family was provided")
+	}
+
+	barriers, srcStage, err := t.barriers(image, subresources, target, true)
+	if err != nil {
+		return err
+	}
+	if len(barriers) == 0 {
+		return nil
+	}
+
+	// Both halves of the transfer must describe the same layout transition- the release half
+	// makes writes available and the acquire half makes them visible
+	acquireBarriers := make([]core1_0.ImageMemoryBarrier, len(barriers))
+	for i := range barriers {
+		barriers[i].DstAccessMask = 0
+
+		acquireBarriers[i] = barriers[i]
+		acquireBarriers[i].SrcAccessMask = 0
+		acquireBarriers[i].DstAccessMask = target.Access
+	}
+
+	err = release.CmdPipelineBarrier(srcStage, core1_0.PipelineStageBottomOfPipe, 0, nil, nil, barriers)
+	if err != nil {
+		return err
+	}
+
+	return acquire.CmdPipelineBarrier(core1_0.PipelineStageTopOfPipe, target.Stage, 0, nil, nil, acquireBarriers)
+}
+
+// barriers builds the barriers that move a range of subresources into the target state, and
+// records that state for them. Runs of subresources that start out in the same state share a
+// barrier.
+func (t *imageStateTracker) barriers(image core1_0.Image, subresources core1_0.ImageSubresourceRange, target imageState, ownership bool) ([]core1_0.ImageMemoryBarrier, core1_0.PipelineStageFlags, error) {
+	tracked, ok := t.images[image]
+	if !ok {
+		return nil, 0, errors.New("imageStateTracker: image is not tracked")
+	}
+
+	if subresources.LevelCount < 1 || subresources.LayerCount < 1 ||
+		subresources.BaseMipLevel < 0 || subresources.BaseMipLevel+subresources.LevelCount > tracked.MipLevels ||
+		subresources.BaseArrayLayer < 0 || subresources.BaseArrayLayer+subresources.LayerCount > tracked.ArrayLayers {
+		return nil, 0, errors.Errorf("imageStateTracker: levels %d-%d, layers %d-%d are out of range for an image with %d levels and %d layers",
+			subresources.BaseMipLevel, subresources.BaseMipLevel+subresources.LevelCount-1,
+			subresources.BaseArrayLayer, subresources.BaseArrayLayer+subresources.LayerCount-1,
+			tracked.MipLevels, tracked.ArrayLayers)
+	}
+
+	// Depth/stencil images that don't have separate depth and stencil layouts must always
+	// transition both aspects at once
+	aspect := imageAspect(tracked.Format)
+
+	var barriers []core1_0.ImageMemoryBarrier
+	var barrierStates []imageState
+	var srcStage core1_0.PipelineStageFlags
+
+	// Nothing is recorded until every subresource has been checked
+	newStates := make(map[int]imageState, subresources.LevelCount*subresources.LayerCount)
+
+	for level := subresources.BaseMipLevel; level < subresources.BaseMipLevel+subresources.LevelCount; level++ {
+		levelStart := len(barriers)
+
+		for layer := subresources.BaseArrayLayer; layer < subresources.BaseArrayLayer+subresources.LayerCount; layer++ {
+			index := level*tracked.ArrayLayers + layer
+			old := tracked.States[index]
+
+			newState := target
+			if ownership {
+				if old.QueueFamily == queueFamilyIgnored {
+					return nil, 0, errors.Errorf("imageStateTracker.TransferOwnership: level %d, layer %d is not owned by any queue family", level, layer)
+				}
+				if old.QueueFamily == target.QueueFamily {
+					return nil, 0, errors.Errorf("imageStateTracker.TransferOwnership: level %d, layer %d is already owned by queue family %d", level, layer, old.QueueFamily)
+				}
+			} else {
+				if target.QueueFamily != queueFamilyIgnored && target.QueueFamily != old.QueueFamily && old.QueueFamily != queueFamilyIgnored {
+					return nil, 0, errors.Errorf("imageStateTracker.Transition: level %d, layer %d is owned by queue family %d- use TransferOwnership to move it to %d", level, layer, old.QueueFamily, target.QueueFamily)
+				}
+				if target.QueueFamily == queueFamilyIgnored {
+					newState.QueueFamily = old.QueueFamily
+				}
+			}
+
+			if !ownership && old.Layout == target.Layout && (old.Access|target.Access)&writeAccesses == 0 {
+				// Read after read doesn't need a barrier, but a later write has to wait on
+				// both reads
+				newState.Access |= old.Access
+				newState.Stage |= old.Stage
+				newStates[index] = newState
+				continue
+			}
+			newStates[index] = newState
+
+			// Extend the previous barrier if it covers the layer before this one in the same
+			// state, otherwise start a new one
+			last := len(barriers) - 1
+			if last >= levelStart && barrierStates[last] == old &&
+				barriers[last].SubresourceRange.BaseArrayLayer+barriers[last].SubresourceRange.LayerCount == layer {
+				barriers[last].SubresourceRange.LayerCount++
+				continue
+			}
+
+			srcStage |= old.Stage
+			barrierStates = append(barrierStates, old)
+			barriers = append(barriers, core1_0.ImageMemoryBarrier{
+				Image:               image,
+				OldLayout:           old.Layout,
+				NewLayout:           target.Layout,
+				SrcQueueFamilyIndex: old.QueueFamily,
+				DstQueueFamilyIndex: target.QueueFamily,
+				// Only writes need to be made available- reads just need the execution dependency
+				SrcAccessMask: old.Access & writeAccesses,
+				DstAccessMask: target.Access,
+				SubresourceRange: core1_0.ImageSubresourceRange{
+					AspectMask:     aspect,
+					BaseMipLevel:   level,
+					LevelCount:     1,
+					BaseArrayLayer: layer,
+					LayerCount:     1,
+				},
+			})
+		}
+
+		// If this level needed exactly the same barrier as the level before it, fold it in
+		if len(barriers)-levelStart == 1 && levelStart > 0 {
+			previous, current := &barriers[levelStart-1], barriers[levelStart]
+			if barrierStates[levelStart-1] == barrierStates[levelStart] &&
+				previous.SubresourceRange.BaseMipLevel+previous.SubresourceRange.LevelCount == level &&
+				previous.SubresourceRange.BaseArrayLayer == current.SubresourceRange.BaseArrayLayer &&
+				previous.SubresourceRange.LayerCount == current.SubresourceRange.LayerCount {
+				previous.SubresourceRange.LevelCount++
+				barriers = barriers[:levelStart]
+				barrierStates = barrierStates[:levelStart]
+			}
+		}
+	}
+
+	for index, state := range newStates {
+		tracked.States[index] = state
+	}
+
+	if srcStage == 0 {
+		srcStage = core1_0.PipelineStageTopOfPipe
+	}
+	return barriers, srcStage, nil
+}
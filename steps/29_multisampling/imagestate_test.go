@@ -0,0 +1,516 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// testImage stands in for a real image- the tracker only uses images as map keys
+type testImage struct {
+	core1_0.Image
+	name string
+}
+
+type pipelineBarrierCall struct {
+	SrcStage       core1_0.PipelineStageFlags
+	DstStage       core1_0.PipelineStageFlags
+	Barriers       []core1_0.ImageMemoryBarrier
+	BufferBarriers []core1_0.BufferMemoryBarrier
+}
+
+// barrierRecorder is a command buffer that only records pipeline barriers
+type barrierRecorder struct {
+	core1_0.CommandBuffer
+	calls []pipelineBarrierCall
+}
+
+func (r *barrierRecorder) CmdPipelineBarrier(srcStageMask, dstStageMask core1_0.PipelineStageFlags, dependencies core1_0.DependencyFlags, memoryBarriers []core1_0.MemoryBarrier, bufferMemoryBarriers []core1_0.BufferMemoryBarrier, imageMemoryBarriers []core1_0.ImageMemoryBarrier) error {
+	r.calls = append(r.calls, pipelineBarrierCall{
+		SrcStage:       srcStageMask,
+		DstStage:       dstStageMask,
+		Barriers:       imageMemoryBarriers,
+		BufferBarriers: bufferMemoryBarriers,
+	})
+	return nil
+}
+
+// expectedBarrier is the part of an image barrier that changes between test cases
+type expectedBarrier struct {
+	OldLayout      core1_0.ImageLayout
+	SrcAccess      core1_0.AccessFlags
+	BaseMipLevel   int
+	LevelCount     int
+	BaseArrayLayer int
+	LayerCount     int
+}
+
+type assumedState struct {
+	Subresources core1_0.ImageSubresourceRange
+	State        imageState
+}
+
+// subresourceRange is a color range of levels and layers
+func subresourceRange(baseLevel, levelCount, baseLayer, layerCount int) core1_0.ImageSubresourceRange {
+	return core1_0.ImageSubresourceRange{
+		AspectMask:     core1_0.ImageAspectColor,
+		BaseMipLevel:   baseLevel,
+		LevelCount:     levelCount,
+		BaseArrayLayer: baseLayer,
+		LayerCount:     layerCount,
+	}
+}
+
+func TestImageStateTrackerTransition(t *testing.T) {
+	transferDst := layoutState(core1_0.ImageLayoutTransferDstOptimal)
+	transferSrc := layoutState(core1_0.ImageLayoutTransferSrcOptimal)
+	shaderRead := layoutState(core1_0.ImageLayoutShaderReadOnlyOptimal)
+
+	vertexShaderRead := shaderRead
+	vertexShaderRead.Stage = core1_0.PipelineStageVertexShader
+
+	testCases := []struct {
+		name        string
+		format      core1_0.Format
+		mipLevels   int
+		arrayLayers int
+		// assume puts ranges of subresources into a state before the transition, in order
+		assume       []assumedState
+		subresources func(tracker *imageStateTracker, image core1_0.Image) core1_0.ImageSubresourceRange
+		target       imageState
+
+		wantSrcStage core1_0.PipelineStageFlags
+		wantAspect   core1_0.ImageAspectFlags
+		wantBarriers []expectedBarrier
+	}{
+		{
+			name:         "LevelsMerge",
+			mipLevels:    4,
+			arrayLayers:  1,
+			target:       transferDst,
+			wantSrcStage: core1_0.PipelineStageTopOfPipe,
+			wantBarriers: []expectedBarrier{
+				{OldLayout: core1_0.ImageLayoutUndefined, LevelCount: 4, LayerCount: 1},
+			},
+		},
+		{
+			name:         "LayersMerge",
+			mipLevels:    1,
+			arrayLayers:  6,
+			target:       transferDst,
+			wantSrcStage: core1_0.PipelineStageTopOfPipe,
+			wantBarriers: []expectedBarrier{
+				{OldLayout: core1_0.ImageLayoutUndefined, LevelCount: 1, LayerCount: 6},
+			},
+		},
+		{
+			name:         "LevelsAndLayersMerge",
+			mipLevels:    3,
+			arrayLayers:  2,
+			target:       transferDst,
+			wantSrcStage: core1_0.PipelineStageTopOfPipe,
+			wantBarriers: []expectedBarrier{
+				{OldLayout: core1_0.ImageLayoutUndefined, LevelCount: 3, LayerCount: 2},
+			},
+		},
+		{
+			// Level 1 was blitted from, so it splits the chain into three barriers
+			name:        "LevelsSplitByState",
+			mipLevels:   4,
+			arrayLayers: 1,
+			assume: []assumedState{
+				{subresourceRange(0, 4, 0, 1), transferDst},
+				{subresourceRange(1, 1, 0, 1), transferSrc},
+			},
+			target:       shaderRead,
+			wantSrcStage: core1_0.PipelineStageTransfer,
+			wantBarriers: []expectedBarrier{
+				{OldLayout: core1_0.ImageLayoutTransferDstOptimal, SrcAccess: core1_0.AccessTransferWrite, BaseMipLevel: 0, LevelCount: 1, LayerCount: 1},
+				{OldLayout: core1_0.ImageLayoutTransferSrcOptimal, BaseMipLevel: 1, LevelCount: 1, LayerCount: 1},
+				{OldLayout: core1_0.ImageLayoutTransferDstOptimal, SrcAccess: core1_0.AccessTransferWrite, BaseMipLevel: 2, LevelCount: 2, LayerCount: 1},
+			},
+		},
+		{
+			name:        "LayersSplitByState",
+			mipLevels:   1,
+			arrayLayers: 4,
+			assume: []assumedState{
+				{subresourceRange(0, 1, 0, 4), transferDst},
+				{subresourceRange(0, 1, 2, 1), transferSrc},
+			},
+			target:       shaderRead,
+			wantSrcStage: core1_0.PipelineStageTransfer,
+			wantBarriers: []expectedBarrier{
+				{OldLayout: core1_0.ImageLayoutTransferDstOptimal, SrcAccess: core1_0.AccessTransferWrite, LevelCount: 1, LayerCount: 2},
+				{OldLayout: core1_0.ImageLayoutTransferSrcOptimal, LevelCount: 1, BaseArrayLayer: 2, LayerCount: 1},
+				{OldLayout: core1_0.ImageLayoutTransferDstOptimal, SrcAccess: core1_0.AccessTransferWrite, LevelCount: 1, BaseArrayLayer: 3, LayerCount: 1},
+			},
+		},
+		{
+			// Level 0 needs two barriers, so level 1's single barrier can't be folded into
+			// either of them
+			name:        "LevelWithSplitLayersIsNotMerged",
+			mipLevels:   2,
+			arrayLayers: 2,
+			assume: []assumedState{
+				{subresourceRange(0, 2, 0, 2), transferDst},
+				{subresourceRange(0, 1, 1, 1), transferSrc},
+			},
+			target:       shaderRead,
+			wantSrcStage: core1_0.PipelineStageTransfer,
+			wantBarriers: []expectedBarrier{
+				{OldLayout: core1_0.ImageLayoutTransferDstOptimal, SrcAccess: core1_0.AccessTransferWrite, LevelCount: 1, LayerCount: 1},
+				{OldLayout: core1_0.ImageLayoutTransferSrcOptimal, LevelCount: 1, BaseArrayLayer: 1, LayerCount: 1},
+				{OldLayout: core1_0.ImageLayoutTransferDstOptimal, SrcAccess: core1_0.AccessTransferWrite, BaseMipLevel: 1, LevelCount: 1, LayerCount: 2},
+			},
+		},
+		{
+			// Only the requested levels get a barrier
+			name:        "PartialRange",
+			mipLevels:   4,
+			arrayLayers: 1,
+			subresources: func(tracker *imageStateTracker, image core1_0.Image) core1_0.ImageSubresourceRange {
+				return tracker.MipLevels(image, 1, 2)
+			},
+			target:       transferSrc,
+			wantSrcStage: core1_0.PipelineStageTopOfPipe,
+			wantBarriers: []expectedBarrier{
+				{OldLayout: core1_0.ImageLayoutUndefined, BaseMipLevel: 1, LevelCount: 2, LayerCount: 1},
+			},
+		},
+		{
+			// Reading again in the same layout needs no barrier
+			name:        "ReadAfterRead",
+			mipLevels:   2,
+			arrayLayers: 1,
+			assume: []assumedState{
+				{subresourceRange(0, 2, 0, 1), vertexShaderRead},
+			},
+			target: shaderRead,
+		},
+		{
+			// Two writes in a row still have to be ordered
+			name:        "WriteAfterWrite",
+			mipLevels:   1,
+			arrayLayers: 1,
+			assume: []assumedState{
+				{subresourceRange(0, 1, 0, 1), transferDst},
+			},
+			target:       transferDst,
+			wantSrcStage: core1_0.PipelineStageTransfer,
+			wantBarriers: []expectedBarrier{
+				{OldLayout: core1_0.ImageLayoutTransferDstOptimal, SrcAccess: core1_0.AccessTransferWrite, LevelCount: 1, LayerCount: 1},
+			},
+		},
+		{
+			name:         "DepthStencil",
+			format:       core1_0.FormatD24UnsignedNormalizedS8UnsignedInt,
+			mipLevels:    1,
+			arrayLayers:  1,
+			target:       layoutState(core1_0.ImageLayoutDepthStencilAttachmentOptimal),
+			wantSrcStage: core1_0.PipelineStageTopOfPipe,
+			wantAspect:   core1_0.ImageAspectDepth | core1_0.ImageAspectStencil,
+			wantBarriers: []expectedBarrier{
+				{OldLayout: core1_0.ImageLayoutUndefined, LevelCount: 1, LayerCount: 1},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			image := &testImage{name: testCase.name}
+			format := testCase.format
+			if format == 0 {
+				format = core1_0.FormatR8G8B8A8SRGB
+			}
+			aspect := testCase.wantAspect
+			if aspect == 0 {
+				aspect = core1_0.ImageAspectColor
+			}
+
+			var tracker imageStateTracker
+			tracker.Track(image, format, testCase.mipLevels, testCase.arrayLayers)
+
+			for _, assumed := range testCase.assume {
+				err := tracker.Assume(image, assumed.Subresources, assumed.State)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			subresources := tracker.WholeImage(image)
+			if testCase.subresources != nil {
+				subresources = testCase.subresources(&tracker, image)
+			}
+
+			buffer := &barrierRecorder{}
+			err := tracker.Transition(buffer, image, subresources, testCase.target)
+			if err != nil {
+				t.Fatalf("Transition: %+v", err)
+			}
+
+			if len(testCase.wantBarriers) == 0 {
+				if len(buffer.calls) != 0 {
+					t.Fatalf("recorded %d barrier calls, expected none", len(buffer.calls))
+				}
+			} else {
+				if len(buffer.calls) != 1 {
+					t.Fatalf("recorded %d barrier calls, expected 1", len(buffer.calls))
+				}
+				call := buffer.calls[0]
+				if call.SrcStage != testCase.wantSrcStage || call.DstStage != testCase.target.Stage {
+					t.Errorf("barrier waits on %s for %s, expected %s for %s", call.SrcStage, call.DstStage, testCase.wantSrcStage, testCase.target.Stage)
+				}
+
+				var got []expectedBarrier
+				for _, barrier := range call.Barriers {
+					if barrier.Image != image || barrier.NewLayout != testCase.target.Layout || barrier.DstAccessMask != testCase.target.Access ||
+						barrier.SrcQueueFamilyIndex != queueFamilyIgnored || barrier.DstQueueFamilyIndex != queueFamilyIgnored ||
+						barrier.SubresourceRange.AspectMask != aspect {
+						t.Errorf("barrier %+v doesn't move the image to %+v", barrier, testCase.target)
+					}
+					got = append(got, expectedBarrier{
+						OldLayout:      barrier.OldLayout,
+						SrcAccess:      barrier.SrcAccessMask,
+						BaseMipLevel:   barrier.SubresourceRange.BaseMipLevel,
+						LevelCount:     barrier.SubresourceRange.LevelCount,
+						BaseArrayLayer: barrier.SubresourceRange.BaseArrayLayer,
+						LayerCount:     barrier.SubresourceRange.LayerCount,
+					})
+				}
+				if len(got) != len(testCase.wantBarriers) {
+					t.Fatalf("got barriers %+v, expected %+v", got, testCase.wantBarriers)
+				}
+				for i := range got {
+					if got[i] != testCase.wantBarriers[i] {
+						t.Errorf("barrier %d is %+v, expected %+v", i, got[i], testCase.wantBarriers[i])
+					}
+				}
+			}
+
+			// Every subresource in the range ends up in the target layout
+			for level := subresources.BaseMipLevel; level < subresources.BaseMipLevel+subresources.LevelCount; level++ {
+				for layer := subresources.BaseArrayLayer; layer < subresources.BaseArrayLayer+subresources.LayerCount; layer++ {
+					state, err := tracker.State(image, level, layer)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if state.Layout != testCase.target.Layout {
+						t.Errorf("level %d, layer %d is in %s, expected %s", level, layer, state.Layout, testCase.target.Layout)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestImageStateTrackerReadsAccumulate(t *testing.T) {
+	image := &testImage{name: "texture"}
+	var tracker imageStateTracker
+	tracker.Track(image, core1_0.FormatR8G8B8A8SRGB, 1, 1)
+
+	vertexShaderRead := layoutState(core1_0.ImageLayoutShaderReadOnlyOptimal)
+	vertexShaderRead.Stage = core1_0.PipelineStageVertexShader
+	err := tracker.Assume(image, tracker.WholeImage(image), vertexShaderRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fragment shader read skips the barrier, but the next write has to wait on both reads
+	buffer := &barrierRecorder{}
+	err = tracker.Transition(buffer, image, tracker.WholeImage(image), layoutState(core1_0.ImageLayoutShaderReadOnlyOptimal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tracker.Transition(buffer, image, tracker.WholeImage(image), layoutState(core1_0.ImageLayoutTransferDstOptimal))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(buffer.calls) != 1 {
+		t.Fatalf("recorded %d barrier calls, expected 1", len(buffer.calls))
+	}
+	wantStage := core1_0.PipelineStageVertexShader | core1_0.PipelineStageFragmentShader
+	if buffer.calls[0].SrcStage != wantStage {
+		t.Errorf("write waits on %s, expected %s", buffer.calls[0].SrcStage, wantStage)
+	}
+	if access := buffer.calls[0].Barriers[0].SrcAccessMask; access != 0 {
+		t.Errorf("barrier makes %s available, expected nothing after reads", access)
+	}
+}
+
+func TestImageStateTrackerRejects(t *testing.T) {
+	image := &testImage{name: "texture"}
+	var tracker imageStateTracker
+	tracker.Track(image, core1_0.FormatR8G8B8A8SRGB, 2, 1)
+
+	testCases := []struct {
+		name         string
+		image        core1_0.Image
+		subresources core1_0.ImageSubresourceRange
+	}{
+		{name: "Untracked", image: &testImage{name: "untracked"}, subresources: subresourceRange(0, 1, 0, 1)},
+		{name: "LevelsPastEnd", image: image, subresources: subresourceRange(1, 2, 0, 1)},
+		{name: "LayersPastEnd", image: image, subresources: subresourceRange(0, 1, 0, 2)},
+		{name: "NegativeLevel", image: image, subresources: subresourceRange(-1, 1, 0, 1)},
+		{name: "NoLevels", image: image, subresources: subresourceRange(0, 0, 0, 1)},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			buffer := &barrierRecorder{}
+			err := tracker.Transition(buffer, testCase.image, testCase.subresources, layoutState(core1_0.ImageLayoutTransferDstOptimal))
+			if err == nil {
+				t.Fatal("Transition succeeded, expected an error")
+			}
+			if len(buffer.calls) != 0 {
+				t.Errorf("recorded %d barrier calls, expected none", len(buffer.calls))
+			}
+
+			// A rejected transition leaves every subresource where it was
+			for level := 0; level < 2; level++ {
+				state, err := tracker.State(image, level, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if state.Layout != core1_0.ImageLayoutUndefined {
+					t.Errorf("level %d moved to %s", level, state.Layout)
+				}
+			}
+		})
+	}
+}
+
+func TestImageStateTrackerTransferOwnership(t *testing.T) {
+	image := &testImage{name: "texture"}
+	var tracker imageStateTracker
+	tracker.Track(image, core1_0.FormatR8G8B8A8SRGB, 2, 1)
+
+	// The transfer queue's family 1 uploaded the image, and family 0 samples it
+	uploaded := layoutState(core1_0.ImageLayoutTransferDstOptimal)
+	uploaded.QueueFamily = 1
+	err := tracker.Assume(image, tracker.WholeImage(image), uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := layoutState(core1_0.ImageLayoutShaderReadOnlyOptimal)
+	target.QueueFamily = 0
+
+	release := &barrierRecorder{}
+	acquire := &barrierRecorder{}
+	err = tracker.TransferOwnership(release, acquire, image, tracker.WholeImage(image), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(release.calls) != 1 || len(acquire.calls) != 1 {
+		t.Fatalf("recorded %d release and %d acquire barrier calls, expected 1 of each", len(release.calls), len(acquire.calls))
+	}
+	if release.calls[0].SrcStage != core1_0.PipelineStageTransfer || release.calls[0].DstStage != core1_0.PipelineStageBottomOfPipe {
+		t.Errorf("release goes from %s to %s, expected %s to %s", release.calls[0].SrcStage, release.calls[0].DstStage,
+			core1_0.PipelineStageTransfer, core1_0.PipelineStageBottomOfPipe)
+	}
+	if acquire.calls[0].SrcStage != core1_0.PipelineStageTopOfPipe || acquire.calls[0].DstStage != core1_0.PipelineStageFragmentShader {
+		t.Errorf("acquire goes from %s to %s, expected %s to %s", acquire.calls[0].SrcStage, acquire.calls[0].DstStage,
+			core1_0.PipelineStageTopOfPipe, core1_0.PipelineStageFragmentShader)
+	}
+
+	// Both halves carry the same layout transition and queue families. The release makes the
+	// upload available, and the acquire makes it visible to the shader.
+	want := core1_0.ImageMemoryBarrier{
+		Image:               image,
+		OldLayout:           core1_0.ImageLayoutTransferDstOptimal,
+		NewLayout:           core1_0.ImageLayoutShaderReadOnlyOptimal,
+		SrcQueueFamilyIndex: 1,
+		DstQueueFamilyIndex: 0,
+		SubresourceRange:    subresourceRange(0, 2, 0, 1),
+	}
+	wantRelease := want
+	wantRelease.SrcAccessMask = core1_0.AccessTransferWrite
+	wantAcquire := want
+	wantAcquire.DstAccessMask = core1_0.AccessShaderRead
+
+	for _, half := range []struct {
+		name     string
+		barriers []core1_0.ImageMemoryBarrier
+		want     core1_0.ImageMemoryBarrier
+	}{
+		{name: "release", barriers: release.calls[0].Barriers, want: wantRelease},
+		{name: "acquire", barriers: acquire.calls[0].Barriers, want: wantAcquire},
+	} {
+		if len(half.barriers) != 1 {
+			t.Errorf("the %s has %d barriers, expected 1", half.name, len(half.barriers))
+			continue
+		}
+		if half.barriers[0] != half.want {
+			t.Errorf("the %s barrier is %+v, expected %+v", half.name, half.barriers[0], half.want)
+		}
+	}
+
+	for level := 0; level < 2; level++ {
+		state, err := tracker.State(image, level, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != target {
+			t.Errorf("level %d is in %+v, expected %+v", level, state, target)
+		}
+	}
+}
+
+func TestImageStateTrackerTransferOwnershipRejects(t *testing.T) {
+	owned := layoutState(core1_0.ImageLayoutTransferDstOptimal)
+	owned.QueueFamily = 1
+
+	toFamily := func(family int) imageState {
+		state := layoutState(core1_0.ImageLayoutShaderReadOnlyOptimal)
+		state.QueueFamily = family
+		return state
+	}
+
+	testCases := []struct {
+		name   string
+		assume *imageState
+		target imageState
+	}{
+		{name: "NoOwner", target: toFamily(0)},
+		{name: "AlreadyOwned", assume: &owned, target: toFamily(1)},
+		{name: "NoDestination", assume: &owned, target: toFamily(queueFamilyIgnored)},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			image := &testImage{name: "texture"}
+			var tracker imageStateTracker
+			tracker.Track(image, core1_0.FormatR8G8B8A8SRGB, 1, 1)
+			if testCase.assume != nil {
+				err := tracker.Assume(image, tracker.WholeImage(image), *testCase.assume)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			before, err := tracker.State(image, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			release := &barrierRecorder{}
+			acquire := &barrierRecorder{}
+			err = tracker.TransferOwnership(release, acquire, image, tracker.WholeImage(image), testCase.target)
+			if err == nil {
+				t.Fatal("TransferOwnership succeeded, expected an error")
+			}
+			if len(release.calls) != 0 || len(acquire.calls) != 0 {
+				t.Errorf("recorded %d release and %d acquire barrier calls, expected none", len(release.calls), len(acquire.calls))
+			}
+
+			after, err := tracker.State(image, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after != before {
+				t.Errorf("the image moved from %+v to %+v", before, after)
+			}
+		})
+	}
+}
@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"embed"
 	"encoding/binary"
-	"image/png"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"math"
+	"path"
 	"runtime"
+	"sync"
 	"unsafe"
 
 	"github.com/g3n/engine/loader/obj"
@@ -31,6 +35,12 @@ var fileSystem embed.FS
 
 const MaxFramesInFlight = 2
 
+// modelFile may be a Wavefront .obj or a glTF 2.0 .gltf/.glb file
+const modelFile = "meshes/viking_room.obj"
+
+// textureFile may be a .png, .jpg, .ktx2 or .dds file
+const textureFile = "images/viking_room.png"
+
 var validationLayers = []string{"VK_LAYER_KHRONOS_validation"}
 var deviceExtensions = []string{khr_swapchain.ExtensionName}
 
@@ -104,28 +114,62 @@ type HelloTriangleApplication struct {
 
 	instance       core1_0.Instance
 	debugMessenger ext_debug_utils.DebugUtilsMessenger
-	surface        khr_surface.Surface
+	validation     *validationLog
+
+	validationFeatures validationFeatures
+	debug              *debugUtils
+	gpuProfiler        *gpuProfiler
+	frameTimer         *frameTimer
+	frameStatsOutput   FrameStatsOutput
+	surface            khr_surface.Surface
 
 	physicalDevice core1_0.PhysicalDevice
 	device         core1_0.Device
+	deviceFeatures *core1_0.PhysicalDeviceFeatures
 
+	queueFamilies QueueFamilyIndices
 	graphicsQueue core1_0.Queue
 	presentQueue  core1_0.Queue
 
-	swapchainExtension    khr_swapchain.Extension
-	swapchain             khr_swapchain.Swapchain
-	swapchainImages       []core1_0.Image
-	swapchainImageFormat  core1_0.Format
-	swapchainExtent       core1_0.Extent2D
-	swapchainImageViews   []core1_0.ImageView
-	swapchainFramebuffers []core1_0.Framebuffer
-
+	// lossRecoveries counts the times the surface or device has been rebuilt since the last
+	// frame was drawn
+	lossRecoveries int
+
+	swapchainExtension   khr_swapchain.Extension
+	swapchain            khr_swapchain.Swapchain
+	swapchainImages      []core1_0.Image
+	swapchainImageFormat core1_0.Format
+	swapchainExtent      core1_0.Extent2D
+	swapchainImageViews  []core1_0.ImageView
+	swapchainTransferSrc bool
+
+	// presentModes is the order present modes are preferred in, and presentMode is the one
+	// the swapchain was created with
+	presentModes []khr_surface.PresentMode
+	presentMode  khr_surface.PresentMode
+
+	// screenshotPath is where the next frame is saved, if a screenshot was requested
+	screenshotPath   string
+	screenshotWrites sync.WaitGroup
+
+	recordPath   string
+	recordEvery  int
+	recordFrames int
+	recorder     *frameRecorder
+
+	// fixedTimestep is the number of seconds animation advances each frame, or 0 to follow
+	// the wall clock
+	fixedTimestep float64
+	frameNumber   int
+
+	renderGraph         *renderGraph
 	renderPass          core1_0.RenderPass
 	descriptorPool      core1_0.DescriptorPool
 	descriptorSets      []core1_0.DescriptorSet
 	descriptorSetLayout core1_0.DescriptorSetLayout
 	pipelineLayout      core1_0.PipelineLayout
-	graphicsPipeline    core1_0.Pipeline
+	pipelines           *pipelineVariants
+	pipelineState       pipelineState
 
 	commandPool    core1_0.CommandPool
 	commandBuffers []core1_0.CommandBuffer
@@ -137,8 +181,15 @@ type HelloTriangleApplication struct {
 	currentFrame            int
 	frameStart              float64
 
-	vertices           []Vertex
-	indices            []uint32
+	// submitSerial counts frame submissions, and completedSerial is the last one known to
+	// have finished. frameSerials is the serial each in flight fence was last submitted with.
+	submitSerial      uint64
+	completedSerial   uint64
+	frameSerials      [MaxFramesInFlight]uint64
+	retiredSwapchains []*retiredSwapchain
+
+	mesh               meshSource
+	meshOptions        meshOptions
 	vertexBuffer       core1_0.Buffer
 	vertexBufferMemory core1_0.DeviceMemory
 	indexBuffer        core1_0.Buffer
@@ -148,23 +199,28 @@ type HelloTriangleApplication struct {
 	uniformBuffersMemory []core1_0.DeviceMemory
 
 	mipLevels          int
+	textureFormat      core1_0.Format
+	mipmapFilter       MipmapFilter
+	maxMipLevels       int
+	minMipSize         int
 	textureImage       core1_0.Image
+	imageStates        imageStateTracker
 	textureImageMemory core1_0.DeviceMemory
 	textureImageView   core1_0.ImageView
 	textureSampler     core1_0.Sampler
 
-	depthImage       core1_0.Image
-	depthImageMemory core1_0.DeviceMemory
-	depthImageView   core1_0.ImageView
-
+	requestedSamples core1_0.SampleCountFlags
+	usableSamples    core1_0.SampleCountFlags
 	msaaSamples      core1_0.SampleCountFlags
-	colorImage       core1_0.Image
-	colorImageMemory core1_0.DeviceMemory
-	colorImageView   core1_0.ImageView
+
+	// sampleShading is the minimum sample shading used when it's switched on, or 0 if it
+	// wasn't requested. compareSampleShading draws the left half of the screen without it.
+	sampleShading        float32
+	compareSampleShading bool
 }
 
-func (app *HelloTriangleApplication) Run() error {
-	err := app.initWindow()
+func (app *HelloTriangleApplication) Run() (err error) {
+	err = app.initWindow()
 	if err != nil {
 		return err
 	}
@@ -173,7 +229,21 @@ func (app *HelloTriangleApplication) Run() error {
 	if err != nil {
 		return err
 	}
-	defer app.cleanup()
+	defer func() {
+		if app.recorder != nil {
+			recordErr := app.recorder.Close()
+			if err == nil {
+				err = recordErr
+			}
+		}
+		app.cleanup()
+
+		// Destroying objects can raise validation errors too, so strict mode checks again
+		// once everything is gone
+		if err == nil {
+			err = app.validation.Err()
+		}
+	}()
 
 	return app.mainLoop()
 }
@@ -213,111 +283,17 @@ func (app *HelloTriangleApplication) initVulkan() error {
 		return err
 	}
 
-	err = app.pickPhysicalDevice()
-	if err != nil {
-		return err
-	}
-
-	err = app.createLogicalDevice()
-	if err != nil {
-		return err
-	}
-
-	err = app.createSwapchain()
-	if err != nil {
-		return err
-	}
-
-	err = app.createImageViews()
-	if err != nil {
-		return err
-	}
-
-	err = app.createRenderPass()
-	if err != nil {
-		return err
-	}
-
-	err = app.createDescriptorSetLayout()
-	if err != nil {
-		return err
-	}
-
-	err = app.createGraphicsPipeline()
-	if err != nil {
-		return err
-	}
-
-	err = app.createCommandPool()
-	if err != nil {
-		return err
-	}
-
-	err = app.createColorResources()
-	if err != nil {
-		return err
-	}
-
-	err = app.createDepthResources()
-	if err != nil {
-		return err
-	}
-
-	err = app.createFramebuffers()
-	if err != nil {
-		return err
-	}
-
-	err = app.createTextureImage()
-	if err != nil {
-		return err
-	}
-
-	err = app.createTextureImageView()
-	if err != nil {
-		return err
-	}
-
-	err = app.createSampler()
-	if err != nil {
-		return err
-	}
-
 	err = app.loadModel()
 	if err != nil {
 		return err
 	}
-	err = app.createVertexBuffer()
-	if err != nil {
-		return err
-	}
-
-	err = app.createIndexBuffer()
-	if err != nil {
-		return err
-	}
-
-	err = app.createUniformBuffers()
-	if err != nil {
-		return err
-	}
-
-	err = app.createDescriptorPool()
-	if err != nil {
-		return err
-	}
 
-	err = app.createDescriptorSets()
+	err = app.createDeviceResources()
 	if err != nil {
 		return err
 	}
 
-	err = app.createCommandBuffers()
-	if err != nil {
-		return err
-	}
-
-	return app.createSyncObjects()
+	return app.createRecorder()
 }
 
 func (app *HelloTriangleApplication) mainLoop() error {
@@ -325,10 +301,21 @@ func (app *HelloTriangleApplication) mainLoop() error {
 
 appLoop:
 	for {
+		app.frameTimer.BeginFrame()
+		span := app.frameTimer.Begin("poll events")
 		for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
 			switch e := event.(type) {
 			case *sdl.QuitEvent:
 				break appLoop
+			case *sdl.KeyboardEvent:
+				if e.Type != sdl.KEYDOWN || e.Repeat != 0 {
+					break
+				}
+
+				err := app.recoverFromLoss(app.handleKey(e.Keysym.Sym))
+				if err != nil {
+					return err
+				}
 			case *sdl.WindowEvent:
 				switch e.Event {
 				case sdl.WINDOWEVENT_MINIMIZED:
@@ -339,18 +326,42 @@ appLoop:
 					w, h := app.window.GetSize()
 					if w > 0 && h > 0 {
 						rendering = true
-						app.recreateSwapChain()
+						err := app.recoverFromLoss(app.recreateSwapChain())
+						if err != nil {
+							return err
+						}
 					} else {
 						rendering = false
 					}
 				}
 			}
 		}
+		app.frameTimer.End(span)
+
 		if rendering {
 			err := app.drawFrame()
+			if err == nil {
+				app.lossRecoveries = 0
+			}
+			err = app.recoverFromLoss(err)
 			if err != nil {
 				return err
 			}
+
+			if app.recorder != nil && app.recorder.Finished() {
+				log.Printf("recorded %d frames- exiting", app.recordFrames)
+				break appLoop
+			}
+
+			stats, ok := app.frameTimer.EndFrame()
+			if ok {
+				app.reportFrameStats(stats)
+			}
+		}
+
+		err := app.validation.Err()
+		if err != nil {
+			return err
 		}
 	}
 
@@ -359,142 +370,12 @@ appLoop:
 }
 
 func (app *HelloTriangleApplication) cleanupSwapChain() {
-	if app.colorImageView != nil {
-		app.colorImageView.Destroy(nil)
-		app.colorImageView = nil
-	}
-
-	if app.colorImage != nil {
-		app.colorImage.Destroy(nil)
-		app.colorImage = nil
-	}
-
-	if app.colorImageMemory != nil {
-		app.colorImageMemory.Free(nil)
-		app.colorImageMemory = nil
-	}
-
-	if app.depthImageView != nil {
-		app.depthImageView.Destroy(nil)
-		app.depthImageView = nil
-	}
-
-	if app.depthImage != nil {
-		app.depthImage.Destroy(nil)
-		app.depthImage = nil
-	}
-
-	if app.depthImageMemory != nil {
-		app.depthImageMemory.Free(nil)
-		app.depthImageMemory = nil
-	}
-
-	for _, framebuffer := range app.swapchainFramebuffers {
-		framebuffer.Destroy(nil)
-	}
-	app.swapchainFramebuffers = []core1_0.Framebuffer{}
-
-	if len(app.commandBuffers) > 0 {
-		app.device.FreeCommandBuffers(app.commandBuffers)
-		app.commandBuffers = []core1_0.CommandBuffer{}
-	}
-
-	if app.graphicsPipeline != nil {
-		app.graphicsPipeline.Destroy(nil)
-		app.graphicsPipeline = nil
-	}
-
-	if app.pipelineLayout != nil {
-		app.pipelineLayout.Destroy(nil)
-		app.pipelineLayout = nil
-	}
-
-	if app.renderPass != nil {
-		app.renderPass.Destroy(nil)
-		app.renderPass = nil
-	}
-
-	for _, imageView := range app.swapchainImageViews {
-		imageView.Destroy(nil)
-	}
-	app.swapchainImageViews = []core1_0.ImageView{}
-
-	if app.swapchain != nil {
-		app.swapchain.Destroy(nil)
-		app.swapchain = nil
-	}
-
-	for i := 0; i < len(app.uniformBuffers); i++ {
-		app.uniformBuffers[i].Destroy(nil)
-	}
-	app.uniformBuffers = app.uniformBuffers[:0]
-
-	for i := 0; i < len(app.uniformBuffersMemory); i++ {
-		app.uniformBuffersMemory[i].Free(nil)
-	}
-	app.uniformBuffersMemory = app.uniformBuffersMemory[:0]
-
-	app.descriptorPool.Destroy(nil)
+	app.retireSwapChain().Destroy()
 }
 
 func (app *HelloTriangleApplication) cleanup() {
-	app.cleanupSwapChain()
-
-	if app.textureSampler != nil {
-		app.textureSampler.Destroy(nil)
-	}
-
-	if app.textureImageView != nil {
-		app.textureImageView.Destroy(nil)
-	}
-
-	if app.textureImage != nil {
-		app.textureImage.Destroy(nil)
-	}
-
-	if app.textureImageMemory != nil {
-		app.textureImageMemory.Free(nil)
-	}
-
-	if app.descriptorSetLayout != nil {
-		app.descriptorSetLayout.Destroy(nil)
-	}
-
-	if app.indexBuffer != nil {
-		app.indexBuffer.Destroy(nil)
-	}
-
-	if app.indexBufferMemory != nil {
-		app.indexBufferMemory.Free(nil)
-	}
-
-	if app.vertexBuffer != nil {
-		app.vertexBuffer.Destroy(nil)
-	}
-
-	if app.vertexBufferMemory != nil {
-		app.vertexBufferMemory.Free(nil)
-	}
-
-	for _, fence := range app.inFlightFence {
-		fence.Destroy(nil)
-	}
-
-	for _, semaphore := range app.renderFinishedSemaphore {
-		semaphore.Destroy(nil)
-	}
-
-	for _, semaphore := range app.imageAvailableSemaphore {
-		semaphore.Destroy(nil)
-	}
-
-	if app.commandPool != nil {
-		app.commandPool.Destroy(nil)
-	}
-
-	if app.device != nil {
-		app.device.Destroy(nil)
-	}
+	app.screenshotWrites.Wait()
+	app.cleanupDevice()
 
 	if app.debugMessenger != nil {
 		app.debugMessenger.Destroy(nil)
@@ -525,14 +406,13 @@ func (app *HelloTriangleApplication) recreateSwapChain() error {
 		return nil
 	}
 
-	_, err := app.device.WaitIdle()
-	if err != nil {
-		return err
-	}
-
-	app.cleanupSwapChain()
+	// Frames in flight keep using the old swapchain's resources, so rather than waiting for
+	// the device to go idle, they're retired and destroyed once those frames finish. Passing
+	// the old swapchain on lets the new one reuse its resources.
+	retired := app.retireSwapChain()
+	app.retiredSwapchains = append(app.retiredSwapchains, retired)
 
-	err = app.createSwapchain()
+	err := app.createSwapchain(retired.swapchain)
 	if err != nil {
 		return err
 	}
@@ -542,7 +422,7 @@ func (app *HelloTriangleApplication) recreateSwapChain() error {
 		return err
 	}
 
-	err = app.createRenderPass()
+	err = app.createRenderGraph()
 	if err != nil {
 		return err
 	}
@@ -552,21 +432,6 @@ func (app *HelloTriangleApplication) recreateSwapChain() error {
 		return err
 	}
 
-	err = app.createColorResources()
-	if err != nil {
-		return err
-	}
-
-	err = app.createDepthResources()
-	if err != nil {
-		return err
-	}
-
-	err = app.createFramebuffers()
-	if err != nil {
-		return err
-	}
-
 	err = app.createUniformBuffers()
 	if err != nil {
 		return err
@@ -587,6 +452,12 @@ func (app *HelloTriangleApplication) recreateSwapChain() error {
 		return err
 	}
 
+	// The new swapchain may have more images than the old one
+	err = app.createRenderFinishedSemaphores()
+	if err != nil {
+		return err
+	}
+
 	app.imagesInFlight = []core1_0.Fence{}
 	for i := 0; i < len(app.swapchainImages); i++ {
 		app.imagesInFlight = append(app.imagesInFlight, nil)
@@ -645,7 +516,26 @@ func (app *HelloTriangleApplication) createInstance() error {
 		}
 
 		// Add debug messenger
-		instanceOptions.Next = app.debugMessengerOptions()
+		messengerOptions := app.debugMessengerOptions()
+
+		if app.validationFeatures.Any() {
+			layerExtensions, _, err := app.loader.AvailableExtensionsForLayer(validationLayers[0])
+			if err != nil {
+				return err
+			}
+
+			_, hasFeatures := layerExtensions[validationFeaturesExtensionName]
+			if hasFeatures {
+				instanceOptions.EnabledExtensionNames = append(instanceOptions.EnabledExtensionNames, validationFeaturesExtensionName)
+				messengerOptions.Next = app.validationFeatures.CreateInfo()
+				log.Printf("validation features: %s", app.validationFeatures)
+			} else {
+				log.Printf("%s is not available- validation features are disabled", validationFeaturesExtensionName)
+				app.validationFeatures = validationFeatures{}
+			}
+		}
+
+		instanceOptions.Next = messengerOptions
 	}
 
 	app.instance, _, err = app.loader.CreateInstance(nil, instanceOptions)
@@ -657,10 +547,16 @@ func (app *HelloTriangleApplication) createInstance() error {
 }
 
 func (app *HelloTriangleApplication) debugMessengerOptions() ext_debug_utils.DebugUtilsMessengerCreateInfo {
+	severity := ext_debug_utils.SeverityError | ext_debug_utils.SeverityWarning
+	if app.validationFeatures.DebugPrintf {
+		// Shader printf output arrives as info messages
+		severity |= ext_debug_utils.SeverityInfo
+	}
+
 	return ext_debug_utils.DebugUtilsMessengerCreateInfo{
-		MessageSeverity: ext_debug_utils.SeverityError | ext_debug_utils.SeverityWarning,
+		MessageSeverity: severity,
 		MessageType:     ext_debug_utils.TypeGeneral | ext_debug_utils.TypeValidation | ext_debug_utils.TypePerformance,
-		UserCallback:    app.logDebug,
+		UserCallback:    app.validation.Callback,
 	}
 }
 
@@ -700,10 +596,15 @@ func (app *HelloTriangleApplication) pickPhysicalDevice() error {
 	for _, device := range physicalDevices {
 		if app.isDeviceSuitable(device) {
 			app.physicalDevice = device
-			app.msaaSamples, err = app.getMaxUsableSampleCount()
+			app.usableSamples, err = app.getUsableSampleCounts()
 			if err != nil {
 				return err
 			}
+
+			app.msaaSamples = chooseSampleCount(app.usableSamples, app.requestedSamples)
+			if app.msaaSamples != app.requestedSamples {
+				log.Printf("%s MSAA is not supported- using %s", app.requestedSamples, app.msaaSamples)
+			}
 			break
 		}
 	}
@@ -749,23 +650,57 @@ func (app *HelloTriangleApplication) createLogicalDevice() error {
 		extensionNames = append(extensionNames, khr_portability_subset.ExtensionName)
 	}
 
+	_, supported = extensions[shaderNonSemanticInfoExtensionName]
+	if app.validationFeatures.DebugPrintf && supported {
+		extensionNames = append(extensionNames, shaderNonSemanticInfoExtensionName)
+	}
+
+	// Sample shading is optional, so it's turned off where the device can't do it
+	supportedFeatures := app.physicalDevice.Features()
+	if app.sampleShading > 0 && !supportedFeatures.SampleRateShading {
+		log.Println("sample shading is not supported- disabling it")
+		app.sampleShading = 0
+		app.compareSampleShading = false
+		app.pipelineState.SampleShading = 0
+	}
+	app.deviceFeatures = &core1_0.PhysicalDeviceFeatures{
+		SamplerAnisotropy: true,
+		// Wireframe rendering is optional, so it's only enabled where it's supported
+		FillModeNonSolid:  supportedFeatures.FillModeNonSolid,
+		SampleRateShading: app.sampleShading > 0,
+	}
+
+	// GPU-assisted validation and debug printf write from instrumented shaders, which needs
+	// stores in every stage
+	if app.validationFeatures.GPUAssisted || app.validationFeatures.DebugPrintf {
+		app.deviceFeatures.VertexPipelineStoresAndAtomics = supportedFeatures.VertexPipelineStoresAndAtomics
+		app.deviceFeatures.FragmentStoresAndAtomics = supportedFeatures.FragmentStoresAndAtomics
+	}
+
 	app.device, _, err = app.physicalDevice.CreateDevice(nil, core1_0.DeviceCreateInfo{
-		QueueCreateInfos: queueFamilyOptions,
-		EnabledFeatures: &core1_0.PhysicalDeviceFeatures{
-			SamplerAnisotropy: true,
-		},
+		QueueCreateInfos:      queueFamilyOptions,
+		EnabledFeatures:       app.deviceFeatures,
 		EnabledExtensionNames: extensionNames,
 	})
 	if err != nil {
 		return err
 	}
 
+	if enableValidationLayers {
+		app.debug = newDebugUtils(ext_debug_utils.CreateExtensionFromInstance(app.instance), app.device)
+	}
+
+	app.queueFamilies = indices
 	app.graphicsQueue = app.device.GetQueue(*indices.GraphicsFamily, 0)
 	app.presentQueue = app.device.GetQueue(*indices.PresentFamily, 0)
+	app.debug.Name(app.graphicsQueue, "graphics queue")
+	if app.presentQueue.Handle() != app.graphicsQueue.Handle() {
+		app.debug.Name(app.presentQueue, "present queue")
+	}
 	return nil
 }
 
-func (app *HelloTriangleApplication) createSwapchain() error {
+func (app *HelloTriangleApplication) createSwapchain(oldSwapchain khr_swapchain.Swapchain) error {
 	app.swapchainExtension = khr_swapchain.CreateExtensionFromDevice(app.device)
 
 	swapchainSupport, err := app.querySwapChainSupport(app.physicalDevice)
@@ -777,6 +712,13 @@ func (app *HelloTriangleApplication) createSwapchain() error {
 	presentMode := app.chooseSwapPresentMode(swapchainSupport.PresentModes)
 	extent := app.chooseSwapExtent(swapchainSupport.Capabilities)
 
+	// Screenshots copy out of the swapchain images, which not every surface allows
+	usage := core1_0.ImageUsageColorAttachment
+	app.swapchainTransferSrc = swapchainSupport.Capabilities.SupportedUsageFlags&core1_0.ImageUsageTransferSrc != 0
+	if app.swapchainTransferSrc {
+		usage |= core1_0.ImageUsageTransferSrc
+	}
+
 	imageCount := swapchainSupport.Capabilities.MinImageCount + 1
 	if swapchainSupport.Capabilities.MaxImageCount > 0 && swapchainSupport.Capabilities.MaxImageCount < imageCount {
 		imageCount = swapchainSupport.Capabilities.MaxImageCount
@@ -795,7 +737,7 @@ func (app *HelloTriangleApplication) createSwapchain() error {
 		queueFamilyIndices = append(queueFamilyIndices, *indices.GraphicsFamily, *indices.PresentFamily)
 	}
 
-	swapchain, _, err := app.swapchainExtension.CreateSwapchain(app.device, nil, khr_swapchain.SwapchainCreateInfo{
+	swapchain, res, err := app.swapchainExtension.CreateSwapchain(app.device, nil, khr_swapchain.SwapchainCreateInfo{
 		Surface: app.surface,
 
 		MinImageCount:    imageCount,
@@ -803,7 +745,7 @@ func (app *HelloTriangleApplication) createSwapchain() error {
 		ImageColorSpace:  surfaceFormat.ColorSpace,
 		ImageExtent:      extent,
 		ImageArrayLayers: 1,
-		ImageUsage:       core1_0.ImageUsageColorAttachment,
+		ImageUsage:       usage,
 
 		ImageSharingMode:   sharingMode,
 		QueueFamilyIndices: queueFamilyIndices,
@@ -812,13 +754,20 @@ func (app *HelloTriangleApplication) createSwapchain() error {
 		CompositeAlpha: khr_surface.CompositeAlphaOpaque,
 		PresentMode:    presentMode,
 		Clipped:        true,
+		OldSwapchain:   oldSwapchain,
 	})
 	if err != nil {
-		return err
+		return checkLost(res, err)
 	}
 	app.swapchainExtent = extent
 	app.swapchain = swapchain
 	app.swapchainImageFormat = surfaceFormat.Format
+	app.debug.Name(swapchain, "swapchain")
+
+	if app.swapchainImages == nil || presentMode != app.presentMode {
+		log.Printf("presenting with %s", presentMode)
+	}
+	app.presentMode = presentMode
 
 	return nil
 }
@@ -831,8 +780,9 @@ func (app *HelloTriangleApplication) createImageViews() error {
 	app.swapchainImages = images
 
 	var imageViews []core1_0.ImageView
-	for _, image := range images {
-		view, err := app.createImageView(image, app.swapchainImageFormat, core1_0.ImageAspectColor, 1)
+	for i, image := range images {
+		app.debug.Name(image, fmt.Sprintf("swapchain image %d", i))
+		view, err := app.createImageView(fmt.Sprintf("swapchain image view %d", i), image, app.swapchainImageFormat, core1_0.ImageAspectColor, 1)
 		if err != nil {
 			return err
 		}
@@ -844,88 +794,6 @@ func (app *HelloTriangleApplication) createImageViews() error {
 	return nil
 }
 
-func (app *HelloTriangleApplication) createRenderPass() error {
-	depthFormat, err := app.findDepthFormat()
-	if err != nil {
-		return err
-	}
-
-	renderPass, _, err := app.device.CreateRenderPass(nil, core1_0.RenderPassCreateInfo{
-		Attachments: []core1_0.AttachmentDescription{
-			{
-				Format:         app.swapchainImageFormat,
-				Samples:        app.msaaSamples,
-				LoadOp:         core1_0.AttachmentLoadOpClear,
-				StoreOp:        core1_0.AttachmentStoreOpStore,
-				StencilLoadOp:  core1_0.AttachmentLoadOpDontCare,
-				StencilStoreOp: core1_0.AttachmentStoreOpDontCare,
-				InitialLayout:  core1_0.ImageLayoutUndefined,
-				FinalLayout:    core1_0.ImageLayoutColorAttachmentOptimal,
-			},
-			{
-				Format:         depthFormat,
-				Samples:        app.msaaSamples,
-				LoadOp:         core1_0.AttachmentLoadOpClear,
-				StoreOp:        core1_0.AttachmentStoreOpDontCare,
-				StencilLoadOp:  core1_0.AttachmentLoadOpDontCare,
-				StencilStoreOp: core1_0.AttachmentStoreOpDontCare,
-				InitialLayout:  core1_0.ImageLayoutUndefined,
-				FinalLayout:    core1_0.ImageLayoutDepthStencilAttachmentOptimal,
-			},
-			{
-				Format:         app.swapchainImageFormat,
-				Samples:        core1_0.Samples1,
-				LoadOp:         core1_0.AttachmentLoadOpDontCare,
-				StoreOp:        core1_0.AttachmentStoreOpStore,
-				StencilLoadOp:  core1_0.AttachmentLoadOpDontCare,
-				StencilStoreOp: core1_0.AttachmentStoreOpDontCare,
-				InitialLayout:  core1_0.ImageLayoutUndefined,
-				FinalLayout:    khr_swapchain.ImageLayoutPresentSrc,
-			},
-		},
-		Subpasses: []core1_0.SubpassDescription{
-			{
-				PipelineBindPoint: core1_0.PipelineBindPointGraphics,
-				ColorAttachments: []core1_0.AttachmentReference{
-					{
-						Attachment: 0,
-						Layout:     core1_0.ImageLayoutColorAttachmentOptimal,
-					},
-				},
-				ResolveAttachments: []core1_0.AttachmentReference{
-					{
-						Attachment: 2,
-						Layout:     core1_0.ImageLayoutColorAttachmentOptimal,
-					},
-				},
-				DepthStencilAttachment: &core1_0.AttachmentReference{
-					Attachment: 1,
-					Layout:     core1_0.ImageLayoutDepthStencilAttachmentOptimal,
-				},
-			},
-		},
-		SubpassDependencies: []core1_0.SubpassDependency{
-			{
-				SrcSubpass: core1_0.SubpassExternal,
-				DstSubpass: 0,
-
-				SrcStageMask:  core1_0.PipelineStageColorAttachmentOutput | core1_0.PipelineStageEarlyFragmentTests,
-				SrcAccessMask: 0,
-
-				DstStageMask:  core1_0.PipelineStageColorAttachmentOutput | core1_0.PipelineStageEarlyFragmentTests,
-				DstAccessMask: core1_0.AccessColorAttachmentWrite | core1_0.AccessDepthStencilAttachmentWrite,
-			},
-		},
-	})
-	if err != nil {
-		return err
-	}
-
-	app.renderPass = renderPass
-
-	return nil
-}
-
 func (app *HelloTriangleApplication) createDescriptorSetLayout() error {
 	var err error
 	app.descriptorSetLayout, _, err = app.device.CreateDescriptorSetLayout(nil, core1_0.DescriptorSetLayoutCreateInfo{
@@ -933,202 +801,62 @@ func (app *HelloTriangleApplication) createDescriptorSetLayout() error {
 			{
 				Binding:         0,
 				DescriptorType:  core1_0.DescriptorTypeUniformBuffer,
-				DescriptorCount: 1,
-
-				StageFlags: core1_0.StageVertex,
-			},
-			{
-				Binding:         1,
-				DescriptorType:  core1_0.DescriptorTypeCombinedImageSampler,
-				DescriptorCount: 1,
-
-				StageFlags: core1_0.StageFragment,
-			},
-		},
-	})
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func bytesToBytecode(b []byte) []uint32 {
-	byteCode := make([]uint32, len(b)/4)
-	for i := 0; i < len(byteCode); i++ {
-		byteIndex := i * 4
-		byteCode[i] = 0
-		byteCode[i] |= uint32(b[byteIndex])
-		byteCode[i] |= uint32(b[byteIndex+1]) << 8
-		byteCode[i] |= uint32(b[byteIndex+2]) << 16
-		byteCode[i] |= uint32(b[byteIndex+3]) << 24
-	}
-
-	return byteCode
-}
-
-func (app *HelloTriangleApplication) createGraphicsPipeline() error {
-	// Load vertex shader
-	vertShaderBytes, err := fileSystem.ReadFile("shaders/vert.spv")
-	if err != nil {
-		return err
-	}
-
-	vertShader, _, err := app.device.CreateShaderModule(nil, core1_0.ShaderModuleCreateInfo{
-		Code: bytesToBytecode(vertShaderBytes),
-	})
-	if err != nil {
-		return err
-	}
-	defer vertShader.Destroy(nil)
-
-	// Load fragment shader
-	fragShaderBytes, err := fileSystem.ReadFile("shaders/frag.spv")
-	if err != nil {
-		return err
-	}
-
-	fragShader, _, err := app.device.CreateShaderModule(nil, core1_0.ShaderModuleCreateInfo{
-		Code: bytesToBytecode(fragShaderBytes),
-	})
-	if err != nil {
-		return err
-	}
-	defer fragShader.Destroy(nil)
-
-	vertexInput := &core1_0.PipelineVertexInputStateCreateInfo{
-		VertexBindingDescriptions:   getVertexBindingDescription(),
-		VertexAttributeDescriptions: getVertexAttributeDescriptions(),
-	}
-
-	inputAssembly := &core1_0.PipelineInputAssemblyStateCreateInfo{
-		Topology:               core1_0.PrimitiveTopologyTriangleList,
-		PrimitiveRestartEnable: false,
-	}
-
-	vertStage := core1_0.PipelineShaderStageCreateInfo{
-		Stage:  core1_0.StageVertex,
-		Module: vertShader,
-		Name:   "main",
-	}
-
-	fragStage := core1_0.PipelineShaderStageCreateInfo{
-		Stage:  core1_0.StageFragment,
-		Module: fragShader,
-		Name:   "main",
-	}
-
-	viewport := &core1_0.PipelineViewportStateCreateInfo{
-		Viewports: []core1_0.Viewport{
-			{
-				X:        0,
-				Y:        0,
-				Width:    float32(app.swapchainExtent.Width),
-				Height:   float32(app.swapchainExtent.Height),
-				MinDepth: 0,
-				MaxDepth: 1,
-			},
-		},
-		Scissors: []core1_0.Rect2D{
-			{
-				Offset: core1_0.Offset2D{X: 0, Y: 0},
-				Extent: app.swapchainExtent,
-			},
-		},
-	}
-
-	rasterization := &core1_0.PipelineRasterizationStateCreateInfo{
-		DepthClampEnable:        false,
-		RasterizerDiscardEnable: false,
-
-		PolygonMode: core1_0.PolygonModeFill,
-		CullMode:    core1_0.CullModeBack,
-		FrontFace:   core1_0.FrontFaceCounterClockwise,
-
-		DepthBiasEnable: false,
-
-		LineWidth: 1.0,
-	}
-
-	multisample := &core1_0.PipelineMultisampleStateCreateInfo{
-		SampleShadingEnable:  false,
-		RasterizationSamples: app.msaaSamples,
-		MinSampleShading:     1.0,
-	}
-
-	depthStencil := &core1_0.PipelineDepthStencilStateCreateInfo{
-		DepthTestEnable:  true,
-		DepthWriteEnable: true,
-		DepthCompareOp:   core1_0.CompareOpLess,
-	}
-
-	colorBlend := &core1_0.PipelineColorBlendStateCreateInfo{
-		LogicOpEnabled: false,
-		LogicOp:        core1_0.LogicOpCopy,
-
-		BlendConstants: [4]float32{0, 0, 0, 0},
-		Attachments: []core1_0.PipelineColorBlendAttachmentState{
-			{
-				BlendEnabled:   false,
-				ColorWriteMask: core1_0.ColorComponentRed | core1_0.ColorComponentGreen | core1_0.ColorComponentBlue | core1_0.ColorComponentAlpha,
-			},
-		},
-	}
+				DescriptorCount: 1,
 
-	app.pipelineLayout, _, err = app.device.CreatePipelineLayout(nil, core1_0.PipelineLayoutCreateInfo{
-		SetLayouts: []core1_0.DescriptorSetLayout{
-			app.descriptorSetLayout,
-		},
-	})
+				StageFlags: core1_0.StageVertex,
+			},
+			{
+				Binding:         1,
+				DescriptorType:  core1_0.DescriptorTypeCombinedImageSampler,
+				DescriptorCount: 1,
 
-	pipelines, _, err := app.device.CreateGraphicsPipelines(nil, nil, []core1_0.GraphicsPipelineCreateInfo{
-		{
-			Stages: []core1_0.PipelineShaderStageCreateInfo{
-				vertStage,
-				fragStage,
+				StageFlags: core1_0.StageFragment,
 			},
-			VertexInputState:   vertexInput,
-			InputAssemblyState: inputAssembly,
-			ViewportState:      viewport,
-			RasterizationState: rasterization,
-			MultisampleState:   multisample,
-			DepthStencilState:  depthStencil,
-			ColorBlendState:    colorBlend,
-			Layout:             app.pipelineLayout,
-			RenderPass:         app.renderPass,
-			Subpass:            0,
-			BasePipelineIndex:  -1,
 		},
 	})
 	if err != nil {
 		return err
 	}
-	app.graphicsPipeline = pipelines[0]
 
+	app.debug.Name(app.descriptorSetLayout, "descriptor set layout")
 	return nil
 }
 
-func (app *HelloTriangleApplication) createFramebuffers() error {
-	for _, imageView := range app.swapchainImageViews {
-		framebuffer, _, err := app.device.CreateFramebuffer(nil, core1_0.FramebufferCreateInfo{
-			RenderPass: app.renderPass,
-			Layers:     1,
-			Attachments: []core1_0.ImageView{
-				app.colorImageView,
-				app.depthImageView,
-				imageView,
+func bytesToBytecode(b []byte) []uint32 {
+	byteCode := make([]uint32, len(b)/4)
+	for i := 0; i < len(byteCode); i++ {
+		byteIndex := i * 4
+		byteCode[i] = 0
+		byteCode[i] |= uint32(b[byteIndex])
+		byteCode[i] |= uint32(b[byteIndex+1]) << 8
+		byteCode[i] |= uint32(b[byteIndex+2]) << 16
+		byteCode[i] |= uint32(b[byteIndex+3]) << 24
+	}
+
+	return byteCode
+}
+
+func (app *HelloTriangleApplication) createGraphicsPipeline() error {
+	if app.pipelines == nil {
+		var err error
+		app.pipelineLayout, _, err = app.device.CreatePipelineLayout(nil, core1_0.PipelineLayoutCreateInfo{
+			SetLayouts: []core1_0.DescriptorSetLayout{
+				app.descriptorSetLayout,
 			},
-			Width:  app.swapchainExtent.Width,
-			Height: app.swapchainExtent.Height,
 		})
 		if err != nil {
 			return err
 		}
+		app.debug.Name(app.pipelineLayout, "scene pipeline layout")
 
-		app.swapchainFramebuffers = append(app.swapchainFramebuffers, framebuffer)
+		app.pipelines = newPipelineVariants(app.pipelineLayout, app.configurePipeline)
 	}
 
-	return nil
+	// Build the current variant now, so that a pipeline that can't be built fails here
+	// instead of while recording command buffers
+	app.pipelineState.Samples = app.msaaSamples
+	_, err := app.pipelines.Get(app.pipelineState, app.renderPass)
+	return err
 }
 
 func (app *HelloTriangleApplication) createCommandPool() error {
@@ -1145,54 +873,11 @@ func (app *HelloTriangleApplication) createCommandPool() error {
 		return err
 	}
 	app.commandPool = pool
+	app.debug.Name(pool, "command pool")
 
 	return nil
 }
 
-func (app *HelloTriangleApplication) createColorResources() error {
-	var err error
-	app.colorImage, app.colorImageMemory, err = app.createImage(
-		app.swapchainExtent.Width,
-		app.swapchainExtent.Height,
-		1,
-		app.msaaSamples,
-		app.swapchainImageFormat,
-		core1_0.ImageTilingOptimal,
-		core1_0.ImageUsageTransientAttachment|core1_0.ImageUsageColorAttachment,
-		core1_0.MemoryPropertyDeviceLocal)
-	if err != nil {
-		return err
-	}
-
-	app.colorImageView, err = app.createImageView(
-		app.colorImage,
-		app.swapchainImageFormat,
-		core1_0.ImageAspectColor,
-		1)
-	return err
-}
-
-func (app *HelloTriangleApplication) createDepthResources() error {
-	depthFormat, err := app.findDepthFormat()
-	if err != nil {
-		return err
-	}
-
-	app.depthImage, app.depthImageMemory, err = app.createImage(app.swapchainExtent.Width,
-		app.swapchainExtent.Height,
-		1,
-		app.msaaSamples,
-		depthFormat,
-		core1_0.ImageTilingOptimal,
-		core1_0.ImageUsageDepthStencilAttachment,
-		core1_0.MemoryPropertyDeviceLocal)
-	if err != nil {
-		return err
-	}
-	app.depthImageView, err = app.createImageView(app.depthImage, depthFormat, core1_0.ImageAspectDepth, 1)
-	return err
-}
-
 func (app *HelloTriangleApplication) findSupportedFormat(formats []core1_0.Format, tiling core1_0.ImageTiling, features core1_0.FormatFeatureFlags) (core1_0.Format, error) {
 	for _, format := range formats {
 		props := app.physicalDevice.FormatProperties(format)
@@ -1219,22 +904,46 @@ func hasStencilComponent(format core1_0.Format) bool {
 
 func (app *HelloTriangleApplication) createTextureImage() error {
 	//Put image data into staging buffer
-	imageBytes, err := fileSystem.ReadFile("images/viking_room.png")
+	texture, err := loadTextureFile(fileSystem, textureFile)
 	if err != nil {
 		return err
 	}
 
-	decodedImage, err := png.Decode(bytes.NewBuffer(imageBytes))
+	texture, err = app.prepareTexture(texture)
 	if err != nil {
 		return err
 	}
-	imageBounds := decodedImage.Bounds()
-	imageDims := imageBounds.Size()
-	imageSize := imageDims.X * imageDims.Y * 4
+	app.textureFormat = texture.Format
+
+	// Files that ship their own mip chain are uploaded as-is, less any levels past the
+	// configured limits. Otherwise, the chain is generated with blits, which compressed
+	// formats don't support.
+	mipLimit := mipLevelCount(texture.Width, texture.Height, app.maxMipLevels, app.minMipSize)
+	generateMips := texture.LevelCount() == 1 && !texture.IsCompressed()
+	if !generateMips {
+		texture.Levels = texture.Levels[:min(texture.LevelCount(), mipLimit)]
+	}
+	if texture.IsCompressed() && texture.LevelCount() == 1 && mipLimit > 1 {
+		log.Printf("%s texture has no mip chain and compressed formats can't be blitted- using a single mip level", texture.Format)
+	}
+	app.mipLevels = texture.LevelCount()
+	if generateMips {
+		app.mipLevels = mipLimit
 
-	app.mipLevels = int(math.Log2(math.Max(float64(imageDims.X), float64(imageDims.Y))))
+		// CPU filters build every level up front, and then the texture is uploaded like
+		// one that shipped with its own mip chain
+		mipmapFilter := app.chooseMipmapFilter(texture.Format)
+		if mipmapFilter != MipmapFilterBlit {
+			texture, err = generateMipChain(texture, app.mipLevels, mipmapFilter)
+			if err != nil {
+				return err
+			}
+			generateMips = false
+		}
+	}
 
-	stagingBuffer, stagingMemory, err := app.createBuffer(imageSize, core1_0.BufferUsageTransferSrc, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
+	imageSize := texture.DataSize()
+	stagingBuffer, stagingMemory, err := app.createBuffer("texture staging buffer", imageSize, core1_0.BufferUsageTransferSrc, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
 	if err != nil {
 		return err
 	}
@@ -1242,26 +951,18 @@ func (app *HelloTriangleApplication) createTextureImage() error {
 	defer stagingBuffer.Destroy(nil)
 	defer stagingMemory.Free(nil)
 
-	var pixelData []byte
-
-	for y := imageBounds.Min.Y; y < imageBounds.Max.Y; y++ {
-		for x := imageBounds.Min.X; x < imageBounds.Max.Y; x++ {
-			r, g, b, a := decodedImage.At(x, y).RGBA()
-			pixelData = append(pixelData, byte(r), byte(g), byte(b), byte(a))
-		}
-	}
-
-	err = writeData(stagingMemory, 0, pixelData)
+	err = mapData(stagingMemory, 0, imageSize, texture.WriteLevels)
 	if err != nil {
 		return err
 	}
 
 	//Create final image
-	app.textureImage, app.textureImageMemory, err = app.createImage(imageDims.X,
-		imageDims.Y,
+	app.textureImage, app.textureImageMemory, err = app.createImage("texture image",
+		texture.Width,
+		texture.Height,
 		app.mipLevels,
 		core1_0.Samples1,
-		core1_0.FormatR8G8B8A8SRGB,
+		app.textureFormat,
 		core1_0.ImageTilingOptimal,
 		core1_0.ImageUsageTransferSrc|core1_0.ImageUsageTransferDst|core1_0.ImageUsageSampled,
 		core1_0.MemoryPropertyDeviceLocal)
@@ -1269,20 +970,25 @@ func (app *HelloTriangleApplication) createTextureImage() error {
 		return err
 	}
 
+	app.imageStates.Track(app.textureImage, app.textureFormat, app.mipLevels, 1)
+
 	// Copy staging to final
-	err = app.transitionImageLayout(app.textureImage, core1_0.FormatR8G8B8A8SRGB, core1_0.ImageLayoutUndefined, core1_0.ImageLayoutTransferDstOptimal, app.mipLevels)
+	err = app.transitionImageLayout(app.textureImage, core1_0.ImageLayoutTransferDstOptimal)
 	if err != nil {
 		return err
 	}
-	err = app.copyBufferToImage(stagingBuffer, app.textureImage, imageDims.X, imageDims.Y)
+	err = app.copyTextureToImage(stagingBuffer, app.textureImage, texture)
 	if err != nil {
 		return err
 	}
 
-	return app.generateMipmaps(app.textureImage, core1_0.FormatR8G8B8A8SRGB, imageDims.X, imageDims.Y, app.mipLevels)
+	if !generateMips {
+		return app.transitionImageLayout(app.textureImage, core1_0.ImageLayoutShaderReadOnlyOptimal)
+	}
+	return app.generateMipmaps(app.textureImage, app.textureFormat, texture, app.mipLevels)
 }
 
-func (app *HelloTriangleApplication) generateMipmaps(image core1_0.Image, imageFormat core1_0.Format, width, height int, mipLevels int) error {
+func (app *HelloTriangleApplication) generateMipmaps(image core1_0.Image, imageFormat core1_0.Format, texture *textureData, mipLevels int) error {
 
 	properties := app.physicalDevice.FormatProperties(imageFormat)
 
@@ -1290,46 +996,21 @@ func (app *HelloTriangleApplication) generateMipmaps(image core1_0.Image, imageF
 		return errors.Errorf("texture image format %s does not support linear blitting", imageFormat)
 	}
 
-	commandBuffer, err := app.beginSingleTimeCommands()
+	commandBuffer, err := app.beginSingleTimeCommands("generate mipmaps")
 	if err != nil {
 		return err
 	}
 
-	barrier := core1_0.ImageMemoryBarrier{
-		Image:               image,
-		SrcQueueFamilyIndex: -1,
-		DstQueueFamilyIndex: -1,
-		SubresourceRange: core1_0.ImageSubresourceRange{
-			AspectMask:     core1_0.ImageAspectColor,
-			BaseArrayLayer: 0,
-			LayerCount:     1,
-			LevelCount:     1,
-		},
-	}
-
-	mipWidth := width
-	mipHeight := height
 	for i := 1; i < mipLevels; i++ {
-		barrier.SubresourceRange.BaseMipLevel = i - 1
-		barrier.OldLayout = core1_0.ImageLayoutTransferDstOptimal
-		barrier.NewLayout = core1_0.ImageLayoutTransferSrcOptimal
-		barrier.SrcAccessMask = core1_0.AccessTransferWrite
-		barrier.DstAccessMask = core1_0.AccessTransferRead
-
-		err = commandBuffer.CmdPipelineBarrier(core1_0.PipelineStageTransfer, core1_0.PipelineStageTransfer, 0, nil, nil, []core1_0.ImageMemoryBarrier{barrier})
+		err = app.imageStates.Transition(commandBuffer, image, app.imageStates.MipLevels(image, i-1, 1), layoutState(core1_0.ImageLayoutTransferSrcOptimal))
 		if err != nil {
 			return err
 		}
 
-		nextMipWidth := mipWidth
-		nextMipHeight := mipHeight
-
-		if nextMipWidth > 1 {
-			nextMipWidth /= 2
-		}
-		if nextMipHeight > 1 {
-			nextMipHeight /= 2
-		}
+		// Odd sizes round down, the same as the CPU filters and the extents the image was
+		// created with
+		mipWidth, mipHeight := texture.LevelExtent(i - 1)
+		nextMipWidth, nextMipHeight := texture.LevelExtent(i)
 		err = commandBuffer.CmdBlitImage(image, core1_0.ImageLayoutTransferSrcOptimal, image, core1_0.ImageLayoutTransferDstOptimal, []core1_0.ImageBlit{
 			{
 				SrcSubresource: core1_0.ImageSubresourceLayers{
@@ -1359,32 +1040,13 @@ func (app *HelloTriangleApplication) generateMipmaps(image core1_0.Image, imageF
 			return err
 		}
 
-		barrier.OldLayout = core1_0.ImageLayoutTransferSrcOptimal
-		barrier.NewLayout = core1_0.ImageLayoutShaderReadOnlyOptimal
-		barrier.SrcAccessMask = core1_0.AccessTransferRead
-		barrier.DstAccessMask = core1_0.AccessShaderRead
-		barrier.SrcQueueFamilyIndex = -1
-		barrier.DstQueueFamilyIndex = -1
-		err = commandBuffer.CmdPipelineBarrier(core1_0.PipelineStageTransfer, core1_0.PipelineStageFragmentShader, 0, nil, nil, []core1_0.ImageMemoryBarrier{barrier})
+		err = app.imageStates.Transition(commandBuffer, image, app.imageStates.MipLevels(image, i-1, 1), layoutState(core1_0.ImageLayoutShaderReadOnlyOptimal))
 		if err != nil {
 			return err
 		}
-
-		mipWidth = nextMipWidth
-		mipHeight = nextMipHeight
 	}
 
-	barrier.SubresourceRange.BaseMipLevel = mipLevels - 1
-	barrier.OldLayout = core1_0.ImageLayoutTransferDstOptimal
-	barrier.NewLayout = core1_0.ImageLayoutShaderReadOnlyOptimal
-	barrier.SrcAccessMask = core1_0.AccessTransferWrite
-	barrier.DstAccessMask = core1_0.AccessShaderRead
-
-	err = commandBuffer.CmdPipelineBarrier(
-		core1_0.PipelineStageTransfer,
-		core1_0.PipelineStageFragmentShader,
-		0, nil, nil,
-		[]core1_0.ImageMemoryBarrier{barrier})
+	err = app.imageStates.Transition(commandBuffer, image, app.imageStates.MipLevels(image, mipLevels-1, 1), layoutState(core1_0.ImageLayoutShaderReadOnlyOptimal))
 	if err != nil {
 		return err
 	}
@@ -1392,38 +1054,9 @@ func (app *HelloTriangleApplication) generateMipmaps(image core1_0.Image, imageF
 	return app.endSingleTimeCommands(commandBuffer)
 }
 
-func (app *HelloTriangleApplication) getMaxUsableSampleCount() (core1_0.SampleCountFlags, error) {
-	properties, err := app.physicalDevice.Properties()
-	if err != nil {
-		return 0, err
-	}
-
-	counts := properties.Limits.FramebufferColorSampleCounts & properties.Limits.FramebufferDepthSampleCounts
-
-	if (counts & core1_0.Samples64) != 0 {
-		return core1_0.Samples64, nil
-	}
-	if (counts & core1_0.Samples32) != 0 {
-		return core1_0.Samples32, nil
-	}
-	if (counts & core1_0.Samples16) != 0 {
-		return core1_0.Samples16, nil
-	}
-	if (counts & core1_0.Samples8) != 0 {
-		return core1_0.Samples8, nil
-	}
-	if (counts & core1_0.Samples4) != 0 {
-		return core1_0.Samples4, nil
-	}
-	if (counts & core1_0.Samples2) != 0 {
-		return core1_0.Samples2, nil
-	}
-	return core1_0.Samples1, nil
-}
-
 func (app *HelloTriangleApplication) createTextureImageView() error {
 	var err error
-	app.textureImageView, err = app.createImageView(app.textureImage, core1_0.FormatR8G8B8A8SRGB, core1_0.ImageAspectColor, app.mipLevels)
+	app.textureImageView, err = app.createImageView("texture image view", app.textureImage, app.textureFormat, core1_0.ImageAspectColor, app.mipLevels)
 	return err
 }
 
@@ -1447,13 +1080,18 @@ func (app *HelloTriangleApplication) createSampler() error {
 
 		MipmapMode: core1_0.SamplerMipmapModeLinear,
 		MinLod:     0,
-		MaxLod:     float32(app.mipLevels),
+		// The last level is mipLevels-1, which may be above 1x1 when the chain is limited
+		MaxLod: float32(app.mipLevels - 1),
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	app.debug.Name(app.textureSampler, "texture sampler")
+	return nil
 }
 
-func (app *HelloTriangleApplication) createImageView(image core1_0.Image, format core1_0.Format, aspect core1_0.ImageAspectFlags, mipLevels int) (core1_0.ImageView, error) {
+func (app *HelloTriangleApplication) createImageView(name string, image core1_0.Image, format core1_0.Format, aspect core1_0.ImageAspectFlags, mipLevels int) (core1_0.ImageView, error) {
 	imageView, _, err := app.device.CreateImageView(nil, core1_0.ImageViewCreateInfo{
 		Image:    image,
 		ViewType: core1_0.ImageViewType2D,
@@ -1466,10 +1104,15 @@ func (app *HelloTriangleApplication) createImageView(image core1_0.Image, format
 			LayerCount:     1,
 		},
 	})
-	return imageView, err
+	if err != nil {
+		return nil, err
+	}
+
+	app.debug.Name(imageView, name)
+	return imageView, nil
 }
 
-func (app *HelloTriangleApplication) createImage(width, height int, mipLevels int, numSamples core1_0.SampleCountFlags, format core1_0.Format, tiling core1_0.ImageTiling, usage core1_0.ImageUsageFlags, memoryProperties core1_0.MemoryPropertyFlags) (core1_0.Image, core1_0.DeviceMemory, error) {
+func (app *HelloTriangleApplication) createImage(name string, width, height int, mipLevels int, numSamples core1_0.SampleCountFlags, format core1_0.Format, tiling core1_0.ImageTiling, usage core1_0.ImageUsageFlags, memoryProperties core1_0.MemoryPropertyFlags) (core1_0.Image, core1_0.DeviceMemory, error) {
 	image, _, err := app.device.CreateImage(nil, core1_0.ImageCreateInfo{
 		ImageType: core1_0.ImageType2D,
 		Extent: core1_0.Extent3D{
@@ -1506,50 +1149,18 @@ func (app *HelloTriangleApplication) createImage(width, height int, mipLevels in
 		return nil, nil, err
 	}
 
+	app.debug.Name(image, name)
+	app.debug.Name(imageMemory, name+" memory")
 	return image, imageMemory, nil
 }
 
-func (app *HelloTriangleApplication) transitionImageLayout(image core1_0.Image, format core1_0.Format, oldLayout core1_0.ImageLayout, newLayout core1_0.ImageLayout, mipLevels int) error {
-	buffer, err := app.beginSingleTimeCommands()
+func (app *HelloTriangleApplication) transitionImageLayout(image core1_0.Image, newLayout core1_0.ImageLayout) error {
+	buffer, err := app.beginSingleTimeCommands("transition image layout")
 	if err != nil {
 		return err
 	}
 
-	var sourceStage, destStage core1_0.PipelineStageFlags
-	var sourceAccess, destAccess core1_0.AccessFlags
-
-	if oldLayout == core1_0.ImageLayoutUndefined && newLayout == core1_0.ImageLayoutTransferDstOptimal {
-		sourceAccess = 0
-		destAccess = core1_0.AccessTransferWrite
-		sourceStage = core1_0.PipelineStageTopOfPipe
-		destStage = core1_0.PipelineStageTransfer
-	} else if oldLayout == core1_0.ImageLayoutTransferDstOptimal && newLayout == core1_0.ImageLayoutShaderReadOnlyOptimal {
-		sourceAccess = core1_0.AccessTransferWrite
-		destAccess = core1_0.AccessShaderRead
-		sourceStage = core1_0.PipelineStageTransfer
-		destStage = core1_0.PipelineStageFragmentShader
-	} else {
-		return errors.Errorf("unexpected layout transition: %s -> %s", oldLayout, newLayout)
-	}
-
-	err = buffer.CmdPipelineBarrier(sourceStage, destStage, 0, nil, nil, []core1_0.ImageMemoryBarrier{
-		{
-			OldLayout:           oldLayout,
-			NewLayout:           newLayout,
-			SrcQueueFamilyIndex: -1,
-			DstQueueFamilyIndex: -1,
-			Image:               image,
-			SubresourceRange: core1_0.ImageSubresourceRange{
-				AspectMask:     core1_0.ImageAspectColor,
-				BaseMipLevel:   0,
-				LevelCount:     mipLevels,
-				BaseArrayLayer: 0,
-				LayerCount:     1,
-			},
-			SrcAccessMask: sourceAccess,
-			DstAccessMask: destAccess,
-		},
-	})
+	err = app.imageStates.Transition(buffer, image, app.imageStates.WholeImage(image), layoutState(newLayout))
 	if err != nil {
 		return err
 	}
@@ -1557,35 +1168,6 @@ func (app *HelloTriangleApplication) transitionImageLayout(image core1_0.Image,
 	return app.endSingleTimeCommands(buffer)
 }
 
-func (app *HelloTriangleApplication) copyBufferToImage(buffer core1_0.Buffer, image core1_0.Image, width, height int) error {
-	cmdBuffer, err := app.beginSingleTimeCommands()
-	if err != nil {
-		return err
-	}
-
-	err = cmdBuffer.CmdCopyBufferToImage(buffer, image, core1_0.ImageLayoutTransferDstOptimal, []core1_0.BufferImageCopy{
-		{
-			BufferOffset:      0,
-			BufferRowLength:   0,
-			BufferImageHeight: 0,
-
-			ImageSubresource: core1_0.ImageSubresourceLayers{
-				AspectMask:     core1_0.ImageAspectColor,
-				MipLevel:       0,
-				BaseArrayLayer: 0,
-				LayerCount:     1,
-			},
-			ImageOffset: core1_0.Offset3D{X: 0, Y: 0, Z: 0},
-			ImageExtent: core1_0.Extent3D{Width: width, Height: height, Depth: 1},
-		},
-	})
-	if err != nil {
-		return err
-	}
-
-	return app.endSingleTimeCommands(cmdBuffer)
-}
-
 func writeData(memory core1_0.DeviceMemory, offset int, data any) error {
 	bufferSize := binary.Size(data)
 
@@ -1607,70 +1189,85 @@ func writeData(memory core1_0.DeviceMemory, offset int, data any) error {
 	return nil
 }
 
-func (app *HelloTriangleApplication) addVertex(decoder *obj.Decoder, uniqueVertices map[int]uint32, face obj.Face, faceIndex int) {
-	vertInd := face.Vertices[faceIndex]
-	index, vertexExists := uniqueVertices[vertInd]
-
-	if !vertexExists {
-		vert := Vertex{Position: vkngmath.Vec3[float32]{
-			X: decoder.Vertices[vertInd*3],
-			Y: decoder.Vertices[vertInd*3+1],
-			Z: decoder.Vertices[vertInd*3+2],
-		}, Color: vkngmath.Vec3[float32]{X: 1, Y: 1, Z: 1}}
-
-		uvInd := face.Uvs[faceIndex]
-		vert.TexCoord = vkngmath.Vec2[float32]{
-			X: decoder.Uvs[uvInd*2],
-			Y: 1.0 - decoder.Uvs[uvInd*2+1],
-		}
-
-		index = uint32(len(app.vertices))
-		app.vertices = append(app.vertices, vert)
-		uniqueVertices[vertInd] = index
+// mapData maps a range of memory and lets fill write into it directly, which avoids
+// building an intermediate copy of large uploads
+func mapData(memory core1_0.DeviceMemory, offset int, size int, fill func(dst []byte) error) error {
+	memoryPtr, _, err := memory.Map(offset, size, 0)
+	if err != nil {
+		return err
 	}
+	defer memory.Unmap()
 
-	app.indices = append(app.indices, index)
+	return fill(unsafe.Slice((*byte)(memoryPtr), size))
 }
 
-func (app *HelloTriangleApplication) loadModel() error {
-	meshFile, err := fileSystem.Open("meshes/viking_room.obj")
-	if err != nil {
-		return err
-	}
-	defer meshFile.Close()
+// objVertex builds the vertex for one corner of an OBJ face
+func objVertex(decoder *obj.Decoder, face obj.Face, faceIndex int) Vertex {
+	vertInd := face.Vertices[faceIndex]
+	vert := Vertex{Position: vkngmath.Vec3[float32]{
+		X: decoder.Vertices[vertInd*3],
+		Y: decoder.Vertices[vertInd*3+1],
+		Z: decoder.Vertices[vertInd*3+2],
+	}, Color: vkngmath.Vec3[float32]{X: 1, Y: 1, Z: 1}}
 
-	matFile, err := fileSystem.Open("meshes/viking_room.mtl")
-	if err != nil {
-		return err
+	uvInd := face.Uvs[faceIndex]
+	vert.TexCoord = vkngmath.Vec2[float32]{
+		X: decoder.Uvs[uvInd*2],
+		Y: 1.0 - decoder.Uvs[uvInd*2+1],
 	}
-	defer matFile.Close()
 
-	decoder, err := obj.DecodeReader(meshFile, matFile)
-	if err != nil {
-		return err
-	}
+	return vert
+}
 
-	uniqueVertices := make(map[int]uint32)
+// objVertices triangulates every face of a decoded OBJ and returns its unique vertices
+// and the indices that draw them
+func objVertices(decoder *obj.Decoder) ([]Vertex, []uint32) {
+	var vertices []Vertex
+	var indices []uint32
+	uniqueVertices := make(map[Vertex]uint32)
 
 	for _, decodedObj := range decoder.Objects {
 		for _, face := range decodedObj.Faces {
 			// We need to triangularize faces
 			for i := 2; i < len(face.Vertices); i++ {
-				app.addVertex(decoder, uniqueVertices, face, 0)
-				app.addVertex(decoder, uniqueVertices, face, i-1)
-				app.addVertex(decoder, uniqueVertices, face, i)
+				for _, faceIndex := range [3]int{0, i - 1, i} {
+					vert := objVertex(decoder, face, faceIndex)
+
+					// Corners that share a position but not a uv (texture seams) must stay
+					// separate, so deduplicate on the whole vertex rather than the position index
+					index, vertexExists := uniqueVertices[vert]
+
+					if !vertexExists {
+						index = uint32(len(vertices))
+						vertices = append(vertices, vert)
+						uniqueVertices[vert] = index
+					}
+
+					indices = append(indices, index)
+				}
 			}
 		}
 	}
 
-	return nil
+	return vertices, indices
+}
+
+func (app *HelloTriangleApplication) loadModel() error {
+	extension := path.Ext(modelFile)
+	if extension == ".gltf" || extension == ".glb" {
+		return app.loadGLTFModel(modelFile)
+	}
+
+	var err error
+	app.mesh, err = loadCachedOBJ(modelFile, app.meshOptions)
+	return err
 }
 
 func (app *HelloTriangleApplication) createVertexBuffer() error {
 	var err error
-	bufferSize := binary.Size(app.vertices)
+	bufferSize := app.mesh.VertexDataSize()
 
-	stagingBuffer, stagingBufferMemory, err := app.createBuffer(bufferSize, core1_0.BufferUsageTransferSrc, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
+	stagingBuffer, stagingBufferMemory, err := app.createBuffer("vertex staging buffer", bufferSize, core1_0.BufferUsageTransferSrc, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
 	if stagingBuffer != nil {
 		defer stagingBuffer.Destroy(nil)
 	}
@@ -1682,23 +1279,23 @@ func (app *HelloTriangleApplication) createVertexBuffer() error {
 		return err
 	}
 
-	err = writeData(stagingBufferMemory, 0, app.vertices)
+	err = mapData(stagingBufferMemory, 0, bufferSize, app.mesh.WriteVertices)
 	if err != nil {
 		return err
 	}
 
-	app.vertexBuffer, app.vertexBufferMemory, err = app.createBuffer(bufferSize, core1_0.BufferUsageTransferDst|core1_0.BufferUsageVertexBuffer, core1_0.MemoryPropertyDeviceLocal)
+	app.vertexBuffer, app.vertexBufferMemory, err = app.createBuffer("vertex buffer", bufferSize, core1_0.BufferUsageTransferDst|core1_0.BufferUsageVertexBuffer, core1_0.MemoryPropertyDeviceLocal)
 	if err != nil {
 		return err
 	}
 
-	return app.copyBuffer(stagingBuffer, app.vertexBuffer, bufferSize)
+	return app.copyBuffer("upload vertices", stagingBuffer, app.vertexBuffer, bufferSize)
 }
 
 func (app *HelloTriangleApplication) createIndexBuffer() error {
-	bufferSize := binary.Size(app.indices)
+	bufferSize := app.mesh.IndexDataSize()
 
-	stagingBuffer, stagingBufferMemory, err := app.createBuffer(bufferSize, core1_0.BufferUsageTransferSrc, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
+	stagingBuffer, stagingBufferMemory, err := app.createBuffer("index staging buffer", bufferSize, core1_0.BufferUsageTransferSrc, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
 	if stagingBuffer != nil {
 		defer stagingBuffer.Destroy(nil)
 	}
@@ -1710,24 +1307,24 @@ func (app *HelloTriangleApplication) createIndexBuffer() error {
 		return err
 	}
 
-	err = writeData(stagingBufferMemory, 0, app.indices)
+	err = mapData(stagingBufferMemory, 0, bufferSize, app.mesh.WriteIndices)
 	if err != nil {
 		return err
 	}
 
-	app.indexBuffer, app.indexBufferMemory, err = app.createBuffer(bufferSize, core1_0.BufferUsageTransferDst|core1_0.BufferUsageIndexBuffer, core1_0.MemoryPropertyDeviceLocal)
+	app.indexBuffer, app.indexBufferMemory, err = app.createBuffer("index buffer", bufferSize, core1_0.BufferUsageTransferDst|core1_0.BufferUsageIndexBuffer, core1_0.MemoryPropertyDeviceLocal)
 	if err != nil {
 		return err
 	}
 
-	return app.copyBuffer(stagingBuffer, app.indexBuffer, bufferSize)
+	return app.copyBuffer("upload indices", stagingBuffer, app.indexBuffer, bufferSize)
 }
 
 func (app *HelloTriangleApplication) createUniformBuffers() error {
 	bufferSize := int(unsafe.Sizeof(UniformBufferObject{}))
 
 	for i := 0; i < len(app.swapchainImages); i++ {
-		buffer, memory, err := app.createBuffer(bufferSize, core1_0.BufferUsageUniformBuffer, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
+		buffer, memory, err := app.createBuffer(fmt.Sprintf("uniform buffer %d", i), bufferSize, core1_0.BufferUsageUniformBuffer, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
 		if err != nil {
 			return err
 		}
@@ -1754,7 +1351,12 @@ func (app *HelloTriangleApplication) createDescriptorPool() error {
 			},
 		},
 	})
-	return err
+	if err != nil {
+		return err
+	}
+
+	app.debug.Name(app.descriptorPool, "descriptor pool")
+	return nil
 }
 
 func (app *HelloTriangleApplication) createDescriptorSets() error {
@@ -1773,6 +1375,7 @@ func (app *HelloTriangleApplication) createDescriptorSets() error {
 	}
 
 	for i := 0; i < len(app.swapchainImages); i++ {
+		app.debug.Name(app.descriptorSets[i], fmt.Sprintf("descriptor set %d", i))
 		err = app.device.UpdateDescriptorSets([]core1_0.WriteDescriptorSet{
 			{
 				DstSet:          app.descriptorSets[i],
@@ -1813,7 +1416,7 @@ func (app *HelloTriangleApplication) createDescriptorSets() error {
 	return nil
 }
 
-func (app *HelloTriangleApplication) createBuffer(size int, usage core1_0.BufferUsageFlags, properties core1_0.MemoryPropertyFlags) (core1_0.Buffer, core1_0.DeviceMemory, error) {
+func (app *HelloTriangleApplication) createBuffer(name string, size int, usage core1_0.BufferUsageFlags, properties core1_0.MemoryPropertyFlags) (core1_0.Buffer, core1_0.DeviceMemory, error) {
 	buffer, _, err := app.device.CreateBuffer(nil, core1_0.BufferCreateInfo{
 		Size:        size,
 		Usage:       usage,
@@ -1838,10 +1441,18 @@ func (app *HelloTriangleApplication) createBuffer(size int, usage core1_0.Buffer
 	}
 
 	_, err = buffer.BindBufferMemory(memory, 0)
-	return buffer, memory, err
+	if err != nil {
+		return buffer, memory, err
+	}
+
+	app.debug.Name(buffer, name)
+	app.debug.Name(memory, name+" memory")
+	return buffer, memory, nil
 }
 
-func (app *HelloTriangleApplication) beginSingleTimeCommands() (core1_0.CommandBuffer, error) {
+// beginSingleTimeCommands starts a command buffer for a one-off upload or transition, labeled
+// with what it does
+func (app *HelloTriangleApplication) beginSingleTimeCommands(label string) (core1_0.CommandBuffer, error) {
 	buffers, _, err := app.device.AllocateCommandBuffers(core1_0.CommandBufferAllocateInfo{
 		CommandPool:        app.commandPool,
 		Level:              core1_0.CommandBufferLevelPrimary,
@@ -1855,10 +1466,19 @@ func (app *HelloTriangleApplication) beginSingleTimeCommands() (core1_0.CommandB
 	_, err = buffer.Begin(core1_0.CommandBufferBeginInfo{
 		Flags: core1_0.CommandBufferUsageOneTimeSubmit,
 	})
-	return buffer, err
+	if err != nil {
+		return nil, err
+	}
+
+	app.debug.BeginLabel(buffer, label, uploadLabelColor)
+	app.gpuProfiler.Reset(buffer, gpuUploadSlot)
+	app.gpuProfiler.BeginScope(buffer, gpuUploadSlot, label)
+	return buffer, nil
 }
 
 func (app *HelloTriangleApplication) endSingleTimeCommands(buffer core1_0.CommandBuffer) error {
+	app.gpuProfiler.EndScope(buffer, gpuUploadSlot)
+	app.debug.EndLabel(buffer)
 	_, err := buffer.End()
 	if err != nil {
 		return err
@@ -1879,12 +1499,18 @@ func (app *HelloTriangleApplication) endSingleTimeCommands(buffer core1_0.Comman
 		return err
 	}
 
+	app.gpuProfiler.Submitted(gpuUploadSlot)
+	err = app.gpuProfiler.Collect(gpuUploadSlot)
+	if err != nil {
+		return err
+	}
+
 	app.device.FreeCommandBuffers([]core1_0.CommandBuffer{buffer})
 	return nil
 }
 
-func (app *HelloTriangleApplication) copyBuffer(srcBuffer core1_0.Buffer, dstBuffer core1_0.Buffer, size int) error {
-	buffer, err := app.beginSingleTimeCommands()
+func (app *HelloTriangleApplication) copyBuffer(label string, srcBuffer core1_0.Buffer, dstBuffer core1_0.Buffer, size int) error {
+	buffer, err := app.beginSingleTimeCommands(label)
 	if err != nil {
 		return err
 	}
@@ -1929,36 +1555,20 @@ func (app *HelloTriangleApplication) createCommandBuffers() error {
 	app.commandBuffers = buffers
 
 	for bufferIdx, buffer := range buffers {
+		app.debug.Name(buffer, fmt.Sprintf("frame command buffer %d", bufferIdx))
+
 		_, err = buffer.Begin(core1_0.CommandBufferBeginInfo{})
 		if err != nil {
 			return err
 		}
 
-		err = buffer.CmdBeginRenderPass(core1_0.SubpassContentsInline,
-			core1_0.RenderPassBeginInfo{
-				RenderPass:  app.renderPass,
-				Framebuffer: app.swapchainFramebuffers[bufferIdx],
-				RenderArea: core1_0.Rect2D{
-					Offset: core1_0.Offset2D{X: 0, Y: 0},
-					Extent: app.swapchainExtent,
-				},
-				ClearValues: []core1_0.ClearValue{
-					core1_0.ClearValueFloat{0, 0, 0, 1},
-					core1_0.ClearValueDepthStencil{Depth: 1.0, Stencil: 0},
-				},
-			})
+		app.gpuProfiler.Reset(buffer, bufferIdx)
+		app.gpuProfiler.BeginScope(buffer, bufferIdx, "frame")
+		err = app.renderGraph.Record(buffer, bufferIdx)
 		if err != nil {
 			return err
 		}
-
-		buffer.CmdBindPipeline(core1_0.PipelineBindPointGraphics, app.graphicsPipeline)
-		buffer.CmdBindVertexBuffers(0, []core1_0.Buffer{app.vertexBuffer}, []int{0})
-		buffer.CmdBindIndexBuffer(app.indexBuffer, 0, core1_0.IndexTypeUInt32)
-		buffer.CmdBindDescriptorSets(core1_0.PipelineBindPointGraphics, app.pipelineLayout, 0, []core1_0.DescriptorSet{
-			app.descriptorSets[bufferIdx],
-		}, nil)
-		buffer.CmdDrawIndexed(len(app.indices), 1, 0, 0, 0)
-		buffer.CmdEndRenderPass()
+		app.gpuProfiler.EndScope(buffer, bufferIdx)
 
 		_, err = buffer.End()
 		if err != nil {
@@ -1976,6 +1586,7 @@ func (app *HelloTriangleApplication) createSyncObjects() error {
 			return err
 		}
 
+		app.debug.Name(semaphore, fmt.Sprintf("image available semaphore %d", i))
 		app.imageAvailableSemaphore = append(app.imageAvailableSemaphore, semaphore)
 
 		fence, _, err := app.device.CreateFence(nil, core1_0.FenceCreateInfo{
@@ -1985,57 +1596,92 @@ func (app *HelloTriangleApplication) createSyncObjects() error {
 			return err
 		}
 
+		app.debug.Name(fence, fmt.Sprintf("in flight fence %d", i))
 		app.inFlightFence = append(app.inFlightFence, fence)
 	}
 
 	for i := 0; i < len(app.swapchainImages); i++ {
+		app.imagesInFlight = append(app.imagesInFlight, nil)
+	}
+
+	return app.createRenderFinishedSemaphores()
+}
+
+// createRenderFinishedSemaphores creates a render finished semaphore for each swapchain image
+// that doesn't have one yet
+func (app *HelloTriangleApplication) createRenderFinishedSemaphores() error {
+	for i := len(app.renderFinishedSemaphore); i < len(app.swapchainImages); i++ {
 		semaphore, _, err := app.device.CreateSemaphore(nil, core1_0.SemaphoreCreateInfo{})
 		if err != nil {
 			return err
 		}
 
+		app.debug.Name(semaphore, fmt.Sprintf("render finished semaphore %d", i))
 		app.renderFinishedSemaphore = append(app.renderFinishedSemaphore, semaphore)
-
-		app.imagesInFlight = append(app.imagesInFlight, nil)
 	}
 
 	return nil
 }
 
 func (app *HelloTriangleApplication) drawFrame() error {
+	// The last recreation was skipped, because the window was minimized at the time
+	if app.swapchain == nil {
+		return app.recreateSwapChain()
+	}
+
 	fences := []core1_0.Fence{app.inFlightFence[app.currentFrame]}
 
-	_, err := app.device.WaitForFences(true, common.NoTimeout, fences)
+	span := app.frameTimer.Begin(spanFenceWait)
+	res, err := app.device.WaitForFences(true, common.NoTimeout, fences)
 	if err != nil {
-		return err
+		return checkLost(res, err)
 	}
+	app.frameTimer.End(span)
 
+	app.completedSerial = max(app.completedSerial, app.frameSerials[app.currentFrame])
+	app.destroyRetiredSwapchains(false)
+
+	span = app.frameTimer.Begin("acquire")
 	imageIndex, res, err := app.swapchain.AcquireNextImage(common.NoTimeout, app.imageAvailableSemaphore[app.currentFrame], nil)
 	if res == khr_swapchain.VKErrorOutOfDate {
+		app.frameTimer.End(span)
 		return app.recreateSwapChain()
 	} else if err != nil {
-		return err
+		return checkLost(res, err)
 	}
+	app.frameTimer.End(span)
 
 	if app.imagesInFlight[imageIndex] != nil {
-		_, err := app.imagesInFlight[imageIndex].Wait(common.NoTimeout)
+		span = app.frameTimer.Begin(spanFenceWait)
+		res, err := app.imagesInFlight[imageIndex].Wait(common.NoTimeout)
 		if err != nil {
-			return err
+			return checkLost(res, err)
 		}
+		app.frameTimer.End(span)
 	}
 	app.imagesInFlight[imageIndex] = app.inFlightFence[app.currentFrame]
 
+	// The last submission of this image's command buffer is finished, so its timestamps
+	// can be read without waiting
+	err = app.gpuProfiler.Collect(imageIndex)
+	if err != nil {
+		return err
+	}
+
 	_, err = app.device.ResetFences(fences)
 	if err != nil {
 		return err
 	}
 
+	span = app.frameTimer.Begin("uniform update")
 	err = app.updateUniformBuffer(imageIndex)
 	if err != nil {
 		return err
 	}
+	app.frameTimer.End(span)
 
-	_, err = app.graphicsQueue.Submit(app.inFlightFence[app.currentFrame], []core1_0.SubmitInfo{
+	span = app.frameTimer.Begin("submit")
+	res, err = app.graphicsQueue.Submit(app.inFlightFence[app.currentFrame], []core1_0.SubmitInfo{
 		{
 			WaitSemaphores:   []core1_0.Semaphore{app.imageAvailableSemaphore[app.currentFrame]},
 			WaitDstStageMask: []core1_0.PipelineStageFlags{core1_0.PipelineStageColorAttachmentOutput},
@@ -2044,19 +1690,52 @@ func (app *HelloTriangleApplication) drawFrame() error {
 		},
 	})
 	if err != nil {
-		return err
+		return checkLost(res, err)
+	}
+	app.gpuProfiler.Submitted(imageIndex)
+	app.frameTimer.End(span)
+	app.frameNumber++
+	app.submitSerial++
+	app.frameSerials[app.currentFrame] = app.submitSerial
+
+	presentWait := app.renderFinishedSemaphore[imageIndex]
+	if app.recorder != nil {
+		span = app.frameTimer.Begin("record")
+		presentWait, err = app.recorder.Capture(app.graphicsQueue, app.presentQueue, app.swapchainImages[imageIndex], app.swapchainImageFormat, app.swapchainExtent, presentWait)
+		if err != nil {
+			return err
+		}
+		app.frameTimer.End(span)
+	}
+
+	var screenshot *swapchainReadback
+	if app.screenshotPath != "" {
+		screenshot, err = app.captureSwapchainImage(imageIndex, presentWait)
+		if err != nil {
+			return err
+		}
+		presentWait = screenshot.Done
 	}
 
+	span = app.frameTimer.Begin("present")
 	res, err = app.swapchainExtension.QueuePresent(app.presentQueue, khr_swapchain.PresentInfo{
-		WaitSemaphores: []core1_0.Semaphore{app.renderFinishedSemaphore[imageIndex]},
+		WaitSemaphores: []core1_0.Semaphore{presentWait},
 		Swapchains:     []khr_swapchain.Swapchain{app.swapchain},
 		ImageIndices:   []int{imageIndex},
 	})
+	app.frameTimer.End(span)
+
+	if screenshot != nil {
+		saveErr := app.saveScreenshot(screenshot)
+		if saveErr != nil {
+			return saveErr
+		}
+	}
 
 	if res == khr_swapchain.VKErrorOutOfDate || res == khr_swapchain.VKSuboptimal {
 		return app.recreateSwapChain()
 	} else if err != nil {
-		return err
+		return checkLost(res, err)
 	}
 	app.currentFrame = (app.currentFrame + 1) % MaxFramesInFlight
 
@@ -2065,6 +1744,9 @@ func (app *HelloTriangleApplication) drawFrame() error {
 
 func (app *HelloTriangleApplication) updateUniformBuffer(currentImage int) error {
 	currentTime := hrtime.Now().Seconds()
+	if app.fixedTimestep > 0 {
+		currentTime = float64(app.frameNumber) * app.fixedTimestep
+	}
 	timePeriod := math.Mod(currentTime, 4.0)
 
 	ubo := UniformBufferObject{}
@@ -2097,13 +1779,7 @@ func (app *HelloTriangleApplication) chooseSwapSurfaceFormat(availableFormats []
 }
 
 func (app *HelloTriangleApplication) chooseSwapPresentMode(availablePresentModes []khr_surface.PresentMode) khr_surface.PresentMode {
-	for _, presentMode := range availablePresentModes {
-		if presentMode == khr_surface.PresentModeMailbox {
-			return presentMode
-		}
-	}
-
-	return khr_surface.PresentModeFIFO
+	return choosePresentMode(availablePresentModes, app.presentModes)
 }
 
 func (app *HelloTriangleApplication) chooseSwapExtent(capabilities *khr_surface.SurfaceCapabilities) core1_0.Extent2D {
@@ -2133,20 +1809,24 @@ func (app *HelloTriangleApplication) chooseSwapExtent(capabilities *khr_surface.
 
 func (app *HelloTriangleApplication) querySwapChainSupport(device core1_0.PhysicalDevice) (SwapChainSupportDetails, error) {
 	var details SwapChainSupportDetails
+	var res common.VkResult
 	var err error
 
-	details.Capabilities, _, err = app.surface.PhysicalDeviceSurfaceCapabilities(device)
+	details.Capabilities, res, err = app.surface.PhysicalDeviceSurfaceCapabilities(device)
 	if err != nil {
-		return details, err
+		return details, checkLost(res, err)
 	}
 
-	details.Formats, _, err = app.surface.PhysicalDeviceSurfaceFormats(device)
+	details.Formats, res, err = app.surface.PhysicalDeviceSurfaceFormats(device)
 	if err != nil {
-		return details, err
+		return details, checkLost(res, err)
 	}
 
-	details.PresentModes, _, err = app.surface.PhysicalDeviceSurfacePresentModes(device)
-	return details, err
+	details.PresentModes, res, err = app.surface.PhysicalDeviceSurfacePresentModes(device)
+	if err != nil {
+		return details, checkLost(res, err)
+	}
+	return details, nil
 }
 
 func (app *HelloTriangleApplication) isDeviceSuitable(device core1_0.PhysicalDevice) bool {
@@ -2215,18 +1895,130 @@ func (app *HelloTriangleApplication) findQueueFamilies(device core1_0.PhysicalDe
 	return indices, nil
 }
 
-func (app *HelloTriangleApplication) logDebug(msgType ext_debug_utils.DebugUtilsMessageTypeFlags, severity ext_debug_utils.DebugUtilsMessageSeverityFlags, data *ext_debug_utils.DebugUtilsMessengerCallbackData) bool {
-	log.Printf("[%s %s] - %s", severity, msgType, data.Message)
-	return false
-}
+var convertMeshPath = flag.String("convert-mesh", "", "convert an .obj file to a binary mesh cache and exit")
+var convertMeshOutput = flag.String("mesh-output", "", "output path for -convert-mesh (defaults to the .obj path with a .mesh extension)")
+var indexPolicyFlag = flag.String("index-policy", "split", "meshes with more than 65535 vertices are either 'split' into 16-bit submeshes or kept whole with '32bit' indices")
+var mipmapFilterFlag = flag.String("mipmap-filter", "blit", "build texture mipmaps with GPU 'blit's, or on the CPU with a 'box' or 'kaiser' filter")
+var maxMipLevelsFlag = flag.Int("max-mip-levels", 0, "the most mip levels a texture may have, or 0 for a full chain")
+var minMipSizeFlag = flag.Int("min-mip-size", 1, "the smallest size, in texels, of the larger side of a texture's last mip level")
+var msaaFlag = flag.Int("msaa", 4, "the MSAA sample count- lowered to the highest count the device supports, and cycled at runtime with M")
+var sampleShadingFlag = flag.Float64("sample-shading", 0, "the minimum fraction of samples to shade per pixel, between 0 and 1- 0 leaves sample shading off, and S switches it at runtime")
+var compareSampleShadingFlag = flag.Bool("compare-sample-shading", false, "draw the left half of the screen without sample shading and the right half with it- switched at runtime with V")
+var validationStrictFlag = flag.Bool("validation-strict", false, "fail the run on any validation error")
+var validationFeaturesFlag = flag.String("validation-features", "", "comma-separated validation layer features to enable- best-practices, sync, gpu-assisted and debug-printf")
+var frameStatsFlag = flag.String("frame-stats", "title", "show frame timing statistics in the window 'title', in the 'log', or 'none'")
+var traceFlag = flag.String("trace", "", "write a Chrome trace of CPU frame spans to this file on exit")
+var validationDumpFlag = flag.String("validation-dump", "", "write every validation message to this file as JSON on exit")
+var recordFlag = flag.String("record", "", "record presented frames to this .y4m file, or as numbered PNGs to this directory")
+var recordEveryFlag = flag.Int("record-every", 1, "record only every Nth presented frame")
+var recordFramesFlag = flag.Int("record-frames", 0, "exit after recording this many frames, or 0 to record until the window is closed")
+var fixedTimestepFlag = flag.Float64("fixed-timestep", 0, "advance animation by this many seconds each frame instead of following the clock, for deterministic recordings")
+var presentModesFlag = flag.String("present-modes", "mailbox,fifo", "comma-separated present modes in order of preference- fifo, fifo-relaxed, mailbox and immediate, falling back to fifo. N cycles the supported modes at runtime")
+var meshOptimizationFlag = flag.String("mesh-optimization", "cache", "reorder meshes for the vertex cache ('cache'), also for overdraw ('overdraw'), or not at all ('none')")
 
 func main() {
+	flag.Parse()
+
+	indexPolicy, err := parseIndexPolicy(*indexPolicyFlag)
+	if err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	meshOptimization, err := parseMeshOptimization(*meshOptimizationFlag)
+	if err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+	options := meshOptions{
+		IndexPolicy:  indexPolicy,
+		Optimization: meshOptimization,
+	}
+
+	mipmapFilter, err := parseMipmapFilter(*mipmapFilterFlag)
+	if err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	msaaSamples, err := parseSampleCount(*msaaFlag)
+	if err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	features, err := parseValidationFeatures(*validationFeaturesFlag)
+	if err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	frameStatsOutput, err := parseFrameStatsOutput(*frameStatsFlag)
+	if err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	presentModes, err := parsePresentModes(*presentModesFlag)
+	if err != nil {
+		log.Fatalf("%+v\n", err)
+	}
+
+	sampleShading := float32(*sampleShadingFlag)
+	if sampleShading < 0 || sampleShading > 1 {
+		log.Fatalf("-sample-shading must be between 0 and 1, got %g\n", sampleShading)
+	}
+	if *compareSampleShadingFlag && sampleShading == 0 {
+		sampleShading = 1
+	}
+
+	if *recordEveryFlag < 1 {
+		log.Fatalf("-record-every must be at least 1, got %d\n", *recordEveryFlag)
+	}
+	if *fixedTimestepFlag < 0 {
+		log.Fatalf("-fixed-timestep can't be negative, got %g\n", *fixedTimestepFlag)
+	}
+
+	if *convertMeshPath != "" {
+		err := convertMesh(*convertMeshPath, *convertMeshOutput, options)
+		if err != nil {
+			log.Fatalf("%+v\n", err)
+		}
+		return
+	}
+
 	runtime.LockOSThread()
 	app := &HelloTriangleApplication{
-		msaaSamples: core1_0.Samples1,
+		requestedSamples:     msaaSamples,
+		msaaSamples:          core1_0.Samples1,
+		meshOptions:          options,
+		mipmapFilter:         mipmapFilter,
+		maxMipLevels:         *maxMipLevelsFlag,
+		minMipSize:           *minMipSizeFlag,
+		validation:           newValidationLog(slog.Default(), *validationStrictFlag),
+		validationFeatures:   features,
+		frameTimer:           newFrameTimer(*traceFlag != ""),
+		frameStatsOutput:     frameStatsOutput,
+		presentModes:         presentModes,
+		sampleShading:        sampleShading,
+		compareSampleShading: *compareSampleShadingFlag,
+		recordPath:           *recordFlag,
+		recordEvery:          *recordEveryFlag,
+		recordFrames:         *recordFramesFlag,
+		fixedTimestep:        *fixedTimestepFlag,
+		pipelineState: pipelineState{
+			CullMode:      core1_0.CullModeBack,
+			SampleShading: sampleShading,
+		},
 	}
 
-	err := app.Run()
+	err = app.Run()
+	if *traceFlag != "" {
+		traceErr := app.frameTimer.WriteTrace(*traceFlag)
+		if traceErr != nil {
+			log.Printf("%+v\n", traceErr)
+		}
+	}
+	if *validationDumpFlag != "" {
+		dumpErr := app.validation.WriteJSON(*validationDumpFlag)
+		if dumpErr != nil {
+			log.Printf("%+v\n", dumpErr)
+		}
+	}
 	if err != nil {
 		log.Fatalf("%+v\n", err)
 	}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"math"
+	"path"
+	"strings"
+	"unsafe"
+
+	"github.com/g3n/engine/loader/obj"
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/core1_0"
+	vkngmath "github.com/vkngwrapper/math"
+)
+
+type MeshBounds struct {
+	Min vkngmath.Vec3[float32]
+	Max vkngmath.Vec3[float32]
+}
+
+// Submesh is a range of the index buffer drawn with its own base vertex
+type Submesh struct {
+	FirstIndex   int
+	IndexCount   int
+	VertexOffset int
+	Bounds       MeshBounds
+}
+
+// meshSource is anything that can fill the vertex and index staging buffers. Meshes
+// decoded in memory implement it, as do binary mesh caches that are still on disk.
+type meshSource interface {
+	VertexCount() int
+	IndexCount() int
+	VertexDataSize() int
+	IndexDataSize() int
+	IndexType() core1_0.IndexType
+	Submeshes() []Submesh
+	WriteVertices(dst []byte) error
+	WriteIndices(dst []byte) error
+}
+
+// Mesh always holds 32-bit indices in memory. When Type is IndexTypeUInt16, every
+// index is known to fit in 16 bits and WriteIndices narrows them during the upload.
+type Mesh struct {
+	Vertices    []Vertex
+	Indices     []uint32
+	SubmeshList []Submesh
+	Bounds      MeshBounds
+	Type        core1_0.IndexType
+}
+
+// IndexPolicy decides what happens to meshes that have too many vertices to be drawn
+// with 16-bit indices
+type IndexPolicy int
+
+const (
+	// IndexPolicySplit breaks large meshes into submeshes of at most maxUInt16Vertices
+	// vertices so that every mesh can use 16-bit indices
+	IndexPolicySplit IndexPolicy = iota
+	// IndexPolicyKeep32 leaves large meshes whole and draws them with 32-bit indices
+	IndexPolicyKeep32
+)
+
+// maxUInt16Vertices leaves 0xFFFF free, since it is the primitive restart index
+const maxUInt16Vertices = 0xFFFF
+
+func parseIndexPolicy(value string) (IndexPolicy, error) {
+	switch value {
+	case "split":
+		return IndexPolicySplit, nil
+	case "32bit":
+		return IndexPolicyKeep32, nil
+	}
+
+	return 0, errors.Errorf("unknown index policy '%s'- expected 'split' or '32bit'", value)
+}
+
+func (p IndexPolicy) String() string {
+	if p == IndexPolicyKeep32 {
+		return "32bit"
+	}
+	return "split"
+}
+
+// newMesh builds a mesh with a single submesh that covers all of the provided indices
+func newMesh(vertices []Vertex, indices []uint32) *Mesh {
+	mesh := &Mesh{
+		Vertices: vertices,
+		Indices:  indices,
+		SubmeshList: []Submesh{
+			{
+				FirstIndex: 0,
+				IndexCount: len(indices),
+			},
+		},
+		Type: core1_0.IndexTypeUInt32,
+	}
+	mesh.computeBounds()
+
+	return mesh
+}
+
+func (m *Mesh) computeBounds() {
+	m.Bounds = emptyBounds()
+	for submeshIndex := range m.SubmeshList {
+		submesh := &m.SubmeshList[submeshIndex]
+		submesh.Bounds = emptyBounds()
+
+		for _, index := range m.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount] {
+			submesh.Bounds.add(&m.Vertices[submesh.VertexOffset+int(index)].Position)
+		}
+
+		m.Bounds.add(&submesh.Bounds.Min)
+		m.Bounds.add(&submesh.Bounds.Max)
+	}
+}
+
+func emptyBounds() MeshBounds {
+	maxFloat := float32(math.MaxFloat32)
+	return MeshBounds{
+		Min: vkngmath.Vec3[float32]{X: maxFloat, Y: maxFloat, Z: maxFloat},
+		Max: vkngmath.Vec3[float32]{X: -maxFloat, Y: -maxFloat, Z: -maxFloat},
+	}
+}
+
+func (b *MeshBounds) add(point *vkngmath.Vec3[float32]) {
+	b.Min.X = min(b.Min.X, point.X)
+	b.Min.Y = min(b.Min.Y, point.Y)
+	b.Min.Z = min(b.Min.Z, point.Z)
+	b.Max.X = max(b.Max.X, point.X)
+	b.Max.Y = max(b.Max.Y, point.Y)
+	b.Max.Z = max(b.Max.Z, point.Z)
+}
+
+func (m *Mesh) VertexCount() int {
+	return len(m.Vertices)
+}
+
+func (m *Mesh) IndexCount() int {
+	return len(m.Indices)
+}
+
+func (m *Mesh) VertexDataSize() int {
+	return len(m.Vertices) * int(unsafe.Sizeof(Vertex{}))
+}
+
+func (m *Mesh) IndexDataSize() int {
+	return len(m.Indices) * indexSize(m.Type)
+}
+
+func (m *Mesh) IndexType() core1_0.IndexType {
+	return m.Type
+}
+
+func indexSize(indexType core1_0.IndexType) int {
+	if indexType == core1_0.IndexTypeUInt16 {
+		return 2
+	}
+	return 4
+}
+
+func (m *Mesh) Submeshes() []Submesh {
+	return m.SubmeshList
+}
+
+func (m *Mesh) WriteVertices(dst []byte) error {
+	return copyExact(dst, sliceBytes(m.Vertices))
+}
+
+func (m *Mesh) WriteIndices(dst []byte) error {
+	if m.Type != core1_0.IndexTypeUInt16 {
+		return copyExact(dst, sliceBytes(m.Indices))
+	}
+
+	if len(dst) != m.IndexDataSize() {
+		return errors.Errorf("expected a destination of %d bytes but received %d", m.IndexDataSize(), len(dst))
+	}
+
+	dstIndices := unsafe.Slice((*uint16)(unsafe.Pointer(unsafe.SliceData(dst))), len(m.Indices))
+	for i, index := range m.Indices {
+		dstIndices[i] = uint16(index)
+	}
+	return nil
+}
+
+// applyIndexPolicy switches the mesh to 16-bit indices when every submesh references
+// few enough vertices. Otherwise, the mesh is either split until it does or left with
+// 32-bit indices, depending on the policy.
+func (m *Mesh) applyIndexPolicy(policy IndexPolicy) {
+	fits := true
+	for _, submesh := range m.SubmeshList {
+		for _, index := range m.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount] {
+			if index >= maxUInt16Vertices {
+				fits = false
+				break
+			}
+		}
+	}
+
+	if !fits && policy == IndexPolicyKeep32 {
+		m.Type = core1_0.IndexTypeUInt32
+		return
+	}
+
+	if !fits {
+		m.splitSubmeshes(maxUInt16Vertices)
+	}
+	m.Type = core1_0.IndexTypeUInt16
+}
+
+// splitSubmeshes rebuilds the mesh so that no submesh references more than
+// maxVertices vertices. Triangles are assigned to submeshes in order, and vertices
+// shared across a split are duplicated into both submeshes.
+func (m *Mesh) splitSubmeshes(maxVertices int) {
+	var vertices []Vertex
+	var indices []uint32
+	var submeshes []Submesh
+
+	for _, submesh := range m.SubmeshList {
+		sourceIndices := m.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount]
+		remap := make(map[uint32]uint32)
+		current := Submesh{FirstIndex: len(indices), VertexOffset: len(vertices)}
+
+		for triangle := 0; triangle+2 < len(sourceIndices); triangle += 3 {
+			newVertices := 0
+			for corner := 0; corner < 3; corner++ {
+				if _, mapped := remap[sourceIndices[triangle+corner]]; !mapped {
+					newVertices++
+				}
+			}
+
+			if len(remap)+newVertices > maxVertices {
+				current.IndexCount = len(indices) - current.FirstIndex
+				submeshes = append(submeshes, current)
+				current = Submesh{FirstIndex: len(indices), VertexOffset: len(vertices)}
+				clear(remap)
+			}
+
+			for corner := 0; corner < 3; corner++ {
+				sourceIndex := sourceIndices[triangle+corner]
+				localIndex, mapped := remap[sourceIndex]
+				if !mapped {
+					localIndex = uint32(len(vertices) - current.VertexOffset)
+					remap[sourceIndex] = localIndex
+					vertices = append(vertices, m.Vertices[submesh.VertexOffset+int(sourceIndex)])
+				}
+				indices = append(indices, localIndex)
+			}
+		}
+
+		current.IndexCount = len(indices) - current.FirstIndex
+		if current.IndexCount > 0 {
+			submeshes = append(submeshes, current)
+		}
+	}
+
+	m.Vertices = vertices
+	m.Indices = indices
+	m.SubmeshList = submeshes
+	m.computeBounds()
+}
+
+// sliceBytes reinterprets a slice of plain-old-data values as its raw bytes
+func sliceBytes[T any](values []T) []byte {
+	if len(values) == 0 {
+		return nil
+	}
+
+	var zero T
+	return unsafe.Slice((*byte)(unsafe.Pointer(&values[0])), len(values)*int(unsafe.Sizeof(zero)))
+}
+
+func copyExact(dst, src []byte) error {
+	if len(dst) != len(src) {
+		return errors.Errorf("expected a destination of %d bytes but received %d", len(src), len(dst))
+	}
+
+	copy(dst, src)
+	return nil
+}
+
+func decodeOBJFile(meshFileSystem fs.FS, filePath string) (*Mesh, error) {
+	meshFile, err := meshFileSystem.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer meshFile.Close()
+
+	var matReader io.Reader = strings.NewReader("")
+	matFile, err := meshFileSystem.Open(strings.TrimSuffix(filePath, path.Ext(filePath)) + ".mtl")
+	if err == nil {
+		defer matFile.Close()
+		matReader = matFile
+	}
+
+	decoder, err := obj.DecodeReader(meshFile, matReader)
+	if err != nil {
+		return nil, err
+	}
+
+	vertices, indices := objVertices(decoder)
+	if len(indices) == 0 {
+		return nil, errors.Errorf("decodeOBJFile: %s has no faces", filePath)
+	}
+
+	return newMesh(vertices, indices), nil
+}
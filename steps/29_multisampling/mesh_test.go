@@ -0,0 +1,172 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+	vkngmath "github.com/vkngwrapper/math"
+)
+
+// gridMesh is a flat grid of columns x rows quads, each split into two triangles, with
+// a vertex at every grid point. Every vertex has its own position, so triangles can be
+// compared by position after the vertices are reordered.
+func gridMesh(columns, rows int) *Mesh {
+	var vertices []Vertex
+	for y := 0; y <= rows; y++ {
+		for x := 0; x <= columns; x++ {
+			vertices = append(vertices, Vertex{Position: vkngmath.Vec3[float32]{X: float32(x), Y: float32(y)}})
+		}
+	}
+
+	var indices []uint32
+	for y := 0; y < rows; y++ {
+		for x := 0; x < columns; x++ {
+			topLeft := uint32(y*(columns+1) + x)
+			bottomLeft := topLeft + uint32(columns+1)
+			indices = append(indices, topLeft, bottomLeft, topLeft+1, topLeft+1, bottomLeft, bottomLeft+1)
+		}
+	}
+
+	return newMesh(vertices, indices)
+}
+
+type meshTriangle [3]vkngmath.Vec3[float32]
+
+// meshTriangles returns the corner positions of every triangle the mesh draws, in order
+func meshTriangles(t *testing.T, mesh *Mesh) []meshTriangle {
+	t.Helper()
+
+	var triangles []meshTriangle
+	for _, submesh := range mesh.SubmeshList {
+		if submesh.IndexCount%3 != 0 {
+			t.Fatalf("submesh %+v does not hold whole triangles", submesh)
+		}
+
+		indices := mesh.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount]
+		for i := 0; i < len(indices); i += 3 {
+			var triangle meshTriangle
+			for corner := 0; corner < 3; corner++ {
+				vertex := submesh.VertexOffset + int(indices[i+corner])
+				if vertex >= len(mesh.Vertices) {
+					t.Fatalf("submesh %+v reads vertex %d of %d", submesh, vertex, len(mesh.Vertices))
+				}
+				triangle[corner] = mesh.Vertices[vertex].Position
+			}
+			triangles = append(triangles, triangle)
+		}
+	}
+
+	return triangles
+}
+
+func TestSplitSubmeshes(t *testing.T) {
+	testCases := []struct {
+		name          string
+		columns, rows int
+		maxVertices   int
+		wantSubmeshes int
+		wantVertices  int
+	}{
+		// A 4x4 grid has 25 vertices, so it fits as it is
+		{name: "NoSplit", columns: 4, rows: 4, maxVertices: 25, wantSubmeshes: 1, wantVertices: 25},
+		// Every triangle needs its own submesh and its own three vertices
+		{name: "OneTrianglePerSubmesh", columns: 2, rows: 1, maxVertices: 3, wantSubmeshes: 4, wantVertices: 12},
+		// Each quad's two triangles share an edge, so a quad fits in four vertices
+		{name: "OneQuadPerSubmesh", columns: 3, rows: 1, maxVertices: 4, wantSubmeshes: 3, wantVertices: 12},
+		// A row of 8 quads has 18 vertices, and the next row shares 9 of them
+		{name: "RowsDuplicateSharedEdge", columns: 8, rows: 2, maxVertices: 18, wantSubmeshes: 2, wantVertices: 36},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			mesh := gridMesh(testCase.columns, testCase.rows)
+			before := meshTriangles(t, mesh)
+
+			mesh.splitSubmeshes(testCase.maxVertices)
+
+			if len(mesh.SubmeshList) != testCase.wantSubmeshes {
+				t.Errorf("got %d submeshes, expected %d", len(mesh.SubmeshList), testCase.wantSubmeshes)
+			}
+			if len(mesh.Vertices) != testCase.wantVertices {
+				t.Errorf("got %d vertices, expected %d", len(mesh.Vertices), testCase.wantVertices)
+			}
+
+			nextIndex := 0
+			for _, submesh := range mesh.SubmeshList {
+				if submesh.FirstIndex != nextIndex {
+					t.Errorf("submesh %+v starts at index %d, expected %d", submesh, submesh.FirstIndex, nextIndex)
+				}
+				nextIndex = submesh.FirstIndex + submesh.IndexCount
+
+				for _, index := range mesh.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount] {
+					if int(index) >= testCase.maxVertices {
+						t.Errorf("submesh %+v has index %d, expected fewer than %d", submesh, index, testCase.maxVertices)
+					}
+				}
+			}
+			if nextIndex != len(mesh.Indices) {
+				t.Errorf("submeshes cover %d of %d indices", nextIndex, len(mesh.Indices))
+			}
+
+			// Triangles are assigned in order, so they are drawn in the same order as before
+			after := meshTriangles(t, mesh)
+			if len(after) != len(before) {
+				t.Fatalf("got %d triangles, expected %d", len(after), len(before))
+			}
+			for i := range before {
+				if after[i] != before[i] {
+					t.Errorf("triangle %d is %v, expected %v", i, after[i], before[i])
+				}
+			}
+		})
+	}
+}
+
+func TestApplyIndexPolicy(t *testing.T) {
+	// 256x256 quads have 66049 vertices, which is more than 16-bit indices can address
+	testCases := []struct {
+		name          string
+		columns, rows int
+		policy        IndexPolicy
+		wantType      core1_0.IndexType
+		wantSplit     bool
+	}{
+		{name: "SmallSplit", columns: 4, rows: 4, policy: IndexPolicySplit, wantType: core1_0.IndexTypeUInt16},
+		{name: "SmallKeep32", columns: 4, rows: 4, policy: IndexPolicyKeep32, wantType: core1_0.IndexTypeUInt16},
+		{name: "LargeSplit", columns: 256, rows: 256, policy: IndexPolicySplit, wantType: core1_0.IndexTypeUInt16, wantSplit: true},
+		{name: "LargeKeep32", columns: 256, rows: 256, policy: IndexPolicyKeep32, wantType: core1_0.IndexTypeUInt32},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			mesh := gridMesh(testCase.columns, testCase.rows)
+			triangleCount := len(mesh.Indices) / 3
+			bounds := mesh.Bounds
+
+			mesh.applyIndexPolicy(testCase.policy)
+
+			if mesh.Type != testCase.wantType {
+				t.Errorf("got %s indices, expected %s", mesh.Type, testCase.wantType)
+			}
+			if split := len(mesh.SubmeshList) > 1; split != testCase.wantSplit {
+				t.Errorf("got %d submeshes, expected a split: %t", len(mesh.SubmeshList), testCase.wantSplit)
+			}
+			if len(mesh.Indices)/3 != triangleCount {
+				t.Errorf("got %d triangles, expected %d", len(mesh.Indices)/3, triangleCount)
+			}
+			if mesh.Bounds != bounds {
+				t.Errorf("bounds changed from %v to %v", bounds, mesh.Bounds)
+			}
+
+			if mesh.Type == core1_0.IndexTypeUInt16 {
+				for _, index := range mesh.Indices {
+					if index >= maxUInt16Vertices {
+						t.Fatalf("16-bit mesh has index %d", index)
+					}
+				}
+			}
+
+			meshTriangles(t, mesh)
+		})
+	}
+}
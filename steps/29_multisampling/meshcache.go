@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// The binary mesh cache is a little-endian file laid out as:
+//
+//	meshCacheHeader
+//	meshCacheAttribute * AttributeCount
+//	meshCacheSubmesh   * SubmeshCount
+//	vertex data        (VertexCount * VertexStride bytes)
+//	index data         (IndexCount * IndexSize bytes)
+//
+// SourceHash is the SHA-256 of the file the mesh was built from, so a cache is only
+// used while it still matches its source. IndexPolicy and Optimization record the
+// meshOptions the mesh was built with, since they change the data that was written.
+const (
+	meshCacheVersion   = 3
+	meshCacheExtension = ".mesh"
+)
+
+var meshCacheMagic = [4]byte{'V', 'K', 'M', 'C'}
+
+type meshCacheHeader struct {
+	Magic          [4]byte
+	Version        uint32
+	SourceHash     [sha256.Size]byte
+	VertexStride   uint32
+	AttributeCount uint32
+	IndexSize      uint32
+	IndexPolicy    uint32
+	Optimization   uint32
+	VertexCount    uint32
+	IndexCount     uint32
+	SubmeshCount   uint32
+	BoundsMin      [3]float32
+	BoundsMax      [3]float32
+}
+
+type meshCacheAttribute struct {
+	Location uint32
+	Format   uint32
+	Offset   uint32
+}
+
+type meshCacheSubmesh struct {
+	FirstIndex   uint32
+	IndexCount   uint32
+	VertexOffset uint32
+	BoundsMin    [3]float32
+	BoundsMax    [3]float32
+}
+
+func vertexLayout() []meshCacheAttribute {
+	var layout []meshCacheAttribute
+	for _, attribute := range getVertexAttributeDescriptions() {
+		layout = append(layout, meshCacheAttribute{
+			Location: uint32(attribute.Location),
+			Format:   uint32(attribute.Format),
+			Offset:   uint32(attribute.Offset),
+		})
+	}
+	return layout
+}
+
+func boundsToArrays(bounds MeshBounds) ([3]float32, [3]float32) {
+	return [3]float32{bounds.Min.X, bounds.Min.Y, bounds.Min.Z}, [3]float32{bounds.Max.X, bounds.Max.Y, bounds.Max.Z}
+}
+
+func boundsFromArrays(minBounds, maxBounds [3]float32) MeshBounds {
+	var bounds MeshBounds
+	bounds.Min.X, bounds.Min.Y, bounds.Min.Z = minBounds[0], minBounds[1], minBounds[2]
+	bounds.Max.X, bounds.Max.Y, bounds.Max.Z = maxBounds[0], maxBounds[1], maxBounds[2]
+	return bounds
+}
+
+// writeMeshCache serializes a decoded mesh along with the hash of the file it came from
+func writeMeshCache(writer io.Writer, mesh *Mesh, options meshOptions, sourceHash [sha256.Size]byte) error {
+	layout := vertexLayout()
+
+	header := meshCacheHeader{
+		Magic:          meshCacheMagic,
+		Version:        meshCacheVersion,
+		SourceHash:     sourceHash,
+		VertexStride:   uint32(unsafe.Sizeof(Vertex{})),
+		AttributeCount: uint32(len(layout)),
+		IndexSize:      uint32(indexSize(mesh.Type)),
+		IndexPolicy:    uint32(options.IndexPolicy),
+		Optimization:   uint32(options.Optimization),
+		VertexCount:    uint32(mesh.VertexCount()),
+		IndexCount:     uint32(mesh.IndexCount()),
+		SubmeshCount:   uint32(len(mesh.SubmeshList)),
+	}
+	header.BoundsMin, header.BoundsMax = boundsToArrays(mesh.Bounds)
+
+	bufferedWriter := bufio.NewWriter(writer)
+	err := binary.Write(bufferedWriter, binary.LittleEndian, &header)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(bufferedWriter, binary.LittleEndian, layout)
+	if err != nil {
+		return err
+	}
+
+	for _, submesh := range mesh.SubmeshList {
+		cacheSubmesh := meshCacheSubmesh{
+			FirstIndex:   uint32(submesh.FirstIndex),
+			IndexCount:   uint32(submesh.IndexCount),
+			VertexOffset: uint32(submesh.VertexOffset),
+		}
+		cacheSubmesh.BoundsMin, cacheSubmesh.BoundsMax = boundsToArrays(submesh.Bounds)
+
+		err = binary.Write(bufferedWriter, binary.LittleEndian, &cacheSubmesh)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Vertex and index payloads are written in host byte order, which is little-endian
+	// on every platform this tutorial runs on
+	_, err = bufferedWriter.Write(sliceBytes(mesh.Vertices))
+	if err != nil {
+		return err
+	}
+
+	indexBytes := make([]byte, mesh.IndexDataSize())
+	err = mesh.WriteIndices(indexBytes)
+	if err != nil {
+		return err
+	}
+
+	_, err = bufferedWriter.Write(indexBytes)
+	if err != nil {
+		return err
+	}
+
+	return bufferedWriter.Flush()
+}
+
+// cachedMesh is a meshSource backed by a mesh cache file. Only the header is held in
+// memory- vertex and index data are read straight into the destination when requested.
+type cachedMesh struct {
+	fileSystem   fs.FS
+	filePath     string
+	header       meshCacheHeader
+	submeshes    []Submesh
+	bounds       MeshBounds
+	vertexOffset int64
+}
+
+// openMeshCache reads the header of a mesh cache file and checks that it matches the
+// current vertex layout and the provided source hash
+func openMeshCache(fileSystem fs.FS, filePath string, options meshOptions, sourceHash [sha256.Size]byte) (*cachedMesh, error) {
+	file, err := fileSystem.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	cached := &cachedMesh{
+		fileSystem: fileSystem,
+		filePath:   filePath,
+	}
+
+	reader := bufio.NewReader(file)
+	err = binary.Read(reader, binary.LittleEndian, &cached.header)
+	if err != nil {
+		return nil, errors.Wrapf(err, "openMeshCache: could not read header of %s", filePath)
+	}
+
+	header := &cached.header
+	if header.Magic != meshCacheMagic {
+		return nil, errors.Errorf("openMeshCache: %s is not a mesh cache", filePath)
+	}
+	if header.Version != meshCacheVersion {
+		return nil, errors.Errorf("openMeshCache: %s has version %d, expected %d", filePath, header.Version, meshCacheVersion)
+	}
+	if header.SourceHash != sourceHash {
+		return nil, errors.Errorf("openMeshCache: %s is out of date with its source", filePath)
+	}
+	if header.IndexPolicy != uint32(options.IndexPolicy) || header.Optimization != uint32(options.Optimization) {
+		return nil, errors.Errorf("openMeshCache: %s was built with index policy %s and optimization %s", filePath,
+			IndexPolicy(header.IndexPolicy), MeshOptimization(header.Optimization))
+	}
+	if header.VertexStride != uint32(unsafe.Sizeof(Vertex{})) || (header.IndexSize != 2 && header.IndexSize != 4) {
+		return nil, errors.Errorf("openMeshCache: %s has an unexpected vertex or index size", filePath)
+	}
+	if header.VertexCount == 0 || header.IndexCount == 0 || header.SubmeshCount == 0 {
+		return nil, errors.Errorf("openMeshCache: %s has an empty mesh", filePath)
+	}
+
+	expectedLayout := vertexLayout()
+	if int(header.AttributeCount) != len(expectedLayout) {
+		return nil, errors.Errorf("openMeshCache: %s has a different vertex layout", filePath)
+	}
+
+	layout := make([]meshCacheAttribute, header.AttributeCount)
+	err = binary.Read(reader, binary.LittleEndian, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range layout {
+		if layout[i] != expectedLayout[i] {
+			return nil, errors.Errorf("openMeshCache: %s has a different vertex layout", filePath)
+		}
+	}
+
+	for i := 0; i < int(header.SubmeshCount); i++ {
+		var cacheSubmesh meshCacheSubmesh
+		err = binary.Read(reader, binary.LittleEndian, &cacheSubmesh)
+		if err != nil {
+			return nil, err
+		}
+
+		// Checked in 64 bits so that corrupt counts can't wrap around into range
+		if uint64(cacheSubmesh.FirstIndex)+uint64(cacheSubmesh.IndexCount) > uint64(header.IndexCount) {
+			return nil, errors.Errorf("openMeshCache: %s has a submesh outside of its index data", filePath)
+		}
+		if cacheSubmesh.VertexOffset >= header.VertexCount {
+			return nil, errors.Errorf("openMeshCache: %s has a submesh outside of its vertex data", filePath)
+		}
+
+		cached.submeshes = append(cached.submeshes, Submesh{
+			FirstIndex:   int(cacheSubmesh.FirstIndex),
+			IndexCount:   int(cacheSubmesh.IndexCount),
+			VertexOffset: int(cacheSubmesh.VertexOffset),
+			Bounds:       boundsFromArrays(cacheSubmesh.BoundsMin, cacheSubmesh.BoundsMax),
+		})
+	}
+	cached.bounds = boundsFromArrays(header.BoundsMin, header.BoundsMax)
+
+	cached.vertexOffset = int64(binary.Size(header)) +
+		int64(binary.Size(layout)) +
+		int64(header.SubmeshCount)*int64(binary.Size(meshCacheSubmesh{}))
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != cached.vertexOffset+int64(cached.VertexDataSize()+cached.IndexDataSize()) {
+		return nil, errors.Errorf("openMeshCache: %s is truncated", filePath)
+	}
+
+	// An index past the end of the vertex data would have the GPU read out of bounds, so
+	// the indices are checked now. They're a fraction of the size of the vertices, which
+	// are skipped.
+	_, err = reader.Discard(cached.VertexDataSize())
+	if err != nil {
+		return nil, err
+	}
+
+	indexBytes := make([]byte, cached.IndexDataSize())
+	_, err = io.ReadFull(reader, indexBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkCachedIndices(indexBytes, header.IndexSize, cached.submeshes, header.VertexCount)
+	if err != nil {
+		return nil, errors.Wrapf(err, "openMeshCache: %s", filePath)
+	}
+
+	return cached, nil
+}
+
+// checkCachedIndices checks that every index, offset by its submesh's base vertex, is
+// inside the vertex data
+func checkCachedIndices(indexBytes []byte, indexSize uint32, submeshes []Submesh, vertexCount uint32) error {
+	for _, submesh := range submeshes {
+		for i := submesh.FirstIndex; i < submesh.FirstIndex+submesh.IndexCount; i++ {
+			var index uint32
+			if indexSize == 2 {
+				index = uint32(binary.LittleEndian.Uint16(indexBytes[i*2:]))
+			} else {
+				index = binary.LittleEndian.Uint32(indexBytes[i*4:])
+			}
+
+			if uint64(submesh.VertexOffset)+uint64(index) >= uint64(vertexCount) {
+				return errors.Errorf("index %d is %d, which is past the %d vertices", i, index, vertexCount)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *cachedMesh) VertexCount() int {
+	return int(c.header.VertexCount)
+}
+
+func (c *cachedMesh) IndexCount() int {
+	return int(c.header.IndexCount)
+}
+
+func (c *cachedMesh) VertexDataSize() int {
+	return int(c.header.VertexCount) * int(c.header.VertexStride)
+}
+
+func (c *cachedMesh) IndexDataSize() int {
+	return int(c.header.IndexCount) * int(c.header.IndexSize)
+}
+
+func (c *cachedMesh) IndexType() core1_0.IndexType {
+	if c.header.IndexSize == 2 {
+		return core1_0.IndexTypeUInt16
+	}
+	return core1_0.IndexTypeUInt32
+}
+
+func (c *cachedMesh) Submeshes() []Submesh {
+	return c.submeshes
+}
+
+func (c *cachedMesh) WriteVertices(dst []byte) error {
+	return c.readAt(dst, c.vertexOffset, c.VertexDataSize())
+}
+
+func (c *cachedMesh) WriteIndices(dst []byte) error {
+	return c.readAt(dst, c.vertexOffset+int64(c.VertexDataSize()), c.IndexDataSize())
+}
+
+func (c *cachedMesh) readAt(dst []byte, offset int64, size int) error {
+	if len(dst) != size {
+		return errors.Errorf("expected a destination of %d bytes but received %d", size, len(dst))
+	}
+
+	file, err := c.fileSystem.Open(c.filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	seeker, isSeeker := file.(io.Seeker)
+	if !isSeeker {
+		return errors.Errorf("mesh cache %s cannot be seeked", c.filePath)
+	}
+
+	_, err = seeker.Seek(offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.ReadFull(file, dst)
+	return err
+}
+
+func meshCacheName(sourcePath string) string {
+	return strings.TrimSuffix(path.Base(sourcePath), path.Ext(sourcePath)) + meshCacheExtension
+}
+
+func userMeshCacheDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(cacheDir, "vulkan-tutorial", "meshes"), nil
+}
+
+// loadCachedOBJ returns the mesh for an embedded OBJ file, preferring a matching cache
+// shipped next to the OBJ, then one in the user's cache directory. If neither matches,
+// the OBJ is decoded and a fresh cache is written to the user's cache directory.
+func loadCachedOBJ(objPath string, options meshOptions) (meshSource, error) {
+	objBytes, err := fileSystem.ReadFile(objPath)
+	if err != nil {
+		return nil, err
+	}
+	sourceHash := sha256.Sum256(objBytes)
+	cacheName := meshCacheName(objPath)
+
+	cached, err := openMeshCache(fileSystem, path.Join(path.Dir(objPath), cacheName), options, sourceHash)
+	if err == nil {
+		return cached, nil
+	}
+
+	cacheDir, cacheDirErr := userMeshCacheDir()
+	if cacheDirErr == nil {
+		cached, err = openMeshCache(os.DirFS(cacheDir), cacheName, options, sourceHash)
+		if err == nil {
+			return cached, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("rebuilding mesh cache: %v", err)
+		}
+	}
+
+	mesh, err := decodeOBJFile(fileSystem, objPath)
+	if err != nil {
+		return nil, err
+	}
+	options.process(mesh)
+
+	if cacheDirErr != nil {
+		log.Printf("could not write mesh cache: %v", cacheDirErr)
+		return mesh, nil
+	}
+
+	err = saveMeshCache(filepath.Join(cacheDir, cacheName), mesh, options, sourceHash)
+	if err != nil {
+		log.Printf("could not write mesh cache: %v", err)
+	}
+
+	return mesh, nil
+}
+
+func saveMeshCache(cachePath string, mesh *Mesh, options meshOptions, sourceHash [sha256.Size]byte) error {
+	err := os.MkdirAll(filepath.Dir(cachePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a half-written cache behind
+	tempPath := cachePath + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+
+	err = writeMeshCache(file, mesh, options, sourceHash)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return os.Rename(tempPath, cachePath)
+}
+
+// convertMesh is the offline converter: it decodes an OBJ file from disk and writes the
+// binary mesh cache for it. If outputPath is empty, the cache is written next to the OBJ.
+func convertMesh(objPath string, outputPath string, options meshOptions) error {
+	objPath = filepath.Clean(objPath)
+	if outputPath == "" {
+		outputPath = filepath.Join(filepath.Dir(objPath), meshCacheName(filepath.ToSlash(objPath)))
+	}
+
+	objBytes, err := os.ReadFile(objPath)
+	if err != nil {
+		return err
+	}
+
+	mesh, err := decodeOBJFile(os.DirFS(filepath.Dir(objPath)), filepath.Base(objPath))
+	if err != nil {
+		return err
+	}
+	options.process(mesh)
+
+	err = saveMeshCache(outputPath, mesh, options, sha256.Sum256(objBytes))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("wrote %s: %d vertices, %d %s indices in %d submeshes", outputPath, mesh.VertexCount(), mesh.IndexCount(), mesh.Type, len(mesh.SubmeshList))
+	return nil
+}
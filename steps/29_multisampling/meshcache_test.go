@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+	"testing/fstest"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+	vkngmath "github.com/vkngwrapper/math"
+)
+
+// testCacheMesh is two triangles in separate submeshes, the second drawn with a base vertex
+func testCacheMesh() *Mesh {
+	var vertices []Vertex
+	for i := 0; i < 6; i++ {
+		vertices = append(vertices, Vertex{Position: vkngmath.Vec3[float32]{X: float32(i), Y: float32(i % 3), Z: 1}})
+	}
+
+	mesh := &Mesh{
+		Vertices: vertices,
+		Indices:  []uint32{0, 1, 2, 2, 1, 0},
+		SubmeshList: []Submesh{
+			{FirstIndex: 0, IndexCount: 3, VertexOffset: 0},
+			{FirstIndex: 3, IndexCount: 3, VertexOffset: 3},
+		},
+		Type: core1_0.IndexTypeUInt16,
+	}
+	mesh.computeBounds()
+	return mesh
+}
+
+// meshCacheFile is a mesh cache taken apart, so tests can corrupt one piece at a time
+type meshCacheFile struct {
+	header    meshCacheHeader
+	layout    []meshCacheAttribute
+	submeshes []meshCacheSubmesh
+	data      []byte
+}
+
+func readMeshCacheFile(t *testing.T, cacheBytes []byte) *meshCacheFile {
+	t.Helper()
+
+	file := &meshCacheFile{}
+	reader := bytes.NewReader(cacheBytes)
+	err := binary.Read(reader, binary.LittleEndian, &file.header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.layout = make([]meshCacheAttribute, file.header.AttributeCount)
+	file.submeshes = make([]meshCacheSubmesh, file.header.SubmeshCount)
+	err = binary.Read(reader, binary.LittleEndian, file.layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = binary.Read(reader, binary.LittleEndian, file.submeshes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.data = bytes.Clone(cacheBytes[len(cacheBytes)-reader.Len():])
+
+	return file
+}
+
+func (f *meshCacheFile) Bytes() []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, &f.header)
+	binary.Write(&buffer, binary.LittleEndian, f.layout)
+	binary.Write(&buffer, binary.LittleEndian, f.submeshes)
+	buffer.Write(f.data)
+	return buffer.Bytes()
+}
+
+func TestMeshCacheRoundTrip(t *testing.T) {
+	mesh := testCacheMesh()
+	options := meshOptions{IndexPolicy: IndexPolicySplit, Optimization: MeshOptimizationNone}
+	sourceHash := sha256.Sum256([]byte("source"))
+
+	var cacheBytes bytes.Buffer
+	err := writeMeshCache(&cacheBytes, mesh, options, sourceHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := openMeshCache(fstest.MapFS{"mesh.mesh": {Data: cacheBytes.Bytes()}}, "mesh.mesh", options, sourceHash)
+	if err != nil {
+		t.Fatalf("openMeshCache: %+v", err)
+	}
+
+	if cached.VertexCount() != mesh.VertexCount() || cached.IndexCount() != mesh.IndexCount() || cached.IndexType() != mesh.IndexType() {
+		t.Errorf("got %d vertices and %d %s indices, expected %d and %d %s", cached.VertexCount(), cached.IndexCount(), cached.IndexType(),
+			mesh.VertexCount(), mesh.IndexCount(), mesh.IndexType())
+	}
+	if len(cached.Submeshes()) != len(mesh.Submeshes()) {
+		t.Fatalf("got %d submeshes, expected %d", len(cached.Submeshes()), len(mesh.Submeshes()))
+	}
+	for i, submesh := range cached.Submeshes() {
+		if submesh != mesh.SubmeshList[i] {
+			t.Errorf("submesh %d is %+v, expected %+v", i, submesh, mesh.SubmeshList[i])
+		}
+	}
+
+	for _, data := range []struct {
+		name   string
+		size   int
+		cached func([]byte) error
+		mesh   func([]byte) error
+	}{
+		{name: "vertex", size: mesh.VertexDataSize(), cached: cached.WriteVertices, mesh: mesh.WriteVertices},
+		{name: "index", size: mesh.IndexDataSize(), cached: cached.WriteIndices, mesh: mesh.WriteIndices},
+	} {
+		cachedBytes := make([]byte, data.size)
+		meshBytes := make([]byte, data.size)
+		err = data.cached(cachedBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = data.mesh(meshBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(cachedBytes, meshBytes) {
+			t.Errorf("cached %s data doesn't match the mesh", data.name)
+		}
+	}
+}
+
+func TestOpenMeshCacheRejects(t *testing.T) {
+	options := meshOptions{IndexPolicy: IndexPolicySplit, Optimization: MeshOptimizationNone}
+	sourceHash := sha256.Sum256([]byte("source"))
+
+	var validBytes bytes.Buffer
+	err := writeMeshCache(&validBytes, testCacheMesh(), options, sourceHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emptyMesh := &Mesh{Type: core1_0.IndexTypeUInt16}
+	var emptyBytes bytes.Buffer
+	err = writeMeshCache(&emptyBytes, emptyMesh, options, sourceHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name    string
+		cache   []byte
+		corrupt func(file *meshCacheFile)
+	}{
+		{
+			name:  "EmptyMesh",
+			cache: emptyBytes.Bytes(),
+		},
+		{
+			name:  "EmptySubmeshList",
+			cache: validBytes.Bytes(),
+			corrupt: func(file *meshCacheFile) {
+				file.header.SubmeshCount = 0
+				file.submeshes = nil
+			},
+		},
+		{
+			name:  "WrongSourceHash",
+			cache: validBytes.Bytes(),
+			corrupt: func(file *meshCacheFile) {
+				file.header.SourceHash[0] ^= 0xff
+			},
+		},
+		{
+			name:  "HugeAttributeCount",
+			cache: validBytes.Bytes(),
+			corrupt: func(file *meshCacheFile) {
+				file.header.AttributeCount = 0xFFFFFFFF
+			},
+		},
+		{
+			// 0xFFFFFFFF + 2 wraps around to 1 in 32 bits, which is inside the index data
+			name:  "IndexRangeWraps",
+			cache: validBytes.Bytes(),
+			corrupt: func(file *meshCacheFile) {
+				file.submeshes[1].FirstIndex = 0xFFFFFFFF
+				file.submeshes[1].IndexCount = 2
+			},
+		},
+		{
+			name:  "IndexRangePastEnd",
+			cache: validBytes.Bytes(),
+			corrupt: func(file *meshCacheFile) {
+				file.submeshes[1].IndexCount = 4
+			},
+		},
+		{
+			name:  "VertexOffsetPastEnd",
+			cache: validBytes.Bytes(),
+			corrupt: func(file *meshCacheFile) {
+				file.submeshes[1].VertexOffset = file.header.VertexCount
+			},
+		},
+		{
+			// The second submesh starts at vertex 3, so index 3 reaches vertex 6 of 6
+			name:  "IndexPastVertexData",
+			cache: validBytes.Bytes(),
+			corrupt: func(file *meshCacheFile) {
+				indexData := file.data[len(file.data)-int(file.header.IndexCount)*2:]
+				binary.LittleEndian.PutUint16(indexData[5*2:], 3)
+			},
+		},
+		{
+			name:  "Truncated",
+			cache: validBytes.Bytes(),
+			corrupt: func(file *meshCacheFile) {
+				file.data = file.data[:len(file.data)-1]
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			cacheBytes := testCase.cache
+			if testCase.corrupt != nil {
+				file := readMeshCacheFile(t, cacheBytes)
+				testCase.corrupt(file)
+				cacheBytes = file.Bytes()
+			}
+
+			_, err := openMeshCache(fstest.MapFS{"mesh.mesh": {Data: cacheBytes}}, "mesh.mesh", options, sourceHash)
+			if err == nil {
+				t.Fatal("openMeshCache succeeded, expected an error")
+			}
+			t.Log(err)
+		})
+	}
+}
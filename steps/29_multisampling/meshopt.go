@@ -0,0 +1,433 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+	vkngmath "github.com/vkngwrapper/math"
+)
+
+// MeshOptimization selects how much work is done reordering a mesh after it is loaded
+type MeshOptimization int
+
+const (
+	// MeshOptimizationNone leaves triangles and vertices in file order
+	MeshOptimizationNone MeshOptimization = iota
+	// MeshOptimizationCache reorders triangles for the post-transform vertex cache and
+	// vertices for fetch locality
+	MeshOptimizationCache
+	// MeshOptimizationOverdraw additionally sorts clusters of triangles so that
+	// outward-facing geometry tends to be drawn first
+	MeshOptimizationOverdraw
+)
+
+func parseMeshOptimization(value string) (MeshOptimization, error) {
+	switch value {
+	case "none":
+		return MeshOptimizationNone, nil
+	case "cache":
+		return MeshOptimizationCache, nil
+	case "overdraw":
+		return MeshOptimizationOverdraw, nil
+	}
+
+	return 0, errors.Errorf("unknown mesh optimization '%s'- expected 'none', 'cache' or 'overdraw'", value)
+}
+
+func (o MeshOptimization) String() string {
+	switch o {
+	case MeshOptimizationCache:
+		return "cache"
+	case MeshOptimizationOverdraw:
+		return "overdraw"
+	}
+	return "none"
+}
+
+// meshOptions holds everything that changes how a decoded mesh is processed before
+// upload. Mesh caches record these, since they change the data that gets written.
+type meshOptions struct {
+	IndexPolicy  IndexPolicy
+	Optimization MeshOptimization
+}
+
+// process optimizes a freshly decoded mesh and then picks its index type
+func (o meshOptions) process(mesh *Mesh) {
+	if o.Optimization != MeshOptimizationNone {
+		before := analyzeVertexCache(mesh, statsCacheSize)
+		mesh.optimize(o.Optimization)
+		after := analyzeVertexCache(mesh, statsCacheSize)
+
+		log.Printf("mesh optimization (%s): ACMR %.3f -> %.3f, ATVR %.3f -> %.3f",
+			o.Optimization, before.ACMR, after.ACMR, before.ATVR, after.ATVR)
+	}
+
+	mesh.applyIndexPolicy(o.IndexPolicy)
+}
+
+// VertexCacheStats describes how well a mesh uses a simulated FIFO vertex cache. ACMR
+// is the number of vertices transformed per triangle (0.5 is ideal for large grids, 3
+// is the worst case) and ATVR is the number of vertices transformed per unique vertex
+// (1 is ideal).
+type VertexCacheStats struct {
+	ACMR float64
+	ATVR float64
+}
+
+// statsCacheSize is the FIFO size used for statistics, which is close to what most
+// current hardware behaves like
+const statsCacheSize = 16
+
+func analyzeVertexCache(mesh *Mesh, cacheSize int) VertexCacheStats {
+	var stats VertexCacheStats
+	triangles := 0
+	transforms := 0
+	referenced := make(map[int]struct{})
+
+	for _, submesh := range mesh.SubmeshList {
+		// Each draw starts with a cold cache
+		fifo := make([]int, 0, cacheSize)
+
+		for _, index := range mesh.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount] {
+			vertex := submesh.VertexOffset + int(index)
+			referenced[vertex] = struct{}{}
+
+			hit := false
+			for _, cached := range fifo {
+				if cached == vertex {
+					hit = true
+					break
+				}
+			}
+			if hit {
+				continue
+			}
+
+			transforms++
+			if len(fifo) == cacheSize {
+				fifo = fifo[1:]
+			}
+			fifo = append(fifo, vertex)
+		}
+
+		triangles += submesh.IndexCount / 3
+	}
+
+	if triangles > 0 {
+		stats.ACMR = float64(transforms) / float64(triangles)
+	}
+	if len(referenced) > 0 {
+		stats.ATVR = float64(transforms) / float64(len(referenced))
+	}
+	return stats
+}
+
+// optimize reorders each submesh's triangles and then the vertex buffer. Afterwards,
+// every submesh has a VertexOffset of 0 and vertices that no triangle uses are dropped.
+func (m *Mesh) optimize(optimization MeshOptimization) {
+	for _, submesh := range m.SubmeshList {
+		indices := m.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount]
+		vertexCount := 0
+		for _, index := range indices {
+			vertexCount = max(vertexCount, int(index)+1)
+		}
+
+		optimizeVertexCache(indices, vertexCount)
+		if optimization == MeshOptimizationOverdraw {
+			optimizeOverdraw(indices, m.Vertices[submesh.VertexOffset:])
+		}
+	}
+
+	m.optimizeVertexFetch()
+	m.computeBounds()
+}
+
+// Scoring constants from Tom Forsyth's "Linear-Speed Vertex Cache Optimisation"
+const (
+	forsythCacheSize         = 32
+	forsythCacheDecayPower   = 1.5
+	forsythLastTriangleScore = 0.75
+	forsythValenceBoostScale = 2.0
+	forsythValencePower      = 0.5
+)
+
+func forsythVertexScore(cachePosition int, remainingTriangles int) float32 {
+	if remainingTriangles == 0 {
+		return -1
+	}
+
+	score := 0.0
+	if cachePosition >= 0 {
+		if cachePosition < 3 {
+			// The vertices of the last triangle get a fixed score so that the algorithm
+			// doesn't prefer strips over fans
+			score = forsythLastTriangleScore
+		} else {
+			scaler := 1.0 / (forsythCacheSize - 3)
+			score = math.Pow(1.0-float64(cachePosition-3)*scaler, forsythCacheDecayPower)
+		}
+	}
+
+	// Vertices with few remaining triangles get a boost, so that lone triangles are
+	// finished off instead of being left behind for later
+	score += forsythValenceBoostScale * math.Pow(float64(remainingTriangles), -forsythValencePower)
+	return float32(score)
+}
+
+// optimizeVertexCache reorders triangles in place using Forsyth's algorithm. Indices
+// must be in the range [0, vertexCount).
+func optimizeVertexCache(indices []uint32, vertexCount int) {
+	triangleCount := len(indices) / 3
+	if triangleCount == 0 {
+		return
+	}
+
+	// Build vertex -> triangle adjacency in a single flat array
+	remaining := make([]int, vertexCount)
+	for _, index := range indices[:triangleCount*3] {
+		remaining[index]++
+	}
+
+	adjacencyOffsets := make([]int, vertexCount+1)
+	for vertex := 0; vertex < vertexCount; vertex++ {
+		adjacencyOffsets[vertex+1] = adjacencyOffsets[vertex] + remaining[vertex]
+	}
+	adjacency := make([]int, adjacencyOffsets[vertexCount])
+	fill := make([]int, vertexCount)
+	copy(fill, adjacencyOffsets[:vertexCount])
+	for triangle := 0; triangle < triangleCount; triangle++ {
+		for corner := 0; corner < 3; corner++ {
+			vertex := indices[triangle*3+corner]
+			adjacency[fill[vertex]] = triangle
+			fill[vertex]++
+		}
+	}
+
+	vertexScores := make([]float32, vertexCount)
+	for vertex := range vertexScores {
+		vertexScores[vertex] = forsythVertexScore(-1, remaining[vertex])
+	}
+
+	emitted := make([]bool, triangleCount)
+	triangleScores := make([]float32, triangleCount)
+	for triangle := 0; triangle < triangleCount; triangle++ {
+		for corner := 0; corner < 3; corner++ {
+			triangleScores[triangle] += vertexScores[indices[triangle*3+corner]]
+		}
+	}
+
+	output := make([]uint32, 0, triangleCount*3)
+	cache := make([]uint32, 0, forsythCacheSize+3)
+	nextCache := make([]uint32, 0, forsythCacheSize+3)
+	scanCursor := 0
+
+	bestTriangle := -1
+	for len(output) < triangleCount*3 {
+		if bestTriangle < 0 {
+			// Nothing in the cache is connected to remaining triangles- continue with the
+			// first triangle that hasn't been emitted yet, which keeps this linear
+			for emitted[scanCursor] {
+				scanCursor++
+			}
+			bestTriangle = scanCursor
+		}
+
+		triangleIndices := indices[bestTriangle*3 : bestTriangle*3+3]
+		output = append(output, triangleIndices...)
+		emitted[bestTriangle] = true
+
+		// Move the triangle's vertices to the front of the cache and drop the triangle
+		// from their adjacency
+		nextCache = append(nextCache[:0], triangleIndices...)
+		for _, vertex := range triangleIndices {
+			start := adjacencyOffsets[vertex]
+			count := remaining[vertex]
+			for i := start; i < start+count; i++ {
+				if adjacency[i] == bestTriangle {
+					adjacency[i] = adjacency[start+count-1]
+					break
+				}
+			}
+			remaining[vertex]--
+		}
+		for _, vertex := range cache {
+			if vertex != triangleIndices[0] && vertex != triangleIndices[1] && vertex != triangleIndices[2] {
+				nextCache = append(nextCache, vertex)
+			}
+		}
+		cache, nextCache = nextCache, cache
+
+		// Rescore everything that was in the cache, including vertices that just fell out
+		for position, vertex := range cache {
+			if position >= forsythCacheSize {
+				position = -1
+			}
+
+			newScore := forsythVertexScore(position, remaining[vertex])
+			delta := newScore - vertexScores[vertex]
+			vertexScores[vertex] = newScore
+
+			start := adjacencyOffsets[vertex]
+			for _, triangle := range adjacency[start : start+remaining[vertex]] {
+				triangleScores[triangle] += delta
+			}
+		}
+		if len(cache) > forsythCacheSize {
+			cache = cache[:forsythCacheSize]
+		}
+
+		bestTriangle = -1
+		bestScore := float32(-1)
+		for _, vertex := range cache {
+			start := adjacencyOffsets[vertex]
+			for _, triangle := range adjacency[start : start+remaining[vertex]] {
+				if triangleScores[triangle] > bestScore {
+					bestScore = triangleScores[triangle]
+					bestTriangle = triangle
+				}
+			}
+		}
+	}
+
+	copy(indices, output)
+}
+
+// optimizeOverdraw follows Sander et al's "Fast Triangle Reordering for Vertex Locality
+// and Reduced Overdraw". The cache-optimized triangle list is cut into clusters wherever
+// a triangle misses the cache on all three vertices, so reordering clusters costs little
+// cache efficiency. Clusters facing away from the center of the mesh are drawn first,
+// since they are the ones most likely to occlude the rest.
+func optimizeOverdraw(indices []uint32, vertices []Vertex) {
+	triangleCount := len(indices) / 3
+	if triangleCount == 0 {
+		return
+	}
+
+	var clusterStarts []int
+	fifo := make([]uint32, 0, statsCacheSize)
+	for triangle := 0; triangle < triangleCount; triangle++ {
+		misses := 0
+		for _, vertex := range indices[triangle*3 : triangle*3+3] {
+			hit := false
+			for _, cached := range fifo {
+				if cached == vertex {
+					hit = true
+					break
+				}
+			}
+			if hit {
+				continue
+			}
+
+			misses++
+			if len(fifo) == statsCacheSize {
+				fifo = fifo[1:]
+			}
+			fifo = append(fifo, vertex)
+		}
+
+		if misses == 3 || triangle == 0 {
+			clusterStarts = append(clusterStarts, triangle)
+		}
+	}
+	clusterStarts = append(clusterStarts, triangleCount)
+
+	type cluster struct {
+		start, end int
+		sortKey    float32
+	}
+
+	var meshCentroid vkngmath.Vec3[float32]
+	var meshArea float32
+	clusters := make([]cluster, 0, len(clusterStarts)-1)
+	centroids := make([]vkngmath.Vec3[float32], 0, len(clusterStarts)-1)
+	normals := make([]vkngmath.Vec3[float32], 0, len(clusterStarts)-1)
+
+	for i := 0; i+1 < len(clusterStarts); i++ {
+		var centroid, normal vkngmath.Vec3[float32]
+		var area float32
+
+		for triangle := clusterStarts[i]; triangle < clusterStarts[i+1]; triangle++ {
+			p0 := vertices[indices[triangle*3]].Position
+			p1 := vertices[indices[triangle*3+1]].Position
+			p2 := vertices[indices[triangle*3+2]].Position
+
+			var edge1, edge2, cross vkngmath.Vec3[float32]
+			edge1.SetSubtractVec3(&p1, &p0)
+			edge2.SetSubtractVec3(&p2, &p0)
+			cross.SetCrossProduct(&edge1, &edge2)
+
+			// The cross product's length is twice the triangle's area, which weights the
+			// normal and the centroid
+			triangleArea := cross.Len()
+			normal.AddVec3(&cross)
+
+			centroid.X += (p0.X + p1.X + p2.X) / 3 * triangleArea
+			centroid.Y += (p0.Y + p1.Y + p2.Y) / 3 * triangleArea
+			centroid.Z += (p0.Z + p1.Z + p2.Z) / 3 * triangleArea
+			area += triangleArea
+		}
+
+		meshCentroid.AddVec3(&centroid)
+		meshArea += area
+		if area > 0 {
+			centroid.Scale(1 / area)
+		}
+		if normal.Len() > 0 {
+			normal.Normalize()
+		}
+
+		clusters = append(clusters, cluster{start: clusterStarts[i], end: clusterStarts[i+1]})
+		centroids = append(centroids, centroid)
+		normals = append(normals, normal)
+	}
+
+	if meshArea > 0 {
+		meshCentroid.Scale(1 / meshArea)
+	}
+
+	for i := range clusters {
+		var offset vkngmath.Vec3[float32]
+		offset.SetSubtractVec3(&centroids[i], &meshCentroid)
+		clusters[i].sortKey = offset.DotProduct(&normals[i])
+	}
+
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return clusters[i].sortKey > clusters[j].sortKey
+	})
+
+	output := make([]uint32, 0, triangleCount*3)
+	for _, c := range clusters {
+		output = append(output, indices[c.start*3:c.end*3]...)
+	}
+	copy(indices, output)
+}
+
+// optimizeVertexFetch rewrites the vertex buffer in the order vertices are first used by
+// the index buffer, so that vertex fetches walk memory mostly linearly
+func (m *Mesh) optimizeVertexFetch() {
+	remap := make([]int, len(m.Vertices))
+	for i := range remap {
+		remap[i] = -1
+	}
+
+	vertices := make([]Vertex, 0, len(m.Vertices))
+	for submeshIndex := range m.SubmeshList {
+		submesh := &m.SubmeshList[submeshIndex]
+		indices := m.Indices[submesh.FirstIndex : submesh.FirstIndex+submesh.IndexCount]
+
+		for i, index := range indices {
+			source := submesh.VertexOffset + int(index)
+			if remap[source] < 0 {
+				remap[source] = len(vertices)
+				vertices = append(vertices, m.Vertices[source])
+			}
+			indices[i] = uint32(remap[source])
+		}
+		submesh.VertexOffset = 0
+	}
+
+	m.Vertices = vertices
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	vkngmath "github.com/vkngwrapper/math"
+)
+
+// sphereMesh is a closed UV sphere, which gives the overdraw ordering clusters that face
+// every direction
+func sphereMesh(stacks, slices int) *Mesh {
+	vertices := []Vertex{{Position: vkngmath.Vec3[float32]{Y: 1}}}
+	for stack := 1; stack < stacks; stack++ {
+		polar := math.Pi * float64(stack) / float64(stacks)
+		for slice := 0; slice < slices; slice++ {
+			azimuth := 2 * math.Pi * float64(slice) / float64(slices)
+			vertices = append(vertices, Vertex{Position: vkngmath.Vec3[float32]{
+				X: float32(math.Sin(polar) * math.Cos(azimuth)),
+				Y: float32(math.Cos(polar)),
+				Z: float32(math.Sin(polar) * math.Sin(azimuth)),
+			}})
+		}
+	}
+	vertices = append(vertices, Vertex{Position: vkngmath.Vec3[float32]{Y: -1}})
+
+	ring := func(stack, slice int) uint32 {
+		return uint32(1 + (stack-1)*slices + slice%slices)
+	}
+	bottom := uint32(len(vertices) - 1)
+
+	var indices []uint32
+	for slice := 0; slice < slices; slice++ {
+		indices = append(indices, 0, ring(1, slice+1), ring(1, slice))
+		for stack := 1; stack < stacks-1; stack++ {
+			indices = append(indices,
+				ring(stack, slice), ring(stack, slice+1), ring(stack+1, slice),
+				ring(stack+1, slice), ring(stack, slice+1), ring(stack+1, slice+1))
+		}
+		indices = append(indices, ring(stacks-1, slice), ring(stacks-1, slice+1), bottom)
+	}
+
+	return newMesh(vertices, indices)
+}
+
+// shuffleTriangles puts a mesh's triangles in a random order, which is about the worst
+// case for the vertex cache
+func shuffleTriangles(mesh *Mesh) *Mesh {
+	random := rand.New(rand.NewSource(1))
+	triangleCount := len(mesh.Indices) / 3
+	for i := triangleCount - 1; i > 0; i-- {
+		j := random.Intn(i + 1)
+		for corner := 0; corner < 3; corner++ {
+			mesh.Indices[i*3+corner], mesh.Indices[j*3+corner] = mesh.Indices[j*3+corner], mesh.Indices[i*3+corner]
+		}
+	}
+	return mesh
+}
+
+// sortedTriangles returns a mesh's triangles in a canonical order. Each triangle is
+// rotated to start at its smallest corner, which keeps its winding.
+func sortedTriangles(t *testing.T, mesh *Mesh) []meshTriangle {
+	t.Helper()
+
+	compare := func(a, b vkngmath.Vec3[float32]) int {
+		return cmp.Or(cmp.Compare(a.X, b.X), cmp.Compare(a.Y, b.Y), cmp.Compare(a.Z, b.Z))
+	}
+
+	triangles := meshTriangles(t, mesh)
+	for i, triangle := range triangles {
+		first := 0
+		for corner := 1; corner < 3; corner++ {
+			if compare(triangle[corner], triangle[first]) < 0 {
+				first = corner
+			}
+		}
+		triangles[i] = meshTriangle{triangle[first], triangle[(first+1)%3], triangle[(first+2)%3]}
+	}
+
+	slices.SortFunc(triangles, func(a, b meshTriangle) int {
+		for corner := 0; corner < 3; corner++ {
+			if result := compare(a[corner], b[corner]); result != 0 {
+				return result
+			}
+		}
+		return 0
+	})
+	return triangles
+}
+
+func TestMeshOptimize(t *testing.T) {
+	meshes := []struct {
+		name string
+		mesh func() *Mesh
+	}{
+		{name: "Grid", mesh: func() *Mesh { return gridMesh(32, 32) }},
+		{name: "ShuffledGrid", mesh: func() *Mesh { return shuffleTriangles(gridMesh(32, 32)) }},
+		{name: "Sphere", mesh: func() *Mesh { return sphereMesh(16, 32) }},
+		{name: "ShuffledSphere", mesh: func() *Mesh { return shuffleTriangles(sphereMesh(16, 32)) }},
+		{name: "SplitSubmeshes", mesh: func() *Mesh {
+			mesh := shuffleTriangles(gridMesh(32, 32))
+			mesh.splitSubmeshes(200)
+			return mesh
+		}},
+	}
+	optimizations := []MeshOptimization{MeshOptimizationCache, MeshOptimizationOverdraw}
+
+	for _, testMesh := range meshes {
+		for _, optimization := range optimizations {
+			t.Run(testMesh.name+"/"+optimization.String(), func(t *testing.T) {
+				mesh := testMesh.mesh()
+				before := analyzeVertexCache(mesh, statsCacheSize)
+				beforeTriangles := sortedTriangles(t, mesh)
+				bounds := mesh.Bounds
+
+				mesh.optimize(optimization)
+
+				after := analyzeVertexCache(mesh, statsCacheSize)
+				t.Logf("ACMR %.3f -> %.3f, ATVR %.3f -> %.3f", before.ACMR, after.ACMR, before.ATVR, after.ATVR)
+				if after.ACMR > before.ACMR {
+					t.Errorf("ACMR got worse, from %.3f to %.3f", before.ACMR, after.ACMR)
+				}
+
+				afterTriangles := sortedTriangles(t, mesh)
+				if !slices.Equal(afterTriangles, beforeTriangles) {
+					t.Errorf("the optimized mesh draws different triangles")
+				}
+
+				for _, submesh := range mesh.SubmeshList {
+					if submesh.VertexOffset != 0 {
+						t.Errorf("submesh %+v still has a vertex offset", submesh)
+					}
+				}
+				if mesh.Bounds != bounds {
+					t.Errorf("bounds changed from %v to %v", bounds, mesh.Bounds)
+				}
+
+				// optimizeVertexFetch orders vertices by first use, so the first use of each
+				// vertex is the next one in the buffer
+				next := uint32(0)
+				for _, index := range mesh.Indices {
+					if index > next {
+						t.Fatalf("vertex %d is used before vertex %d", index, next)
+					}
+					if index == next {
+						next++
+					}
+				}
+				if int(next) != len(mesh.Vertices) {
+					t.Errorf("%d of %d vertices are used", next, len(mesh.Vertices))
+				}
+			})
+		}
+	}
+}
+
+func TestOptimizeVertexCacheShuffled(t *testing.T) {
+	// A shuffled grid misses on nearly every vertex, and Forsyth should bring it close to
+	// the ~0.6-0.7 a 16 entry FIFO can reach on a regular grid
+	mesh := shuffleTriangles(gridMesh(64, 64))
+	optimizeVertexCache(mesh.Indices, len(mesh.Vertices))
+
+	stats := analyzeVertexCache(mesh, statsCacheSize)
+	if stats.ACMR > 0.8 {
+		t.Errorf("ACMR is %.3f after optimizing, expected at most 0.8", stats.ACMR)
+	}
+}
+
+func TestOptimizeOverdrawDrawsOutwardClustersFirst(t *testing.T) {
+	// Two quads on either side of the origin, both facing -Z. The one at Z = 1 faces in
+	// toward the center of the mesh and the one at Z = -1 faces out, so the second is
+	// moved in front of the first.
+	var vertices []Vertex
+	for _, z := range []float32{1, -1} {
+		for _, corner := range [][2]float32{{0, 0}, {0, 1}, {1, 1}, {1, 0}} {
+			vertices = append(vertices, Vertex{Position: vkngmath.Vec3[float32]{X: corner[0], Y: corner[1], Z: z}})
+		}
+	}
+	indices := []uint32{
+		0, 1, 2, 0, 2, 3,
+		4, 5, 6, 4, 6, 7,
+	}
+
+	optimizeOverdraw(indices, vertices)
+
+	expected := []uint32{
+		4, 5, 6, 4, 6, 7,
+		0, 1, 2, 0, 2, 3,
+	}
+	if !slices.Equal(indices, expected) {
+		t.Errorf("got indices %v, expected %v", indices, expected)
+	}
+}
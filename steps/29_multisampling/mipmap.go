@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// MipmapFilter selects how the mip chain of a texture without one is built
+type MipmapFilter int
+
+const (
+	// MipmapFilterBlit generates mip levels on the GPU with linear blits, falling back to
+	// MipmapFilterBox when the texture format can't be blitted with linear filtering
+	MipmapFilterBlit MipmapFilter = iota
+	// MipmapFilterBox averages the texels under each destination texel on the CPU
+	MipmapFilterBox
+	// MipmapFilterKaiser uses a Kaiser-windowed sinc on the CPU, which keeps smaller
+	// levels sharper than a box filter
+	MipmapFilterKaiser
+)
+
+func parseMipmapFilter(value string) (MipmapFilter, error) {
+	switch value {
+	case "blit":
+		return MipmapFilterBlit, nil
+	case "box":
+		return MipmapFilterBox, nil
+	case "kaiser":
+		return MipmapFilterKaiser, nil
+	}
+
+	return 0, errors.Errorf("unknown mipmap filter '%s'- expected 'blit', 'box' or 'kaiser'", value)
+}
+
+func (f MipmapFilter) String() string {
+	switch f {
+	case MipmapFilterBox:
+		return "box"
+	case MipmapFilterKaiser:
+		return "kaiser"
+	}
+	return "blit"
+}
+
+// mipLevelCount returns the number of levels in a mip chain that halves down to 1x1,
+// rounding odd sizes down. The chain is cut short after maxLevels levels (if maxLevels
+// is above 0) or before the larger side of a level would drop below minSize.
+func mipLevelCount(width, height int, maxLevels int, minSize int) int {
+	levels := 1
+	for size := max(width, height); size > 1 && size/2 >= minSize; size /= 2 {
+		levels++
+	}
+
+	if maxLevels > 0 {
+		levels = min(levels, maxLevels)
+	}
+	return levels
+}
+
+// chooseMipmapFilter returns the filter that will actually be used for a texture. That
+// is the box filter when blits were requested but the device can't blit the format with
+// linear filtering. Textures that aren't a power of two are still blitted, level by level,
+// using the extents from LevelExtent.
+func (app *HelloTriangleApplication) chooseMipmapFilter(format core1_0.Format) MipmapFilter {
+	if app.mipmapFilter != MipmapFilterBlit {
+		return app.mipmapFilter
+	}
+
+	properties := app.physicalDevice.FormatProperties(format)
+	if (properties.OptimalTilingFeatures & core1_0.FormatFeatureSampledImageFilterLinear) == 0 {
+		log.Printf("texture image format %s does not support linear blitting- generating mipmaps on the CPU", format)
+		return MipmapFilterBox
+	}
+
+	return MipmapFilterBlit
+}
+
+const (
+	// kaiserRadius is the half-width of the Kaiser filter, in destination texels
+	kaiserRadius = 3
+	kaiserAlpha  = 4
+)
+
+func sinc(x float64) float64 {
+	if math.Abs(x) < 1e-6 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// besselI0 is the zeroth order modified Bessel function of the first kind, which the
+// Kaiser window is built from
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; k < 32; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < sum*1e-12 {
+			break
+		}
+	}
+	return sum
+}
+
+func kaiser(x float64) float64 {
+	if math.Abs(x) > kaiserRadius {
+		return 0
+	}
+	ratio := x / kaiserRadius
+	return sinc(x) * besselI0(kaiserAlpha*math.Sqrt(1-ratio*ratio)) / besselI0(kaiserAlpha)
+}
+
+type filterTap struct {
+	Index  int
+	Weight float32
+}
+
+// resampleTaps builds the normalized filter taps for every destination texel along one
+// axis. Each destination texel covers srcSize/dstSize source texels, which handles odd
+// sizes without dropping the last row or column.
+func resampleTaps(srcSize, dstSize int, filter MipmapFilter) [][]filterTap {
+	scale := float64(srcSize) / float64(dstSize)
+	taps := make([][]filterTap, dstSize)
+
+	for dst := range taps {
+		center := (float64(dst) + 0.5) * scale
+
+		support := scale / 2
+		if filter == MipmapFilterKaiser {
+			support = scale * kaiserRadius
+		}
+
+		var total float64
+		var weights []float64
+		first := int(math.Floor(center - support))
+		last := int(math.Ceil(center + support))
+		for src := first; src < last; src++ {
+			var weight float64
+			if filter == MipmapFilterKaiser {
+				weight = kaiser((float64(src) + 0.5 - center) / scale)
+			} else {
+				// The box filter weighs each source texel by how much of it is covered
+				weight = max(0, min(float64(src+1), center+support)-max(float64(src), center-support))
+			}
+			weights = append(weights, weight)
+			total += weight
+		}
+
+		for i, weight := range weights {
+			if weight == 0 {
+				continue
+			}
+
+			// Texels past the edge are clamped to it
+			index := min(max(first+i, 0), srcSize-1)
+			taps[dst] = append(taps[dst], filterTap{Index: index, Weight: float32(weight / total)})
+		}
+	}
+
+	return taps
+}
+
+var srgbToLinearTable = func() [256]float32 {
+	var table [256]float32
+	for i := range table {
+		value := float64(i) / 255
+		if value <= 0.04045 {
+			table[i] = float32(value / 12.92)
+		} else {
+			table[i] = float32(math.Pow((value+0.055)/1.055, 2.4))
+		}
+	}
+	return table
+}()
+
+func linearToSRGB(value float32) byte {
+	linear := float64(min(max(value, 0), 1))
+	if linear <= 0.0031308 {
+		linear *= 12.92
+	} else {
+		linear = 1.055*math.Pow(linear, 1/2.4) - 0.055
+	}
+	return byte(linear*255 + 0.5)
+}
+
+// mipChannelCodec reads and writes one channel of a texel as a float, so the CPU filters
+// can work on every uncompressed format the same way
+type mipChannelCodec struct {
+	ChannelBytes int
+	Decode       func(src []byte, channel int) float32
+	Encode       func(dst []byte, channel int, value float32)
+}
+
+func unormCodec(srgb bool) mipChannelCodec {
+	return mipChannelCodec{
+		ChannelBytes: 1,
+		Decode: func(src []byte, channel int) float32 {
+			if srgb && channel < 3 {
+				return srgbToLinearTable[src[0]]
+			}
+			return float32(src[0]) / 255
+		},
+		Encode: func(dst []byte, channel int, value float32) {
+			if srgb && channel < 3 {
+				dst[0] = linearToSRGB(value)
+				return
+			}
+			dst[0] = byte(min(max(value, 0), 1)*255 + 0.5)
+		},
+	}
+}
+
+// snormCodec handles 8-bit signed normalized channels, where both -128 and -127 are -1.0
+var snormCodec = mipChannelCodec{
+	ChannelBytes: 1,
+	Decode: func(src []byte, channel int) float32 {
+		return max(float32(int8(src[0]))/127, -1)
+	},
+	Encode: func(dst []byte, channel int, value float32) {
+		dst[0] = byte(int8(math.Round(float64(min(max(value, -1), 1) * 127))))
+	},
+}
+
+var halfFloatCodec = mipChannelCodec{
+	ChannelBytes: 2,
+	Decode: func(src []byte, channel int) float32 {
+		return halfToFloat32(binary.LittleEndian.Uint16(src))
+	},
+	Encode: func(dst []byte, channel int, value float32) {
+		binary.LittleEndian.PutUint16(dst, float32ToHalf(value))
+	},
+}
+
+// mipChannelCodecs covers every uncompressed format in textureFormats. The channel order
+// doesn't matter to the filters, so BGRA is treated like RGBA.
+var mipChannelCodecs = map[core1_0.Format]mipChannelCodec{
+	core1_0.FormatR8G8B8A8UnsignedNormalized: unormCodec(false),
+	core1_0.FormatR8G8B8A8SRGB:               unormCodec(true),
+	core1_0.FormatB8G8R8A8UnsignedNormalized: unormCodec(false),
+	core1_0.FormatB8G8R8A8SRGB:               unormCodec(true),
+	core1_0.FormatR8G8B8A8SignedNormalized:   snormCodec,
+	core1_0.FormatR16G16B16A16SignedFloat:    halfFloatCodec,
+}
+
+func halfToFloat32(half uint16) float32 {
+	sign := uint32(half>>15) << 31
+	exponent := int(half>>10) & 0x1f
+	mantissa := uint32(half) & 0x3ff
+
+	switch {
+	case exponent == 0x1f:
+		// Infinity and NaN keep their mantissa
+		return math.Float32frombits(sign | 0xff<<23 | mantissa<<13)
+	case exponent == 0:
+		// Zero and denormals, which are all normal numbers as float32s
+		value := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			return -value
+		}
+		return value
+	}
+	return math.Float32frombits(sign | uint32(exponent-15+127)<<23 | mantissa<<13)
+}
+
+// float32ToHalf rounds to the nearest half float. Values too large for a half float are
+// clamped to the largest finite one, which filter overshoot can reach.
+func float32ToHalf(value float32) uint16 {
+	if math.IsNaN(float64(value)) {
+		return 0x7e00
+	}
+
+	var sign uint16
+	if math.Signbit(float64(value)) {
+		sign = 0x8000
+		value = -value
+	}
+	value = min(value, 65504)
+
+	if value < 1.0/(1<<14) {
+		// Denormals are multiples of 2^-24
+		return sign | uint16(math.RoundToEven(float64(value)*(1<<24)))
+	}
+
+	bits := math.Float32bits(value)
+	exponent := int(bits>>23) - 127 + 15
+	mantissa := bits & 0x7fffff
+
+	// Round the 23-bit mantissa to 10 bits, to even on ties. A carry into the exponent
+	// still gives the right half float.
+	half := uint32(exponent)<<10 | mantissa>>13
+	remainder := mantissa & 0x1fff
+	if remainder > 0x1000 || (remainder == 0x1000 && half&1 != 0) {
+		half++
+	}
+	return sign | uint16(half)
+}
+
+// downsampleLevel filters a level of four-channel texels into the next size down. sRGB
+// color channels are filtered in linear space- alpha is always linear.
+func downsampleLevel(src []byte, srcWidth, srcHeight int, dstWidth, dstHeight int, codec mipChannelCodec, filter MipmapFilter) []byte {
+	texelBytes := codec.ChannelBytes * 4
+	horizontalTaps := resampleTaps(srcWidth, dstWidth, filter)
+	verticalTaps := resampleTaps(srcHeight, dstHeight, filter)
+
+	// Filter horizontally into a float buffer, then vertically into the destination
+	horizontal := make([]float32, dstWidth*srcHeight*4)
+	for y := 0; y < srcHeight; y++ {
+		for x, taps := range horizontalTaps {
+			for channel := 0; channel < 4; channel++ {
+				var sum float32
+				for _, tap := range taps {
+					offset := (y*srcWidth+tap.Index)*texelBytes + channel*codec.ChannelBytes
+					sum += tap.Weight * codec.Decode(src[offset:], channel)
+				}
+				horizontal[(y*dstWidth+x)*4+channel] = sum
+			}
+		}
+	}
+
+	dst := make([]byte, dstWidth*dstHeight*texelBytes)
+	for y, taps := range verticalTaps {
+		for x := 0; x < dstWidth; x++ {
+			for channel := 0; channel < 4; channel++ {
+				var sum float32
+				for _, tap := range taps {
+					sum += tap.Weight * horizontal[(tap.Index*dstWidth+x)*4+channel]
+				}
+				codec.Encode(dst[(y*dstWidth+x)*texelBytes+channel*codec.ChannelBytes:], channel, sum)
+			}
+		}
+	}
+
+	return dst
+}
+
+// generateMipChain builds every mip level of a single-level uncompressed texture on the CPU
+func generateMipChain(texture *textureData, mipLevels int, filter MipmapFilter) (*textureData, error) {
+	codec, supported := mipChannelCodecs[texture.Format]
+	if !supported {
+		return nil, errors.Errorf("generateMipChain: cannot generate mipmaps for %s on the CPU", texture.Format)
+	}
+
+	level := make([]byte, texture.LevelDataSize(0))
+	err := texture.WriteLevels(level)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &textureData{
+		Format: texture.Format,
+		Width:  texture.Width,
+		Height: texture.Height,
+		Levels: [][]byte{level},
+	}
+
+	for i := 1; i < mipLevels; i++ {
+		srcWidth, srcHeight := output.LevelExtent(i - 1)
+		dstWidth, dstHeight := output.LevelExtent(i)
+
+		level = downsampleLevel(level, srcWidth, srcHeight, dstWidth, dstHeight, codec, filter)
+		output.Levels = append(output.Levels, level)
+	}
+
+	return output, nil
+}
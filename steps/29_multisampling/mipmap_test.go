@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+var cpuMipmapFilters = []MipmapFilter{MipmapFilterBox, MipmapFilterKaiser}
+
+// formatFeaturePhysicalDevice reports the same optimal tiling features for every format
+type formatFeaturePhysicalDevice struct {
+	core1_0.PhysicalDevice
+	features core1_0.FormatFeatureFlags
+}
+
+func (d formatFeaturePhysicalDevice) FormatProperties(format core1_0.Format) *core1_0.FormatProperties {
+	return &core1_0.FormatProperties{OptimalTilingFeatures: d.features}
+}
+
+// solidRGBA8 is a width x height level filled with one color
+func solidRGBA8(width, height int, color [4]byte) []byte {
+	return bytes.Repeat(color[:], width*height)
+}
+
+func TestMipLevelCount(t *testing.T) {
+	testCases := []struct {
+		name          string
+		width, height int
+		maxLevels     int
+		minSize       int
+		want          int
+	}{
+		{name: "PowerOfTwo", width: 1024, height: 1024, minSize: 1, want: 11},
+		{name: "NonSquare", width: 1024, height: 16, minSize: 1, want: 11},
+		{name: "Odd", width: 5, height: 3, minSize: 1, want: 3},
+		{name: "NonPowerOfTwo", width: 800, height: 600, minSize: 1, want: 10},
+		{name: "OneTexel", width: 1, height: 1, minSize: 1, want: 1},
+		{name: "MaxLevels", width: 1024, height: 1024, maxLevels: 4, minSize: 1, want: 4},
+		{name: "MaxLevelsAboveChain", width: 4, height: 4, maxLevels: 8, minSize: 1, want: 3},
+		// Levels stop before the larger side drops below 64: 1024, 512, 256, 128, 64
+		{name: "MinSize", width: 1024, height: 1024, minSize: 64, want: 5},
+		{name: "MinSizeUsesLargerSide", width: 1024, height: 16, minSize: 64, want: 5},
+		{name: "MinSizeAboveTexture", width: 32, height: 32, minSize: 64, want: 1},
+		{name: "BothLimits", width: 1024, height: 1024, maxLevels: 3, minSize: 64, want: 3},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			got := mipLevelCount(testCase.width, testCase.height, testCase.maxLevels, testCase.minSize)
+			if got != testCase.want {
+				t.Errorf("got %d levels, expected %d", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestChooseMipmapFilter(t *testing.T) {
+	testCases := []struct {
+		name      string
+		requested MipmapFilter
+		features  core1_0.FormatFeatureFlags
+		want      MipmapFilter
+	}{
+		{name: "Blit", requested: MipmapFilterBlit, features: core1_0.FormatFeatureSampledImageFilterLinear, want: MipmapFilterBlit},
+		{name: "BlitUnsupported", requested: MipmapFilterBlit, want: MipmapFilterBox},
+		{name: "Box", requested: MipmapFilterBox, features: core1_0.FormatFeatureSampledImageFilterLinear, want: MipmapFilterBox},
+		{name: "Kaiser", requested: MipmapFilterKaiser, want: MipmapFilterKaiser},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			app := &HelloTriangleApplication{
+				mipmapFilter:   testCase.requested,
+				physicalDevice: formatFeaturePhysicalDevice{features: testCase.features},
+			}
+
+			// The texture size doesn't matter- odd sizes are blitted level by level
+			got := app.chooseMipmapFilter(core1_0.FormatR8G8B8A8SRGB)
+			if got != testCase.want {
+				t.Errorf("got the %s filter, expected %s", got, testCase.want)
+			}
+		})
+	}
+}
+
+func TestResampleTaps(t *testing.T) {
+	sizes := [][2]int{{4, 2}, {5, 2}, {3, 1}, {7, 3}, {2, 1}, {1, 1}}
+
+	for _, filter := range cpuMipmapFilters {
+		for _, size := range sizes {
+			srcSize, dstSize := size[0], size[1]
+			t.Run(fmt.Sprintf("%s/%dto%d", filter, srcSize, dstSize), func(t *testing.T) {
+				taps := resampleTaps(srcSize, dstSize, filter)
+				if len(taps) != dstSize {
+					t.Fatalf("got taps for %d texels, expected %d", len(taps), dstSize)
+				}
+
+				coverage := make([]float32, srcSize)
+				for dst, dstTaps := range taps {
+					var total float32
+					for _, tap := range dstTaps {
+						if tap.Index < 0 || tap.Index >= srcSize {
+							t.Fatalf("texel %d reads source texel %d of %d", dst, tap.Index, srcSize)
+						}
+						total += tap.Weight
+						coverage[tap.Index] += tap.Weight
+					}
+					if math.Abs(float64(total-1)) > 1e-5 {
+						t.Errorf("texel %d weights sum to %f, expected 1", dst, total)
+					}
+				}
+
+				for src, weight := range coverage {
+					if weight == 0 {
+						t.Errorf("source texel %d is never read", src)
+					}
+					// The box filter splits every source texel evenly between the
+					// destination texels it falls under
+					want := float32(dstSize) / float32(srcSize)
+					if filter == MipmapFilterBox && math.Abs(float64(weight-want)) > 1e-5 {
+						t.Errorf("source texel %d has a total weight of %f, expected %f", src, weight, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDownsampleLevel(t *testing.T) {
+	// A 2x2 checker averages to 0.5 in linear space, which is 188 in sRGB. Averaging the
+	// sRGB values directly would give 128.
+	checker := []byte{
+		0, 0, 0, 255, 255, 255, 255, 255,
+		255, 255, 255, 255, 0, 0, 0, 255,
+	}
+
+	// A signed checker of -1 and 1 averages to 0. Both -128 and -127 are -1.
+	signedChecker := []byte{
+		0x80, 0x80, 0x80, 0x7f, 0x7f, 0x7f, 0x7f, 0x7f,
+		0x7f, 0x7f, 0x7f, 0x7f, 0x81, 0x81, 0x81, 0x7f,
+	}
+
+	// A 5x3 level that is black apart from its last column, or its last row
+	lastColumn := solidRGBA8(5, 3, [4]byte{0, 0, 0, 255})
+	lastRow := solidRGBA8(5, 3, [4]byte{0, 0, 0, 255})
+	for i := 0; i < 5*3; i++ {
+		if i%5 == 4 {
+			copy(lastColumn[i*4:], []byte{255, 255, 255, 255})
+		}
+		if i/5 == 2 {
+			copy(lastRow[i*4:], []byte{255, 255, 255, 255})
+		}
+	}
+
+	testCases := []struct {
+		name                string
+		src                 []byte
+		srcWidth, srcHeight int
+		dstWidth, dstHeight int
+		format              core1_0.Format
+		filters             []MipmapFilter
+		want                [][4]byte
+	}{
+		{
+			name: "FlatSRGB", src: solidRGBA8(5, 3, [4]byte{200, 100, 50, 128}),
+			srcWidth: 5, srcHeight: 3, dstWidth: 2, dstHeight: 1, format: core1_0.FormatR8G8B8A8SRGB, filters: cpuMipmapFilters,
+			want: [][4]byte{{200, 100, 50, 128}, {200, 100, 50, 128}},
+		},
+		{
+			name: "FlatLinear", src: solidRGBA8(8, 8, [4]byte{17, 34, 51, 68}),
+			srcWidth: 8, srcHeight: 8, dstWidth: 2, dstHeight: 2, format: core1_0.FormatR8G8B8A8UnsignedNormalized, filters: cpuMipmapFilters,
+			want: [][4]byte{{17, 34, 51, 68}, {17, 34, 51, 68}, {17, 34, 51, 68}, {17, 34, 51, 68}},
+		},
+		{
+			name: "CheckerSRGB", src: checker,
+			srcWidth: 2, srcHeight: 2, dstWidth: 1, dstHeight: 1, format: core1_0.FormatR8G8B8A8SRGB, filters: []MipmapFilter{MipmapFilterBox},
+			want: [][4]byte{{188, 188, 188, 255}},
+		},
+		{
+			name: "CheckerLinear", src: checker,
+			srcWidth: 2, srcHeight: 2, dstWidth: 1, dstHeight: 1, format: core1_0.FormatR8G8B8A8UnsignedNormalized, filters: []MipmapFilter{MipmapFilterBox},
+			want: [][4]byte{{128, 128, 128, 255}},
+		},
+		{
+			// The last column is a fifth of the source and lands in the second texel, which
+			// covers 2.5 columns
+			name: "OddWidthKeepsLastColumn", src: lastColumn,
+			srcWidth: 5, srcHeight: 3, dstWidth: 2, dstHeight: 1, format: core1_0.FormatR8G8B8A8UnsignedNormalized, filters: []MipmapFilter{MipmapFilterBox},
+			want: [][4]byte{{0, 0, 0, 255}, {102, 102, 102, 255}},
+		},
+		{
+			// The last row is a third of the source, so both texels are a third white
+			name: "OddHeightKeepsLastRow", src: lastRow,
+			srcWidth: 5, srcHeight: 3, dstWidth: 2, dstHeight: 1, format: core1_0.FormatR8G8B8A8UnsignedNormalized, filters: []MipmapFilter{MipmapFilterBox},
+			want: [][4]byte{{85, 85, 85, 255}, {85, 85, 85, 255}},
+		},
+		{
+			name: "FlatSignedNormalized", src: solidRGBA8(5, 3, [4]byte{0xc0, 64, 0, 127}),
+			srcWidth: 5, srcHeight: 3, dstWidth: 2, dstHeight: 1, format: core1_0.FormatR8G8B8A8SignedNormalized, filters: cpuMipmapFilters,
+			want: [][4]byte{{0xc0, 64, 0, 127}, {0xc0, 64, 0, 127}},
+		},
+		{
+			name: "CheckerSignedNormalized", src: signedChecker,
+			srcWidth: 2, srcHeight: 2, dstWidth: 1, dstHeight: 1, format: core1_0.FormatR8G8B8A8SignedNormalized, filters: []MipmapFilter{MipmapFilterBox},
+			want: [][4]byte{{0, 0, 0, 127}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		for _, filter := range testCase.filters {
+			t.Run(testCase.name+"/"+filter.String(), func(t *testing.T) {
+				dst := downsampleLevel(testCase.src, testCase.srcWidth, testCase.srcHeight,
+					testCase.dstWidth, testCase.dstHeight, mipChannelCodecs[testCase.format], filter)
+
+				if len(dst) != testCase.dstWidth*testCase.dstHeight*4 {
+					t.Fatalf("got %d bytes, expected %d", len(dst), testCase.dstWidth*testCase.dstHeight*4)
+				}
+				for i, want := range testCase.want {
+					if got := [4]byte(dst[i*4:]); got != want {
+						t.Errorf("texel %d is %v, expected %v", i, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDownsampleHalfFloat(t *testing.T) {
+	halfTexels := func(values ...uint16) []byte {
+		var data []byte
+		for _, value := range values {
+			for channel := 0; channel < 4; channel++ {
+				data = binary.LittleEndian.AppendUint16(data, value)
+			}
+		}
+		return data
+	}
+
+	// 1, 3, -2 and 10 average to 3
+	dst := downsampleLevel(halfTexels(0x3c00, 0x4200, 0xc000, 0x4900), 2, 2, 1, 1, mipChannelCodecs[core1_0.FormatR16G16B16A16SignedFloat], MipmapFilterBox)
+	if want := halfTexels(0x4200); !bytes.Equal(dst, want) {
+		t.Errorf("got %x, expected %x", dst, want)
+	}
+
+	// The Kaiser filter overshoots next to the edge of the largest finite half float, which
+	// is clamped rather than turned into infinity
+	edge := halfTexels(0x7bff, 0x7bff, 0x7bff, 0, 0, 0, 0, 0)
+	dst = downsampleLevel(edge, 8, 1, 4, 1, mipChannelCodecs[core1_0.FormatR16G16B16A16SignedFloat], MipmapFilterKaiser)
+	for texel := 0; texel < 4; texel++ {
+		if value := binary.LittleEndian.Uint16(dst[texel*8:]); value&0x7c00 == 0x7c00 {
+			t.Errorf("texel %d is %04x, expected a finite half float", texel, value)
+		}
+	}
+}
+
+func TestHalfFloatConversion(t *testing.T) {
+	testCases := []struct {
+		name  string
+		value float32
+		want  uint16
+	}{
+		{name: "Zero", value: 0, want: 0x0000},
+		{name: "NegativeZero", value: float32(math.Copysign(0, -1)), want: 0x8000},
+		{name: "One", value: 1, want: 0x3c00},
+		{name: "NegativeTwo", value: -2, want: 0xc000},
+		{name: "Largest", value: 65504, want: 0x7bff},
+		{name: "TooLarge", value: 1e6, want: 0x7bff},
+		{name: "SmallestNormal", value: 1.0 / (1 << 14), want: 0x0400},
+		{name: "SmallestDenormal", value: 1.0 / (1 << 24), want: 0x0001},
+		{name: "TieRoundsToEven", value: 1 + 1.0/(1<<11), want: 0x3c00},
+		{name: "TieRoundsUp", value: 1 + 3.0/(1<<11), want: 0x3c02},
+		{name: "Infinity", value: float32(math.Inf(1)), want: 0x7bff},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if got := float32ToHalf(testCase.value); got != testCase.want {
+				t.Errorf("got %04x, expected %04x", got, testCase.want)
+			}
+		})
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		for half := 0; half <= 0xffff; half++ {
+			value := halfToFloat32(uint16(half))
+			if half&0x7c00 == 0x7c00 && half&0x3ff != 0 {
+				if !math.IsNaN(float64(value)) {
+					t.Errorf("%04x is %f, expected NaN", half, value)
+				}
+				continue
+			}
+
+			// Infinities are clamped on the way back
+			want := uint16(half)
+			if half&0x7fff == 0x7c00 {
+				want = uint16(half&0x8000 | 0x7bff)
+			}
+			if got := float32ToHalf(value); got != want {
+				t.Errorf("%04x became %f and then %04x", half, value, got)
+			}
+		}
+	})
+}
+
+func TestGenerateMipChain(t *testing.T) {
+	testCases := []struct {
+		format core1_0.Format
+		texel  []byte
+	}{
+		{format: core1_0.FormatR8G8B8A8SRGB, texel: []byte{200, 100, 50, 255}},
+		{format: core1_0.FormatB8G8R8A8UnsignedNormalized, texel: []byte{200, 100, 50, 255}},
+		{format: core1_0.FormatR8G8B8A8SignedNormalized, texel: []byte{0xc0, 64, 0, 127}},
+		// 3.0, -0.5, 0 and 1.0
+		{format: core1_0.FormatR16G16B16A16SignedFloat, texel: []byte{0x00, 0x42, 0x00, 0xb8, 0x00, 0x00, 0x00, 0x3c}},
+	}
+
+	for _, testCase := range testCases {
+		texture := &textureData{
+			Format: testCase.format,
+			Width:  5,
+			Height: 3,
+			Levels: [][]byte{bytes.Repeat(testCase.texel, 5*3)},
+		}
+		mipLevels := mipLevelCount(texture.Width, texture.Height, 0, 1)
+
+		for _, filter := range cpuMipmapFilters {
+			t.Run(fmt.Sprintf("%s/%s", testCase.format, filter), func(t *testing.T) {
+				chain, err := generateMipChain(texture, mipLevels, filter)
+				if err != nil {
+					t.Fatalf("generateMipChain: %+v", err)
+				}
+
+				// 5x3 halves to 2x1 and then 1x1
+				wantExtents := [][2]int{{5, 3}, {2, 1}, {1, 1}}
+				if len(chain.Levels) != len(wantExtents) {
+					t.Fatalf("got %d levels, expected %d", len(chain.Levels), len(wantExtents))
+				}
+				for level, extent := range wantExtents {
+					if !bytes.Equal(chain.Levels[level], bytes.Repeat(testCase.texel, extent[0]*extent[1])) {
+						t.Errorf("level %d is %v, expected %dx%d texels of %v", level, chain.Levels[level], extent[0], extent[1], testCase.texel)
+					}
+				}
+			})
+		}
+	}
+
+	_, err := generateMipChain(&textureData{Format: core1_0.FormatBC1_RGBAsRGB, Width: 4, Height: 4, Levels: [][]byte{make([]byte, 8)}}, 3, MipmapFilterBox)
+	if err == nil {
+		t.Error("generateMipChain accepted a compressed texture, expected an error")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+var sampleCounts = []core1_0.SampleCountFlags{
+	core1_0.Samples1,
+	core1_0.Samples2,
+	core1_0.Samples4,
+	core1_0.Samples8,
+	core1_0.Samples16,
+	core1_0.Samples32,
+	core1_0.Samples64,
+}
+
+func parseSampleCount(samples int) (core1_0.SampleCountFlags, error) {
+	for i, count := range sampleCounts {
+		if samples == 1<<i {
+			return count, nil
+		}
+	}
+
+	return 0, errors.Errorf("unsupported sample count %d- expected 1, 2, 4, 8, 16, 32 or 64", samples)
+}
+
+// chooseSampleCount returns the highest sample count in supported that is no higher than
+// requested. A single sample is always supported.
+func chooseSampleCount(supported core1_0.SampleCountFlags, requested core1_0.SampleCountFlags) core1_0.SampleCountFlags {
+	chosen := core1_0.Samples1
+	for _, count := range sampleCounts {
+		if count <= requested && supported&count != 0 {
+			chosen = count
+		}
+	}
+	return chosen
+}
+
+// nextSampleCount returns the next higher sample count in supported, wrapping back around
+// to a single sample
+func nextSampleCount(supported core1_0.SampleCountFlags, current core1_0.SampleCountFlags) core1_0.SampleCountFlags {
+	for _, count := range sampleCounts {
+		if count > current && supported&count != 0 {
+			return count
+		}
+	}
+	return core1_0.Samples1
+}
+
+// getUsableSampleCounts returns the sample counts both color and depth attachments support
+func (app *HelloTriangleApplication) getUsableSampleCounts() (core1_0.SampleCountFlags, error) {
+	properties, err := app.physicalDevice.Properties()
+	if err != nil {
+		return 0, err
+	}
+
+	return properties.Limits.FramebufferColorSampleCounts & properties.Limits.FramebufferDepthSampleCounts, nil
+}
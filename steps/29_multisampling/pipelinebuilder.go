@@ -0,0 +1,390 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// BlendMode is a preset for how a pipeline's color output is blended into its attachment
+type BlendMode int
+
+const (
+	// BlendModeOpaque overwrites the attachment
+	BlendModeOpaque BlendMode = iota
+	// BlendModeAlpha blends by the output's alpha
+	BlendModeAlpha
+	// BlendModePremultiplied blends an output whose color was already multiplied by its alpha
+	BlendModePremultiplied
+	// BlendModeAdditive adds the output to the attachment
+	BlendModeAdditive
+)
+
+func (m BlendMode) String() string {
+	switch m {
+	case BlendModeAlpha:
+		return "alpha"
+	case BlendModePremultiplied:
+		return "premultiplied"
+	case BlendModeAdditive:
+		return "additive"
+	}
+	return "opaque"
+}
+
+func (m BlendMode) attachmentState() core1_0.PipelineColorBlendAttachmentState {
+	state := core1_0.PipelineColorBlendAttachmentState{
+		ColorWriteMask: core1_0.ColorComponentRed | core1_0.ColorComponentGreen | core1_0.ColorComponentBlue | core1_0.ColorComponentAlpha,
+		ColorBlendOp:   core1_0.BlendOpAdd,
+		AlphaBlendOp:   core1_0.BlendOpAdd,
+	}
+
+	switch m {
+	case BlendModeAlpha:
+		state.BlendEnabled = true
+		state.SrcColorBlendFactor = core1_0.BlendFactorSrcAlpha
+		state.DstColorBlendFactor = core1_0.BlendFactorOneMinusSrcAlpha
+		state.SrcAlphaBlendFactor = core1_0.BlendFactorOne
+		state.DstAlphaBlendFactor = core1_0.BlendFactorOneMinusSrcAlpha
+	case BlendModePremultiplied:
+		state.BlendEnabled = true
+		state.SrcColorBlendFactor = core1_0.BlendFactorOne
+		state.DstColorBlendFactor = core1_0.BlendFactorOneMinusSrcAlpha
+		state.SrcAlphaBlendFactor = core1_0.BlendFactorOne
+		state.DstAlphaBlendFactor = core1_0.BlendFactorOneMinusSrcAlpha
+	case BlendModeAdditive:
+		state.BlendEnabled = true
+		state.SrcColorBlendFactor = core1_0.BlendFactorSrcAlpha
+		state.DstColorBlendFactor = core1_0.BlendFactorOne
+		state.SrcAlphaBlendFactor = core1_0.BlendFactorOne
+		state.DstAlphaBlendFactor = core1_0.BlendFactorOne
+	}
+
+	return state
+}
+
+type pipelineShader struct {
+	Stage core1_0.ShaderStageFlags
+	Path  string
+}
+
+// pipelineBuilder builds a graphics pipeline, starting from the state the tutorial uses-
+// back-face culled triangle lists with depth testing and no blending. Build checks the
+// state against the features the device was created with before creating the pipeline.
+type pipelineBuilder struct {
+	device   core1_0.Device
+	features *core1_0.PhysicalDeviceFeatures
+	debug    *debugUtils
+	name     string
+
+	shaders          []pipelineShader
+	vertexBindings   []core1_0.VertexInputBindingDescription
+	vertexAttributes []core1_0.VertexInputAttributeDescription
+	topology         core1_0.PrimitiveTopology
+	primitiveRestart bool
+
+	viewport      *core1_0.Extent2D
+	polygonMode   core1_0.PolygonMode
+	cullMode      core1_0.CullModeFlags
+	frontFace     core1_0.FrontFace
+	lineWidth     float32
+	samples       core1_0.SampleCountFlags
+	sampleShading float32
+	depthTest     bool
+	depthWrite    bool
+	depthCompare  core1_0.CompareOp
+	blend         BlendMode
+	dynamicStates []core1_0.DynamicState
+
+	layout     core1_0.PipelineLayout
+	renderPass core1_0.RenderPass
+	subpass    int
+}
+
+func newPipelineBuilder(device core1_0.Device, debug *debugUtils, features *core1_0.PhysicalDeviceFeatures) *pipelineBuilder {
+	return &pipelineBuilder{
+		device:       device,
+		debug:        debug,
+		features:     features,
+		topology:     core1_0.PrimitiveTopologyTriangleList,
+		polygonMode:  core1_0.PolygonModeFill,
+		cullMode:     core1_0.CullModeBack,
+		frontFace:    core1_0.FrontFaceCounterClockwise,
+		lineWidth:    1,
+		samples:      core1_0.Samples1,
+		depthTest:    true,
+		depthWrite:   true,
+		depthCompare: core1_0.CompareOpLess,
+		blend:        BlendModeOpaque,
+	}
+}
+
+// Name sets the debug name of the pipeline and its shader modules
+func (b *pipelineBuilder) Name(name string) *pipelineBuilder {
+	b.name = name
+	return b
+}
+
+// Shader adds a SPIR-V shader from the embedded file system, with a "main" entry point
+func (b *pipelineBuilder) Shader(stage core1_0.ShaderStageFlags, path string) *pipelineBuilder {
+	b.shaders = append(b.shaders, pipelineShader{Stage: stage, Path: path})
+	return b
+}
+
+func (b *pipelineBuilder) VertexLayout(bindings []core1_0.VertexInputBindingDescription, attributes []core1_0.VertexInputAttributeDescription) *pipelineBuilder {
+	b.vertexBindings = bindings
+	b.vertexAttributes = attributes
+	return b
+}
+
+func (b *pipelineBuilder) Topology(topology core1_0.PrimitiveTopology, primitiveRestart bool) *pipelineBuilder {
+	b.topology = topology
+	b.primitiveRestart = primitiveRestart
+	return b
+}
+
+// Viewport sets a viewport and scissor covering the whole extent. It isn't needed if both
+// are dynamic.
+func (b *pipelineBuilder) Viewport(extent core1_0.Extent2D) *pipelineBuilder {
+	b.viewport = &extent
+	return b
+}
+
+func (b *pipelineBuilder) PolygonMode(mode core1_0.PolygonMode) *pipelineBuilder {
+	b.polygonMode = mode
+	return b
+}
+
+// CullMode sets which faces are culled- 0 disables culling
+func (b *pipelineBuilder) CullMode(cullMode core1_0.CullModeFlags, frontFace core1_0.FrontFace) *pipelineBuilder {
+	b.cullMode = cullMode
+	b.frontFace = frontFace
+	return b
+}
+
+func (b *pipelineBuilder) LineWidth(width float32) *pipelineBuilder {
+	b.lineWidth = width
+	return b
+}
+
+func (b *pipelineBuilder) DepthTest(test bool, write bool, compare core1_0.CompareOp) *pipelineBuilder {
+	b.depthTest = test
+	b.depthWrite = write
+	b.depthCompare = compare
+	return b
+}
+
+func (b *pipelineBuilder) Blend(mode BlendMode) *pipelineBuilder {
+	b.blend = mode
+	return b
+}
+
+func (b *pipelineBuilder) Samples(samples core1_0.SampleCountFlags) *pipelineBuilder {
+	b.samples = samples
+	return b
+}
+
+// SampleShading runs the fragment shader for at least this fraction of each pixel's samples,
+// instead of once per pixel. 0 disables sample shading.
+func (b *pipelineBuilder) SampleShading(minSampleShading float32) *pipelineBuilder {
+	b.sampleShading = minSampleShading
+	return b
+}
+
+func (b *pipelineBuilder) DynamicStates(states ...core1_0.DynamicState) *pipelineBuilder {
+	b.dynamicStates = append(b.dynamicStates, states...)
+	return b
+}
+
+func (b *pipelineBuilder) Layout(layout core1_0.PipelineLayout) *pipelineBuilder {
+	b.layout = layout
+	return b
+}
+
+func (b *pipelineBuilder) RenderPass(renderPass core1_0.RenderPass, subpass int) *pipelineBuilder {
+	b.renderPass = renderPass
+	b.subpass = subpass
+	return b
+}
+
+func (b *pipelineBuilder) isDynamic(state core1_0.DynamicState) bool {
+	for _, dynamic := range b.dynamicStates {
+		if dynamic == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the pipeline's state is complete, and that the device was created
+// with every feature it needs
+func (b *pipelineBuilder) Validate() error {
+	if b.layout == nil {
+		return errors.New("pipelineBuilder: no pipeline layout was set")
+	}
+	if b.renderPass == nil {
+		return errors.New("pipelineBuilder: no render pass was set")
+	}
+
+	var stages core1_0.ShaderStageFlags
+	for _, shader := range b.shaders {
+		if stages&shader.Stage != 0 {
+			return errors.Errorf("pipelineBuilder: more than one %s shader was added", shader.Stage)
+		}
+		stages |= shader.Stage
+	}
+	if stages&core1_0.StageVertex == 0 {
+		return errors.New("pipelineBuilder: a vertex shader is required")
+	}
+	if stages&core1_0.StageGeometry != 0 && !b.features.GeometryShader {
+		return errors.New("pipelineBuilder: geometry shaders require the GeometryShader feature")
+	}
+
+	tessellation := stages & (core1_0.StageTessellationControl | core1_0.StageTessellationEvaluation)
+	if tessellation != 0 && !b.features.TessellationShader {
+		return errors.New("pipelineBuilder: tessellation shaders require the TessellationShader feature")
+	}
+	if tessellation != 0 && tessellation != core1_0.StageTessellationControl|core1_0.StageTessellationEvaluation {
+		return errors.New("pipelineBuilder: tessellation needs both a control and an evaluation shader")
+	}
+	if tessellation != 0 && b.topology != core1_0.PrimitiveTopologyPatchList {
+		return errors.Errorf("pipelineBuilder: tessellation shaders need the patch list topology, not %s", b.topology)
+	}
+	if tessellation == 0 && b.topology == core1_0.PrimitiveTopologyPatchList {
+		return errors.New("pipelineBuilder: the patch list topology needs tessellation shaders")
+	}
+
+	switch b.topology {
+	case core1_0.PrimitiveTopologyPointList, core1_0.PrimitiveTopologyLineList, core1_0.PrimitiveTopologyTriangleList,
+		core1_0.PrimitiveTopologyLineListWithAdjacency, core1_0.PrimitiveTopologyTriangleListWithAdjacency,
+		core1_0.PrimitiveTopologyPatchList:
+		if b.primitiveRestart {
+			return errors.Errorf("pipelineBuilder: primitive restart can't be used with topology %s", b.topology)
+		}
+	}
+
+	if b.polygonMode != core1_0.PolygonModeFill && !b.features.FillModeNonSolid {
+		return errors.Errorf("pipelineBuilder: polygon mode %s requires the FillModeNonSolid feature", b.polygonMode)
+	}
+	if b.lineWidth != 1 && !b.isDynamic(core1_0.DynamicStateLineWidth) && !b.features.WideLines {
+		return errors.Errorf("pipelineBuilder: line width %g requires the WideLines feature", b.lineWidth)
+	}
+
+	if b.samples == 0 || b.samples&(b.samples-1) != 0 {
+		return errors.Errorf("pipelineBuilder: sample count %s must be a single sample count", b.samples)
+	}
+	if b.sampleShading < 0 || b.sampleShading > 1 {
+		return errors.Errorf("pipelineBuilder: minimum sample shading %g must be between 0 and 1", b.sampleShading)
+	}
+	if b.sampleShading > 0 && !b.features.SampleRateShading {
+		return errors.New("pipelineBuilder: sample shading requires the SampleRateShading feature")
+	}
+
+	if b.viewport == nil && (!b.isDynamic(core1_0.DynamicStateViewport) || !b.isDynamic(core1_0.DynamicStateScissor)) {
+		return errors.New("pipelineBuilder: no viewport was set, and the viewport and scissor aren't dynamic")
+	}
+
+	return nil
+}
+
+// Build validates the pipeline's state and creates it
+func (b *pipelineBuilder) Build() (core1_0.Pipeline, error) {
+	err := b.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var stages []core1_0.PipelineShaderStageCreateInfo
+	for _, shader := range b.shaders {
+		shaderBytes, err := fileSystem.ReadFile(shader.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		module, _, err := b.device.CreateShaderModule(nil, core1_0.ShaderModuleCreateInfo{
+			Code: bytesToBytecode(shaderBytes),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "pipelineBuilder: shader '%s'", shader.Path)
+		}
+		defer module.Destroy(nil)
+		if b.name != "" {
+			b.debug.Name(module, fmt.Sprintf("%s %s", b.name, shader.Path))
+		}
+
+		stages = append(stages, core1_0.PipelineShaderStageCreateInfo{
+			Stage:  shader.Stage,
+			Module: module,
+			Name:   "main",
+		})
+	}
+
+	// With a dynamic viewport and scissor, the counts still come from here
+	viewport := &core1_0.PipelineViewportStateCreateInfo{
+		Viewports: []core1_0.Viewport{{MaxDepth: 1}},
+		Scissors:  []core1_0.Rect2D{{}},
+	}
+	if b.viewport != nil {
+		viewport.Viewports[0].Width = float32(b.viewport.Width)
+		viewport.Viewports[0].Height = float32(b.viewport.Height)
+		viewport.Scissors[0].Extent = *b.viewport
+	}
+
+	var dynamicState *core1_0.PipelineDynamicStateCreateInfo
+	if len(b.dynamicStates) > 0 {
+		dynamicState = &core1_0.PipelineDynamicStateCreateInfo{
+			DynamicStates: b.dynamicStates,
+		}
+	}
+
+	pipelines, _, err := b.device.CreateGraphicsPipelines(nil, nil, []core1_0.GraphicsPipelineCreateInfo{
+		{
+			Stages: stages,
+			VertexInputState: &core1_0.PipelineVertexInputStateCreateInfo{
+				VertexBindingDescriptions:   b.vertexBindings,
+				VertexAttributeDescriptions: b.vertexAttributes,
+			},
+			InputAssemblyState: &core1_0.PipelineInputAssemblyStateCreateInfo{
+				Topology:               b.topology,
+				PrimitiveRestartEnable: b.primitiveRestart,
+			},
+			ViewportState: viewport,
+			RasterizationState: &core1_0.PipelineRasterizationStateCreateInfo{
+				PolygonMode: b.polygonMode,
+				CullMode:    b.cullMode,
+				FrontFace:   b.frontFace,
+				LineWidth:   b.lineWidth,
+			},
+			MultisampleState: &core1_0.PipelineMultisampleStateCreateInfo{
+				RasterizationSamples: b.samples,
+				SampleShadingEnable:  b.sampleShading > 0,
+				MinSampleShading:     b.sampleShading,
+			},
+			DepthStencilState: &core1_0.PipelineDepthStencilStateCreateInfo{
+				DepthTestEnable:  b.depthTest,
+				DepthWriteEnable: b.depthWrite,
+				DepthCompareOp:   b.depthCompare,
+			},
+			ColorBlendState: &core1_0.PipelineColorBlendStateCreateInfo{
+				LogicOp: core1_0.LogicOpCopy,
+				Attachments: []core1_0.PipelineColorBlendAttachmentState{
+					b.blend.attachmentState(),
+				},
+			},
+			DynamicState:      dynamicState,
+			Layout:            b.layout,
+			RenderPass:        b.renderPass,
+			Subpass:           b.subpass,
+			BasePipelineIndex: -1,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if b.name != "" {
+		b.debug.Name(pipelines[0], b.name)
+	}
+	return pipelines[0], nil
+}
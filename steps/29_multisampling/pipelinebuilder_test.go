@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+type testPipelineLayout struct {
+	core1_0.PipelineLayout
+}
+
+type testRenderPass struct {
+	core1_0.RenderPass
+}
+
+func TestPipelineBuilderValidate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		features core1_0.PhysicalDeviceFeatures
+		build    func(b *pipelineBuilder)
+		wantErr  bool
+	}{
+		{name: "Default", build: func(b *pipelineBuilder) {}},
+		{
+			name:     "Wireframe",
+			features: core1_0.PhysicalDeviceFeatures{FillModeNonSolid: true},
+			build:    func(b *pipelineBuilder) { b.PolygonMode(core1_0.PolygonModeLine) },
+		},
+		{
+			name:    "WireframeUnsupported",
+			build:   func(b *pipelineBuilder) { b.PolygonMode(core1_0.PolygonModeLine) },
+			wantErr: true,
+		},
+		{
+			name:     "SampleShading",
+			features: core1_0.PhysicalDeviceFeatures{SampleRateShading: true},
+			build:    func(b *pipelineBuilder) { b.Samples(core1_0.Samples4).SampleShading(0.5) },
+		},
+		{
+			name:    "SampleShadingUnsupported",
+			build:   func(b *pipelineBuilder) { b.Samples(core1_0.Samples4).SampleShading(0.5) },
+			wantErr: true,
+		},
+		{
+			name:     "SampleShadingAboveOne",
+			features: core1_0.PhysicalDeviceFeatures{SampleRateShading: true},
+			build:    func(b *pipelineBuilder) { b.SampleShading(2) },
+			wantErr:  true,
+		},
+		{
+			name:    "SeveralSampleCounts",
+			build:   func(b *pipelineBuilder) { b.Samples(core1_0.Samples1 | core1_0.Samples4) },
+			wantErr: true,
+		},
+		{
+			name:    "NoSampleCount",
+			build:   func(b *pipelineBuilder) { b.Samples(0) },
+			wantErr: true,
+		},
+		{
+			name: "NoVertexShader",
+			build: func(b *pipelineBuilder) {
+				b.shaders = nil
+				b.Shader(core1_0.StageFragment, "shaders/frag.spv")
+			},
+			wantErr: true,
+		},
+		{
+			name:    "TwoVertexShaders",
+			build:   func(b *pipelineBuilder) { b.Shader(core1_0.StageVertex, "shaders/vert.spv") },
+			wantErr: true,
+		},
+		{
+			name:     "Tessellation",
+			features: core1_0.PhysicalDeviceFeatures{TessellationShader: true},
+			build: func(b *pipelineBuilder) {
+				b.Shader(core1_0.StageTessellationControl, "shaders/tesc.spv").
+					Shader(core1_0.StageTessellationEvaluation, "shaders/tese.spv").
+					Topology(core1_0.PrimitiveTopologyPatchList, false)
+			},
+		},
+		{
+			name:     "TessellationWithoutPatchList",
+			features: core1_0.PhysicalDeviceFeatures{TessellationShader: true},
+			build: func(b *pipelineBuilder) {
+				b.Shader(core1_0.StageTessellationControl, "shaders/tesc.spv").
+					Shader(core1_0.StageTessellationEvaluation, "shaders/tese.spv")
+			},
+			wantErr: true,
+		},
+		{
+			name:    "PatchListWithoutTessellation",
+			build:   func(b *pipelineBuilder) { b.Topology(core1_0.PrimitiveTopologyPatchList, false) },
+			wantErr: true,
+		},
+		{
+			name:  "PrimitiveRestartStrip",
+			build: func(b *pipelineBuilder) { b.Topology(core1_0.PrimitiveTopologyTriangleStrip, true) },
+		},
+		{
+			name:    "PrimitiveRestartList",
+			build:   func(b *pipelineBuilder) { b.Topology(core1_0.PrimitiveTopologyTriangleList, true) },
+			wantErr: true,
+		},
+		{
+			name: "DynamicViewport",
+			build: func(b *pipelineBuilder) {
+				b.viewport = nil
+				b.DynamicStates(core1_0.DynamicStateViewport, core1_0.DynamicStateScissor)
+			},
+		},
+		{
+			name: "NoViewport",
+			build: func(b *pipelineBuilder) {
+				b.viewport = nil
+				b.DynamicStates(core1_0.DynamicStateViewport)
+			},
+			wantErr: true,
+		},
+		{
+			name:    "NoLayout",
+			build:   func(b *pipelineBuilder) { b.Layout(nil) },
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			features := testCase.features
+			builder := newPipelineBuilder(nil, nil, &features).
+				Shader(core1_0.StageVertex, "shaders/vert.spv").
+				Shader(core1_0.StageFragment, "shaders/frag.spv").
+				Viewport(core1_0.Extent2D{Width: 800, Height: 600}).
+				Layout(testPipelineLayout{}).
+				RenderPass(testRenderPass{}, 0)
+			testCase.build(builder)
+
+			err := builder.Validate()
+			if testCase.wantErr && err == nil {
+				t.Error("Validate accepted the pipeline, expected an error")
+			} else if !testCase.wantErr && err != nil {
+				t.Errorf("Validate: %+v", err)
+			}
+		})
+	}
+}
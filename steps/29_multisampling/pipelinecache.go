@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// pipelineState is the part of the scene pipeline that can be changed at runtime. Every
+// combination is a separate pipeline.
+type pipelineState struct {
+	Wireframe bool
+	CullMode  core1_0.CullModeFlags
+	Blend     BlendMode
+	Samples   core1_0.SampleCountFlags
+	// SampleShading is the minimum fraction of samples shaded per pixel, or 0 for none
+	SampleShading float32
+}
+
+func (s pipelineState) String() string {
+	cullMode := "none"
+	switch s.CullMode {
+	case core1_0.CullModeBack:
+		cullMode = "back"
+	case core1_0.CullModeFront:
+		cullMode = "front"
+	case core1_0.CullModeFront | core1_0.CullModeBack:
+		cullMode = "front and back"
+	}
+	return fmt.Sprintf("wireframe: %t, cull: %s, blend: %s, samples: %s, sample shading: %g", s.Wireframe, cullMode, s.Blend, s.Samples, s.SampleShading)
+}
+
+type pipelineKey struct {
+	State      pipelineState
+	RenderPass core1_0.RenderPass
+}
+
+type pipelineCacheStats struct {
+	Hits        int
+	Misses      int
+	CompileTime time.Duration
+}
+
+func (s pipelineCacheStats) String() string {
+	return fmt.Sprintf("%d hits, %d misses, %s compiling", s.Hits, s.Misses, s.CompileTime)
+}
+
+// pipelineVariants builds a pipeline for each pipelineState the first time it's asked for,
+// and keeps it until the render pass it was built for goes away. Every variant shares the
+// same pipeline layout.
+type pipelineVariants struct {
+	layout core1_0.PipelineLayout
+	// configure returns a builder for a state, which the cache sets the layout and render
+	// pass of
+	configure func(state pipelineState) *pipelineBuilder
+
+	variants map[pipelineKey]core1_0.Pipeline
+	stats    pipelineCacheStats
+}
+
+func newPipelineVariants(layout core1_0.PipelineLayout, configure func(state pipelineState) *pipelineBuilder) *pipelineVariants {
+	return &pipelineVariants{
+		layout:    layout,
+		configure: configure,
+		variants:  make(map[pipelineKey]core1_0.Pipeline),
+	}
+}
+
+// Get returns the pipeline for a state and render pass, building it if it hasn't been yet
+func (c *pipelineVariants) Get(state pipelineState, renderPass core1_0.RenderPass) (core1_0.Pipeline, error) {
+	key := pipelineKey{State: state, RenderPass: renderPass}
+	pipeline, ok := c.variants[key]
+	if ok {
+		c.stats.Hits++
+		return pipeline, nil
+	}
+
+	c.stats.Misses++
+	start := time.Now()
+	pipeline, err := c.configure(state).
+		Layout(c.layout).
+		RenderPass(renderPass, 0).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := time.Since(start)
+	c.stats.CompileTime += elapsed
+	log.Printf("built pipeline variant (%s) in %s", state, elapsed)
+
+	c.variants[key] = pipeline
+	return pipeline, nil
+}
+
+// ReleaseRenderPass destroys every variant built for a render pass. It's called before the
+// render pass is destroyed, since nothing can use those variants afterward.
+func (c *pipelineVariants) ReleaseRenderPass(renderPass core1_0.RenderPass) {
+	for key, pipeline := range c.variants {
+		if key.RenderPass == renderPass {
+			pipeline.Destroy(nil)
+			delete(c.variants, key)
+		}
+	}
+}
+
+func (c *pipelineVariants) Stats() pipelineCacheStats {
+	return c.stats
+}
+
+// Destroy destroys every variant. The pipeline layout belongs to the caller.
+func (c *pipelineVariants) Destroy() {
+	for key, pipeline := range c.variants {
+		pipeline.Destroy(nil)
+		delete(c.variants, key)
+	}
+}
+
+func (app *HelloTriangleApplication) configurePipeline(state pipelineState) *pipelineBuilder {
+	polygonMode := core1_0.PolygonModeFill
+	if state.Wireframe {
+		polygonMode = core1_0.PolygonModeLine
+	}
+
+	return newPipelineBuilder(app.device, app.debug, app.deviceFeatures).
+		Name(fmt.Sprintf("scene pipeline (%s)", state)).
+		Shader(core1_0.StageVertex, "shaders/vert.spv").
+		Shader(core1_0.StageFragment, "shaders/frag.spv").
+		VertexLayout(getVertexBindingDescription(), getVertexAttributeDescriptions()).
+		Viewport(app.swapchainExtent).
+		DynamicStates(core1_0.DynamicStateScissor).
+		PolygonMode(polygonMode).
+		CullMode(state.CullMode, core1_0.FrontFaceCounterClockwise).
+		Blend(state.Blend).
+		Samples(state.Samples).
+		SampleShading(state.SampleShading)
+}
+
+// setPipelineState switches the scene to another pipeline variant. States the device can't
+// build, such as wireframe without FillModeNonSolid, are logged and ignored.
+func (app *HelloTriangleApplication) setPipelineState(state pipelineState) error {
+	_, err := app.pipelines.Get(state, app.renderPass)
+	if err != nil {
+		log.Printf("can't switch to pipeline (%s): %v", state, err)
+		return nil
+	}
+
+	app.pipelineState = state
+	log.Printf("switched to pipeline (%s)", state)
+	return app.recreateCommandBuffers()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/extensions/v2/khr_surface"
+)
+
+// presentModes is every present mode, in the order the hotkey cycles through them
+var presentModes = []khr_surface.PresentMode{
+	khr_surface.PresentModeFIFO,
+	khr_surface.PresentModeFIFORelaxed,
+	khr_surface.PresentModeMailbox,
+	khr_surface.PresentModeImmediate,
+}
+
+func parsePresentMode(mode string) (khr_surface.PresentMode, error) {
+	switch mode {
+	case "fifo":
+		return khr_surface.PresentModeFIFO, nil
+	case "fifo-relaxed":
+		return khr_surface.PresentModeFIFORelaxed, nil
+	case "mailbox":
+		return khr_surface.PresentModeMailbox, nil
+	case "immediate":
+		return khr_surface.PresentModeImmediate, nil
+	}
+
+	return 0, errors.Errorf("unknown present mode '%s'- expected fifo, fifo-relaxed, mailbox or immediate", mode)
+}
+
+// parsePresentModes parses a comma-separated list of present modes, most preferred first
+func parsePresentModes(modes string) ([]khr_surface.PresentMode, error) {
+	var parsed []khr_surface.PresentMode
+	for _, name := range strings.Split(modes, ",") {
+		mode, err := parsePresentMode(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(parsed, mode) {
+			parsed = append(parsed, mode)
+		}
+	}
+
+	return parsed, nil
+}
+
+// choosePresentMode returns the first mode in preferred that's available. Every surface
+// supports FIFO, so it's the fallback.
+func choosePresentMode(available []khr_surface.PresentMode, preferred []khr_surface.PresentMode) khr_surface.PresentMode {
+	for _, mode := range preferred {
+		if slices.Contains(available, mode) {
+			return mode
+		}
+	}
+
+	return khr_surface.PresentModeFIFO
+}
+
+// nextPresentMode returns the next mode after current that's available, wrapping around
+func nextPresentMode(available []khr_surface.PresentMode, current khr_surface.PresentMode) khr_surface.PresentMode {
+	start := slices.Index(presentModes, current)
+	for i := 1; i <= len(presentModes); i++ {
+		mode := presentModes[(start+i)%len(presentModes)]
+		if slices.Contains(available, mode) {
+			return mode
+		}
+	}
+
+	return khr_surface.PresentModeFIFO
+}
+
+// preferPresentMode moves a mode to the front of a preference list
+func preferPresentMode(preferred []khr_surface.PresentMode, mode khr_surface.PresentMode) []khr_surface.PresentMode {
+	reordered := []khr_surface.PresentMode{mode}
+	for _, preferredMode := range preferred {
+		if preferredMode != mode {
+			reordered = append(reordered, preferredMode)
+		}
+	}
+	return reordered
+}
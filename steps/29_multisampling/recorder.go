@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// recorderRingSize is how many readbacks can be in flight at once. A readback is only read
+// when its slot comes around again, by which point its copy has long finished, so recording
+// never waits on the GPU.
+const recorderRingSize = 3
+
+// recorderQueueSize is how many read back frames can wait to be encoded before capturing
+// blocks on the encoder
+const recorderQueueSize = 8
+
+type recordingFormat int
+
+const (
+	recordingPNG recordingFormat = iota
+	recordingY4M
+)
+
+type recordedFrame struct {
+	number int
+	pixels *image.NRGBA
+}
+
+type recorderSlot struct {
+	readback *swapchainReadback
+	number   int
+	pending  bool
+}
+
+// frameRecorder saves presented frames as numbered PNGs in a directory, or as a Y4M stream.
+// Frames are copied through a ring of readbacks and encoded in order on a background
+// goroutine.
+type frameRecorder struct {
+	path        string
+	format      recordingFormat
+	every       int
+	limit       int
+	frameLength float64
+
+	newReadback func() (*swapchainReadback, error)
+	slots       []recorderSlot
+	next        int
+	presented   int
+	captured    int
+
+	frames chan recordedFrame
+	done   sync.WaitGroup
+	mutex  sync.Mutex
+	err    error
+
+	y4mFile   *os.File
+	y4mWriter *bufio.Writer
+	y4mWidth  int
+	y4mHeight int
+}
+
+// newFrameRecorder records every Nth presented frame to path, which is a Y4M file if it ends
+// in .y4m and a directory of PNGs otherwise. Recording stops after limit frames, or never if
+// it's 0. frameLength is the number of seconds each recorded frame lasts, which is written
+// to Y4M headers.
+func newFrameRecorder(path string, every, limit int, frameLength float64, newReadback func() (*swapchainReadback, error)) (*frameRecorder, error) {
+	if every < 1 {
+		return nil, errors.Errorf("frameRecorder: frames must be recorded at least every frame, got every %d", every)
+	}
+
+	recorder := &frameRecorder{
+		path:        path,
+		every:       every,
+		limit:       limit,
+		frameLength: frameLength,
+		newReadback: newReadback,
+		slots:       make([]recorderSlot, recorderRingSize),
+		frames:      make(chan recordedFrame, recorderQueueSize),
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".y4m") {
+		recorder.format = recordingY4M
+
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "frameRecorder: failed to create %s", path)
+		}
+		recorder.y4mFile = file
+		recorder.y4mWriter = bufio.NewWriter(file)
+	} else {
+		err := os.MkdirAll(path, 0755)
+		if err != nil {
+			return nil, errors.Wrapf(err, "frameRecorder: failed to create %s", path)
+		}
+	}
+
+	recorder.done.Add(1)
+	go recorder.encode()
+
+	return recorder, nil
+}
+
+// Finished reports whether the recorder has recorded as many frames as it was asked to
+func (r *frameRecorder) Finished() bool {
+	return r.limit > 0 && r.captured >= r.limit
+}
+
+// Capture is called for every presented frame, before it's presented. If the frame is
+// recorded, a copy is submitted that waits on wait, and the semaphore the present must wait
+// on instead is returned. Otherwise wait is returned as it is. presentQueue is the queue
+// earlier frames were presented on.
+func (r *frameRecorder) Capture(queue, presentQueue core1_0.Queue, image core1_0.Image, format core1_0.Format, extent core1_0.Extent2D, wait core1_0.Semaphore) (core1_0.Semaphore, error) {
+	presented := r.presented
+	r.presented++
+	if presented%r.every != 0 || r.Finished() {
+		return wait, nil
+	}
+
+	err := r.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, slot := range r.slots {
+		if slot.readback != nil && (slot.readback.format != format || slot.readback.extent != extent) {
+			// The swapchain was recreated, so every slot is drained and replaced
+			err = r.Flush()
+			if err != nil {
+				return nil, err
+			}
+
+			// Queued presents may still be waiting on the readbacks' semaphores
+			_, err = presentQueue.WaitIdle()
+			if err != nil {
+				return nil, err
+			}
+			r.destroySlots()
+			break
+		}
+	}
+
+	slot := &r.slots[r.next]
+	r.next = (r.next + 1) % len(r.slots)
+
+	if slot.pending {
+		err = r.read(slot)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if slot.readback == nil {
+		slot.readback, err = r.newReadback()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = slot.readback.Submit(queue, image, wait)
+	if err != nil {
+		return nil, err
+	}
+	slot.number = r.captured
+	slot.pending = true
+	r.captured++
+
+	return slot.readback.Done, nil
+}
+
+// read hands a slot's finished readback to the encoder
+func (r *frameRecorder) read(slot *recorderSlot) error {
+	pixels, err := slot.readback.Read()
+	if err != nil {
+		return err
+	}
+	slot.pending = false
+
+	r.frames <- recordedFrame{number: slot.number, pixels: pixels}
+	return nil
+}
+
+// Flush reads every readback still in flight, oldest first, waiting for any that haven't
+// finished
+func (r *frameRecorder) Flush() error {
+	for i := 0; i < len(r.slots); i++ {
+		slot := &r.slots[(r.next+i)%len(r.slots)]
+		if !slot.pending {
+			continue
+		}
+
+		err := r.read(slot)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Discard drops the readbacks still in flight without reading them, for when the device
+// they were made on has been lost. New ones are made from the next frame on.
+func (r *frameRecorder) Discard() {
+	for i := range r.slots {
+		if r.slots[i].pending {
+			log.Printf("frameRecorder: frame %d was lost with the device", r.slots[i].number)
+		}
+	}
+	r.destroySlots()
+}
+
+func (r *frameRecorder) destroySlots() {
+	for i := range r.slots {
+		if r.slots[i].readback != nil {
+			r.slots[i].readback.Destroy()
+		}
+		r.slots[i] = recorderSlot{}
+	}
+}
+
+func (r *frameRecorder) setErr(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// Err returns the first error the encoder ran into
+func (r *frameRecorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.err
+}
+
+func (r *frameRecorder) encode() {
+	defer r.done.Done()
+
+	for frame := range r.frames {
+		if r.Err() != nil {
+			continue
+		}
+
+		var err error
+		if r.format == recordingY4M {
+			err = r.writeY4MFrame(frame.pixels)
+		} else {
+			err = writePNG(filepath.Join(r.path, fmt.Sprintf("frame-%06d.png", frame.number)), frame.pixels)
+		}
+		if err != nil {
+			r.setErr(err)
+		}
+	}
+}
+
+// Close finishes recording, once the device is idle. Frames still in flight are written
+// before it returns.
+func (r *frameRecorder) Close() error {
+	err := r.Flush()
+	r.destroySlots()
+	close(r.frames)
+	r.done.Wait()
+	if err != nil {
+		return err
+	}
+
+	if r.y4mFile != nil {
+		err = r.y4mWriter.Flush()
+		closeErr := r.y4mFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return errors.Wrapf(err, "frameRecorder: failed to write %s", r.path)
+		}
+	}
+
+	err = r.Err()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("recorded %d frames to %s", r.captured, r.path)
+	return nil
+}
+
+// y4mFrameRate returns the frame rate of a Y4M stream as a ratio. Rates like 60 or 60/7
+// are written exactly, and anything else to the nearest microsecond of frame length.
+func y4mFrameRate(frameLength float64) (int, int) {
+	if frameLength > 0 {
+		for denominator := 1; denominator <= 1000; denominator++ {
+			numerator := float64(denominator) / frameLength
+			if math.Abs(numerator-math.Round(numerator)) < 1e-6 {
+				return int(math.Round(numerator)), denominator
+			}
+		}
+	}
+
+	denominator := int(math.Round(frameLength * 1000000))
+	if denominator < 1 {
+		denominator = 1
+	}
+	return 1000000, denominator
+}
+
+// writeY4MFrame writes a frame as 4:4:4 BT.709 limited range YCbCr, so no chroma is lost to
+// subsampling. The pixels are sRGB encoded, which is close enough to BT.709's transfer
+// function that they're used as they are.
+func (r *frameRecorder) writeY4MFrame(pixels *image.NRGBA) error {
+	width, height := pixels.Rect.Dx(), pixels.Rect.Dy()
+	if r.y4mWidth == 0 {
+		numerator, denominator := y4mFrameRate(r.frameLength)
+		_, err := fmt.Fprintf(r.y4mWriter, "YUV4MPEG2 W%d H%d F%d:%d Ip A1:1 C444 XCOLORRANGE=LIMITED\n", width, height, numerator, denominator)
+		if err != nil {
+			return errors.Wrapf(err, "frameRecorder: failed to write %s", r.path)
+		}
+		r.y4mWidth, r.y4mHeight = width, height
+	} else if width != r.y4mWidth || height != r.y4mHeight {
+		return errors.Errorf("frameRecorder: the swapchain was resized from %dx%d to %dx%d- a y4m stream can't change size", r.y4mWidth, r.y4mHeight, width, height)
+	}
+
+	planeSize := width * height
+	planes := make([]byte, planeSize*3)
+	for i := 0; i < planeSize; i++ {
+		red := float64(pixels.Pix[i*4])
+		green := float64(pixels.Pix[i*4+1])
+		blue := float64(pixels.Pix[i*4+2])
+
+		luma := 0.2126*red + 0.7152*green + 0.0722*blue
+		planes[i] = uint8(math.Round(16 + luma*219/255))
+		planes[planeSize+i] = uint8(math.Round(128 + (blue-luma)/1.8556*224/255))
+		planes[planeSize*2+i] = uint8(math.Round(128 + (red-luma)/1.5748*224/255))
+	}
+
+	_, err := r.y4mWriter.WriteString("FRAME\n")
+	if err == nil {
+		_, err = r.y4mWriter.Write(planes)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "frameRecorder: failed to write %s", r.path)
+	}
+	return nil
+}
+
+// createRecorder starts recording frames if -record was passed
+func (app *HelloTriangleApplication) createRecorder() error {
+	if app.recordPath == "" {
+		return nil
+	}
+	if !app.swapchainTransferSrc {
+		return errors.New("the surface doesn't allow copying from swapchain images, so frames can't be recorded")
+	}
+	if !swapchainReadbackSupported(app.swapchainImageFormat) {
+		return errors.Errorf("can't record %s swapchain images", app.swapchainImageFormat)
+	}
+
+	// Without a fixed timestep, frames are assumed to come at 60fps
+	frameLength := float64(app.recordEvery) / 60
+	if app.fixedTimestep > 0 {
+		frameLength = app.fixedTimestep * float64(app.recordEvery)
+	}
+
+	recorder, err := newFrameRecorder(app.recordPath, app.recordEvery, app.recordFrames, frameLength, func() (*swapchainReadback, error) {
+		return app.createSwapchainReadback("recording")
+	})
+	if err != nil {
+		return err
+	}
+	app.recorder = recorder
+
+	log.Printf("recording every %d frames to %s", app.recordEvery, app.recordPath)
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"math"
+	"testing"
+)
+
+func TestY4MFrameRate(t *testing.T) {
+	testCases := []struct {
+		name            string
+		frameLength     float64
+		wantNumerator   int
+		wantDenominator int
+	}{
+		{name: "60fps", frameLength: 1.0 / 60, wantNumerator: 60, wantDenominator: 1},
+		{name: "EverySecondFrame", frameLength: 2.0 / 60, wantNumerator: 30, wantDenominator: 1},
+		{name: "EverySeventhFrame", frameLength: 7.0 / 60, wantNumerator: 60, wantDenominator: 7},
+		{name: "OneSecond", frameLength: 1, wantNumerator: 1, wantDenominator: 1},
+		// Nothing up to 1000 frames lines up, so the frame length is rounded to microseconds
+		{name: "Irrational", frameLength: math.Pi / 100, wantNumerator: 1000000, wantDenominator: 31416},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			numerator, denominator := y4mFrameRate(testCase.frameLength)
+			if numerator != testCase.wantNumerator || denominator != testCase.wantDenominator {
+				t.Errorf("got %d:%d, expected %d:%d", numerator, denominator, testCase.wantNumerator, testCase.wantDenominator)
+			}
+		})
+	}
+}
+
+func TestWriteY4MFrame(t *testing.T) {
+	// Black, white, red, green and blue in a 5x1 frame
+	colors := [][4]byte{{0, 0, 0, 255}, {255, 255, 255, 255}, {255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}}
+	pixels := image.NewNRGBA(image.Rect(0, 0, len(colors), 1))
+	for i, color := range colors {
+		copy(pixels.Pix[i*4:], color[:])
+	}
+
+	var out bytes.Buffer
+	recorder := &frameRecorder{
+		path:        "test.y4m",
+		frameLength: 1.0 / 60,
+		y4mWriter:   bufio.NewWriter(&out),
+	}
+	for frame := 0; frame < 2; frame++ {
+		err := recorder.writeY4MFrame(pixels)
+		if err != nil {
+			t.Fatalf("writeY4MFrame: %+v", err)
+		}
+	}
+	err := recorder.y4mWriter.Flush()
+	if err != nil {
+		t.Fatalf("Flush: %+v", err)
+	}
+
+	// The planes are Y, then Cb, then Cr, each a full-size 4:4:4 plane. The BT.709 limited
+	// range values are 16-235 for luma and 16-240 for chroma.
+	planes := []byte{
+		16, 235, 63, 173, 32,
+		128, 128, 102, 42, 240,
+		128, 128, 240, 26, 118,
+	}
+	frame := append([]byte("FRAME\n"), planes...)
+	want := append([]byte("YUV4MPEG2 W5 H1 F60:1 Ip A1:1 C444 XCOLORRANGE=LIMITED\n"), frame...)
+	want = append(want, frame...)
+
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("got the stream\n%q\nexpected\n%q", out.Bytes(), want)
+	}
+
+	err = recorder.writeY4MFrame(image.NewNRGBA(image.Rect(0, 0, 4, 1)))
+	if err == nil {
+		t.Error("writeY4MFrame accepted a frame of a different size, expected an error")
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"log"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/common"
+	"github.com/vkngwrapper/core/v2/core1_0"
+	"github.com/vkngwrapper/extensions/v2/khr_surface"
+)
+
+// maxLossRecoveries is how many times in a row the surface or device is rebuilt without a
+// frame being drawn in between, before giving up
+const maxLossRecoveries = 3
+
+var (
+	errSurfaceLost = errors.New("the surface was lost")
+	errDeviceLost  = errors.New("the device was lost")
+)
+
+// checkLost returns errSurfaceLost or errDeviceLost when a result means the surface or the
+// device is gone, so the main loop can rebuild it, and err otherwise
+func checkLost(res common.VkResult, err error) error {
+	switch res {
+	case khr_surface.VKErrorSurfaceLost:
+		return errors.WithStack(errSurfaceLost)
+	case core1_0.VKErrorDeviceLost:
+		return errors.WithStack(errDeviceLost)
+	}
+	return err
+}
+
+// recoverFromLoss rebuilds the surface or the device if err says it was lost, and returns
+// any other error as it is
+func (app *HelloTriangleApplication) recoverFromLoss(err error) error {
+	lostSurface := errors.Is(err, errSurfaceLost)
+	lostDevice := errors.Is(err, errDeviceLost)
+	if !lostSurface && !lostDevice {
+		return err
+	}
+
+	app.lossRecoveries++
+	if app.lossRecoveries > maxLossRecoveries {
+		return errors.Wrapf(err, "gave up after %d attempts to recover", maxLossRecoveries)
+	}
+
+	if lostSurface {
+		log.Printf("the surface was lost- recreating it and the swapchain (attempt %d of %d)", app.lossRecoveries, maxLossRecoveries)
+		return app.recoverFromLoss(app.recreateSurface())
+	}
+
+	log.Printf("the device was lost- rebuilding it and every resource (attempt %d of %d)", app.lossRecoveries, maxLossRecoveries)
+	return app.recoverFromLoss(app.recreateDevice())
+}
+
+// recreateSurface replaces a lost surface, along with the swapchain that was built on it
+func (app *HelloTriangleApplication) recreateSurface() error {
+	res, err := app.device.WaitIdle()
+	if err != nil {
+		return checkLost(res, err)
+	}
+
+	// The swapchain has to be destroyed before its surface
+	app.destroyRetiredSwapchains(true)
+	app.cleanupSwapChain()
+	app.surface.Destroy(nil)
+	app.surface = nil
+
+	err = app.createSurface()
+	if err != nil {
+		return err
+	}
+
+	// The device's queues were picked for the old surface. If the new one can't be
+	// presented from them, the device has to be rebuilt as well.
+	indices, err := app.findQueueFamilies(app.physicalDevice)
+	if err != nil {
+		return err
+	}
+	if !indices.IsComplete() || *indices.GraphicsFamily != *app.queueFamilies.GraphicsFamily || *indices.PresentFamily != *app.queueFamilies.PresentFamily {
+		log.Println("the new surface needs different queue families- rebuilding the device")
+		return app.recreateDevice()
+	}
+
+	return app.recreateSwapChain()
+}
+
+// recreateDevice tears down the device and everything created from it, then builds them all
+// again. The instance, surface and window are kept.
+func (app *HelloTriangleApplication) recreateDevice() error {
+	// A lost device reports that it's lost here, which is already known
+	res, err := app.device.WaitIdle()
+	if err != nil && res != core1_0.VKErrorDeviceLost {
+		log.Printf("waiting for the lost device: %v", err)
+	}
+
+	if app.recorder != nil {
+		app.recorder.Discard()
+	}
+	app.cleanupDevice()
+
+	err = app.createDeviceResources()
+	if err != nil {
+		return err
+	}
+
+	log.Println("rebuilt the device")
+	return nil
+}
+
+// createDeviceResources picks a physical device and creates the logical device and
+// everything built from it. It's run again to rebuild them when the device is lost.
+func (app *HelloTriangleApplication) createDeviceResources() error {
+	err := app.pickPhysicalDevice()
+	if err != nil {
+		return err
+	}
+
+	err = app.createLogicalDevice()
+	if err != nil {
+		return err
+	}
+
+	err = app.createGPUProfiler()
+	if err != nil {
+		return err
+	}
+
+	err = app.createSwapchain(nil)
+	if err != nil {
+		return err
+	}
+
+	err = app.createImageViews()
+	if err != nil {
+		return err
+	}
+
+	err = app.createRenderGraph()
+	if err != nil {
+		return err
+	}
+
+	err = app.createDescriptorSetLayout()
+	if err != nil {
+		return err
+	}
+
+	err = app.createGraphicsPipeline()
+	if err != nil {
+		return err
+	}
+
+	err = app.createCommandPool()
+	if err != nil {
+		return err
+	}
+
+	err = app.createTextureImage()
+	if err != nil {
+		return err
+	}
+
+	err = app.createTextureImageView()
+	if err != nil {
+		return err
+	}
+
+	err = app.createSampler()
+	if err != nil {
+		return err
+	}
+
+	err = app.createVertexBuffer()
+	if err != nil {
+		return err
+	}
+
+	err = app.createIndexBuffer()
+	if err != nil {
+		return err
+	}
+
+	err = app.createUniformBuffers()
+	if err != nil {
+		return err
+	}
+
+	err = app.createDescriptorPool()
+	if err != nil {
+		return err
+	}
+
+	err = app.createDescriptorSets()
+	if err != nil {
+		return err
+	}
+
+	err = app.createCommandBuffers()
+	if err != nil {
+		return err
+	}
+
+	return app.createSyncObjects()
+}
+
+// cleanupDevice destroys the device and everything created from it, leaving the app ready
+// for createDeviceResources
+func (app *HelloTriangleApplication) cleanupDevice() {
+	if app.device == nil {
+		return
+	}
+
+	app.destroyRetiredSwapchains(true)
+	app.cleanupSwapChain()
+
+	if app.pipelines != nil {
+		log.Printf("pipeline cache: %s", app.pipelines.Stats())
+		app.pipelines.Destroy()
+	}
+
+	app.gpuProfiler.LogStats()
+	app.gpuProfiler.Destroy()
+
+	if app.pipelineLayout != nil {
+		app.pipelineLayout.Destroy(nil)
+	}
+
+	if app.textureSampler != nil {
+		app.textureSampler.Destroy(nil)
+	}
+
+	if app.textureImageView != nil {
+		app.textureImageView.Destroy(nil)
+	}
+
+	if app.textureImage != nil {
+		app.imageStates.Forget(app.textureImage)
+		app.textureImage.Destroy(nil)
+	}
+
+	if app.textureImageMemory != nil {
+		app.textureImageMemory.Free(nil)
+	}
+
+	if app.descriptorSetLayout != nil {
+		app.descriptorSetLayout.Destroy(nil)
+	}
+
+	if app.indexBuffer != nil {
+		app.indexBuffer.Destroy(nil)
+	}
+
+	if app.indexBufferMemory != nil {
+		app.indexBufferMemory.Free(nil)
+	}
+
+	if app.vertexBuffer != nil {
+		app.vertexBuffer.Destroy(nil)
+	}
+
+	if app.vertexBufferMemory != nil {
+		app.vertexBufferMemory.Free(nil)
+	}
+
+	for _, fence := range app.inFlightFence {
+		fence.Destroy(nil)
+	}
+
+	for _, semaphore := range app.renderFinishedSemaphore {
+		semaphore.Destroy(nil)
+	}
+
+	for _, semaphore := range app.imageAvailableSemaphore {
+		semaphore.Destroy(nil)
+	}
+
+	if app.commandPool != nil {
+		app.commandPool.Destroy(nil)
+	}
+
+	app.device.Destroy(nil)
+
+	// Everything else created from the device is gone with it, so nothing stale is picked up
+	// if it's rebuilt
+	app.device = nil
+	app.physicalDevice = nil
+	app.debug = nil
+	app.gpuProfiler = nil
+	app.pipelines = nil
+	app.pipelineLayout = nil
+	app.commandPool = nil
+	app.swapchainImages = nil
+	app.imageStates = imageStateTracker{}
+	app.textureSampler = nil
+	app.textureImageView = nil
+	app.textureImage = nil
+	app.textureImageMemory = nil
+	app.descriptorSetLayout = nil
+	app.indexBuffer = nil
+	app.indexBufferMemory = nil
+	app.vertexBuffer = nil
+	app.vertexBufferMemory = nil
+	app.inFlightFence = nil
+	app.renderFinishedSemaphore = nil
+	app.imageAvailableSemaphore = nil
+	app.imagesInFlight = nil
+	app.currentFrame = 0
+	app.frameSerials = [MaxFramesInFlight]uint64{}
+	app.completedSerial = app.submitSerial
+}
@@ -0,0 +1,979 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/common"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// graphImage and graphBuffer are handles to the resources of a renderGraph
+type graphImage int
+type graphBuffer int
+
+type graphAttachment int
+
+const (
+	graphAttachmentNone graphAttachment = iota
+	graphAttachmentColor
+	graphAttachmentResolve
+	graphAttachmentDepth
+)
+
+// graphImageDesc describes an image the graph creates and owns. A Width or Height of 0
+// means the graph's extent.
+type graphImageDesc struct {
+	Format  core1_0.Format
+	Samples core1_0.SampleCountFlags
+	Width   int
+	Height  int
+}
+
+// graphImport describes an image that was created outside the graph, such as the swapchain
+// images. Images and Views hold one entry per frame, or a single entry if the same image is
+// used every frame. Each frame starts with the image in Initial and finishes with it moved to
+// Final.
+type graphImport struct {
+	Format  core1_0.Format
+	Samples core1_0.SampleCountFlags
+	Width   int
+	Height  int
+	Images  []core1_0.Image
+	Views   []core1_0.ImageView
+	Initial imageState
+	Final   imageState
+}
+
+type renderGraphImage struct {
+	Name     string
+	Desc     graphImageDesc
+	Imported *graphImport
+
+	usage      core1_0.ImageUsageFlags
+	firstUse   int
+	lastUse    int
+	lastState  imageState
+	frameStart imageState
+
+	image core1_0.Image
+	view  core1_0.ImageView
+}
+
+type renderGraphBuffer struct {
+	Name   string
+	Buffer core1_0.Buffer
+}
+
+type graphImageUse struct {
+	Image      graphImage
+	State      imageState
+	Attachment graphAttachment
+	Clear      core1_0.ClearValue
+}
+
+type graphBufferUse struct {
+	Buffer graphBuffer
+	Access core1_0.AccessFlags
+	Stage  core1_0.PipelineStageFlags
+}
+
+// renderGraphPass is a single pass of a renderGraph. Passes that use attachments are run
+// inside a render pass the graph creates for them. Other passes, such as transfers, just
+// have their barriers recorded before them.
+type renderGraphPass struct {
+	Name string
+
+	images    []graphImageUse
+	buffers   []graphBufferUse
+	execute   func(buffer core1_0.CommandBuffer, frame int) error
+	neverCull bool
+
+	renderPass   core1_0.RenderPass
+	framebuffers []core1_0.Framebuffer
+	clearValues  []core1_0.ClearValue
+	extent       core1_0.Extent2D
+}
+
+// ColorAttachment renders to an image. A nil clear value keeps what earlier passes rendered
+// to the image.
+func (p *renderGraphPass) ColorAttachment(image graphImage, clear core1_0.ClearValue) *renderGraphPass {
+	p.images = append(p.images, graphImageUse{
+		Image:      image,
+		State:      layoutState(core1_0.ImageLayoutColorAttachmentOptimal),
+		Attachment: graphAttachmentColor,
+		Clear:      clear,
+	})
+	return p
+}
+
+// ResolveAttachment resolves the multisampled color attachment with the same index into
+// an image
+func (p *renderGraphPass) ResolveAttachment(image graphImage) *renderGraphPass {
+	p.images = append(p.images, graphImageUse{
+		Image:      image,
+		State:      layoutState(core1_0.ImageLayoutColorAttachmentOptimal),
+		Attachment: graphAttachmentResolve,
+	})
+	return p
+}
+
+// DepthAttachment uses an image as the depth/stencil attachment
+func (p *renderGraphPass) DepthAttachment(image graphImage, clear core1_0.ClearValue) *renderGraphPass {
+	p.images = append(p.images, graphImageUse{
+		Image:      image,
+		State:      layoutState(core1_0.ImageLayoutDepthStencilAttachmentOptimal),
+		Attachment: graphAttachmentDepth,
+		Clear:      clear,
+	})
+	return p
+}
+
+// SampledImage reads an image from the fragment shader
+func (p *renderGraphPass) SampledImage(image graphImage) *renderGraphPass {
+	return p.UseImage(image, layoutState(core1_0.ImageLayoutShaderReadOnlyOptimal))
+}
+
+// UseImage uses an image outside of an attachment, such as for a copy or from a compute
+// shader. The pass writes to the image if the state has any write access.
+func (p *renderGraphPass) UseImage(image graphImage, state imageState) *renderGraphPass {
+	p.images = append(p.images, graphImageUse{Image: image, State: state})
+	return p
+}
+
+// UseBuffer reads or writes a buffer with the given access and stages
+func (p *renderGraphPass) UseBuffer(buffer graphBuffer, access core1_0.AccessFlags, stage core1_0.PipelineStageFlags) *renderGraphPass {
+	p.buffers = append(p.buffers, graphBufferUse{Buffer: buffer, Access: access, Stage: stage})
+	return p
+}
+
+// NeverCull keeps a pass even when nothing reads what it writes, for passes that have
+// effects the graph can't see
+func (p *renderGraphPass) NeverCull() *renderGraphPass {
+	p.neverCull = true
+	return p
+}
+
+// Execute sets the function that records the pass's commands. frame is the index of the
+// imported images to use, which is the swapchain image index.
+func (p *renderGraphPass) Execute(execute func(buffer core1_0.CommandBuffer, frame int) error) *renderGraphPass {
+	p.execute = execute
+	return p
+}
+
+// RenderPass returns the render pass created for this pass by Compile, or nil if the pass
+// doesn't use any attachments
+func (p *renderGraphPass) RenderPass() core1_0.RenderPass {
+	return p.renderPass
+}
+
+func (p *renderGraphPass) writes(use graphImageUse) bool {
+	return use.Attachment != graphAttachmentNone || use.State.Access&writeAccesses != 0
+}
+
+// renderGraph orders a set of passes by the resources they use, culls passes whose output
+// is never used, creates the images that only live within a frame and records the barriers
+// and layout transitions between passes. Images whose lifetimes don't overlap share memory.
+type renderGraph struct {
+	device         core1_0.Device
+	debug          *debugUtils
+	profiler       *gpuProfiler
+	findMemoryType func(typeFilter uint32, properties core1_0.MemoryPropertyFlags) (int, error)
+	extent         core1_0.Extent2D
+
+	images  []*renderGraphImage
+	buffers []*renderGraphBuffer
+	passes  []*renderGraphPass
+
+	order    []*renderGraphPass
+	frames   int
+	memories []core1_0.DeviceMemory
+}
+
+func newRenderGraph(device core1_0.Device, debug *debugUtils, profiler *gpuProfiler, extent core1_0.Extent2D, findMemoryType func(typeFilter uint32, properties core1_0.MemoryPropertyFlags) (int, error)) *renderGraph {
+	return &renderGraph{
+		device:         device,
+		debug:          debug,
+		profiler:       profiler,
+		extent:         extent,
+		findMemoryType: findMemoryType,
+	}
+}
+
+// CreateImage declares an image that the graph creates when it's compiled
+func (g *renderGraph) CreateImage(name string, desc graphImageDesc) graphImage {
+	if desc.Width == 0 || desc.Height == 0 {
+		desc.Width = g.extent.Width
+		desc.Height = g.extent.Height
+	}
+	if desc.Samples == 0 {
+		desc.Samples = core1_0.Samples1
+	}
+
+	g.images = append(g.images, &renderGraphImage{Name: name, Desc: desc})
+	return graphImage(len(g.images) - 1)
+}
+
+// ImportImage declares an image created outside of the graph
+func (g *renderGraph) ImportImage(name string, imported graphImport) graphImage {
+	if imported.Width == 0 || imported.Height == 0 {
+		imported.Width = g.extent.Width
+		imported.Height = g.extent.Height
+	}
+	if imported.Samples == 0 {
+		imported.Samples = core1_0.Samples1
+	}
+
+	g.images = append(g.images, &renderGraphImage{
+		Name:     name,
+		Imported: &imported,
+		Desc: graphImageDesc{
+			Format:  imported.Format,
+			Samples: imported.Samples,
+			Width:   imported.Width,
+			Height:  imported.Height,
+		},
+	})
+	return graphImage(len(g.images) - 1)
+}
+
+// ImportBuffer declares a buffer created outside of the graph
+func (g *renderGraph) ImportBuffer(name string, buffer core1_0.Buffer) graphBuffer {
+	g.buffers = append(g.buffers, &renderGraphBuffer{Name: name, Buffer: buffer})
+	return graphBuffer(len(g.buffers) - 1)
+}
+
+// AddPass declares a new pass. The resources it uses are declared on the returned pass.
+func (g *renderGraph) AddPass(name string) *renderGraphPass {
+	pass := &renderGraphPass{Name: name}
+	g.passes = append(g.passes, pass)
+	return pass
+}
+
+// View returns the image view of an image for a frame, for passes that need to bind
+// it to a descriptor set
+func (g *renderGraph) View(image graphImage, frame int) core1_0.ImageView {
+	resource := g.images[image]
+	if resource.Imported != nil {
+		return resource.Imported.Views[min(frame, len(resource.Imported.Views)-1)]
+	}
+	return resource.view
+}
+
+func (g *renderGraph) imageForFrame(image graphImage, frame int) core1_0.Image {
+	resource := g.images[image]
+	if resource.Imported != nil {
+		return resource.Imported.Images[min(frame, len(resource.Imported.Images)-1)]
+	}
+	return resource.image
+}
+
+// Compile orders and culls the passes and creates every image, render pass and framebuffer
+// the graph needs. It must be called once, after every pass has been declared.
+func (g *renderGraph) Compile() error {
+	err := g.validate()
+	if err != nil {
+		return err
+	}
+
+	err = g.orderPasses()
+	if err != nil {
+		return err
+	}
+
+	g.computeLifetimes()
+
+	err = g.createImages()
+	if err != nil {
+		return err
+	}
+
+	for _, pass := range g.order {
+		err = g.createRenderPass(pass)
+		if err != nil {
+			return errors.Wrapf(err, "renderGraph: pass '%s'", pass.Name)
+		}
+	}
+
+	return nil
+}
+
+func (g *renderGraph) validate() error {
+	g.frames = 1
+	for _, image := range g.images {
+		if image.Imported == nil {
+			continue
+		}
+		if len(image.Imported.Images) == 0 || len(image.Imported.Images) != len(image.Imported.Views) {
+			return errors.Errorf("renderGraph: imported image '%s' needs one view for each of its images", image.Name)
+		}
+
+		if len(image.Imported.Images) > 1 {
+			if g.frames > 1 && g.frames != len(image.Imported.Images) {
+				return errors.Errorf("renderGraph: imported image '%s' has %d frames, but another image has %d", image.Name, len(image.Imported.Images), g.frames)
+			}
+			g.frames = len(image.Imported.Images)
+		}
+	}
+
+	for _, pass := range g.passes {
+		if pass.execute == nil {
+			return errors.Errorf("renderGraph: pass '%s' has no Execute function", pass.Name)
+		}
+
+		var colors, resolves, depths int
+		for _, use := range pass.images {
+			if use.Image < 0 || int(use.Image) >= len(g.images) {
+				return errors.Errorf("renderGraph: pass '%s' uses an unknown image", pass.Name)
+			}
+
+			switch use.Attachment {
+			case graphAttachmentColor:
+				colors++
+			case graphAttachmentResolve:
+				resolves++
+				if g.images[use.Image].Desc.Samples != core1_0.Samples1 {
+					return errors.Errorf("renderGraph: pass '%s' resolves into multisampled image '%s'", pass.Name, g.images[use.Image].Name)
+				}
+			case graphAttachmentDepth:
+				depths++
+			}
+		}
+
+		if resolves > 0 && resolves != colors {
+			return errors.Errorf("renderGraph: pass '%s' has %d color attachments but %d resolve attachments", pass.Name, colors, resolves)
+		}
+		if depths > 1 {
+			return errors.Errorf("renderGraph: pass '%s' has more than one depth attachment", pass.Name)
+		}
+
+		for _, use := range pass.buffers {
+			if use.Buffer < 0 || int(use.Buffer) >= len(g.buffers) {
+				return errors.Errorf("renderGraph: pass '%s' uses an unknown buffer", pass.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// graphResource identifies an image or buffer, so that both can be handled by orderPasses
+type graphResource struct {
+	Buffer bool
+	Index  int
+}
+
+// orderPasses sorts the passes so that every pass runs after the passes that write what it
+// reads, and drops passes that don't contribute to an imported resource. Passes that don't
+// depend on each other keep the order they were declared in.
+func (g *renderGraph) orderPasses() error {
+	writers := make(map[graphResource][]int)
+	readers := make(map[graphResource][]int)
+
+	type passUse struct {
+		Resource graphResource
+		Write    bool
+	}
+	uses := make([][]passUse, len(g.passes))
+	for i, pass := range g.passes {
+		for _, use := range pass.images {
+			uses[i] = append(uses[i], passUse{graphResource{Index: int(use.Image)}, pass.writes(use)})
+		}
+		for _, use := range pass.buffers {
+			uses[i] = append(uses[i], passUse{graphResource{Buffer: true, Index: int(use.Buffer)}, use.Access&writeAccesses != 0})
+		}
+
+		for _, use := range uses[i] {
+			if use.Write {
+				writers[use.Resource] = append(writers[use.Resource], i)
+			} else {
+				readers[use.Resource] = append(readers[use.Resource], i)
+			}
+		}
+	}
+
+	// A read depends on the writes declared before it, or every write if there are none,
+	// so consumers may be declared before their producers. Writes stay in declaration order
+	// with the other passes using the same resource.
+	dependencies := make([]map[int]bool, len(g.passes))
+	for i := range g.passes {
+		dependencies[i] = make(map[int]bool)
+
+		for _, use := range uses[i] {
+			var earlierWriters []int
+			for _, writer := range writers[use.Resource] {
+				if writer < i {
+					earlierWriters = append(earlierWriters, writer)
+				}
+			}
+
+			if use.Write {
+				for _, writer := range earlierWriters {
+					dependencies[i][writer] = true
+				}
+				// Earlier readers of an earlier write have to finish before it is overwritten.
+				// Readers declared before any writer read this pass's output instead.
+				for _, reader := range readers[use.Resource] {
+					if reader < i && len(writers[use.Resource]) > 0 && writers[use.Resource][0] < reader {
+						dependencies[i][reader] = true
+					}
+				}
+				continue
+			}
+
+			if len(earlierWriters) == 0 {
+				earlierWriters = writers[use.Resource]
+			}
+			for _, writer := range earlierWriters {
+				if writer != i {
+					dependencies[i][writer] = true
+				}
+			}
+		}
+	}
+
+	// Walk back from the passes that write imported resources to find the ones that matter
+	live := make([]bool, len(g.passes))
+	var visit func(int)
+	visit = func(i int) {
+		if live[i] {
+			return
+		}
+		live[i] = true
+		for dependency := range dependencies[i] {
+			visit(dependency)
+		}
+	}
+	for i, pass := range g.passes {
+		external := pass.neverCull
+		for _, use := range uses[i] {
+			if use.Write && (use.Resource.Buffer || g.images[use.Resource.Index].Imported != nil) {
+				external = true
+			}
+		}
+		if external {
+			visit(i)
+		}
+	}
+
+	// Kahn's algorithm, always taking the earliest declared pass that is ready
+	remaining := make([]int, len(g.passes))
+	dependents := make([][]int, len(g.passes))
+	for i := range g.passes {
+		if !live[i] {
+			continue
+		}
+		for dependency := range dependencies[i] {
+			remaining[i]++
+			dependents[dependency] = append(dependents[dependency], i)
+		}
+	}
+
+	var ready []int
+	liveCount := 0
+	for i := range g.passes {
+		if live[i] {
+			liveCount++
+			if remaining[i] == 0 {
+				ready = append(ready, i)
+			}
+		}
+	}
+
+	g.order = g.order[:0]
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		next := ready[0]
+		ready = ready[1:]
+
+		g.order = append(g.order, g.passes[next])
+		for _, dependent := range dependents[next] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+
+	if len(g.order) != liveCount {
+		return errors.New("renderGraph: passes have a circular dependency")
+	}
+	return nil
+}
+
+func imageUsageForState(use graphImageUse) core1_0.ImageUsageFlags {
+	switch use.Attachment {
+	case graphAttachmentColor, graphAttachmentResolve:
+		return core1_0.ImageUsageColorAttachment
+	case graphAttachmentDepth:
+		return core1_0.ImageUsageDepthStencilAttachment
+	}
+
+	switch use.State.Layout {
+	case core1_0.ImageLayoutShaderReadOnlyOptimal:
+		return core1_0.ImageUsageSampled
+	case core1_0.ImageLayoutTransferSrcOptimal:
+		return core1_0.ImageUsageTransferSrc
+	case core1_0.ImageLayoutTransferDstOptimal:
+		return core1_0.ImageUsageTransferDst
+	case core1_0.ImageLayoutColorAttachmentOptimal:
+		return core1_0.ImageUsageColorAttachment
+	case core1_0.ImageLayoutDepthStencilAttachmentOptimal, core1_0.ImageLayoutDepthStencilReadOnlyOptimal:
+		return core1_0.ImageUsageDepthStencilAttachment
+	}
+	return core1_0.ImageUsageStorage
+}
+
+// computeLifetimes finds the first and last pass using each image, and the usage flags
+// the images that the graph creates need
+func (g *renderGraph) computeLifetimes() {
+	for _, image := range g.images {
+		image.usage = 0
+		image.firstUse = -1
+		image.lastUse = -1
+	}
+
+	for position, pass := range g.order {
+		for _, use := range pass.images {
+			image := g.images[use.Image]
+			image.usage |= imageUsageForState(use)
+			if image.firstUse < 0 {
+				image.firstUse = position
+			}
+			image.lastUse = position
+			image.lastState = use.State
+		}
+	}
+
+	for _, image := range g.images {
+		attachmentOnly := image.usage&^(core1_0.ImageUsageColorAttachment|core1_0.ImageUsageDepthStencilAttachment) == 0
+		if image.Imported == nil && attachmentOnly && image.firstUse == image.lastUse {
+			// Images that never leave a single render pass may never need to be written to memory
+			image.usage |= core1_0.ImageUsageTransientAttachment
+		}
+	}
+}
+
+type graphMemorySlot struct {
+	TypeBits  uint32
+	Size      int
+	Alignment int
+	LastUse   int
+	Images    []*renderGraphImage
+}
+
+// createImages creates the images the graph owns, and packs them into as few allocations as
+// it can. An image can reuse the memory of one whose last use comes before its first use.
+func (g *renderGraph) createImages() error {
+	var created []*renderGraphImage
+	for _, image := range g.images {
+		if image.Imported != nil || image.firstUse < 0 {
+			continue
+		}
+
+		var err error
+		image.image, _, err = g.device.CreateImage(nil, core1_0.ImageCreateInfo{
+			ImageType: core1_0.ImageType2D,
+			Extent: core1_0.Extent3D{
+				Width:  image.Desc.Width,
+				Height: image.Desc.Height,
+				Depth:  1,
+			},
+			MipLevels:     1,
+			ArrayLayers:   1,
+			Format:        image.Desc.Format,
+			Tiling:        core1_0.ImageTilingOptimal,
+			InitialLayout: core1_0.ImageLayoutUndefined,
+			Usage:         image.usage,
+			SharingMode:   core1_0.SharingModeExclusive,
+			Samples:       image.Desc.Samples,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "renderGraph: image '%s'", image.Name)
+		}
+		g.debug.Name(image.image, image.Name)
+		created = append(created, image)
+	}
+
+	sort.SliceStable(created, func(i, j int) bool {
+		return created[i].firstUse < created[j].firstUse
+	})
+
+	var slots []*graphMemorySlot
+	for _, image := range created {
+		requirements := image.image.MemoryRequirements()
+
+		var slot *graphMemorySlot
+		for _, candidate := range slots {
+			if candidate.LastUse < image.firstUse && candidate.TypeBits&requirements.MemoryTypeBits != 0 {
+				slot = candidate
+				break
+			}
+		}
+		if slot == nil {
+			slot = &graphMemorySlot{TypeBits: requirements.MemoryTypeBits}
+			slots = append(slots, slot)
+		}
+
+		slot.TypeBits &= requirements.MemoryTypeBits
+		slot.Size = max(slot.Size, requirements.Size)
+		slot.Alignment = max(slot.Alignment, requirements.Alignment)
+		slot.LastUse = image.lastUse
+		slot.Images = append(slot.Images, image)
+	}
+
+	for _, slot := range slots {
+		memoryType, err := g.findMemoryType(slot.TypeBits, core1_0.MemoryPropertyDeviceLocal)
+		if err != nil {
+			return err
+		}
+
+		memory, _, err := g.device.AllocateMemory(nil, core1_0.MemoryAllocateInfo{
+			AllocationSize:  slot.Size,
+			MemoryTypeIndex: memoryType,
+		})
+		if err != nil {
+			return err
+		}
+		g.memories = append(g.memories, memory)
+		g.debug.Name(memory, fmt.Sprintf("render graph memory %d", len(g.memories)-1))
+
+		// Every frame starts each image from the undefined layout, but the first barrier
+		// still has to wait for the last use of the memory in the frame before, by this
+		// image or any other that shares the memory
+		var frameStart imageState
+		frameStart.Layout = core1_0.ImageLayoutUndefined
+		frameStart.QueueFamily = queueFamilyIgnored
+		for _, image := range slot.Images {
+			frameStart.Stage |= image.lastState.Stage
+			frameStart.Access |= image.lastState.Access & writeAccesses
+		}
+
+		for i, image := range slot.Images {
+			image.frameStart = frameStart
+			if i > 0 {
+				// Within a frame, the image has to wait for the one that used the memory before it
+				previous := slot.Images[i-1]
+				image.frameStart.Stage |= previous.lastState.Stage
+				image.frameStart.Access |= previous.lastState.Access & writeAccesses
+			}
+
+			_, err = image.image.BindImageMemory(memory, 0)
+			if err != nil {
+				return err
+			}
+
+			image.view, _, err = g.device.CreateImageView(nil, core1_0.ImageViewCreateInfo{
+				Image:    image.image,
+				ViewType: core1_0.ImageViewType2D,
+				Format:   image.Desc.Format,
+				SubresourceRange: core1_0.ImageSubresourceRange{
+					AspectMask: imageAspect(image.Desc.Format),
+					LevelCount: 1,
+					LayerCount: 1,
+				},
+			})
+			if err != nil {
+				return err
+			}
+			g.debug.Name(image.view, image.Name+" view")
+		}
+	}
+
+	return nil
+}
+
+// attachmentOps decides whether an attachment is cleared, loaded or discarded at the start of
+// a pass, and whether it is stored at the end of it
+func (g *renderGraph) attachmentOps(position int, use graphImageUse) (core1_0.AttachmentLoadOp, core1_0.AttachmentStoreOp) {
+	image := g.images[use.Image]
+
+	loadOp := core1_0.AttachmentLoadOpDontCare
+	if use.Clear != nil {
+		loadOp = core1_0.AttachmentLoadOpClear
+	} else if use.Attachment != graphAttachmentResolve &&
+		(image.firstUse < position || (image.Imported != nil && image.Imported.Initial.Layout != core1_0.ImageLayoutUndefined)) {
+		loadOp = core1_0.AttachmentLoadOpLoad
+	}
+
+	storeOp := core1_0.AttachmentStoreOpDontCare
+	if image.lastUse > position || image.Imported != nil {
+		storeOp = core1_0.AttachmentStoreOpStore
+	}
+
+	return loadOp, storeOp
+}
+
+func (g *renderGraph) createRenderPass(pass *renderGraphPass) error {
+	position := -1
+	for i, ordered := range g.order {
+		if ordered == pass {
+			position = i
+		}
+	}
+
+	var attachments []core1_0.AttachmentDescription
+	var colors, resolves []core1_0.AttachmentReference
+	var depth *core1_0.AttachmentReference
+	var attachmentImages []graphImage
+
+	for _, use := range pass.images {
+		if use.Attachment == graphAttachmentNone {
+			continue
+		}
+
+		image := g.images[use.Image]
+		if len(attachmentImages) == 0 {
+			pass.extent = core1_0.Extent2D{Width: image.Desc.Width, Height: image.Desc.Height}
+		} else if pass.extent.Width != image.Desc.Width || pass.extent.Height != image.Desc.Height {
+			return errors.Errorf("attachment '%s' is %dx%d, but the pass is %dx%d", image.Name,
+				image.Desc.Width, image.Desc.Height, pass.extent.Width, pass.extent.Height)
+		}
+
+		loadOp, storeOp := g.attachmentOps(position, use)
+		stencilLoadOp, stencilStoreOp := core1_0.AttachmentLoadOpDontCare, core1_0.AttachmentStoreOpDontCare
+		if imageAspect(image.Desc.Format)&core1_0.ImageAspectStencil != 0 {
+			stencilLoadOp, stencilStoreOp = loadOp, storeOp
+		}
+
+		// The graph records every layout transition itself, so attachments stay in the
+		// layout they're used in for the whole render pass
+		reference := core1_0.AttachmentReference{Attachment: len(attachments), Layout: use.State.Layout}
+		attachments = append(attachments, core1_0.AttachmentDescription{
+			Format:         image.Desc.Format,
+			Samples:        image.Desc.Samples,
+			LoadOp:         loadOp,
+			StoreOp:        storeOp,
+			StencilLoadOp:  stencilLoadOp,
+			StencilStoreOp: stencilStoreOp,
+			InitialLayout:  use.State.Layout,
+			FinalLayout:    use.State.Layout,
+		})
+		attachmentImages = append(attachmentImages, use.Image)
+
+		clear := use.Clear
+		if clear == nil {
+			clear = core1_0.ClearValueFloat{0, 0, 0, 0}
+		}
+		pass.clearValues = append(pass.clearValues, clear)
+
+		switch use.Attachment {
+		case graphAttachmentColor:
+			colors = append(colors, reference)
+		case graphAttachmentResolve:
+			resolves = append(resolves, reference)
+		case graphAttachmentDepth:
+			depth = &reference
+		}
+	}
+
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	var err error
+	pass.renderPass, _, err = g.device.CreateRenderPass(nil, core1_0.RenderPassCreateInfo{
+		Attachments: attachments,
+		Subpasses: []core1_0.SubpassDescription{
+			{
+				PipelineBindPoint:      core1_0.PipelineBindPointGraphics,
+				ColorAttachments:       colors,
+				ResolveAttachments:     resolves,
+				DepthStencilAttachment: depth,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	g.debug.Name(pass.renderPass, pass.Name+" render pass")
+
+	for frame := 0; frame < g.frames; frame++ {
+		views := make([]core1_0.ImageView, 0, len(attachmentImages))
+		for _, image := range attachmentImages {
+			views = append(views, g.View(image, frame))
+		}
+
+		framebuffer, _, err := g.device.CreateFramebuffer(nil, core1_0.FramebufferCreateInfo{
+			RenderPass:  pass.renderPass,
+			Layers:      1,
+			Attachments: views,
+			Width:       pass.extent.Width,
+			Height:      pass.extent.Height,
+		})
+		if err != nil {
+			return err
+		}
+		g.debug.Name(framebuffer, fmt.Sprintf("%s framebuffer %d", pass.Name, frame))
+		pass.framebuffers = append(pass.framebuffers, framebuffer)
+	}
+
+	return nil
+}
+
+type graphBufferState struct {
+	WriteAccess core1_0.AccessFlags
+	WriteStage  core1_0.PipelineStageFlags
+	ReadStage   core1_0.PipelineStageFlags
+}
+
+// Record records every pass for a frame into a command buffer, with the barriers between them
+func (g *renderGraph) Record(buffer core1_0.CommandBuffer, frame int) error {
+	var states imageStateTracker
+	for index, image := range g.images {
+		if image.firstUse < 0 {
+			continue
+		}
+
+		vkImage := g.imageForFrame(graphImage(index), frame)
+		states.Track(vkImage, image.Desc.Format, 1, 1)
+
+		start := image.frameStart
+		if image.Imported != nil {
+			start = image.Imported.Initial
+		}
+		err := states.Assume(vkImage, states.WholeImage(vkImage), start)
+		if err != nil {
+			return err
+		}
+	}
+
+	bufferStates := make([]graphBufferState, len(g.buffers))
+
+	for _, pass := range g.order {
+		g.debug.BeginLabel(buffer, pass.Name, passLabelColor)
+		g.profiler.BeginScope(buffer, frame, pass.Name)
+		err := g.recordPass(buffer, frame, pass, &states, bufferStates)
+		if err != nil {
+			return err
+		}
+		g.profiler.EndScope(buffer, frame)
+		g.debug.EndLabel(buffer)
+	}
+
+	for index, image := range g.images {
+		if image.Imported == nil || image.firstUse < 0 {
+			continue
+		}
+
+		vkImage := g.imageForFrame(graphImage(index), frame)
+		err := states.Transition(buffer, vkImage, states.WholeImage(vkImage), image.Imported.Final)
+		if err != nil {
+			return errors.Wrapf(err, "renderGraph: image '%s'", image.Name)
+		}
+	}
+
+	return nil
+}
+
+func (g *renderGraph) recordPass(buffer core1_0.CommandBuffer, frame int, pass *renderGraphPass, states *imageStateTracker, bufferStates []graphBufferState) error {
+	for _, use := range pass.images {
+		vkImage := g.imageForFrame(use.Image, frame)
+		err := states.Transition(buffer, vkImage, states.WholeImage(vkImage), use.State)
+		if err != nil {
+			return errors.Wrapf(err, "renderGraph: pass '%s'", pass.Name)
+		}
+	}
+
+	err := g.recordBufferBarriers(buffer, pass, bufferStates)
+	if err != nil {
+		return errors.Wrapf(err, "renderGraph: pass '%s'", pass.Name)
+	}
+
+	if pass.renderPass == nil {
+		return pass.execute(buffer, frame)
+	}
+
+	err = buffer.CmdBeginRenderPass(core1_0.SubpassContentsInline, core1_0.RenderPassBeginInfo{
+		RenderPass:  pass.renderPass,
+		Framebuffer: pass.framebuffers[frame],
+		RenderArea: core1_0.Rect2D{
+			Offset: core1_0.Offset2D{X: 0, Y: 0},
+			Extent: pass.extent,
+		},
+		ClearValues: pass.clearValues,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = pass.execute(buffer, frame)
+	if err != nil {
+		return err
+	}
+	buffer.CmdEndRenderPass()
+	return nil
+}
+
+func (g *renderGraph) recordBufferBarriers(buffer core1_0.CommandBuffer, pass *renderGraphPass, bufferStates []graphBufferState) error {
+	var barriers []core1_0.BufferMemoryBarrier
+	var srcStage, dstStage core1_0.PipelineStageFlags
+
+	for _, use := range pass.buffers {
+		state := &bufferStates[use.Buffer]
+		write := use.Access&writeAccesses != 0
+
+		if state.WriteStage != 0 {
+			// Read or write after write makes the earlier write visible
+			srcStage |= state.WriteStage
+			dstStage |= use.Stage
+			barriers = append(barriers, core1_0.BufferMemoryBarrier{
+				SrcAccessMask:       state.WriteAccess,
+				DstAccessMask:       use.Access,
+				SrcQueueFamilyIndex: queueFamilyIgnored,
+				DstQueueFamilyIndex: queueFamilyIgnored,
+				Buffer:              g.buffers[use.Buffer].Buffer,
+				Offset:              0,
+				Size:                common.WholeSize,
+			})
+		}
+		if write && state.ReadStage != 0 {
+			// Write after read only needs the reads to finish first
+			srcStage |= state.ReadStage
+			dstStage |= use.Stage
+		}
+
+		if write {
+			*state = graphBufferState{WriteAccess: use.Access & writeAccesses, WriteStage: use.Stage}
+		} else {
+			state.ReadStage |= use.Stage
+		}
+	}
+
+	if srcStage == 0 {
+		return nil
+	}
+	return buffer.CmdPipelineBarrier(srcStage, dstStage, 0, nil, barriers, nil)
+}
+
+// Destroy destroys everything the graph created. Imported resources are left alone.
+func (g *renderGraph) Destroy() {
+	for _, pass := range g.passes {
+		for _, framebuffer := range pass.framebuffers {
+			framebuffer.Destroy(nil)
+		}
+		pass.framebuffers = nil
+
+		if pass.renderPass != nil {
+			pass.renderPass.Destroy(nil)
+			pass.renderPass = nil
+		}
+	}
+
+	for _, image := range g.images {
+		if image.view != nil {
+			image.view.Destroy(nil)
+			image.view = nil
+		}
+		if image.image != nil {
+			image.image.Destroy(nil)
+			image.image = nil
+		}
+	}
+
+	for _, memory := range g.memories {
+		memory.Free(nil)
+	}
+	g.memories = nil
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/vkngwrapper/core/v2/common"
+	"github.com/vkngwrapper/core/v2/core1_0"
+	"github.com/vkngwrapper/core/v2/driver"
+)
+
+// graphTestDevice creates fake images and memory, with the memory types each image format
+// can use set by memoryTypeBits
+type graphTestDevice struct {
+	core1_0.Device
+	memoryTypeBits map[core1_0.Format]uint32
+	allocations    []*graphTestMemory
+}
+
+func (d *graphTestDevice) CreateImage(allocationCallbacks *driver.AllocationCallbacks, options core1_0.ImageCreateInfo) (core1_0.Image, common.VkResult, error) {
+	typeBits, ok := d.memoryTypeBits[options.Format]
+	if !ok {
+		typeBits = 0xff
+	}
+	return &graphTestImage{info: options, typeBits: typeBits}, core1_0.VKSuccess, nil
+}
+
+func (d *graphTestDevice) AllocateMemory(allocationCallbacks *driver.AllocationCallbacks, options core1_0.MemoryAllocateInfo) (core1_0.DeviceMemory, common.VkResult, error) {
+	memory := &graphTestMemory{size: options.AllocationSize}
+	d.allocations = append(d.allocations, memory)
+	return memory, core1_0.VKSuccess, nil
+}
+
+func (d *graphTestDevice) CreateImageView(allocationCallbacks *driver.AllocationCallbacks, options core1_0.ImageViewCreateInfo) (core1_0.ImageView, common.VkResult, error) {
+	return &graphTestView{}, core1_0.VKSuccess, nil
+}
+
+type graphTestImage struct {
+	core1_0.Image
+	info     core1_0.ImageCreateInfo
+	typeBits uint32
+	memory   core1_0.DeviceMemory
+}
+
+func (i *graphTestImage) MemoryRequirements() *core1_0.MemoryRequirements {
+	return &core1_0.MemoryRequirements{
+		Size:           i.info.Extent.Width * i.info.Extent.Height * 4,
+		Alignment:      256,
+		MemoryTypeBits: i.typeBits,
+	}
+}
+
+func (i *graphTestImage) BindImageMemory(memory core1_0.DeviceMemory, offset int) (common.VkResult, error) {
+	i.memory = memory
+	return core1_0.VKSuccess, nil
+}
+
+type graphTestMemory struct {
+	core1_0.DeviceMemory
+	size int
+}
+
+type graphTestView struct {
+	core1_0.ImageView
+}
+
+type testBuffer struct {
+	core1_0.Buffer
+	name string
+}
+
+func noCommands(buffer core1_0.CommandBuffer, frame int) error {
+	return nil
+}
+
+// newTestGraph returns a graph with a 64x64 extent and an imported swapchain image
+func newTestGraph(device core1_0.Device) (*renderGraph, graphImage) {
+	graph := newRenderGraph(device, nil, nil, core1_0.Extent2D{Width: 64, Height: 64},
+		func(typeFilter uint32, properties core1_0.MemoryPropertyFlags) (int, error) {
+			return 0, nil
+		})
+	swapchain := graph.ImportImage("swapchain", graphImport{
+		Format:  core1_0.FormatB8G8R8A8SRGB,
+		Images:  []core1_0.Image{&testImage{name: "swapchain"}},
+		Views:   []core1_0.ImageView{&graphTestView{}},
+		Initial: layoutState(core1_0.ImageLayoutUndefined),
+		Final:   layoutState(core1_0.ImageLayoutTransferSrcOptimal),
+	})
+	return graph, swapchain
+}
+
+func passNames(passes []*renderGraphPass) []string {
+	var names []string
+	for _, pass := range passes {
+		names = append(names, pass.Name)
+	}
+	return names
+}
+
+func TestRenderGraphOrder(t *testing.T) {
+	write := layoutState(core1_0.ImageLayoutTransferDstOptimal)
+	read := layoutState(core1_0.ImageLayoutTransferSrcOptimal)
+
+	testCases := []struct {
+		name string
+		// declare adds passes to a graph whose swapchain image counts as output
+		declare   func(graph *renderGraph, swapchain graphImage)
+		wantOrder []string
+		wantErr   bool
+	}{
+		{
+			name: "DeclarationOrder",
+			declare: func(graph *renderGraph, swapchain graphImage) {
+				color := graph.CreateImage("color", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				graph.AddPass("draw").UseImage(color, write).Execute(noCommands)
+				graph.AddPass("copy").UseImage(color, read).UseImage(swapchain, write).Execute(noCommands)
+			},
+			wantOrder: []string{"draw", "copy"},
+		},
+		{
+			// A read with no writer declared before it reads whatever is written later
+			name: "ConsumerBeforeProducer",
+			declare: func(graph *renderGraph, swapchain graphImage) {
+				color := graph.CreateImage("color", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				graph.AddPass("copy").UseImage(color, read).UseImage(swapchain, write).Execute(noCommands)
+				graph.AddPass("draw").UseImage(color, write).Execute(noCommands)
+			},
+			wantOrder: []string{"draw", "copy"},
+		},
+		{
+			// overwrite must wait for the read of draw's output, even though the read can't
+			// run until the later mask pass does
+			name: "WriteAfterRead",
+			declare: func(graph *renderGraph, swapchain graphImage) {
+				color := graph.CreateImage("color", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				mask := graph.CreateImage("mask", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				output := graph.ImportBuffer("output", &testBuffer{name: "output"})
+				graph.AddPass("draw").UseImage(color, write).Execute(noCommands)
+				graph.AddPass("copy").UseImage(color, read).UseImage(mask, read).UseImage(swapchain, write).Execute(noCommands)
+				graph.AddPass("overwrite").UseImage(color, write).
+					UseBuffer(output, core1_0.AccessTransferWrite, core1_0.PipelineStageTransfer).Execute(noCommands)
+				graph.AddPass("mask").UseImage(mask, write).Execute(noCommands)
+			},
+			wantOrder: []string{"draw", "mask", "copy", "overwrite"},
+		},
+		{
+			name: "BufferDependency",
+			declare: func(graph *renderGraph, swapchain graphImage) {
+				vertices := graph.ImportBuffer("vertices", &testBuffer{name: "vertices"})
+				graph.AddPass("draw").UseBuffer(vertices, core1_0.AccessVertexAttributeRead, core1_0.PipelineStageVertexInput).
+					UseImage(swapchain, write).Execute(noCommands)
+				graph.AddPass("upload").UseBuffer(vertices, core1_0.AccessTransferWrite, core1_0.PipelineStageTransfer).Execute(noCommands)
+			},
+			wantOrder: []string{"upload", "draw"},
+		},
+		{
+			// Nothing reads unused, so the pass that writes it is dropped unless it's marked
+			// as having effects the graph can't see
+			name: "Culled",
+			declare: func(graph *renderGraph, swapchain graphImage) {
+				unused := graph.CreateImage("unused", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				debug := graph.CreateImage("debug", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				graph.AddPass("unused").UseImage(unused, write).Execute(noCommands)
+				graph.AddPass("debug").UseImage(debug, write).NeverCull().Execute(noCommands)
+				graph.AddPass("draw").UseImage(swapchain, write).Execute(noCommands)
+			},
+			wantOrder: []string{"debug", "draw"},
+		},
+		{
+			// Culled passes don't hold up the passes that are kept
+			name: "CulledCycle",
+			declare: func(graph *renderGraph, swapchain graphImage) {
+				first := graph.CreateImage("first", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				second := graph.CreateImage("second", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				graph.AddPass("first").UseImage(second, read).UseImage(first, write).Execute(noCommands)
+				graph.AddPass("second").UseImage(first, read).UseImage(second, write).Execute(noCommands)
+				graph.AddPass("draw").UseImage(swapchain, write).Execute(noCommands)
+			},
+			wantOrder: []string{"draw"},
+		},
+		{
+			name: "CircularDependency",
+			declare: func(graph *renderGraph, swapchain graphImage) {
+				first := graph.CreateImage("first", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				second := graph.CreateImage("second", graphImageDesc{Format: core1_0.FormatR8G8B8A8SRGB})
+				graph.AddPass("first").UseImage(second, read).UseImage(first, write).UseImage(swapchain, write).Execute(noCommands)
+				graph.AddPass("second").UseImage(first, read).UseImage(second, write).Execute(noCommands)
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			graph, swapchain := newTestGraph(&graphTestDevice{})
+			testCase.declare(graph, swapchain)
+
+			err := graph.Compile()
+			if testCase.wantErr {
+				if err == nil {
+					t.Errorf("Compile succeeded with the order %v, expected an error", passNames(graph.order))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Compile: %+v", err)
+			}
+
+			if got := passNames(graph.order); !slices.Equal(got, testCase.wantOrder) {
+				t.Errorf("got the order %v, expected %v", got, testCase.wantOrder)
+			}
+		})
+	}
+}
+
+func TestRenderGraphImages(t *testing.T) {
+	device := &graphTestDevice{
+		// Color and depth images can't share a memory type
+		memoryTypeBits: map[core1_0.Format]uint32{
+			core1_0.FormatR8G8B8A8UnsignedNormalized: 0x1,
+			core1_0.FormatD32SignedFloat:             0x2,
+		},
+	}
+	graph, swapchain := newTestGraph(device)
+
+	shaderRead := layoutState(core1_0.ImageLayoutShaderReadOnlyOptimal)
+	transferDst := layoutState(core1_0.ImageLayoutTransferDstOptimal)
+	transferSrc := layoutState(core1_0.ImageLayoutTransferSrcOptimal)
+	depthWrite := layoutState(core1_0.ImageLayoutDepthStencilAttachmentOptimal)
+	colorWrite := layoutState(core1_0.ImageLayoutColorAttachmentOptimal)
+
+	shadow := graph.CreateImage("shadow", graphImageDesc{Format: core1_0.FormatR8G8B8A8UnsignedNormalized})
+	albedo := graph.CreateImage("albedo", graphImageDesc{Format: core1_0.FormatR8G8B8A8UnsignedNormalized})
+	lit := graph.CreateImage("lit", graphImageDesc{Format: core1_0.FormatR8G8B8A8UnsignedNormalized})
+	depth := graph.CreateImage("depth", graphImageDesc{Format: core1_0.FormatD32SignedFloat})
+	scratch := graph.CreateImage("scratch", graphImageDesc{Format: core1_0.FormatR8G8B8A8UnsignedNormalized})
+	unused := graph.CreateImage("unused", graphImageDesc{Format: core1_0.FormatR8G8B8A8UnsignedNormalized})
+
+	graph.AddPass("shadow").UseImage(shadow, colorWrite).Execute(noCommands)
+	graph.AddPass("gbuffer").UseImage(shadow, shaderRead).UseImage(albedo, colorWrite).Execute(noCommands)
+	graph.AddPass("light").UseImage(albedo, shaderRead).UseImage(lit, transferDst).Execute(noCommands)
+	graph.AddPass("present").UseImage(lit, transferSrc).UseImage(scratch, colorWrite).UseImage(depth, depthWrite).
+		UseImage(swapchain, transferDst).Execute(noCommands)
+	graph.AddPass("unused").UseImage(unused, transferDst).Execute(noCommands)
+
+	err := graph.Compile()
+	if err != nil {
+		t.Fatalf("Compile: %+v", err)
+	}
+
+	testCases := []struct {
+		name              string
+		image             graphImage
+		firstUse, lastUse int
+		transient         bool
+		// memory is the allocation the image is bound to, in the order they were made
+		memory     int
+		frameStart imageState
+	}{
+		{
+			// shadow's memory is reused by lit, so each frame's shadow pass has to wait for the
+			// previous frame's copy out of lit
+			name: "shadow", image: shadow, firstUse: 0, lastUse: 1, memory: 0,
+			frameStart: imageState{Stage: core1_0.PipelineStageFragmentShader | core1_0.PipelineStageTransfer},
+		},
+		{
+			// albedo's memory is reused by scratch, whose color writes have to be made available
+			// before the next frame's gbuffer pass
+			name: "albedo", image: albedo, firstUse: 1, lastUse: 2, memory: 1,
+			frameStart: imageState{
+				Stage:  core1_0.PipelineStageFragmentShader | core1_0.PipelineStageColorAttachmentOutput,
+				Access: core1_0.AccessColorAttachmentWrite,
+			},
+		},
+		{
+			name: "lit", image: lit, firstUse: 2, lastUse: 3, memory: 0,
+			frameStart: imageState{Stage: core1_0.PipelineStageFragmentShader | core1_0.PipelineStageTransfer},
+		},
+		{
+			name: "scratch", image: scratch, firstUse: 3, lastUse: 3, transient: true, memory: 1,
+			frameStart: imageState{
+				Stage:  core1_0.PipelineStageFragmentShader | core1_0.PipelineStageColorAttachmentOutput,
+				Access: core1_0.AccessColorAttachmentWrite,
+			},
+		},
+		{
+			// depth could reuse albedo's memory, but no memory type suits both
+			name: "depth", image: depth, firstUse: 3, lastUse: 3, transient: true, memory: 2,
+			frameStart: imageState{
+				Stage:  core1_0.PipelineStageEarlyFragmentTests | core1_0.PipelineStageLateFragmentTests,
+				Access: core1_0.AccessDepthStencilAttachmentWrite,
+			},
+		},
+	}
+
+	if len(device.allocations) != 3 {
+		t.Fatalf("got %d allocations, expected 3", len(device.allocations))
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			image := graph.images[testCase.image]
+			if image.firstUse != testCase.firstUse || image.lastUse != testCase.lastUse {
+				t.Errorf("used from pass %d to %d, expected %d to %d", image.firstUse, image.lastUse, testCase.firstUse, testCase.lastUse)
+			}
+
+			created, ok := image.image.(*graphTestImage)
+			if !ok {
+				t.Fatal("the image wasn't created")
+			}
+			if transient := created.info.Usage&core1_0.ImageUsageTransientAttachment != 0; transient != testCase.transient {
+				t.Errorf("got transient %t with usage %s, expected %t", transient, created.info.Usage, testCase.transient)
+			}
+			if created.memory != device.allocations[testCase.memory] {
+				t.Errorf("bound to allocation %d, expected %d", slices.Index(device.allocations, created.memory.(*graphTestMemory)), testCase.memory)
+			}
+
+			want := testCase.frameStart
+			want.Layout = core1_0.ImageLayoutUndefined
+			want.QueueFamily = queueFamilyIgnored
+			if image.frameStart != want {
+				t.Errorf("each frame starts from %+v, expected %+v", image.frameStart, want)
+			}
+		})
+	}
+
+	if image := graph.images[unused]; image.image != nil || image.firstUse >= 0 {
+		t.Errorf("the image only used by a culled pass was created")
+	}
+}
+
+func TestRenderGraphBufferBarriers(t *testing.T) {
+	vertices := &testBuffer{name: "vertices"}
+	indirect := &testBuffer{name: "indirect"}
+
+	graph, _ := newTestGraph(&graphTestDevice{})
+	verticesBuffer := graph.ImportBuffer("vertices", vertices)
+	indirectBuffer := graph.ImportBuffer("indirect", indirect)
+
+	// A buffer barrier that makes a write visible to a later use
+	visible := func(buffer core1_0.Buffer, src, dst core1_0.AccessFlags) core1_0.BufferMemoryBarrier {
+		return core1_0.BufferMemoryBarrier{
+			SrcAccessMask:       src,
+			DstAccessMask:       dst,
+			SrcQueueFamilyIndex: queueFamilyIgnored,
+			DstQueueFamilyIndex: queueFamilyIgnored,
+			Buffer:              buffer,
+			Size:                common.WholeSize,
+		}
+	}
+
+	// Each pass is recorded after the ones before it, with the barriers it needs
+	passes := []struct {
+		name string
+		uses []graphBufferUse
+		want *pipelineBarrierCall
+	}{
+		{
+			name: "Upload",
+			uses: []graphBufferUse{{Buffer: verticesBuffer, Access: core1_0.AccessTransferWrite, Stage: core1_0.PipelineStageTransfer}},
+		},
+		{
+			name: "ReadAfterWrite",
+			uses: []graphBufferUse{{Buffer: verticesBuffer, Access: core1_0.AccessVertexAttributeRead, Stage: core1_0.PipelineStageVertexInput}},
+			want: &pipelineBarrierCall{
+				SrcStage:       core1_0.PipelineStageTransfer,
+				DstStage:       core1_0.PipelineStageVertexInput,
+				BufferBarriers: []core1_0.BufferMemoryBarrier{visible(vertices, core1_0.AccessTransferWrite, core1_0.AccessVertexAttributeRead)},
+			},
+		},
+		{
+			name: "SecondRead",
+			uses: []graphBufferUse{{Buffer: verticesBuffer, Access: core1_0.AccessShaderRead, Stage: core1_0.PipelineStageComputeShader}},
+			want: &pipelineBarrierCall{
+				SrcStage:       core1_0.PipelineStageTransfer,
+				DstStage:       core1_0.PipelineStageComputeShader,
+				BufferBarriers: []core1_0.BufferMemoryBarrier{visible(vertices, core1_0.AccessTransferWrite, core1_0.AccessShaderRead)},
+			},
+		},
+		{
+			// The rewrite waits for the upload and both reads of it
+			name: "WriteAfterReads",
+			uses: []graphBufferUse{{Buffer: verticesBuffer, Access: core1_0.AccessShaderWrite, Stage: core1_0.PipelineStageComputeShader}},
+			want: &pipelineBarrierCall{
+				SrcStage:       core1_0.PipelineStageTransfer | core1_0.PipelineStageVertexInput | core1_0.PipelineStageComputeShader,
+				DstStage:       core1_0.PipelineStageComputeShader,
+				BufferBarriers: []core1_0.BufferMemoryBarrier{visible(vertices, core1_0.AccessTransferWrite, core1_0.AccessShaderWrite)},
+			},
+		},
+		{
+			// The indirect buffer was never written, so reading it needs nothing
+			name: "FirstRead",
+			uses: []graphBufferUse{
+				{Buffer: verticesBuffer, Access: core1_0.AccessVertexAttributeRead, Stage: core1_0.PipelineStageVertexInput},
+				{Buffer: indirectBuffer, Access: core1_0.AccessIndirectCommandRead, Stage: core1_0.PipelineStageDrawIndirect},
+			},
+			want: &pipelineBarrierCall{
+				SrcStage:       core1_0.PipelineStageComputeShader,
+				DstStage:       core1_0.PipelineStageVertexInput,
+				BufferBarriers: []core1_0.BufferMemoryBarrier{visible(vertices, core1_0.AccessShaderWrite, core1_0.AccessVertexAttributeRead)},
+			},
+		},
+		{
+			// Writing after a read only needs an execution dependency
+			name: "WriteAfterReadOnly",
+			uses: []graphBufferUse{{Buffer: indirectBuffer, Access: core1_0.AccessTransferWrite, Stage: core1_0.PipelineStageTransfer}},
+			want: &pipelineBarrierCall{
+				SrcStage: core1_0.PipelineStageDrawIndirect,
+				DstStage: core1_0.PipelineStageTransfer,
+			},
+		},
+	}
+
+	bufferStates := make([]graphBufferState, len(graph.buffers))
+	for _, pass := range passes {
+		buffer := &barrierRecorder{}
+		err := graph.recordBufferBarriers(buffer, &renderGraphPass{Name: pass.name, buffers: pass.uses}, bufferStates)
+		if err != nil {
+			t.Fatalf("%s: %+v", pass.name, err)
+		}
+
+		if pass.want == nil {
+			if len(buffer.calls) != 0 {
+				t.Errorf("%s: recorded %d barrier calls, expected none", pass.name, len(buffer.calls))
+			}
+			continue
+		}
+		if len(buffer.calls) != 1 {
+			t.Errorf("%s: recorded %d barrier calls, expected 1", pass.name, len(buffer.calls))
+			continue
+		}
+
+		got := buffer.calls[0]
+		if got.SrcStage != pass.want.SrcStage || got.DstStage != pass.want.DstStage {
+			t.Errorf("%s: barrier goes from %s to %s, expected %s to %s", pass.name, got.SrcStage, got.DstStage, pass.want.SrcStage, pass.want.DstStage)
+		}
+		if !slices.Equal(got.BufferBarriers, pass.want.BufferBarriers) {
+			t.Errorf("%s: got the buffer barriers %+v, expected %+v", pass.name, got.BufferBarriers, pass.want.BufferBarriers)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"github.com/vkngwrapper/core/v2/core1_0"
+	"github.com/vkngwrapper/extensions/v2/khr_swapchain"
+)
+
+// retiredSwapchain holds everything built for a swapchain that has been replaced. Frames
+// submitted before it was replaced may still be using it, so it's destroyed once a frame
+// submitted after serial has finished. That frame was submitted after any readback of the
+// old swapchain's last frame, and its fence covers all earlier work on the queue.
+//
+// The presentation engine can hold on to old swapchain images a little longer, which nothing
+// in core Vulkan can wait for, but a retired swapchain never presents again and destroying
+// it is allowed once the work that rendered to it is finished.
+type retiredSwapchain struct {
+	serial uint64
+
+	device    core1_0.Device
+	pipelines *pipelineVariants
+
+	commandBuffers       []core1_0.CommandBuffer
+	renderGraph          *renderGraph
+	renderPass           core1_0.RenderPass
+	imageViews           []core1_0.ImageView
+	swapchain            khr_swapchain.Swapchain
+	uniformBuffers       []core1_0.Buffer
+	uniformBuffersMemory []core1_0.DeviceMemory
+	descriptorPool       core1_0.DescriptorPool
+}
+
+// retireSwapChain takes the swapchain and everything built for it away from the app, to be
+// destroyed later
+func (app *HelloTriangleApplication) retireSwapChain() *retiredSwapchain {
+	retired := &retiredSwapchain{
+		serial:               app.submitSerial,
+		device:               app.device,
+		pipelines:            app.pipelines,
+		commandBuffers:       app.commandBuffers,
+		renderGraph:          app.renderGraph,
+		renderPass:           app.renderPass,
+		imageViews:           app.swapchainImageViews,
+		swapchain:            app.swapchain,
+		uniformBuffers:       app.uniformBuffers,
+		uniformBuffersMemory: app.uniformBuffersMemory,
+		descriptorPool:       app.descriptorPool,
+	}
+
+	app.commandBuffers = nil
+	app.renderGraph = nil
+	app.renderPass = nil
+	app.swapchainImageViews = nil
+	app.swapchain = nil
+	app.uniformBuffers = nil
+	app.uniformBuffersMemory = nil
+	app.descriptorPool = nil
+	app.descriptorSets = nil
+
+	return retired
+}
+
+// destroyRetiredSwapchains destroys the retired swapchains that no unfinished frame uses.
+// With all set, every one is destroyed, which is only safe once the device is idle.
+func (app *HelloTriangleApplication) destroyRetiredSwapchains(all bool) {
+	remaining := app.retiredSwapchains[:0]
+	for _, retired := range app.retiredSwapchains {
+		if all || app.completedSerial > retired.serial {
+			retired.Destroy()
+		} else {
+			remaining = append(remaining, retired)
+		}
+	}
+	app.retiredSwapchains = remaining
+}
+
+func (r *retiredSwapchain) Destroy() {
+	if len(r.commandBuffers) > 0 {
+		r.device.FreeCommandBuffers(r.commandBuffers)
+	}
+
+	if r.pipelines != nil && r.renderPass != nil {
+		r.pipelines.ReleaseRenderPass(r.renderPass)
+	}
+
+	if r.renderGraph != nil {
+		r.renderGraph.Destroy()
+	}
+
+	for _, imageView := range r.imageViews {
+		imageView.Destroy(nil)
+	}
+
+	if r.swapchain != nil {
+		r.swapchain.Destroy(nil)
+	}
+
+	for _, buffer := range r.uniformBuffers {
+		buffer.Destroy(nil)
+	}
+
+	for _, memory := range r.uniformBuffersMemory {
+		memory.Free(nil)
+	}
+
+	if r.descriptorPool != nil {
+		r.descriptorPool.Destroy(nil)
+	}
+}
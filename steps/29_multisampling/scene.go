@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/vkngwrapper/core/v2/core1_0"
+	"github.com/vkngwrapper/extensions/v2/khr_swapchain"
+)
+
+// createRenderGraph declares the scene pass and its attachments. The graph builds the render
+// pass, framebuffers, transient images and barriers from them.
+func (app *HelloTriangleApplication) createRenderGraph() error {
+	depthFormat, err := app.findDepthFormat()
+	if err != nil {
+		return err
+	}
+
+	graph := newRenderGraph(app.device, app.debug, app.gpuProfiler, app.swapchainExtent, app.findMemoryType)
+
+	swapchainImage := graph.ImportImage("swapchain", graphImport{
+		Format: app.swapchainImageFormat,
+		Images: app.swapchainImages,
+		Views:  app.swapchainImageViews,
+		// The first barrier has to wait on the stage that waits on the image available semaphore
+		Initial: imageState{
+			Layout:      core1_0.ImageLayoutUndefined,
+			Stage:       core1_0.PipelineStageColorAttachmentOutput,
+			QueueFamily: queueFamilyIgnored,
+		},
+		Final: layoutState(khr_swapchain.ImageLayoutPresentSrc),
+	})
+	depth := graph.CreateImage("depth", graphImageDesc{Format: depthFormat, Samples: app.msaaSamples})
+
+	scene := graph.AddPass("scene")
+	if app.msaaSamples == core1_0.Samples1 {
+		scene.ColorAttachment(swapchainImage, core1_0.ClearValueFloat{0, 0, 0, 1})
+	} else {
+		color := graph.CreateImage("color", graphImageDesc{Format: app.swapchainImageFormat, Samples: app.msaaSamples})
+		scene.ColorAttachment(color, core1_0.ClearValueFloat{0, 0, 0, 1}).
+			ResolveAttachment(swapchainImage)
+	}
+	scene.DepthAttachment(depth, core1_0.ClearValueDepthStencil{Depth: 1.0, Stencil: 0}).
+		Execute(app.recordScene)
+
+	err = graph.Compile()
+	if err != nil {
+		graph.Destroy()
+		return err
+	}
+
+	app.renderGraph = graph
+	app.renderPass = scene.RenderPass()
+
+	return nil
+}
+
+// recordScene draws the scene inside the scene pass, split in half when sample shading is
+// being compared
+func (app *HelloTriangleApplication) recordScene(buffer core1_0.CommandBuffer, imageIndex int) error {
+	scissor := core1_0.Rect2D{Extent: app.swapchainExtent}
+	if !app.compareSampleShading {
+		return app.drawScene(buffer, imageIndex, app.pipelineState, scissor)
+	}
+
+	// The comparison draws the scene twice, once into each half of the screen
+	off := app.pipelineState
+	off.SampleShading = 0
+	on := app.pipelineState
+	on.SampleShading = app.sampleShading
+
+	left := scissor
+	left.Extent.Width /= 2
+	err := app.drawScene(buffer, imageIndex, off, left)
+	if err != nil {
+		return err
+	}
+
+	right := scissor
+	right.Offset.X = int(left.Extent.Width)
+	right.Extent.Width -= left.Extent.Width
+	return app.drawScene(buffer, imageIndex, on, right)
+}
+
+func (app *HelloTriangleApplication) drawScene(buffer core1_0.CommandBuffer, imageIndex int, state pipelineState, scissor core1_0.Rect2D) error {
+	pipeline, err := app.pipelines.Get(state, app.renderPass)
+	if err != nil {
+		return err
+	}
+
+	buffer.CmdBindPipeline(core1_0.PipelineBindPointGraphics, pipeline)
+	buffer.CmdSetScissor([]core1_0.Rect2D{scissor})
+	buffer.CmdBindVertexBuffers(0, []core1_0.Buffer{app.vertexBuffer}, []int{0})
+	buffer.CmdBindIndexBuffer(app.indexBuffer, 0, app.mesh.IndexType())
+	buffer.CmdBindDescriptorSets(core1_0.PipelineBindPointGraphics, app.pipelineLayout, 0, []core1_0.DescriptorSet{
+		app.descriptorSets[imageIndex],
+	}, nil)
+	for _, submesh := range app.mesh.Submeshes() {
+		buffer.CmdDrawIndexed(submesh.IndexCount, 1, uint32(submesh.FirstIndex), submesh.VertexOffset, 0)
+	}
+
+	return nil
+}
+
+// recreateCommandBuffers records the command buffers again, after something they use has
+// changed
+func (app *HelloTriangleApplication) recreateCommandBuffers() error {
+	_, err := app.device.WaitIdle()
+	if err != nil {
+		return err
+	}
+
+	if len(app.commandBuffers) > 0 {
+		app.device.FreeCommandBuffers(app.commandBuffers)
+		app.commandBuffers = []core1_0.CommandBuffer{}
+	}
+
+	return app.createCommandBuffers()
+}
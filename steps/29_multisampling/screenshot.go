@@ -0,0 +1,324 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/common"
+	"github.com/vkngwrapper/core/v2/core1_0"
+	"github.com/vkngwrapper/extensions/v2/khr_swapchain"
+)
+
+// swapchainReadback copies a presented swapchain image into a host-visible buffer. The copy
+// is submitted between the frame that rendered the image and its present, so the present
+// waits on Done instead of the frame's render finished semaphore.
+type swapchainReadback struct {
+	device      core1_0.Device
+	commandPool core1_0.CommandPool
+	debug       *debugUtils
+
+	buffer core1_0.Buffer
+	memory core1_0.DeviceMemory
+	Done   core1_0.Semaphore
+	fence  core1_0.Fence
+
+	commandBuffer core1_0.CommandBuffer
+	format        core1_0.Format
+	extent        core1_0.Extent2D
+}
+
+// createSwapchainReadback creates a readback sized for the current swapchain
+func (app *HelloTriangleApplication) createSwapchainReadback(name string) (*swapchainReadback, error) {
+	readback := &swapchainReadback{
+		device:      app.device,
+		commandPool: app.commandPool,
+		debug:       app.debug,
+		format:      app.swapchainImageFormat,
+		extent:      app.swapchainExtent,
+	}
+
+	var err error
+	readback.buffer, readback.memory, err = app.createBuffer(name, app.swapchainExtent.Width*app.swapchainExtent.Height*4, core1_0.BufferUsageTransferDst, core1_0.MemoryPropertyHostVisible|core1_0.MemoryPropertyHostCoherent)
+	if err != nil {
+		readback.Destroy()
+		return nil, err
+	}
+
+	readback.Done, _, err = app.device.CreateSemaphore(nil, core1_0.SemaphoreCreateInfo{})
+	if err != nil {
+		readback.Destroy()
+		return nil, err
+	}
+	app.debug.Name(readback.Done, name+" semaphore")
+
+	readback.fence, _, err = app.device.CreateFence(nil, core1_0.FenceCreateInfo{})
+	if err != nil {
+		readback.Destroy()
+		return nil, err
+	}
+	app.debug.Name(readback.fence, name+" fence")
+
+	return readback, nil
+}
+
+// swapchainReadbackSupported reports whether the swapchain images can be read back in this
+// format, which is any 8-bit RGBA or BGRA one
+func swapchainReadbackSupported(format core1_0.Format) bool {
+	switch format {
+	case core1_0.FormatB8G8R8A8UnsignedNormalized, core1_0.FormatB8G8R8A8SRGB,
+		core1_0.FormatR8G8B8A8UnsignedNormalized, core1_0.FormatR8G8B8A8SRGB:
+		return true
+	}
+	return false
+}
+
+// Submit copies a swapchain image once the semaphore its frame signals has been signaled.
+// The image is left in the present layout.
+func (r *swapchainReadback) Submit(queue core1_0.Queue, image core1_0.Image, wait core1_0.Semaphore) error {
+	buffers, _, err := r.device.AllocateCommandBuffers(core1_0.CommandBufferAllocateInfo{
+		CommandPool:        r.commandPool,
+		Level:              core1_0.CommandBufferLevelPrimary,
+		CommandBufferCount: 1,
+	})
+	if err != nil {
+		return err
+	}
+	r.commandBuffer = buffers[0]
+
+	_, err = r.commandBuffer.Begin(core1_0.CommandBufferBeginInfo{
+		Flags: core1_0.CommandBufferUsageOneTimeSubmit,
+	})
+	if err != nil {
+		return err
+	}
+
+	r.debug.BeginLabel(r.commandBuffer, "swapchain readback", uploadLabelColor)
+	err = r.record(image)
+	if err != nil {
+		return err
+	}
+	r.debug.EndLabel(r.commandBuffer)
+
+	_, err = r.commandBuffer.End()
+	if err != nil {
+		return err
+	}
+
+	_, err = queue.Submit(r.fence, []core1_0.SubmitInfo{
+		{
+			WaitSemaphores:   []core1_0.Semaphore{wait},
+			WaitDstStageMask: []core1_0.PipelineStageFlags{core1_0.PipelineStageTransfer},
+			CommandBuffers:   []core1_0.CommandBuffer{r.commandBuffer},
+			SignalSemaphores: []core1_0.Semaphore{r.Done},
+		},
+	})
+	return err
+}
+
+func (r *swapchainReadback) record(image core1_0.Image) error {
+	// Swapchain images aren't in the app's tracker, since the render pass handles their
+	// layouts, so this tracks the one image just for the copy
+	var states imageStateTracker
+	states.Track(image, r.format, 1, 1)
+	subresources := states.WholeImage(image)
+
+	// The submission waits on the frame's semaphore at the transfer stage, which the
+	// barrier below chains onto
+	err := states.Assume(image, subresources, imageState{
+		Layout:      khr_swapchain.ImageLayoutPresentSrc,
+		Stage:       core1_0.PipelineStageTransfer,
+		QueueFamily: queueFamilyIgnored,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = states.Transition(r.commandBuffer, image, subresources, layoutState(core1_0.ImageLayoutTransferSrcOptimal))
+	if err != nil {
+		return err
+	}
+
+	err = r.commandBuffer.CmdCopyImageToBuffer(image, core1_0.ImageLayoutTransferSrcOptimal, r.buffer, []core1_0.BufferImageCopy{
+		{
+			ImageSubresource: core1_0.ImageSubresourceLayers{
+				AspectMask: core1_0.ImageAspectColor,
+				LayerCount: 1,
+			},
+			ImageExtent: core1_0.Extent3D{Width: r.extent.Width, Height: r.extent.Height, Depth: 1},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = r.commandBuffer.CmdPipelineBarrier(core1_0.PipelineStageTransfer, core1_0.PipelineStageHost, 0, nil, []core1_0.BufferMemoryBarrier{
+		{
+			SrcAccessMask:       core1_0.AccessTransferWrite,
+			DstAccessMask:       core1_0.AccessHostRead,
+			SrcQueueFamilyIndex: queueFamilyIgnored,
+			DstQueueFamilyIndex: queueFamilyIgnored,
+			Buffer:              r.buffer,
+			Size:                r.extent.Width * r.extent.Height * 4,
+		},
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	return states.Transition(r.commandBuffer, image, subresources, layoutState(khr_swapchain.ImageLayoutPresentSrc))
+}
+
+// Read waits for the copy to finish and returns the image it copied
+func (r *swapchainReadback) Read() (*image.NRGBA, error) {
+	_, err := r.fence.Wait(common.NoTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	r.device.FreeCommandBuffers([]core1_0.CommandBuffer{r.commandBuffer})
+	r.commandBuffer = nil
+	_, err = r.device.ResetFences([]core1_0.Fence{r.fence})
+	if err != nil {
+		return nil, err
+	}
+
+	size := r.extent.Width * r.extent.Height * 4
+	memoryPtr, _, err := r.memory.Map(0, size, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.memory.Unmap()
+
+	return swapchainPixels(r.format, r.extent, unsafe.Slice((*byte)(memoryPtr), size))
+}
+
+// swapchainPixels converts pixels copied out of a swapchain image to an image.
+//
+// No color conversion is needed for sRGB. An _SRGB image stores the sRGB-encoded bytes the
+// hardware wrote, and an _UNORM image presented with an sRGB color space holds values that
+// the display already treats as sRGB, so either way the bytes are what a PNG expects.
+// Presentation ignores alpha, so it's made opaque rather than saved with whatever the
+// blending left behind.
+func swapchainPixels(format core1_0.Format, extent core1_0.Extent2D, data []byte) (*image.NRGBA, error) {
+	var red, blue int
+	switch format {
+	case core1_0.FormatB8G8R8A8UnsignedNormalized, core1_0.FormatB8G8R8A8SRGB:
+		red, blue = 2, 0
+	case core1_0.FormatR8G8B8A8UnsignedNormalized, core1_0.FormatR8G8B8A8SRGB:
+		red, blue = 0, 2
+	default:
+		return nil, errors.Errorf("swapchainReadback: unsupported swapchain format %s", format)
+	}
+
+	pixels := image.NewNRGBA(image.Rect(0, 0, extent.Width, extent.Height))
+	for i := 0; i+3 < len(data) && i+3 < len(pixels.Pix); i += 4 {
+		pixels.Pix[i] = data[i+red]
+		pixels.Pix[i+1] = data[i+1]
+		pixels.Pix[i+2] = data[i+blue]
+		pixels.Pix[i+3] = 0xff
+	}
+
+	return pixels, nil
+}
+
+func (r *swapchainReadback) Destroy() {
+	if r.commandBuffer != nil {
+		r.device.FreeCommandBuffers([]core1_0.CommandBuffer{r.commandBuffer})
+	}
+	if r.fence != nil {
+		r.fence.Destroy(nil)
+	}
+	if r.Done != nil {
+		r.Done.Destroy(nil)
+	}
+	if r.buffer != nil {
+		r.buffer.Destroy(nil)
+	}
+	if r.memory != nil {
+		r.memory.Free(nil)
+	}
+}
+
+func writePNG(path string, pixels image.Image) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s", path)
+	}
+
+	err = png.Encode(file, pixels)
+	if err != nil {
+		file.Close()
+		return errors.Wrapf(err, "failed to encode %s", path)
+	}
+
+	return file.Close()
+}
+
+// RequestScreenshot saves the next frame to a PNG file once it's been rendered
+func (app *HelloTriangleApplication) RequestScreenshot(path string) {
+	if !app.swapchainTransferSrc {
+		log.Println("the surface doesn't allow copying from swapchain images- screenshots are disabled")
+		return
+	}
+	if !swapchainReadbackSupported(app.swapchainImageFormat) {
+		log.Printf("can't save screenshots of %s swapchain images", app.swapchainImageFormat)
+		return
+	}
+
+	app.screenshotPath = path
+}
+
+// captureSwapchainImage submits a copy of a swapchain image once wait is signaled, which its
+// present must wait on
+func (app *HelloTriangleApplication) captureSwapchainImage(imageIndex int, wait core1_0.Semaphore) (*swapchainReadback, error) {
+	readback, err := app.createSwapchainReadback("screenshot")
+	if err != nil {
+		return nil, err
+	}
+
+	err = readback.Submit(app.graphicsQueue, app.swapchainImages[imageIndex], wait)
+	if err != nil {
+		readback.Destroy()
+		return nil, err
+	}
+
+	return readback, nil
+}
+
+// saveScreenshot reads back a captured image and writes it to the requested path in the
+// background, so encoding doesn't stall the frame
+func (app *HelloTriangleApplication) saveScreenshot(readback *swapchainReadback) error {
+	path := app.screenshotPath
+	app.screenshotPath = ""
+
+	pixels, err := readback.Read()
+	if err != nil {
+		readback.Destroy()
+		return err
+	}
+
+	// The present may still be waiting on the readback's semaphore
+	_, err = app.presentQueue.WaitIdle()
+	readback.Destroy()
+	if err != nil {
+		return err
+	}
+
+	app.screenshotWrites.Add(1)
+	go func() {
+		defer app.screenshotWrites.Done()
+
+		err := writePNG(path, pixels)
+		if err != nil {
+			log.Printf("%+v\n", err)
+			return
+		}
+		log.Printf("saved screenshot to %s", path)
+	}()
+
+	return nil
+}
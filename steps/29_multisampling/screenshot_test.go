@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+func TestSwapchainPixels(t *testing.T) {
+	// A 2x1 image of red and half-transparent blue, as the swapchain stores it
+	bgra := []byte{0, 0, 255, 255, 255, 0, 0, 128}
+	rgba := []byte{255, 0, 0, 255, 0, 0, 255, 128}
+	extent := core1_0.Extent2D{Width: 2, Height: 1}
+
+	testCases := []struct {
+		format core1_0.Format
+		data   []byte
+	}{
+		{format: core1_0.FormatB8G8R8A8UnsignedNormalized, data: bgra},
+		{format: core1_0.FormatB8G8R8A8SRGB, data: bgra},
+		{format: core1_0.FormatR8G8B8A8UnsignedNormalized, data: rgba},
+		{format: core1_0.FormatR8G8B8A8SRGB, data: rgba},
+	}
+
+	// Alpha is made opaque, since presentation ignores it
+	want := []byte{255, 0, 0, 255, 0, 0, 255, 255}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.format.String(), func(t *testing.T) {
+			if !swapchainReadbackSupported(testCase.format) {
+				t.Errorf("swapchainReadbackSupported rejected %s", testCase.format)
+			}
+
+			pixels, err := swapchainPixels(testCase.format, extent, testCase.data)
+			if err != nil {
+				t.Fatalf("swapchainPixels: %+v", err)
+			}
+			if pixels.Rect.Dx() != 2 || pixels.Rect.Dy() != 1 {
+				t.Fatalf("got a %dx%d image, expected 2x1", pixels.Rect.Dx(), pixels.Rect.Dy())
+			}
+			for i := range want {
+				if pixels.Pix[i] != want[i] {
+					t.Errorf("got the pixels %v, expected %v", pixels.Pix, want)
+					break
+				}
+			}
+		})
+	}
+
+	if swapchainReadbackSupported(core1_0.FormatA2B10G10R10UnsignedNormalizedPacked) {
+		t.Error("swapchainReadbackSupported accepted a 10-bit format")
+	}
+	_, err := swapchainPixels(core1_0.FormatA2B10G10R10UnsignedNormalizedPacked, extent, make([]byte, 8))
+	if err == nil {
+		t.Error("swapchainPixels accepted a 10-bit format, expected an error")
+	}
+}
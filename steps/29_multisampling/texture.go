@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// writeImagePixels converts an image to tightly-packed 8-bit RGBA with straight (not
+// premultiplied) alpha and writes it to dst, which is usually mapped staging memory.
+// *image.NRGBA and *image.RGBA are copied a row at a time. Everything else is converted
+// by the image/draw package in horizontal bands spread across goroutines.
+func writeImagePixels(dst []byte, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	rowSize := width * 4
+
+	if len(dst) != rowSize*height {
+		return errors.Errorf("writeImagePixels: expected a destination of %d bytes but received %d", rowSize*height, len(dst))
+	}
+	if width == 0 || height == 0 {
+		return nil
+	}
+
+	switch source := img.(type) {
+	case *image.NRGBA:
+		for y := 0; y < height; y++ {
+			rowStart := source.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			copy(dst[y*rowSize:(y+1)*rowSize], source.Pix[rowStart:rowStart+rowSize])
+		}
+		return nil
+	case *image.RGBA:
+		for y := 0; y < height; y++ {
+			rowStart := source.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			row := dst[y*rowSize : (y+1)*rowSize]
+			copy(row, source.Pix[rowStart:rowStart+rowSize])
+			unpremultiplyRow(row)
+		}
+		return nil
+	}
+
+	target := &image.NRGBA{
+		Pix:    dst,
+		Stride: rowSize,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+
+	bandCount := min(runtime.GOMAXPROCS(0), height)
+	bandHeight := (height + bandCount - 1) / bandCount
+
+	var waitGroup sync.WaitGroup
+	for bandStart := 0; bandStart < height; bandStart += bandHeight {
+		band := image.Rect(0, bandStart, width, min(bandStart+bandHeight, height))
+
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+			draw.Draw(target, band, img, bounds.Min.Add(band.Min), draw.Src)
+		}()
+	}
+	waitGroup.Wait()
+
+	return nil
+}
+
+// unpremultiplyRow converts a row of premultiplied RGBA pixels to straight alpha in place,
+// rounding the way color.NRGBAModel does so it matches the image/draw path. Fully opaque
+// and fully transparent pixels are unchanged, so this is nearly free for the common case
+// of an opaque image.
+func unpremultiplyRow(row []byte) {
+	for i := 0; i+3 < len(row); i += 4 {
+		alpha := uint32(row[i+3])
+		if alpha == 0 || alpha == 0xff {
+			continue
+		}
+
+		row[i] = byte(uint32(row[i]) * 0xffff / alpha >> 8)
+		row[i+1] = byte(uint32(row[i+1]) * 0xffff / alpha >> 8)
+		row[i+2] = byte(uint32(row[i+2]) * 0xffff / alpha >> 8)
+	}
+}
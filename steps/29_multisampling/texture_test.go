@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// patternImage fills an image with every combination of channel values it can fit,
+// including partially transparent ones
+func patternImage(img interface {
+	image.Image
+	Set(x, y int, c color.Color)
+}) {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			alpha := uint8(x * 37)
+			img.Set(x, y, color.NRGBA{R: uint8(x * 13), G: uint8(y * 29), B: uint8((x + y) * 7), A: alpha})
+		}
+	}
+}
+
+func patternYCbCr(rect image.Rectangle, ratio image.YCbCrSubsampleRatio) *image.YCbCr {
+	img := image.NewYCbCr(rect, ratio)
+	for i := range img.Y {
+		img.Y[i] = uint8(i * 7)
+	}
+	for i := range img.Cb {
+		img.Cb[i] = uint8(i * 11)
+		img.Cr[i] = uint8(255 - i*5)
+	}
+	return img
+}
+
+func newPatternNRGBA(rect image.Rectangle) *image.NRGBA {
+	img := image.NewNRGBA(rect)
+	patternImage(img)
+	return img
+}
+
+func newPatternGray(rect image.Rectangle) *image.Gray {
+	img := image.NewGray(rect)
+	patternImage(img)
+	return img
+}
+
+func newPatternRGBA(rect image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(rect)
+	patternImage(img)
+	return img
+}
+
+func TestWriteImagePixels(t *testing.T) {
+	// Every image is a sub-image that doesn't start at the origin, with a width and height
+	// that differ, so rows have to be found through the stride and Rect.Min
+	subRect := image.Rect(3, 5, 40, 22)
+	parentRect := image.Rect(-2, 1, 45, 30)
+
+	testCases := []struct {
+		name string
+		img  image.Image
+	}{
+		{name: "NRGBA", img: newPatternNRGBA(parentRect).SubImage(subRect)},
+		{name: "RGBAPremultiplied", img: newPatternRGBA(parentRect).SubImage(subRect)},
+		{name: "YCbCr420", img: patternYCbCr(parentRect, image.YCbCrSubsampleRatio420).SubImage(subRect)},
+		{name: "YCbCr444", img: patternYCbCr(parentRect, image.YCbCrSubsampleRatio444).SubImage(subRect)},
+		{name: "Gray", img: newPatternGray(parentRect).SubImage(subRect)},
+		{name: "SingleRow", img: patternYCbCr(parentRect, image.YCbCrSubsampleRatio420).SubImage(image.Rect(3, 5, 40, 6))},
+		{name: "ZeroHeightYCbCr", img: patternYCbCr(parentRect, image.YCbCrSubsampleRatio420).SubImage(image.Rect(3, 5, 40, 5))},
+		{name: "ZeroWidthGray", img: image.NewGray(parentRect).SubImage(image.Rect(3, 5, 3, 22))},
+		{name: "ZeroHeightNRGBA", img: newPatternNRGBA(parentRect).SubImage(image.Rect(3, 5, 40, 5))},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			bounds := testCase.img.Bounds()
+			width, height := bounds.Dx(), bounds.Dy()
+			dst := make([]byte, width*height*4)
+
+			err := writeImagePixels(dst, testCase.img)
+			if err != nil {
+				t.Fatalf("writeImagePixels: %+v", err)
+			}
+
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					expected := color.NRGBAModel.Convert(testCase.img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.NRGBA)
+					offset := (y*width + x) * 4
+					got := color.NRGBA{R: dst[offset], G: dst[offset+1], B: dst[offset+2], A: dst[offset+3]}
+					if got != expected {
+						t.Fatalf("pixel (%d, %d) is %v, expected %v", x, y, got, expected)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWriteImagePixelsWrongSize(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	err := writeImagePixels(make([]byte, 4*3*4-1), img)
+	if err == nil {
+		t.Error("writeImagePixels succeeded with a short destination, expected an error")
+	}
+}
+
+func BenchmarkWriteImagePixels(b *testing.B) {
+	rect := image.Rect(0, 0, 4096, 4096)
+	images := []struct {
+		name string
+		img  image.Image
+	}{
+		{name: "NRGBA", img: newPatternNRGBA(rect)},
+		{name: "RGBA", img: newPatternRGBA(rect)},
+		{name: "YCbCr420", img: patternYCbCr(rect, image.YCbCrSubsampleRatio420)},
+	}
+
+	for _, benchmark := range images {
+		b.Run(fmt.Sprintf("%s-%dx%d", benchmark.name, rect.Dx(), rect.Dy()), func(b *testing.B) {
+			dst := make([]byte, rect.Dx()*rect.Dy()*4)
+			b.SetBytes(int64(len(dst)))
+
+			for b.Loop() {
+				err := writeImagePixels(dst, benchmark.img)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
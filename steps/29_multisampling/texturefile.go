@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/fs"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// textureData is a texture file that has been read into memory but not yet uploaded.
+// PNG and JPEG files keep their decoded image so that it can be converted straight into
+// the staging buffer. KTX2 and DDS files hold the raw data for each mip level instead,
+// largest level first.
+type textureData struct {
+	Format core1_0.Format
+	Width  int
+	Height int
+	Image  image.Image
+	Levels [][]byte
+}
+
+// textureFormat describes how a format lays out its data. Uncompressed formats have a
+// BlockExtent of 1, and block-compressed formats store 4x4 texel blocks.
+type textureFormat struct {
+	BlockBytes  int
+	BlockExtent int
+}
+
+var textureFormats = map[core1_0.Format]textureFormat{
+	core1_0.FormatR8G8B8A8UnsignedNormalized: {BlockBytes: 4, BlockExtent: 1},
+	core1_0.FormatR8G8B8A8SignedNormalized:   {BlockBytes: 4, BlockExtent: 1},
+	core1_0.FormatR8G8B8A8SRGB:               {BlockBytes: 4, BlockExtent: 1},
+	core1_0.FormatB8G8R8A8UnsignedNormalized: {BlockBytes: 4, BlockExtent: 1},
+	core1_0.FormatB8G8R8A8SRGB:               {BlockBytes: 4, BlockExtent: 1},
+	core1_0.FormatR16G16B16A16SignedFloat:    {BlockBytes: 8, BlockExtent: 1},
+	core1_0.FormatBC1_RGBUnsignedNormalized:  {BlockBytes: 8, BlockExtent: 4},
+	core1_0.FormatBC1_RGBsRGB:                {BlockBytes: 8, BlockExtent: 4},
+	core1_0.FormatBC1_RGBAUnsignedNormalized: {BlockBytes: 8, BlockExtent: 4},
+	core1_0.FormatBC1_RGBAsRGB:               {BlockBytes: 8, BlockExtent: 4},
+	core1_0.FormatBC2_UnsignedNormalized:     {BlockBytes: 16, BlockExtent: 4},
+	core1_0.FormatBC2_sRGB:                   {BlockBytes: 16, BlockExtent: 4},
+	core1_0.FormatBC3_UnsignedNormalized:     {BlockBytes: 16, BlockExtent: 4},
+	core1_0.FormatBC3_sRGB:                   {BlockBytes: 16, BlockExtent: 4},
+	core1_0.FormatBC4_UnsignedNormalized:     {BlockBytes: 8, BlockExtent: 4},
+	core1_0.FormatBC4_SignedNormalized:       {BlockBytes: 8, BlockExtent: 4},
+	core1_0.FormatBC5_UnsignedNormalized:     {BlockBytes: 16, BlockExtent: 4},
+	core1_0.FormatBC5_SignedNormalized:       {BlockBytes: 16, BlockExtent: 4},
+	core1_0.FormatBC6_UnsignedFloat:          {BlockBytes: 16, BlockExtent: 4},
+	core1_0.FormatBC6_SignedFloat:            {BlockBytes: 16, BlockExtent: 4},
+	core1_0.FormatBC7_UnsignedNormalized:     {BlockBytes: 16, BlockExtent: 4},
+	core1_0.FormatBC7_sRGB:                   {BlockBytes: 16, BlockExtent: 4},
+}
+
+func (t *textureData) IsCompressed() bool {
+	return textureFormats[t.Format].BlockExtent > 1
+}
+
+func (t *textureData) LevelCount() int {
+	if t.Image != nil {
+		return 1
+	}
+	return len(t.Levels)
+}
+
+func (t *textureData) LevelExtent(level int) (int, int) {
+	return max(1, t.Width>>level), max(1, t.Height>>level)
+}
+
+func levelDataSize(format core1_0.Format, width, height int) int {
+	info := textureFormats[format]
+	blocksWide := (width + info.BlockExtent - 1) / info.BlockExtent
+	blocksHigh := (height + info.BlockExtent - 1) / info.BlockExtent
+	return blocksWide * blocksHigh * info.BlockBytes
+}
+
+func (t *textureData) LevelDataSize(level int) int {
+	width, height := t.LevelExtent(level)
+	return levelDataSize(t.Format, width, height)
+}
+
+func (t *textureData) DataSize() int {
+	size := 0
+	for level := 0; level < t.LevelCount(); level++ {
+		size += t.LevelDataSize(level)
+	}
+	return size
+}
+
+// WriteLevels writes every mip level into dst back to back, which is the layout
+// copyTextureToImage expects
+func (t *textureData) WriteLevels(dst []byte) error {
+	if t.Image != nil {
+		return writeImagePixels(dst, t.Image)
+	}
+
+	if len(dst) != t.DataSize() {
+		return errors.Errorf("expected a destination of %d bytes but received %d", t.DataSize(), len(dst))
+	}
+
+	offset := 0
+	for _, level := range t.Levels {
+		offset += copy(dst[offset:], level)
+	}
+	return nil
+}
+
+// loadTextureFile reads a PNG, JPEG, KTX2 or DDS file. KTX2 and DDS files must hold a
+// single 2D image, with or without a mip chain.
+func loadTextureFile(fileSystem fs.FS, filePath string) (*textureData, error) {
+	data, err := fs.ReadFile(fileSystem, filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var texture *textureData
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".ktx2":
+		texture, err = parseKTX2(data)
+	case ".dds":
+		texture, err = parseDDS(data)
+	default:
+		var decodedImage image.Image
+		decodedImage, _, err = image.Decode(bytes.NewReader(data))
+		if err == nil {
+			imageDims := decodedImage.Bounds().Size()
+			texture = &textureData{
+				Format: core1_0.FormatR8G8B8A8SRGB,
+				Width:  imageDims.X,
+				Height: imageDims.Y,
+				Image:  decodedImage,
+			}
+		}
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "loadTextureFile: could not load %s", filePath)
+	}
+
+	return texture, nil
+}
+
+// setLevels slices each mip level out of a container file and checks that it holds as
+// much data as the format requires
+func (t *textureData) setLevels(data []byte, offsets []int, lengths []int) error {
+	if t.Width <= 0 || t.Height <= 0 {
+		return errors.Errorf("invalid texture size %dx%d", t.Width, t.Height)
+	}
+	if _, known := textureFormats[t.Format]; !known {
+		return errors.Errorf("unsupported texture format %s", t.Format)
+	}
+
+	for level := range offsets {
+		size := t.LevelDataSize(level)
+		if lengths[level] < size || offsets[level] < 0 || offsets[level]+size > len(data) {
+			return errors.Errorf("mip level %d is truncated", level)
+		}
+
+		t.Levels = append(t.Levels, data[offsets[level]:offsets[level]+size])
+	}
+	return nil
+}
+
+var ktx2Identifier = [12]byte{0xAB, 'K', 'T', 'X', ' ', '2', '0', 0xBB, '\r', '\n', 0x1A, '\n'}
+
+type ktx2Header struct {
+	Identifier             [12]byte
+	VkFormat               uint32
+	TypeSize               uint32
+	PixelWidth             uint32
+	PixelHeight            uint32
+	PixelDepth             uint32
+	LayerCount             uint32
+	FaceCount              uint32
+	LevelCount             uint32
+	SupercompressionScheme uint32
+	DFDByteOffset          uint32
+	DFDByteLength          uint32
+	KVDByteOffset          uint32
+	KVDByteLength          uint32
+	SGDByteOffset          uint64
+	SGDByteLength          uint64
+}
+
+type ktx2Level struct {
+	ByteOffset             uint64
+	ByteLength             uint64
+	UncompressedByteLength uint64
+}
+
+// parseKTX2 reads a KTX 2.0 container. Since KTX2 stores a VkFormat directly, no format
+// translation is needed, but supercompressed (Basis/zstd) files are not supported.
+func parseKTX2(data []byte) (*textureData, error) {
+	reader := bytes.NewReader(data)
+
+	var header ktx2Header
+	err := binary.Read(reader, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseKTX2: could not read header")
+	}
+
+	if header.Identifier != ktx2Identifier {
+		return nil, errors.New("parseKTX2: not a KTX2 file")
+	}
+	if header.SupercompressionScheme != 0 {
+		return nil, errors.Errorf("parseKTX2: supercompression scheme %d is not supported", header.SupercompressionScheme)
+	}
+	if header.PixelDepth > 1 || header.LayerCount > 1 || header.FaceCount != 1 {
+		return nil, errors.New("parseKTX2: only single 2D images are supported")
+	}
+
+	texture := &textureData{
+		Format: core1_0.Format(header.VkFormat),
+		Width:  int(header.PixelWidth),
+		Height: int(header.PixelHeight),
+	}
+
+	// A level count of 0 asks the loader to generate the mip chain itself
+	levelCount := max(1, int(header.LevelCount))
+	if levelCount > mipLevelCount(texture.Width, texture.Height, 0, 1) {
+		return nil, errors.Errorf("parseKTX2: %d mip levels is too many for a %dx%d texture", levelCount, texture.Width, texture.Height)
+	}
+
+	levels := make([]ktx2Level, levelCount)
+	err = binary.Read(reader, binary.LittleEndian, levels)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseKTX2: could not read level index")
+	}
+
+	offsets := make([]int, len(levels))
+	lengths := make([]int, len(levels))
+	for i, level := range levels {
+		offsets[i] = int(level.ByteOffset)
+		lengths[i] = int(level.ByteLength)
+	}
+
+	err = texture.setLevels(data, offsets, lengths)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseKTX2")
+	}
+	return texture, nil
+}
+
+type ddsPixelFormat struct {
+	Size        uint32
+	Flags       uint32
+	FourCC      [4]byte
+	RGBBitCount uint32
+	RBitMask    uint32
+	GBitMask    uint32
+	BBitMask    uint32
+	ABitMask    uint32
+}
+
+type ddsHeader struct {
+	Magic             [4]byte
+	Size              uint32
+	Flags             uint32
+	Height            uint32
+	Width             uint32
+	PitchOrLinearSize uint32
+	Depth             uint32
+	MipMapCount       uint32
+	Reserved1         [11]uint32
+	PixelFormat       ddsPixelFormat
+	Caps              uint32
+	Caps2             uint32
+	Caps3             uint32
+	Caps4             uint32
+	Reserved2         uint32
+}
+
+type ddsHeaderDX10 struct {
+	DXGIFormat        uint32
+	ResourceDimension uint32
+	MiscFlag          uint32
+	ArraySize         uint32
+	MiscFlags2        uint32
+}
+
+const (
+	ddsFlagMipMapCount   = 0x20000
+	ddsPixelFormatFourCC = 0x4
+	ddsPixelFormatRGB    = 0x40
+	ddsCaps2Cubemap      = 0x200
+	ddsCaps2Volume       = 0x200000
+	ddsResourceTexture2D = 3
+	ddsMiscTextureCube   = 0x4
+)
+
+// Legacy DDS files don't record a color space. They are treated as sRGB, the same as
+// the tutorial treats its PNG texture.
+var ddsFourCCFormats = map[[4]byte]core1_0.Format{
+	{'D', 'X', 'T', '1'}: core1_0.FormatBC1_RGBAsRGB,
+	{'D', 'X', 'T', '2'}: core1_0.FormatBC2_sRGB,
+	{'D', 'X', 'T', '3'}: core1_0.FormatBC2_sRGB,
+	{'D', 'X', 'T', '4'}: core1_0.FormatBC3_sRGB,
+	{'D', 'X', 'T', '5'}: core1_0.FormatBC3_sRGB,
+	{'A', 'T', 'I', '1'}: core1_0.FormatBC4_UnsignedNormalized,
+	{'B', 'C', '4', 'U'}: core1_0.FormatBC4_UnsignedNormalized,
+	{'B', 'C', '4', 'S'}: core1_0.FormatBC4_SignedNormalized,
+	{'A', 'T', 'I', '2'}: core1_0.FormatBC5_UnsignedNormalized,
+	{'B', 'C', '5', 'U'}: core1_0.FormatBC5_UnsignedNormalized,
+	{'B', 'C', '5', 'S'}: core1_0.FormatBC5_SignedNormalized,
+}
+
+var ddsDXGIFormats = map[uint32]core1_0.Format{
+	10: core1_0.FormatR16G16B16A16SignedFloat,
+	28: core1_0.FormatR8G8B8A8UnsignedNormalized,
+	29: core1_0.FormatR8G8B8A8SRGB,
+	31: core1_0.FormatR8G8B8A8SignedNormalized,
+	71: core1_0.FormatBC1_RGBAUnsignedNormalized,
+	72: core1_0.FormatBC1_RGBAsRGB,
+	74: core1_0.FormatBC2_UnsignedNormalized,
+	75: core1_0.FormatBC2_sRGB,
+	77: core1_0.FormatBC3_UnsignedNormalized,
+	78: core1_0.FormatBC3_sRGB,
+	80: core1_0.FormatBC4_UnsignedNormalized,
+	81: core1_0.FormatBC4_SignedNormalized,
+	83: core1_0.FormatBC5_UnsignedNormalized,
+	84: core1_0.FormatBC5_SignedNormalized,
+	87: core1_0.FormatB8G8R8A8UnsignedNormalized,
+	91: core1_0.FormatB8G8R8A8SRGB,
+	95: core1_0.FormatBC6_UnsignedFloat,
+	96: core1_0.FormatBC6_SignedFloat,
+	98: core1_0.FormatBC7_UnsignedNormalized,
+	99: core1_0.FormatBC7_sRGB,
+}
+
+// parseDDS reads a DirectDraw Surface file, with or without the DX10 header extension
+func parseDDS(data []byte) (*textureData, error) {
+	reader := bytes.NewReader(data)
+
+	var header ddsHeader
+	err := binary.Read(reader, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseDDS: could not read header")
+	}
+
+	if header.Magic != [4]byte{'D', 'D', 'S', ' '} || header.Size != 124 {
+		return nil, errors.New("parseDDS: not a DDS file")
+	}
+	if header.Caps2&(ddsCaps2Cubemap|ddsCaps2Volume) != 0 {
+		return nil, errors.New("parseDDS: only single 2D images are supported")
+	}
+
+	texture := &textureData{
+		Width:  int(header.Width),
+		Height: int(header.Height),
+	}
+
+	pixelFormat := header.PixelFormat
+	var known bool
+	switch {
+	case pixelFormat.Flags&ddsPixelFormatFourCC != 0 && pixelFormat.FourCC == [4]byte{'D', 'X', '1', '0'}:
+		var headerDX10 ddsHeaderDX10
+		err = binary.Read(reader, binary.LittleEndian, &headerDX10)
+		if err != nil {
+			return nil, errors.Wrap(err, "parseDDS: could not read DX10 header")
+		}
+		if headerDX10.ResourceDimension != ddsResourceTexture2D || headerDX10.ArraySize > 1 || headerDX10.MiscFlag&ddsMiscTextureCube != 0 {
+			return nil, errors.New("parseDDS: only single 2D images are supported")
+		}
+
+		texture.Format, known = ddsDXGIFormats[headerDX10.DXGIFormat]
+		if !known {
+			return nil, errors.Errorf("parseDDS: unsupported DXGI format %d", headerDX10.DXGIFormat)
+		}
+	case pixelFormat.Flags&ddsPixelFormatFourCC != 0:
+		texture.Format, known = ddsFourCCFormats[pixelFormat.FourCC]
+		if !known {
+			return nil, errors.Errorf("parseDDS: unsupported FourCC '%s'", pixelFormat.FourCC[:])
+		}
+	case pixelFormat.Flags&ddsPixelFormatRGB != 0 && pixelFormat.RGBBitCount == 32 && pixelFormat.RBitMask == 0xff:
+		texture.Format = core1_0.FormatR8G8B8A8SRGB
+	case pixelFormat.Flags&ddsPixelFormatRGB != 0 && pixelFormat.RGBBitCount == 32 && pixelFormat.RBitMask == 0xff0000:
+		texture.Format = core1_0.FormatB8G8R8A8SRGB
+	default:
+		return nil, errors.New("parseDDS: unsupported pixel format")
+	}
+
+	levelCount := 1
+	if header.Flags&ddsFlagMipMapCount != 0 && header.MipMapCount > 0 {
+		levelCount = int(header.MipMapCount)
+	}
+	if levelCount > mipLevelCount(texture.Width, texture.Height, 0, 1) {
+		return nil, errors.Errorf("parseDDS: %d mip levels is too many for a %dx%d texture", levelCount, texture.Width, texture.Height)
+	}
+
+	offset := len(data) - reader.Len()
+	offsets := make([]int, levelCount)
+	lengths := make([]int, levelCount)
+	for level := 0; level < levelCount; level++ {
+		width, height := max(1, texture.Width>>level), max(1, texture.Height>>level)
+		offsets[level] = offset
+		lengths[level] = len(data) - offset
+		offset += levelDataSize(texture.Format, width, height)
+	}
+
+	err = texture.setLevels(data, offsets, lengths)
+	if err != nil {
+		return nil, errors.Wrap(err, "parseDDS")
+	}
+	return texture, nil
+}
+
+// prepareTexture checks that the device can sample the texture's format. Block-compressed
+// textures the device can't sample are decompressed on the CPU instead.
+func (app *HelloTriangleApplication) prepareTexture(texture *textureData) (*textureData, error) {
+	properties := app.physicalDevice.FormatProperties(texture.Format)
+	if properties.OptimalTilingFeatures&core1_0.FormatFeatureSampledImage != 0 {
+		return texture, nil
+	}
+
+	if !texture.IsCompressed() {
+		return nil, errors.Errorf("prepareTexture: format %s cannot be sampled on this device", texture.Format)
+	}
+
+	log.Printf("%s textures are not supported by this device- decompressing on the CPU", texture.Format)
+	return decompressTexture(texture)
+}
+
+// copyTextureToImage copies every mip level of a texture from a staging buffer laid out
+// by WriteLevels into an image in the TransferDstOptimal layout
+func (app *HelloTriangleApplication) copyTextureToImage(buffer core1_0.Buffer, image core1_0.Image, texture *textureData) error {
+	cmdBuffer, err := app.beginSingleTimeCommands("upload texture")
+	if err != nil {
+		return err
+	}
+
+	var regions []core1_0.BufferImageCopy
+	offset := 0
+	for level := 0; level < texture.LevelCount(); level++ {
+		width, height := texture.LevelExtent(level)
+		regions = append(regions, core1_0.BufferImageCopy{
+			BufferOffset: offset,
+			ImageSubresource: core1_0.ImageSubresourceLayers{
+				AspectMask:     core1_0.ImageAspectColor,
+				MipLevel:       level,
+				BaseArrayLayer: 0,
+				LayerCount:     1,
+			},
+			ImageOffset: core1_0.Offset3D{X: 0, Y: 0, Z: 0},
+			ImageExtent: core1_0.Extent3D{Width: width, Height: height, Depth: 1},
+		})
+
+		offset += texture.LevelDataSize(level)
+	}
+
+	err = cmdBuffer.CmdCopyBufferToImage(buffer, image, core1_0.ImageLayoutTransferDstOptimal, regions)
+	if err != nil {
+		return err
+	}
+
+	return app.endSingleTimeCommands(cmdBuffer)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"github.com/vkngwrapper/core/v2/core1_0"
+)
+
+// testLevels is an 8x4 BC1 mip chain, two blocks then one, filled with bytes that say
+// which level they came from
+func testLevels() [][]byte {
+	return [][]byte{
+		bytes.Repeat([]byte{0x10}, 16),
+		bytes.Repeat([]byte{0x11}, 8),
+	}
+}
+
+// ktx2File writes a KTX2 container. Levels are stored smallest first after the level
+// index, the way KTX2 tools lay them out.
+func ktx2File(header ktx2Header, levels [][]byte) []byte {
+	index := make([]ktx2Level, len(levels))
+	offset := binary.Size(header) + binary.Size(index)
+	for level := len(levels) - 1; level >= 0; level-- {
+		index[level] = ktx2Level{ByteOffset: uint64(offset), ByteLength: uint64(len(levels[level]))}
+		offset += len(levels[level])
+	}
+
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, &header)
+	binary.Write(&buffer, binary.LittleEndian, index)
+	for level := len(levels) - 1; level >= 0; level-- {
+		buffer.Write(levels[level])
+	}
+	return buffer.Bytes()
+}
+
+func testKTX2Header() ktx2Header {
+	return ktx2Header{
+		Identifier:  ktx2Identifier,
+		VkFormat:    uint32(core1_0.FormatBC1_RGBAsRGB),
+		TypeSize:    1,
+		PixelWidth:  8,
+		PixelHeight: 4,
+		FaceCount:   1,
+		LevelCount:  2,
+	}
+}
+
+// ddsFile writes a DDS file with an optional DX10 header, followed by its levels
+// largest first
+func ddsFile(header ddsHeader, headerDX10 *ddsHeaderDX10, levels [][]byte) []byte {
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.LittleEndian, &header)
+	if headerDX10 != nil {
+		binary.Write(&buffer, binary.LittleEndian, headerDX10)
+	}
+	for _, level := range levels {
+		buffer.Write(level)
+	}
+	return buffer.Bytes()
+}
+
+func testDDSHeader(fourCC string) ddsHeader {
+	return ddsHeader{
+		Magic:       [4]byte{'D', 'D', 'S', ' '},
+		Size:        124,
+		Flags:       ddsFlagMipMapCount,
+		Height:      4,
+		Width:       8,
+		MipMapCount: 2,
+		PixelFormat: ddsPixelFormat{
+			Size:   32,
+			Flags:  ddsPixelFormatFourCC,
+			FourCC: [4]byte([]byte(fourCC)),
+		},
+	}
+}
+
+func testRGBADDSHeader(redMask uint32) ddsHeader {
+	header := testDDSHeader("\x00\x00\x00\x00")
+	header.Flags = 0
+	header.MipMapCount = 0
+	header.Width, header.Height = 2, 1
+	header.PixelFormat.Flags = ddsPixelFormatRGB
+	header.PixelFormat.RGBBitCount = 32
+	header.PixelFormat.RBitMask = redMask
+	return header
+}
+
+func TestLevelExtent(t *testing.T) {
+	testCases := []struct {
+		name          string
+		width, height int
+		want          [][2]int
+	}{
+		{name: "PowerOfTwo", width: 8, height: 8, want: [][2]int{{8, 8}, {4, 4}, {2, 2}, {1, 1}}},
+		{name: "NonSquare", width: 8, height: 2, want: [][2]int{{8, 2}, {4, 1}, {2, 1}, {1, 1}}},
+		{name: "Odd", width: 5, height: 3, want: [][2]int{{5, 3}, {2, 1}, {1, 1}}},
+		{name: "NonPowerOfTwo", width: 800, height: 600, want: [][2]int{
+			{800, 600}, {400, 300}, {200, 150}, {100, 75}, {50, 37}, {25, 18}, {12, 9}, {6, 4}, {3, 2}, {1, 1},
+		}},
+		{name: "OneTexel", width: 1, height: 1, want: [][2]int{{1, 1}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			texture := &textureData{Format: core1_0.FormatR8G8B8A8SRGB, Width: testCase.width, Height: testCase.height}
+
+			levels := mipLevelCount(testCase.width, testCase.height, 0, 1)
+			if levels != len(testCase.want) {
+				t.Fatalf("got %d levels, expected %d", levels, len(testCase.want))
+			}
+			for level, want := range testCase.want {
+				width, height := texture.LevelExtent(level)
+				if [2]int{width, height} != want {
+					t.Errorf("level %d is %dx%d, expected %dx%d", level, width, height, want[0], want[1])
+				}
+			}
+		})
+	}
+}
+
+func TestParseTextureFile(t *testing.T) {
+	withKTX2 := func(change func(header *ktx2Header)) ktx2Header {
+		header := testKTX2Header()
+		change(&header)
+		return header
+	}
+	withDDS := func(fourCC string, change func(header *ddsHeader)) ddsHeader {
+		header := testDDSHeader(fourCC)
+		change(&header)
+		return header
+	}
+	rgbaLevel := [][]byte{{1, 2, 3, 4, 5, 6, 7, 8}}
+
+	testCases := []struct {
+		name       string
+		path       string
+		data       []byte
+		wantFormat core1_0.Format
+		wantWidth  int
+		wantHeight int
+		wantLevels [][]byte
+	}{
+		{
+			name:       "KTX2",
+			path:       "texture.ktx2",
+			data:       ktx2File(testKTX2Header(), testLevels()),
+			wantFormat: core1_0.FormatBC1_RGBAsRGB,
+			wantWidth:  8,
+			wantHeight: 4,
+			wantLevels: testLevels(),
+		},
+		{
+			// A level count of 0 still has one level in the file
+			name:       "KTX2NoLevelCount",
+			path:       "texture.KTX2",
+			data:       ktx2File(withKTX2(func(header *ktx2Header) { header.LevelCount = 0 }), testLevels()[:1]),
+			wantFormat: core1_0.FormatBC1_RGBAsRGB,
+			wantWidth:  8,
+			wantHeight: 4,
+			wantLevels: testLevels()[:1],
+		},
+		{
+			name:       "DDSFourCC",
+			path:       "texture.dds",
+			data:       ddsFile(testDDSHeader("DXT1"), nil, testLevels()),
+			wantFormat: core1_0.FormatBC1_RGBAsRGB,
+			wantWidth:  8,
+			wantHeight: 4,
+			wantLevels: testLevels(),
+		},
+		{
+			// Without the mip map count flag, the count field is ignored
+			name:       "DDSNoMipMapFlag",
+			path:       "texture.dds",
+			data:       ddsFile(withDDS("DXT1", func(header *ddsHeader) { header.Flags = 0 }), nil, testLevels()),
+			wantFormat: core1_0.FormatBC1_RGBAsRGB,
+			wantWidth:  8,
+			wantHeight: 4,
+			wantLevels: testLevels()[:1],
+		},
+		{
+			// DXT5 blocks are twice the size of DXT1 blocks, so the two 8-byte levels
+			// make up one 16-byte level
+			name:       "DDSDXT5",
+			path:       "texture.dds",
+			data:       ddsFile(withDDS("DXT5", func(header *ddsHeader) { header.Width = 4; header.MipMapCount = 1 }), nil, testLevels()),
+			wantFormat: core1_0.FormatBC3_sRGB,
+			wantWidth:  4,
+			wantHeight: 4,
+			wantLevels: testLevels()[:1],
+		},
+		{
+			name: "DDSDX10",
+			path: "texture.dds",
+			data: ddsFile(withDDS("DX10", func(header *ddsHeader) { header.Width = 4; header.MipMapCount = 1 }),
+				&ddsHeaderDX10{DXGIFormat: 98, ResourceDimension: ddsResourceTexture2D, ArraySize: 1}, testLevels()[:1]),
+			wantFormat: core1_0.FormatBC7_UnsignedNormalized,
+			wantWidth:  4,
+			wantHeight: 4,
+			wantLevels: testLevels()[:1],
+		},
+		{
+			name:       "DDSRGBA",
+			path:       "texture.dds",
+			data:       ddsFile(testRGBADDSHeader(0xff), nil, rgbaLevel),
+			wantFormat: core1_0.FormatR8G8B8A8SRGB,
+			wantWidth:  2,
+			wantHeight: 1,
+			wantLevels: rgbaLevel,
+		},
+		{
+			name:       "DDSBGRA",
+			path:       "texture.dds",
+			data:       ddsFile(testRGBADDSHeader(0xff0000), nil, rgbaLevel),
+			wantFormat: core1_0.FormatB8G8R8A8SRGB,
+			wantWidth:  2,
+			wantHeight: 1,
+			wantLevels: rgbaLevel,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			texture, err := loadTextureFile(fstest.MapFS{testCase.path: {Data: testCase.data}}, testCase.path)
+			if err != nil {
+				t.Fatalf("loadTextureFile: %+v", err)
+			}
+
+			if texture.Format != testCase.wantFormat || texture.Width != testCase.wantWidth || texture.Height != testCase.wantHeight {
+				t.Errorf("got a %dx%d %s texture, expected %dx%d %s", texture.Width, texture.Height, texture.Format,
+					testCase.wantWidth, testCase.wantHeight, testCase.wantFormat)
+			}
+			if !slices.EqualFunc(texture.Levels, testCase.wantLevels, bytes.Equal) {
+				t.Errorf("got levels %v, expected %v", texture.Levels, testCase.wantLevels)
+			}
+		})
+	}
+}
+
+func TestParseTextureFileRejects(t *testing.T) {
+	withKTX2 := func(change func(header *ktx2Header)) ktx2Header {
+		header := testKTX2Header()
+		change(&header)
+		return header
+	}
+	withDDS := func(fourCC string, change func(header *ddsHeader)) ddsHeader {
+		header := testDDSHeader(fourCC)
+		change(&header)
+		return header
+	}
+	dx10Header := func(change func(header *ddsHeaderDX10)) *ddsHeaderDX10 {
+		header := &ddsHeaderDX10{DXGIFormat: 71, ResourceDimension: ddsResourceTexture2D, ArraySize: 1}
+		change(header)
+		return header
+	}
+	validKTX2 := ktx2File(testKTX2Header(), testLevels())
+	validDDS := ddsFile(testDDSHeader("DXT1"), nil, testLevels())
+
+	testCases := []struct {
+		name string
+		path string
+		data []byte
+	}{
+		{name: "KTX2Identifier", path: "texture.ktx2", data: ktx2File(withKTX2(func(header *ktx2Header) { header.Identifier[1] = 'X' }), testLevels())},
+		{name: "KTX2TruncatedHeader", path: "texture.ktx2", data: validKTX2[:40]},
+		{name: "KTX2Supercompression", path: "texture.ktx2", data: ktx2File(withKTX2(func(header *ktx2Header) { header.SupercompressionScheme = 2 }), testLevels())},
+		{name: "KTX2Cubemap", path: "texture.ktx2", data: ktx2File(withKTX2(func(header *ktx2Header) { header.FaceCount = 6 }), testLevels())},
+		{name: "KTX2Array", path: "texture.ktx2", data: ktx2File(withKTX2(func(header *ktx2Header) { header.LayerCount = 2 }), testLevels())},
+		{name: "KTX2Volume", path: "texture.ktx2", data: ktx2File(withKTX2(func(header *ktx2Header) { header.PixelDepth = 2 }), testLevels())},
+		{name: "KTX2UnknownFormat", path: "texture.ktx2", data: ktx2File(withKTX2(func(header *ktx2Header) { header.VkFormat = 9999 }), testLevels())},
+		{name: "KTX2ZeroWidth", path: "texture.ktx2", data: ktx2File(withKTX2(func(header *ktx2Header) { header.PixelWidth = 0; header.LevelCount = 1 }), testLevels()[:1])},
+		// An 8x4 texture has 4 levels at most, so a huge count is rejected before the
+		// level index is allocated
+		{name: "KTX2TooManyLevels", path: "texture.ktx2", data: ktx2File(withKTX2(func(header *ktx2Header) { header.LevelCount = 0xFFFFFFFF }), testLevels())},
+		{name: "KTX2TruncatedLevelIndex", path: "texture.ktx2", data: validKTX2[:binary.Size(ktx2Header{})+8]},
+		{name: "KTX2TruncatedLevel", path: "texture.ktx2", data: validKTX2[:len(validKTX2)-1]},
+		{name: "KTX2ShortLevel", path: "texture.ktx2", data: ktx2File(testKTX2Header(), [][]byte{testLevels()[0][:8], testLevels()[1]})},
+		{name: "DDSMagic", path: "texture.dds", data: ddsFile(withDDS("DXT1", func(header *ddsHeader) { header.Magic[0] = 'X' }), nil, testLevels())},
+		{name: "DDSHeaderSize", path: "texture.dds", data: ddsFile(withDDS("DXT1", func(header *ddsHeader) { header.Size = 100 }), nil, testLevels())},
+		{name: "DDSTruncatedHeader", path: "texture.dds", data: validDDS[:64]},
+		{name: "DDSCubemap", path: "texture.dds", data: ddsFile(withDDS("DXT1", func(header *ddsHeader) { header.Caps2 = ddsCaps2Cubemap }), nil, testLevels())},
+		{name: "DDSVolume", path: "texture.dds", data: ddsFile(withDDS("DXT1", func(header *ddsHeader) { header.Caps2 = ddsCaps2Volume }), nil, testLevels())},
+		{name: "DDSUnknownFourCC", path: "texture.dds", data: ddsFile(testDDSHeader("ETC2"), nil, testLevels())},
+		{name: "DDSUnknownPixelFormat", path: "texture.dds", data: ddsFile(testRGBADDSHeader(0xf800), nil, testLevels())},
+		{name: "DDSTruncatedDX10Header", path: "texture.dds", data: ddsFile(testDDSHeader("DX10"), nil, nil)},
+		{name: "DDSDX10Array", path: "texture.dds", data: ddsFile(testDDSHeader("DX10"), dx10Header(func(header *ddsHeaderDX10) { header.ArraySize = 6 }), testLevels())},
+		{name: "DDSDX10Cubemap", path: "texture.dds", data: ddsFile(testDDSHeader("DX10"), dx10Header(func(header *ddsHeaderDX10) { header.MiscFlag = ddsMiscTextureCube }), testLevels())},
+		{name: "DDSDX10Volume", path: "texture.dds", data: ddsFile(testDDSHeader("DX10"), dx10Header(func(header *ddsHeaderDX10) { header.ResourceDimension = 4 }), testLevels())},
+		{name: "DDSDX10UnknownFormat", path: "texture.dds", data: ddsFile(testDDSHeader("DX10"), dx10Header(func(header *ddsHeaderDX10) { header.DXGIFormat = 1 }), testLevels())},
+		{name: "DDSTooManyLevels", path: "texture.dds", data: ddsFile(withDDS("DXT1", func(header *ddsHeader) { header.MipMapCount = 0xFFFFFFFF }), nil, testLevels())},
+		{name: "DDSTruncatedLevel", path: "texture.dds", data: validDDS[:len(validDDS)-1]},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := loadTextureFile(fstest.MapFS{testCase.path: {Data: testCase.data}}, testCase.path)
+			if err == nil {
+				t.Fatal("loadTextureFile succeeded, expected an error")
+			}
+			t.Log(err)
+		})
+	}
+}
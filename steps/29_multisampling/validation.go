@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/extensions/v2/ext_debug_utils"
+)
+
+type validationObject struct {
+	Type   string `json:"type"`
+	Handle string `json:"handle"`
+	Name   string `json:"name,omitempty"`
+}
+
+// validationMessage is one message from the debug messenger
+type validationMessage struct {
+	Time            time.Time          `json:"time"`
+	Severity        string             `json:"severity"`
+	Type            string             `json:"type"`
+	MessageIDName   string             `json:"messageIdName,omitempty"`
+	MessageIDNumber int                `json:"messageIdNumber"`
+	Message         string             `json:"message"`
+	QueueLabels     []string           `json:"queueLabels,omitempty"`
+	CmdBufLabels    []string           `json:"cmdBufLabels,omitempty"`
+	Objects         []validationObject `json:"objects,omitempty"`
+}
+
+func validationLevel(severity ext_debug_utils.DebugUtilsMessageSeverityFlags) slog.Level {
+	switch {
+	case severity&ext_debug_utils.SeverityError != 0:
+		return slog.LevelError
+	case severity&ext_debug_utils.SeverityWarning != 0:
+		return slog.LevelWarn
+	case severity&ext_debug_utils.SeverityInfo != 0:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
+
+func labelNames(labels []ext_debug_utils.DebugUtilsLabel) []string {
+	var names []string
+	for _, label := range labels {
+		names = append(names, label.LabelName)
+	}
+	return names
+}
+
+// validationLog collects every message from the debug messenger and logs it through slog.
+// In strict mode, an error message fails the run. The messenger can call in from any
+// thread, so everything is behind a mutex.
+type validationLog struct {
+	logger *slog.Logger
+	strict bool
+
+	mutex    sync.Mutex
+	messages []validationMessage
+	errors   int
+}
+
+func newValidationLog(logger *slog.Logger, strict bool) *validationLog {
+	return &validationLog{
+		logger: logger,
+		strict: strict,
+	}
+}
+
+// Callback is the debug messenger's UserCallback. It always returns false, so that the
+// call which raised the message isn't aborted.
+func (v *validationLog) Callback(msgType ext_debug_utils.DebugUtilsMessageTypeFlags, severity ext_debug_utils.DebugUtilsMessageSeverityFlags, data *ext_debug_utils.DebugUtilsMessengerCallbackData) bool {
+	message := validationMessage{
+		Time:            time.Now(),
+		Severity:        severity.String(),
+		Type:            msgType.String(),
+		MessageIDName:   data.MessageIDName,
+		MessageIDNumber: data.MessageIDNumber,
+		Message:         data.Message,
+		QueueLabels:     labelNames(data.QueueLabels),
+		CmdBufLabels:    labelNames(data.CmdBufLabels),
+	}
+	for _, object := range data.Objects {
+		message.Objects = append(message.Objects, validationObject{
+			Type:   object.ObjectType.String(),
+			Handle: fmt.Sprintf("0x%x", uintptr(object.ObjectHandle)),
+			Name:   object.ObjectName,
+		})
+	}
+
+	level := validationLevel(severity)
+	if isDebugPrintf(message.MessageIDName) {
+		v.mutex.Lock()
+		v.messages = append(v.messages, message)
+		v.mutex.Unlock()
+
+		v.logger.Info("shader printf", slog.String("output", debugPrintfOutput(message.Message)))
+		return false
+	}
+
+	v.mutex.Lock()
+	v.messages = append(v.messages, message)
+	if level == slog.LevelError {
+		v.errors++
+	}
+	v.mutex.Unlock()
+
+	attrs := []slog.Attr{
+		slog.String("type", message.Type),
+		slog.String("id", message.MessageIDName),
+		slog.Int("number", message.MessageIDNumber),
+	}
+	if len(message.CmdBufLabels) > 0 {
+		attrs = append(attrs, slog.Any("cmdBufLabels", message.CmdBufLabels))
+	}
+	if len(message.QueueLabels) > 0 {
+		attrs = append(attrs, slog.Any("queueLabels", message.QueueLabels))
+	}
+	if len(message.Objects) > 0 {
+		attrs = append(attrs, slog.Any("objects", message.Objects))
+	}
+	v.logger.LogAttrs(context.Background(), level, message.Message, attrs...)
+
+	return false
+}
+
+func (v *validationLog) Messages() []validationMessage {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	return append([]validationMessage(nil), v.messages...)
+}
+
+// Err returns an error if the log is strict and any error messages have been received
+func (v *validationLog) Err() error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if !v.strict || v.errors == 0 {
+		return nil
+	}
+	return errors.Errorf("validationLog: %d validation errors were reported", v.errors)
+}
+
+// WriteJSON writes every message received so far to a file as a JSON array
+func (v *validationLog) WriteJSON(path string) error {
+	messages := v.Messages()
+	if messages == nil {
+		messages = []validationMessage{}
+	}
+
+	data, err := json.MarshalIndent(messages, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "validationLog: failed to encode messages")
+	}
+
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "validationLog: failed to write %s", path)
+	}
+	return nil
+}
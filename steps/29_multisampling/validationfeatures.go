@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strings"
+	"unsafe"
+
+	"github.com/CannibalVox/cgoparam"
+	"github.com/pkg/errors"
+	"github.com/vkngwrapper/core/v2/common"
+)
+
+// The validation layer provides VK_EXT_validation_features itself, and vkngwrapper doesn't
+// wrap it, so its create info is populated by hand below
+const validationFeaturesExtensionName = "VK_EXT_validation_features"
+
+// Debug printf needs this on the device before Vulkan 1.3
+const shaderNonSemanticInfoExtensionName = "VK_KHR_shader_non_semantic_info"
+
+const structureTypeValidationFeatures = 1000247000
+
+type validationFeatureEnable uint32
+
+const (
+	validationFeatureEnableGPUAssisted               validationFeatureEnable = 0
+	validationFeatureEnableBestPractices             validationFeatureEnable = 2
+	validationFeatureEnableDebugPrintf               validationFeatureEnable = 3
+	validationFeatureEnableSynchronizationValidation validationFeatureEnable = 4
+)
+
+// validationFeatures are the optional checks the validation layer runs on top of its
+// default ones
+type validationFeatures struct {
+	BestPractices   bool
+	Synchronization bool
+	GPUAssisted     bool
+	DebugPrintf     bool
+}
+
+func parseValidationFeatures(features string) (validationFeatures, error) {
+	var parsed validationFeatures
+	if features == "" {
+		return parsed, nil
+	}
+
+	for _, feature := range strings.Split(features, ",") {
+		switch strings.TrimSpace(feature) {
+		case "best-practices":
+			parsed.BestPractices = true
+		case "sync":
+			parsed.Synchronization = true
+		case "gpu-assisted":
+			parsed.GPUAssisted = true
+		case "debug-printf":
+			parsed.DebugPrintf = true
+		default:
+			return parsed, errors.Errorf("unknown validation feature '%s'- expected best-practices, sync, gpu-assisted or debug-printf", feature)
+		}
+	}
+
+	// Both are built on the same shader instrumentation, and the layer only runs one
+	if parsed.GPUAssisted && parsed.DebugPrintf {
+		return parsed, errors.New("gpu-assisted validation and debug-printf can't be enabled together")
+	}
+
+	return parsed, nil
+}
+
+func (f validationFeatures) Any() bool {
+	return f.BestPractices || f.Synchronization || f.GPUAssisted || f.DebugPrintf
+}
+
+func (f validationFeatures) String() string {
+	var names []string
+	if f.BestPractices {
+		names = append(names, "best-practices")
+	}
+	if f.Synchronization {
+		names = append(names, "sync")
+	}
+	if f.GPUAssisted {
+		names = append(names, "gpu-assisted")
+	}
+	if f.DebugPrintf {
+		names = append(names, "debug-printf")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+func (f validationFeatures) CreateInfo() validationFeaturesCreateInfo {
+	var info validationFeaturesCreateInfo
+	if f.BestPractices {
+		info.Enabled = append(info.Enabled, validationFeatureEnableBestPractices)
+	}
+	if f.Synchronization {
+		info.Enabled = append(info.Enabled, validationFeatureEnableSynchronizationValidation)
+	}
+	if f.GPUAssisted {
+		info.Enabled = append(info.Enabled, validationFeatureEnableGPUAssisted)
+	}
+	if f.DebugPrintf {
+		info.Enabled = append(info.Enabled, validationFeatureEnableDebugPrintf)
+	}
+	return info
+}
+
+// vkValidationFeaturesEXT matches the layout of VkValidationFeaturesEXT
+type vkValidationFeaturesEXT struct {
+	sType                          uint32
+	pNext                          unsafe.Pointer
+	enabledValidationFeatureCount  uint32
+	pEnabledValidationFeatures     unsafe.Pointer
+	disabledValidationFeatureCount uint32
+	pDisabledValidationFeatures    unsafe.Pointer
+}
+
+// validationFeaturesCreateInfo is chained onto the instance create info to turn on
+// validation features
+type validationFeaturesCreateInfo struct {
+	Enabled []validationFeatureEnable
+
+	common.NextOptions
+}
+
+func (o validationFeaturesCreateInfo) PopulateCPointer(allocator *cgoparam.Allocator, preallocatedPointer unsafe.Pointer, next unsafe.Pointer) (unsafe.Pointer, error) {
+	if preallocatedPointer == nil {
+		preallocatedPointer = allocator.Malloc(int(unsafe.Sizeof(vkValidationFeaturesEXT{})))
+	}
+
+	info := (*vkValidationFeaturesEXT)(preallocatedPointer)
+	info.sType = structureTypeValidationFeatures
+	info.pNext = next
+	info.enabledValidationFeatureCount = uint32(len(o.Enabled))
+	info.pEnabledValidationFeatures = nil
+	info.disabledValidationFeatureCount = 0
+	info.pDisabledValidationFeatures = nil
+
+	if len(o.Enabled) > 0 {
+		enabledPtr := allocator.Malloc(len(o.Enabled) * int(unsafe.Sizeof(validationFeatureEnable(0))))
+		copy(unsafe.Slice((*validationFeatureEnable)(enabledPtr), len(o.Enabled)), o.Enabled)
+		info.pEnabledValidationFeatures = enabledPtr
+	}
+
+	return preallocatedPointer, nil
+}
+
+// isDebugPrintf reports whether a validation message is output from debugPrintfEXT in a shader
+func isDebugPrintf(messageIDName string) bool {
+	return strings.Contains(messageIDName, "DEBUG-PRINTF")
+}
+
+// debugPrintfOutput strips the layer's prefix from a debug printf message, leaving what the
+// shader printed
+func debugPrintfOutput(message string) string {
+	index := strings.LastIndex(message, "| ")
+	if index < 0 {
+		return message
+	}
+	return message[index+2:]
+}